@@ -163,7 +163,7 @@ func main() {
 			utils.Fatalf("%v", err)
 		}
 	} else {
-		if _, err := discover.ListenUDP(nodeKey, *listenAddr, natm, "", restrictList, uint64(NetworkID), false); err != nil {
+		if _, err := discover.ListenUDP(nodeKey, *listenAddr, natm, "", restrictList, uint64(NetworkID), false, discover.Config{}); err != nil {
 			utils.Fatalf("%v", err)
 		}
 	}
@@ -0,0 +1,54 @@
+// Copyright 2021 The MOAC-core Authors
+// This file is part of the MOAC-core library.
+//
+// The MOAC-core library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The MOAC-core library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the MOAC-core library. If not, see <http://www.gnu.org/licenses/>.
+
+package utils
+
+import (
+	"encoding/json"
+
+	"gopkg.in/urfave/cli.v1"
+
+	"github.com/MOACChain/xchain/core"
+	"github.com/MOACChain/xchain/mc"
+)
+
+// redactedValue replaces secrets in dumped configurations.
+const redactedValue = "<redacted>"
+
+// resolvedConfig is the dumpable view of mc.Config. The shadowing fields hide
+// the genesis block and replace the xchain key and passphrase with markers.
+type resolvedConfig struct {
+	mc.Config
+	Genesis        *core.Genesis `json:"-"`
+	XchainKey      string        `json:",omitempty"`
+	XchainPassword string        `json:",omitempty"`
+}
+
+// DumpResolvedConfig serializes the effective mc configuration, after all
+// flags and config files have been applied, as indented JSON. The xchain
+// private key and passphrase are never included, only a redaction marker
+// telling they were set. The passphrase is marked whatever its source, flag,
+// environment or default.
+func DumpResolvedConfig(ctx *cli.Context, cfg *mc.Config) ([]byte, error) {
+	dump := resolvedConfig{Config: *cfg}
+	if cfg.XchainKey != nil {
+		dump.XchainKey = redactedValue
+	}
+	if MakeXchainPassphrace(ctx) != "" {
+		dump.XchainPassword = redactedValue
+	}
+	return json.MarshalIndent(&dump, "", "  ")
+}
@@ -18,6 +18,42 @@ package utils
 
 import "testing"
 
+// fakeFdLimits replaces the file descriptor accessors with an OS whose
+// allowance can be raised up to hard, returning a function restoring them.
+func fakeFdLimits(hard int) func() {
+	current := 256
+	fdRaise = func(max uint64) error {
+		if int(max) > hard {
+			max = uint64(hard)
+		}
+		current = int(max)
+		return nil
+	}
+	fdLimit = func() (int, error) { return current, nil }
+	fdMaxLimit = func() (int, error) { return hard, nil }
+
+	return func() {
+		fdRaise, fdLimit, fdMaxLimit = raiseFdLimit, getFdLimit, getFdMaxLimit
+	}
+}
+
+func TestDatabaseHandles(t *testing.T) {
+	defer fakeFdLimits(65536)()
+
+	tests := []struct {
+		max, want int
+	}{
+		{2048, 1024},    // default cap
+		{16384, 8192},   // raised cap
+		{100000, 32768}, // clamped to the hard limit
+	}
+	for _, tt := range tests {
+		if have := makeDatabaseHandles(tt.max); have != tt.want {
+			t.Errorf("max %d: database handles mismatch: have %d, want %d", tt.max, have, tt.want)
+		}
+	}
+}
+
 // TestFileDescriptorLimits simply tests whether the file descriptor allowance
 // per this process can be retrieved.
 func TestFileDescriptorLimits(t *testing.T) {
@@ -18,7 +18,10 @@
 
 package utils
 
-import "syscall"
+import (
+	"math"
+	"syscall"
+)
 
 // raiseFdLimit tries to maximize the file descriptor allowance of this process
 // to the maximum hard-limit allowed by the OS.
@@ -48,3 +51,16 @@ func getFdLimit() (int, error) {
 	}
 	return int(limit.Cur), nil
 }
+
+// getFdMaxLimit retrieves the OS hard limit of file descriptors this process
+// can raise its allowance to.
+func getFdMaxLimit() (int, error) {
+	var limit syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &limit); err != nil {
+		return 0, err
+	}
+	if limit.Max > math.MaxInt32 { // RLIM_INFINITY doesn't fit an int
+		return math.MaxInt32, nil
+	}
+	return int(limit.Max), nil
+}
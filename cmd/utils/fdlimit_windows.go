@@ -39,3 +39,9 @@ func getFdLimit() (int, error) {
 	// Please see raiseFdLimit for the reason why we use hard coded 16K as the limit
 	return 16384, nil
 }
+
+// getFdMaxLimit retrieves the OS hard limit of file descriptors this process
+// can raise its allowance to.
+func getFdMaxLimit() (int, error) {
+	return 16384, nil
+}
@@ -22,11 +22,13 @@ import (
 	"fmt"
 	"io/ioutil"
 	"math/big"
+	"net"
 	"os"
 	"path/filepath"
 	"runtime"
 	"strconv"
 	"strings"
+	"time"
 
 	"gopkg.in/urfave/cli.v1"
 
@@ -42,6 +44,7 @@ import (
 	"github.com/MOACChain/xchain/accounts/keystore"
 	"github.com/MOACChain/xchain/consensus/ethash"
 	"github.com/MOACChain/xchain/core"
+	"github.com/MOACChain/xchain/core/contracts"
 	"github.com/MOACChain/xchain/mc"
 	"github.com/MOACChain/xchain/mc/downloader"
 	"github.com/MOACChain/xchain/mc/gasprice"
@@ -58,6 +61,10 @@ import (
 
 const (
 	XchainPassphrace = "xchaindefaultphrace"
+
+	// XchainPasswordEnv is the environment variable holding the xchain
+	// keystore passphrase if --xchainpassword is not given.
+	XchainPasswordEnv = "XCHAIN_PASSWORD"
 )
 
 var (
@@ -140,6 +147,53 @@ var (
 		Usage: "Network identifier (integer, 99=mainnet, 101=testnet, 100=devnet)",
 		Value: mc.DefaultConfig.NetworkId,
 	}
+	DiscoveryRefreshIntervalFlag = cli.DurationFlag{
+		Name:  "discovery.refresh",
+		Usage: "Time between P2P discovery table refreshes (minimum 5s)",
+		Value: time.Hour,
+	}
+	DiscoveryPreferFreshFlag = cli.BoolFlag{
+		Name:  "discovery.preferfresh",
+		Usage: "Prefer recently seen nodes among equally close ones in P2P discovery replies",
+	}
+	DiscoveryReapIntervalFlag = cli.DurationFlag{
+		Name:  "discovery.reap",
+		Usage: "Time between pings of the least recently seen node of each P2P discovery bucket (0 = disabled)",
+		Value: 10 * time.Minute,
+	}
+	DiscoveryReapFailuresFlag = cli.IntFlag{
+		Name:  "discovery.reapfailures",
+		Usage: "Consecutive failed pings after which an idle node is evicted from the P2P discovery table",
+		Value: 3,
+	}
+	DiscoveryMaxLookupsFlag = cli.IntFlag{
+		Name:  "discovery.maxlookups",
+		Usage: "Maximum number of P2P discovery lookups running at the same time",
+		Value: 8,
+	}
+	DiscoveryLookupCacheTTLFlag = cli.DurationFlag{
+		Name:  "discovery.lookupcache",
+		Usage: "Time a P2P discovery lookup result is reused for lookups of the same target (0 = disabled)",
+		Value: 10 * time.Second,
+	}
+	DiscoveryAlienMismatchesFlag = cli.IntFlag{
+		Name:  "discovery.alienmismatches",
+		Usage: "Consecutive network id mismatches after which a P2P discovery node is classified alien",
+		Value: 3,
+	}
+	DiscoveryAlienWindowFlag = cli.DurationFlag{
+		Name:  "discovery.alienwindow",
+		Usage: "Time within which the network id mismatches of a P2P discovery node have to happen",
+		Value: 10 * time.Minute,
+	}
+	DiscoveryMinVersionFlag = cli.Uint64Flag{
+		Name:  "discovery.minversion",
+		Usage: "Lowest P2P discovery protocol version a node has to advertise to be answered (0 = any)",
+	}
+	DiscoveryNetworkIdFlag = cli.Uint64Flag{
+		Name:  "discovery.networkid",
+		Usage: "Network identifier used by P2P discovery to classify nodes (default = networkid)",
+	}
 	TestnetFlag = cli.BoolFlag{
 		Name:  "testnet",
 		Usage: "MOAC test network: pre-configured proof-of-work test network",
@@ -254,6 +308,11 @@ var (
 		Usage: "Megabytes of memory allocated to internal caching (min 16MB / database forced)",
 		Value: 128,
 	}
+	FdLimitFlag = cli.IntFlag{
+		Name:  "fdlimit",
+		Usage: "Maximum number of file descriptors to raise the allowance to, half of them go to the database",
+		Value: 2048,
+	}
 	TrieCacheGenFlag = cli.IntFlag{
 		Name:  "trie-cache-gens",
 		Usage: "Number of trie node generations to keep in memory",
@@ -288,6 +347,18 @@ var (
 		Name:  "extradata",
 		Usage: "Block extra data set by the miner (default = client version)",
 	}
+	MinerQuietUnconfirmedFlag = cli.BoolFlag{
+		Name:  "miner.quietunconfirmed",
+		Usage: "Log a periodic summary instead of every mined block reaching the canonical chain or a side fork",
+	}
+	MinerNotifyFlag = cli.StringFlag{
+		Name:  "miner.notify",
+		Usage: "HTTP URL to POST mined blocks reaching the canonical chain or a side fork to",
+	}
+	MinerRetryUnconfirmedFlag = cli.Uint64Flag{
+		Name:  "miner.retryunconfirmed",
+		Usage: "Blocks past the confirmation depth to retry mined blocks whose header can't be retrieved (0 = report missing right away)",
+	}
 	// Account settings
 	UnlockedAccountFlag = cli.StringFlag{
 		Name:  "unlock",
@@ -301,13 +372,25 @@ var (
 	}
 	XchainPasswordFlag = cli.StringFlag{
 		Name:  "xchainpassword",
-		Usage: "Password for xchain node keystore",
+		Usage: "Password for xchain node keystore (defaults to $XCHAIN_PASSWORD)",
 		Value: XchainPassphrace,
 	}
 	VMEnableDebugFlag = cli.BoolFlag{
 		Name:  "vmdebug",
 		Usage: "Record information useful for VM and contract debugging",
 	}
+	ScsNotifyCallersFlag = cli.StringFlag{
+		Name:  "scs.notifycallers",
+		Usage: "Comma separated whitelisted callers whose SCS notifications are relayed (default = all)",
+	}
+	WhiteListReadOnlyFlag = cli.BoolFlag{
+		Name:  "whitelist.readonly",
+		Usage: "Run whitelist lookups on a read-only view of the state instead of under a snapshot",
+	}
+	WhiteListFailClosedFlag = cli.BoolFlag{
+		Name:  "whitelist.failclosed",
+		Usage: "Treat every caller as not whitelisted while the whitelist contract is not deployed",
+	}
 	// Logging and debug settings
 	MoacStatusURLFlag = cli.StringFlag{
 		Name:  "mcstats",
@@ -422,6 +505,16 @@ var (
 		Usage: "Comma separated enode URLs for P2P v5 discovery bootstrap (light server, light nodes)",
 		Value: "",
 	}
+	SubnetPortFlag = cli.IntFlag{
+		Name:  "subnetport",
+		Usage: "First UDP port for the discovery of subnets, each further subnet takes the next port (0 = subnet listen port)",
+		Value: 0,
+	}
+	DiscoveryTrustedNodesFlag = cli.StringFlag{
+		Name:  "trustednodes",
+		Usage: "Comma separated enode URLs of nodes never classified as alien by P2P discovery",
+		Value: "",
+	}
 	SubnetBootnodesFlag = cli.StringFlag{
 		Name:  "subnetBootnodes",
 		Usage: "Comma separated enode URLs for Subnet P2P discovery bootstrap",
@@ -446,12 +539,16 @@ var (
 	}
 	DiscoveryV5Flag = cli.BoolFlag{
 		Name:  "v5disc",
-		Usage: "Enables the experimental RLPx V5 (Topic Discovery) mechanism",
+		Usage: "Enables the experimental RLPx V5 (Topic Discovery) mechanism, on by default unless --nodiscover (--v5disc=false disables it)",
 	}
 	NetrestrictFlag = cli.StringFlag{
 		Name:  "netrestrict",
 		Usage: "Restricts network communication to the given IP networks (CIDR masks)",
 	}
+	SubnetDisableFlag = cli.BoolFlag{
+		Name:  "subnet.disable",
+		Usage: "Disables the subnet STORE/FINDVALUE discovery messages",
+	}
 
 	// ATM the url is left to the user and deployment to
 	JSpathFlag = cli.StringFlag{
@@ -477,6 +574,19 @@ var (
 		Usage: "vnodeconfig file path",
 		Value: mc.DefaultConfig.VnodeConfigPath,
 	}
+	VnodeProbeFlag = cli.BoolFlag{
+		Name:  "vnodeprobe",
+		Usage: "Check at startup that the configured vnode endpoint accepts TCP connections",
+	}
+	VnodeProbeTimeoutFlag = cli.DurationFlag{
+		Name:  "vnodeprobe.timeout",
+		Usage: "Dial timeout of the vnode endpoint startup check",
+		Value: 3 * time.Second,
+	}
+	VnodeProbeStrictFlag = cli.BoolFlag{
+		Name:  "vnodeprobe.strict",
+		Usage: "Exit when the vnode endpoint startup check fails (implies --vnodeprobe)",
+	}
 	// Vaults config setttings for sentinel service
 	VaultsConfigFlag = cli.StringFlag{
 		Name:  "vaultxconfig",
@@ -562,6 +672,23 @@ func setBootstrapNodes(ctx *cli.Context, cfg *p2p.Config) {
 	}
 }
 
+// setDiscoveryTrustedNodes creates a list of discovery trusted nodes from the
+// command line flags.
+func setDiscoveryTrustedNodes(ctx *cli.Context, cfg *p2p.Config) {
+	if !ctx.GlobalIsSet(DiscoveryTrustedNodesFlag.Name) {
+		return
+	}
+	urls := strings.Split(ctx.GlobalString(DiscoveryTrustedNodesFlag.Name), ",")
+	for _, url := range urls {
+		node, err := discover.ParseNode(url)
+		if err != nil {
+			log.Error("Trusted node URL invalid", "enode", url, "err", err)
+			continue
+		}
+		cfg.DiscoveryTrustedNodes = append(cfg.DiscoveryTrustedNodes, node)
+	}
+}
+
 func setSubnetBootstrapNodes(ctx *cli.Context, cfg *p2p.Config) {
 	urls := params.SubnetBootnodes
 	if ctx.GlobalIsSet(SubnetBootnodesFlag.Name) {
@@ -586,6 +713,60 @@ func setListenAddress(ctx *cli.Context, cfg *p2p.Config) {
 	}
 }
 
+// setSubnetDiscoveryPort sets the first UDP port of the subnet discovery from
+// the command line flags.
+func setSubnetDiscoveryPort(ctx *cli.Context, cfg *p2p.Config) {
+	if ctx.GlobalIsSet(SubnetPortFlag.Name) {
+		cfg.SubnetDiscoveryPort = ctx.GlobalInt(SubnetPortFlag.Name)
+	}
+}
+
+// checkUDPPorts ensures the main discovery, V5 discovery and subnet discovery
+// are not configured to bind the same UDP port. Unset addresses and random
+// ports (0) are ignored.
+func checkUDPPorts(cfg *p2p.Config) error {
+	type service struct {
+		name string
+		addr string
+	}
+	var services []service
+	if !cfg.NoDiscovery {
+		addr := cfg.DiscoveryAddr
+		if addr == "" {
+			addr = cfg.ListenAddr
+		}
+		services = append(services, service{"discovery", addr})
+	}
+	if cfg.DiscoveryV5 {
+		services = append(services, service{"v5 discovery", cfg.DiscoveryV5Addr})
+	}
+	if cfg.SubnetDiscoveryPort != 0 {
+		services = append(services, service{"subnet discovery", fmt.Sprintf(":%d", cfg.SubnetDiscoveryPort)})
+	}
+	used := make(map[int]string)
+	for _, s := range services {
+		if s.addr == "" {
+			continue
+		}
+		_, portStr, err := net.SplitHostPort(s.addr)
+		if err != nil {
+			return fmt.Errorf("invalid %s address %q: %v", s.name, s.addr, err)
+		}
+		port, err := strconv.Atoi(portStr)
+		if err != nil {
+			return fmt.Errorf("invalid %s port %q", s.name, portStr)
+		}
+		if port == 0 {
+			continue
+		}
+		if other, ok := used[port]; ok {
+			return fmt.Errorf("%s and %s both use UDP port %d", other, s.name, port)
+		}
+		used[port] = s.name
+	}
+	return nil
+}
+
 // setDiscoveryV5Address creates a UDP listening address string from set command
 // line flags for the V5 discovery protocol.
 func setDiscoveryV5Address(ctx *cli.Context, cfg *p2p.Config) {
@@ -594,6 +775,20 @@ func setDiscoveryV5Address(ctx *cli.Context, cfg *p2p.Config) {
 	}
 }
 
+// setDiscoveryV5 enables the v5 peer discovery unless it is disabled with
+// --nodiscover. An explicit --v5disc wins in both directions: --v5disc=false
+// disables v5 while keeping v4 discovery, and --v5disc overrides --nodiscover,
+// in which case the latter only disables v4 discovery.
+func setDiscoveryV5(ctx *cli.Context, cfg *p2p.Config) {
+	if ctx.GlobalIsSet(DiscoveryV5Flag.Name) {
+		cfg.DiscoveryV5 = ctx.GlobalBool(DiscoveryV5Flag.Name)
+		return
+	}
+	if !ctx.GlobalBool(NoDiscoverFlag.Name) {
+		cfg.DiscoveryV5 = true
+	}
+}
+
 // setNAT creates a port mapper from command line flags.
 func setNAT(ctx *cli.Context, cfg *p2p.Config) {
 	if ctx.GlobalIsSet(NATFlag.Name) {
@@ -669,18 +864,33 @@ func setIPC(ctx *cli.Context, cfg *node.Config) {
 	}
 }
 
+// File descriptor limit accessors, replaced in tests.
+var (
+	fdRaise    = raiseFdLimit
+	fdLimit    = getFdLimit
+	fdMaxLimit = getFdMaxLimit
+)
+
 // makeDatabaseHandles raises out the number of allowed file handles per process
-// for Moac and returns half of the allowance to assign to the database.
-func makeDatabaseHandles() int {
-	if err := raiseFdLimit(2048); err != nil {
+// for Moac, up to max, and returns half of the allowance to assign to the database.
+func makeDatabaseHandles(max int) int {
+	hard, err := fdMaxLimit()
+	if err != nil {
+		Fatalf("Failed to retrieve file descriptor hard limit: %v", err)
+	}
+	if max > hard {
+		log.Warn("Requested file descriptor allowance above OS limit, clamping", "requested", max, "limit", hard)
+		max = hard
+	}
+	if err := fdRaise(uint64(max)); err != nil {
 		Fatalf("Failed to raise file descriptor allowance: %v", err)
 	}
-	limit, err := getFdLimit()
+	limit, err := fdLimit()
 	if err != nil {
 		Fatalf("Failed to retrieve file descriptor allowance: %v", err)
 	}
-	if limit > 2048 { // cap database file descriptors even if more is available
-		limit = 2048
+	if limit > max { // cap database file descriptors even if more is available
+		limit = max
 	}
 	return limit / 2 // Leave half for networking and other stuff
 }
@@ -704,14 +914,24 @@ func MakeAddress(ks *keystore.KeyStore, account string) (accounts.Account, error
 	return accs[index], nil
 }
 
+// MakeXchainPassphrace returns the xchain keystore passphrase given by the
+// --xchainpassword flag, or by the XCHAIN_PASSWORD environment variable, or
+// the default passphrase if neither is set.
+func MakeXchainPassphrace(ctx *cli.Context) string {
+	if ctx.GlobalIsSet(XchainPasswordFlag.Name) {
+		return ctx.GlobalString(XchainPasswordFlag.Name)
+	}
+	if passphrace, ok := os.LookupEnv(XchainPasswordEnv); ok && passphrace != "" {
+		return passphrace
+	}
+	return XchainPassphrace
+}
+
 func setXchainBase(ctx *cli.Context, cfg *mc.Config) {
 	datadir := MakeDataDir(ctx)
 	keystore.SetXBasePath(datadir)
 
-	passphrace := XchainPassphrace
-	if ctx.GlobalIsSet(XchainPasswordFlag.Name) {
-		passphrace = XchainPasswordFlag.Name
-	}
+	passphrace := MakeXchainPassphrace(ctx)
 	if err := keystore.SaveXPassphrace(passphrace); err != nil {
 		log.Errorf("SavePassphrace() err: %v", err)
 	}
@@ -755,7 +975,29 @@ func setVnodeConfig(ctx *cli.Context, cfg *mc.Config) {
 			cfg.VnodeConfig.VnodePort,
 			cfg.VnodeConfig.VssBaseAddr,
 		)
+		strict := ctx.GlobalBool(VnodeProbeStrictFlag.Name)
+		if !strict && !ctx.GlobalBool(VnodeProbeFlag.Name) {
+			return
+		}
+		timeout := ctx.GlobalDuration(VnodeProbeTimeoutFlag.Name)
+		if err := probeVnode(cfg.VnodeConfig, timeout); err != nil {
+			if strict {
+				Fatalf("Vnode endpoint unreachable: %v", err)
+			}
+			log.Warn("Vnode endpoint unreachable", "err", err)
+		}
+	}
+}
+
+// probeVnode dials the vnode endpoint of the given config over TCP to make
+// sure a misconfigured vnode IP or port is noticed at startup.
+func probeVnode(cfg *vnodeconfig.Configuration, timeout time.Duration) error {
+	addr := net.JoinHostPort(cfg.VnodeIP, cfg.VnodePort)
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return err
 	}
+	return conn.Close()
 }
 
 // setMoacbase retrieves the moacbase either from the directly specified
@@ -766,6 +1008,9 @@ func setMoacbase(ctx *cli.Context, ks *keystore.KeyStore, cfg *mc.Config) {
 		if err != nil {
 			Fatalf("Option %q: %v", MoacbaseFlag.Name, err)
 		}
+		if err := checkMoacbase(ctx, ks, account.Address); err != nil {
+			Fatalf("Option %q: %v", MoacbaseFlag.Name, err)
+		}
 		cfg.Moacbase = account.Address
 		return
 	}
@@ -780,6 +1025,43 @@ func setMoacbase(ctx *cli.Context, ks *keystore.KeyStore, cfg *mc.Config) {
 	}
 }
 
+// checkMoacbase ensures the moacbase is held by the keystore when mining is
+// enabled, otherwise the rewards of mined blocks would be lost for good.
+func checkMoacbase(ctx *cli.Context, ks *keystore.KeyStore, moacbase common.Address) error {
+	if !ctx.GlobalBool(MiningEnabledFlag.Name) || ks.HasAddress(moacbase) {
+		return nil
+	}
+	return fmt.Errorf("moacbase %s is not in the keystore", moacbase.Hex())
+}
+
+// setScsNotifyCallers restricts the callers whose SCS notifications are
+// relayed if set on the command line.
+func setScsNotifyCallers(ctx *cli.Context) {
+	if !ctx.GlobalIsSet(ScsNotifyCallersFlag.Name) {
+		return
+	}
+	callers, err := parseAddressSet(ctx.GlobalString(ScsNotifyCallersFlag.Name))
+	if err != nil {
+		Fatalf("Option %q: %v", ScsNotifyCallersFlag.Name, err)
+	}
+	contracts.ScsNotifyCallers = callers
+}
+
+// parseAddressSet parses a comma separated list of hex addresses.
+func parseAddressSet(list string) (map[common.Address]bool, error) {
+	set := make(map[common.Address]bool)
+	for _, s := range strings.Split(list, ",") {
+		if s = strings.TrimSpace(s); s == "" {
+			continue
+		}
+		if !common.IsHexAddress(s) {
+			return nil, fmt.Errorf("invalid address %q", s)
+		}
+		set[common.HexToAddress(s)] = true
+	}
+	return set, nil
+}
+
 // MakePasswordList reads password lines from the file specified by the global --password flag.
 func MakePasswordList(ctx *cli.Context) []string {
 	path := ctx.GlobalString(PasswordFileFlag.Name)
@@ -806,8 +1088,40 @@ func SetP2PConfig(ctx *cli.Context, cfg *p2p.Config) {
 	setNAT(ctx, cfg)
 	setListenAddress(ctx, cfg)
 	setDiscoveryV5Address(ctx, cfg)
+	setSubnetDiscoveryPort(ctx, cfg)
 	setBootstrapNodes(ctx, cfg)
 	setSubnetBootstrapNodes(ctx, cfg)
+	setDiscoveryTrustedNodes(ctx, cfg)
+
+	if ctx.GlobalIsSet(DiscoveryRefreshIntervalFlag.Name) {
+		cfg.DiscoveryRefreshInterval = ctx.GlobalDuration(DiscoveryRefreshIntervalFlag.Name)
+	}
+	if ctx.GlobalIsSet(DiscoveryPreferFreshFlag.Name) {
+		cfg.DiscoveryPreferFresh = ctx.GlobalBool(DiscoveryPreferFreshFlag.Name)
+	}
+	if ctx.GlobalIsSet(DiscoveryReapIntervalFlag.Name) {
+		interval := ctx.GlobalDuration(DiscoveryReapIntervalFlag.Name)
+		cfg.DiscoveryReapInterval = &interval
+	}
+	if ctx.GlobalIsSet(DiscoveryReapFailuresFlag.Name) {
+		cfg.DiscoveryReapFailures = ctx.GlobalInt(DiscoveryReapFailuresFlag.Name)
+	}
+	if ctx.GlobalIsSet(DiscoveryMaxLookupsFlag.Name) {
+		cfg.DiscoveryMaxLookups = ctx.GlobalInt(DiscoveryMaxLookupsFlag.Name)
+	}
+	if ctx.GlobalIsSet(DiscoveryLookupCacheTTLFlag.Name) {
+		ttl := ctx.GlobalDuration(DiscoveryLookupCacheTTLFlag.Name)
+		cfg.DiscoveryLookupCacheTTL = &ttl
+	}
+	if ctx.GlobalIsSet(DiscoveryAlienMismatchesFlag.Name) {
+		cfg.DiscoveryAlienMismatches = ctx.GlobalInt(DiscoveryAlienMismatchesFlag.Name)
+	}
+	if ctx.GlobalIsSet(DiscoveryAlienWindowFlag.Name) {
+		cfg.DiscoveryAlienWindow = ctx.GlobalDuration(DiscoveryAlienWindowFlag.Name)
+	}
+	if ctx.GlobalIsSet(DiscoveryMinVersionFlag.Name) {
+		cfg.DiscoveryMinVersion = uint(ctx.GlobalUint64(DiscoveryMinVersionFlag.Name))
+	}
 
 	if ctx.GlobalIsSet(MaxPeersFlag.Name) {
 		cfg.MaxPeers = ctx.GlobalInt(MaxPeersFlag.Name)
@@ -819,14 +1133,10 @@ func SetP2PConfig(ctx *cli.Context, cfg *p2p.Config) {
 		cfg.NoDiscovery = true
 	}
 
-	// if we're running a light client or server, force enable the v5 peer discovery
-	// unless it is explicitly disabled with --nodiscover note that explicitly specifying
-	// --v5disc overrides --nodiscover, in which case the later only disables v4 discovery
-	forceV5Discovery := !ctx.GlobalBool(NoDiscoverFlag.Name)
-	if ctx.GlobalIsSet(DiscoveryV5Flag.Name) {
-		cfg.DiscoveryV5 = ctx.GlobalBool(DiscoveryV5Flag.Name)
-	} else if forceV5Discovery {
-		cfg.DiscoveryV5 = true
+	setDiscoveryV5(ctx, cfg)
+
+	if ctx.GlobalBool(SubnetDisableFlag.Name) {
+		cfg.NoSubnet = true
 	}
 
 	if netrestrict := ctx.GlobalString(NetrestrictFlag.Name); netrestrict != "" {
@@ -845,6 +1155,9 @@ func SetP2PConfig(ctx *cli.Context, cfg *p2p.Config) {
 		cfg.NoDiscovery = true
 		cfg.DiscoveryV5 = false
 	}
+	if err := checkUDPPorts(cfg); err != nil {
+		Fatalf("Port collision: %v", err)
+	}
 }
 
 // SetNodeConfig applies node-related command line flags to the config.
@@ -880,6 +1193,9 @@ func SetNodeConfig(ctx *cli.Context, cfg *node.Config) {
 	if ctx.GlobalIsSet(NetworkIdFlag.Name) {
 		cfg.P2P.NetworkId = ctx.GlobalUint64(NetworkIdFlag.Name)
 	}
+	if ctx.GlobalIsSet(DiscoveryNetworkIdFlag.Name) {
+		cfg.P2P.DiscoveryNetworkId = ctx.GlobalUint64(DiscoveryNetworkIdFlag.Name)
+	}
 }
 
 func setGPO(ctx *cli.Context, cfg *gasprice.Config) {
@@ -957,6 +1273,31 @@ func checkExclusive(ctx *cli.Context, flags ...cli.Flag) {
 	}
 }
 
+// NetworkSyncMode is the sync mode of configs leaving the mode to the selected
+// network. SetMoacConfig replaces it by the mode of networkSyncModes.
+const NetworkSyncMode downloader.SyncMode = -1
+
+// networkSyncModes is the default sync mode of each network, used unless
+// --syncmode or --fast is given or the config file sets a mode. Test and dev
+// networks sync fully, so that every block is executed locally for
+// deterministic debugging. Mainnet syncs fast.
+var networkSyncModes = map[string]downloader.SyncMode{
+	"mainnet": downloader.FastSync,
+	"testnet": downloader.FullSync,
+	"devnet":  downloader.FullSync,
+}
+
+// networkName returns the name of the network selected on the command line.
+func networkName(ctx *cli.Context) string {
+	switch {
+	case ctx.GlobalBool(TestnetFlag.Name):
+		return "testnet"
+	case ctx.GlobalBool(DevModeFlag.Name):
+		return "devnet"
+	}
+	return "mainnet"
+}
+
 // SetMoacConfig applies mc-related command line flags to the config.
 func SetMoacConfig(ctx *cli.Context, n *node.Node, cfg *mc.Config) {
 	// Avoid conflicting network flags
@@ -971,12 +1312,21 @@ func SetMoacConfig(ctx *cli.Context, n *node.Node, cfg *mc.Config) {
 	setGPO(ctx, &cfg.GPO)
 	setTxPool(ctx, &cfg.TxPool)
 	setEthash(ctx, cfg)
+	setScsNotifyCallers(ctx)
+	if ctx.GlobalBool(WhiteListReadOnlyFlag.Name) {
+		contracts.WhiteListReadOnly = true
+	}
+	if ctx.GlobalBool(WhiteListFailClosedFlag.Name) {
+		contracts.WhiteListFailOpen = false
+	}
 
 	switch {
 	case ctx.GlobalIsSet(SyncModeFlag.Name):
 		cfg.SyncMode = *GlobalTextMarshaler(ctx, SyncModeFlag.Name).(*downloader.SyncMode)
 	case ctx.GlobalBool(FastSyncFlag.Name):
 		cfg.SyncMode = downloader.FastSync
+	case cfg.SyncMode == NetworkSyncMode:
+		cfg.SyncMode = networkSyncModes[networkName(ctx)]
 	}
 	if ctx.GlobalIsSet(NetworkIdFlag.Name) {
 		cfg.NetworkId = ctx.GlobalUint64(NetworkIdFlag.Name)
@@ -984,7 +1334,7 @@ func SetMoacConfig(ctx *cli.Context, n *node.Node, cfg *mc.Config) {
 	if ctx.GlobalIsSet(CacheFlag.Name) {
 		cfg.DatabaseCache = ctx.GlobalInt(CacheFlag.Name)
 	}
-	cfg.DatabaseHandles = makeDatabaseHandles()
+	cfg.DatabaseHandles = makeDatabaseHandles(ctx.GlobalInt(FdLimitFlag.Name))
 
 	if ctx.GlobalIsSet(MinerThreadsFlag.Name) {
 		cfg.MinerThreads = ctx.GlobalInt(MinerThreadsFlag.Name)
@@ -993,11 +1343,24 @@ func SetMoacConfig(ctx *cli.Context, n *node.Node, cfg *mc.Config) {
 		cfg.DocRoot = ctx.GlobalString(DocRootFlag.Name)
 	}
 	if ctx.GlobalIsSet(ExtraDataFlag.Name) {
-		cfg.ExtraData = []byte(ctx.GlobalString(ExtraDataFlag.Name))
+		extra := []byte(ctx.GlobalString(ExtraDataFlag.Name))
+		if err := validateExtraData(extra); err != nil {
+			Fatalf("Invalid --%s: %v", ExtraDataFlag.Name, err)
+		}
+		cfg.ExtraData = extra
 	}
 	if ctx.GlobalIsSet(GasPriceFlag.Name) {
 		cfg.GasPrice = GlobalBig(ctx, GasPriceFlag.Name)
 	}
+	if ctx.GlobalIsSet(MinerQuietUnconfirmedFlag.Name) {
+		cfg.MinerQuietUnconfirmed = ctx.GlobalBool(MinerQuietUnconfirmedFlag.Name)
+	}
+	if ctx.GlobalIsSet(MinerNotifyFlag.Name) {
+		cfg.MinerNotify = ctx.GlobalString(MinerNotifyFlag.Name)
+	}
+	if ctx.GlobalIsSet(MinerRetryUnconfirmedFlag.Name) {
+		cfg.MinerRetryUnconfirmed = ctx.GlobalUint64(MinerRetryUnconfirmedFlag.Name)
+	}
 	if ctx.GlobalIsSet(VMEnableDebugFlag.Name) {
 		cfg.EnablePreimageRecording = ctx.GlobalBool(VMEnableDebugFlag.Name)
 	}
@@ -1059,14 +1422,35 @@ func RegisterMcStatsService(n *node.Node, url string) {
 
 // SetupNetwork configures the system for either the main net or some test network.
 func SetupNetwork(ctx *cli.Context) {
-	params.TargetGasLimit = new(big.Int).SetUint64(ctx.GlobalUint64(TargetGasLimitFlag.Name))
+	params.TargetGasLimit = targetGasLimit(ctx.GlobalUint64(TargetGasLimitFlag.Name))
+}
+
+// targetGasLimit returns the gas floor miners should target. Values below the
+// protocol minimum would make mined blocks degenerate, the default is used
+// instead.
+func targetGasLimit(limit uint64) *big.Int {
+	target := new(big.Int).SetUint64(limit)
+	if target.Cmp(params.MinGasLimit) < 0 {
+		log.Warn("Target gas limit too low, using the default", "provided", limit, "minimum", params.MinGasLimit, "default", TargetGasLimitFlag.Value)
+		return new(big.Int).SetUint64(TargetGasLimitFlag.Value)
+	}
+	return target
+}
+
+// validateExtraData checks that the block extra-data fits the protocol limit,
+// mined blocks with longer extra-data would be rejected.
+func validateExtraData(extra []byte) error {
+	if uint64(len(extra)) > params.MaximumExtraDataSize {
+		return fmt.Errorf("extra-data too long: %d bytes, the limit is %d", len(extra), params.MaximumExtraDataSize)
+	}
+	return nil
 }
 
 // MakeChainDatabase open an LevelDB using the flags passed to the client and will hard crash if it fails.
 func MakeChainDatabase(ctx *cli.Context, node *node.Node) mcdb.Database {
 	var (
 		cache   = ctx.GlobalInt(CacheFlag.Name)
-		handles = makeDatabaseHandles()
+		handles = makeDatabaseHandles(ctx.GlobalInt(FdLimitFlag.Name))
 	)
 	name := "chaindata"
 	chainDb, err := node.OpenDatabase(name, cache, handles)
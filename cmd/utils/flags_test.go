@@ -0,0 +1,372 @@
+// Copyright 2021 The MOAC-core Authors
+// This file is part of MOAC-core.
+//
+// MOAC-core is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// MOAC-core is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with MOAC-core. If not, see <http://www.gnu.org/licenses/>.
+
+package utils
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/urfave/cli.v1"
+
+	"github.com/MOACChain/MoacLib/common"
+	"github.com/MOACChain/MoacLib/crypto"
+	"github.com/MOACChain/MoacLib/params"
+	"github.com/MOACChain/xchain/accounts/keystore"
+	"github.com/MOACChain/xchain/mc"
+	"github.com/MOACChain/xchain/mc/downloader"
+	"github.com/MOACChain/xchain/node"
+	"github.com/MOACChain/xchain/p2p"
+	vnodeconfig "github.com/MOACChain/xchain/vnode/config"
+)
+
+func TestValidateExtraData(t *testing.T) {
+	if err := validateExtraData([]byte(strings.Repeat("x", int(params.MaximumExtraDataSize)))); err != nil {
+		t.Errorf("extra-data at the limit rejected: %v", err)
+	}
+	if err := validateExtraData([]byte(strings.Repeat("x", 40))); err == nil {
+		t.Error("40 byte extra-data accepted")
+	}
+}
+
+func TestCheckUDPPorts(t *testing.T) {
+	tests := []struct {
+		cfg     p2p.Config
+		collide bool
+	}{
+		{p2p.Config{ListenAddr: ":30333"}, false},
+		{p2p.Config{ListenAddr: ":30333", DiscoveryV5: true, DiscoveryV5Addr: ":30334", SubnetDiscoveryPort: 30340}, false},
+		{p2p.Config{ListenAddr: ":30333", DiscoveryV5: true, DiscoveryV5Addr: ":30334", SubnetDiscoveryPort: 30334}, true},
+		{p2p.Config{ListenAddr: ":30333", SubnetDiscoveryPort: 30333}, true},
+		{p2p.Config{ListenAddr: ":30333", DiscoveryAddr: ":30340", SubnetDiscoveryPort: 30333}, false},
+		{p2p.Config{ListenAddr: ":30333", NoDiscovery: true, SubnetDiscoveryPort: 30333}, false},
+		{p2p.Config{ListenAddr: ":0", DiscoveryV5: true, DiscoveryV5Addr: ":0"}, false},
+		{p2p.Config{ListenAddr: ":30333", DiscoveryV5: true, DiscoveryV5Addr: ":30334"}, false},
+	}
+	for i, tt := range tests {
+		err := checkUDPPorts(&tt.cfg)
+		if tt.collide && err == nil {
+			t.Errorf("test %d: collision not detected", i)
+		}
+		if !tt.collide && err != nil {
+			t.Errorf("test %d: unexpected error: %v", i, err)
+		}
+	}
+	if err := checkUDPPorts(&p2p.Config{ListenAddr: "30333"}); err == nil {
+		t.Error("no error for invalid address")
+	}
+}
+
+func TestSetupNetworkTargetGasLimit(t *testing.T) {
+	defer func(limit *big.Int) { params.TargetGasLimit = limit }(params.TargetGasLimit)
+
+	tests := []struct {
+		arg  string
+		want uint64
+	}{
+		{"0", TargetGasLimitFlag.Value},
+		{"1", TargetGasLimitFlag.Value},
+		{params.MinGasLimit.String(), params.MinGasLimit.Uint64()},
+		{"9000000", 9000000},
+	}
+	for _, tt := range tests {
+		set := flag.NewFlagSet("test", flag.ContinueOnError)
+		TargetGasLimitFlag.Apply(set)
+		if err := set.Parse([]string{"--" + TargetGasLimitFlag.Name, tt.arg}); err != nil {
+			t.Fatal(err)
+		}
+		SetupNetwork(cli.NewContext(nil, set, nil))
+		if params.TargetGasLimit.Uint64() != tt.want {
+			t.Errorf("--%s %s: target gas limit %v, want %d", TargetGasLimitFlag.Name, tt.arg, params.TargetGasLimit, tt.want)
+		}
+	}
+}
+
+func TestParseAddressSet(t *testing.T) {
+	set, err := parseAddressSet("0x0000000000000000000000000000000000001234, 0x0000000000000000000000000000000000005678,")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(set) != 2 || !set[common.HexToAddress("0x1234")] || !set[common.HexToAddress("0x5678")] {
+		t.Errorf("address set mismatch: %v", set)
+	}
+	if _, err := parseAddressSet("0x1234,nonsense"); err == nil {
+		t.Error("invalid address accepted")
+	}
+}
+
+func TestCheckMoacbase(t *testing.T) {
+	dir, err := ioutil.TempDir("", "moacbase-keystore-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ks := keystore.NewPlaintextKeyStore(dir)
+	account, err := ks.NewAccount("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	missing := common.HexToAddress("0x00000000000000000000000000000000deadbeef")
+
+	tests := []struct {
+		mine     bool
+		moacbase common.Address
+		fail     bool
+	}{
+		{true, account.Address, false},
+		{true, missing, true},
+		{false, missing, false},
+	}
+	for i, tt := range tests {
+		set := flag.NewFlagSet("test", flag.ContinueOnError)
+		MiningEnabledFlag.Apply(set)
+		var args []string
+		if tt.mine {
+			args = append(args, "--"+MiningEnabledFlag.Name)
+		}
+		if err := set.Parse(args); err != nil {
+			t.Fatal(err)
+		}
+		err := checkMoacbase(cli.NewContext(nil, set, nil), ks, tt.moacbase)
+		if tt.fail && err == nil {
+			t.Errorf("test %d: moacbase %x missing from the keystore accepted", i, tt.moacbase)
+		}
+		if !tt.fail && err != nil {
+			t.Errorf("test %d: unexpected error: %v", i, err)
+		}
+	}
+}
+
+func TestDumpResolvedConfig(t *testing.T) {
+	priv, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := mc.DefaultConfig
+	cfg.NetworkId = 1234
+	cfg.VnodeConfigPath = "/tmp/vnode.json"
+	cfg.VaultsConfigPath = "/tmp/vaults.json"
+	cfg.XchainId = crypto.PubkeyToAddress(priv.PublicKey)
+	cfg.XchainKey = &keystore.Key{Address: cfg.XchainId, PrivateKey: priv}
+
+	set := flag.NewFlagSet("test", flag.ContinueOnError)
+	XchainPasswordFlag.Apply(set)
+	if err := set.Parse([]string{"--" + XchainPasswordFlag.Name, "s3cr3t-passphrase"}); err != nil {
+		t.Fatal(err)
+	}
+	out, err := DumpResolvedConfig(cli.NewContext(nil, set, nil), &cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var dump map[string]interface{}
+	if err := json.Unmarshal(out, &dump); err != nil {
+		t.Fatalf("dump is not valid JSON: %v\n%s", err, out)
+	}
+	for _, key := range []string{"NetworkId", "TxPool", "GPO", "EthashCacheDir", "VnodeConfigPath", "VaultsConfigPath", "XchainId"} {
+		if _, ok := dump[key]; !ok {
+			t.Errorf("dump is missing %s", key)
+		}
+	}
+	if id, _ := dump["NetworkId"].(float64); id != 1234 {
+		t.Errorf("NetworkId = %v, want 1234", dump["NetworkId"])
+	}
+	if dump["VnodeConfigPath"] != cfg.VnodeConfigPath || dump["VaultsConfigPath"] != cfg.VaultsConfigPath {
+		t.Errorf("config paths = %v, %v", dump["VnodeConfigPath"], dump["VaultsConfigPath"])
+	}
+	if dump["XchainKey"] != redactedValue {
+		t.Errorf("XchainKey = %v, want %q", dump["XchainKey"], redactedValue)
+	}
+	if dump["XchainPassword"] != redactedValue {
+		t.Errorf("XchainPassword = %v, want %q", dump["XchainPassword"], redactedValue)
+	}
+	if _, ok := dump["Genesis"]; ok {
+		t.Error("dump contains the genesis block")
+	}
+	for _, secret := range []string{"s3cr3t-passphrase", fmt.Sprintf("%x", crypto.FromECDSA(priv)), priv.D.String()} {
+		if strings.Contains(string(out), secret) {
+			t.Errorf("dump leaks secret %q", secret)
+		}
+	}
+}
+
+func TestDumpResolvedConfigEnvPassword(t *testing.T) {
+	old, had := os.LookupEnv(XchainPasswordEnv)
+	defer func() {
+		if had {
+			os.Setenv(XchainPasswordEnv, old)
+		} else {
+			os.Unsetenv(XchainPasswordEnv)
+		}
+	}()
+	os.Setenv(XchainPasswordEnv, "env-s3cr3t")
+
+	set := flag.NewFlagSet("test", flag.ContinueOnError)
+	XchainPasswordFlag.Apply(set)
+	cfg := mc.DefaultConfig
+	out, err := DumpResolvedConfig(cli.NewContext(nil, set, nil), &cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var dump map[string]interface{}
+	if err := json.Unmarshal(out, &dump); err != nil {
+		t.Fatalf("dump is not valid JSON: %v\n%s", err, out)
+	}
+	if dump["XchainPassword"] != redactedValue {
+		t.Errorf("XchainPassword = %v, want %q", dump["XchainPassword"], redactedValue)
+	}
+	if strings.Contains(string(out), "env-s3cr3t") {
+		t.Error("dump leaks the passphrase from the environment")
+	}
+}
+
+func TestProbeVnode(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	host, port, _ := net.SplitHostPort(ln.Addr().String())
+	cfg := &vnodeconfig.Configuration{VnodeIP: host, VnodePort: port}
+	if err := probeVnode(cfg, time.Second); err != nil {
+		t.Errorf("probe of open listener failed: %v", err)
+	}
+
+	// Grab a free port and release it again so nothing is listening there.
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, cfg.VnodePort, _ = net.SplitHostPort(closed.Addr().String())
+	closed.Close()
+	if err := probeVnode(cfg, time.Second); err == nil {
+		t.Error("probe of closed port succeeded")
+	}
+}
+
+func TestSetMoacConfigNetworkSyncMode(t *testing.T) {
+	datadir, err := ioutil.TempDir("", "xchain-syncmode-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(datadir)
+
+	stack, err := node.New(&node.Config{DataDir: datadir, UseLightweightKDF: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		args   []string
+		config downloader.SyncMode // sync mode of the config file
+		want   downloader.SyncMode
+	}{
+		{nil, NetworkSyncMode, downloader.FastSync},
+		{[]string{"--" + TestnetFlag.Name}, NetworkSyncMode, downloader.FullSync},
+		{[]string{"--" + DevModeFlag.Name}, NetworkSyncMode, downloader.FullSync},
+		{[]string{"--" + TestnetFlag.Name, "--" + FastSyncFlag.Name}, NetworkSyncMode, downloader.FastSync},
+		{[]string{"--" + DevModeFlag.Name, "--" + SyncModeFlag.Name, "fast"}, NetworkSyncMode, downloader.FastSync},
+		// A mode set in the config file is kept.
+		{[]string{"--" + TestnetFlag.Name}, downloader.FastSync, downloader.FastSync},
+		{nil, downloader.FullSync, downloader.FullSync},
+		{[]string{"--" + DevModeFlag.Name, "--" + SyncModeFlag.Name, "fast"}, downloader.FullSync, downloader.FastSync},
+	}
+	for _, tt := range tests {
+		set := flag.NewFlagSet("test", flag.ContinueOnError)
+		for _, f := range []cli.Flag{DataDirFlag, FdLimitFlag, TestnetFlag, DevModeFlag, FastSyncFlag, SyncModeFlag} {
+			f.Apply(set)
+		}
+		args := append([]string{"--" + DataDirFlag.Name, datadir}, tt.args...)
+		if err := set.Parse(args); err != nil {
+			t.Fatal(err)
+		}
+		cfg := mc.DefaultConfig
+		cfg.SyncMode = tt.config
+		SetMoacConfig(cli.NewContext(nil, set, nil), stack, &cfg)
+		if cfg.SyncMode != tt.want {
+			t.Errorf("%v, config %v: sync mode %v, want %v", tt.args, tt.config, cfg.SyncMode, tt.want)
+		}
+	}
+}
+
+func TestSetDiscoveryV5(t *testing.T) {
+	tests := []struct {
+		args   []string
+		wantV5 bool
+	}{
+		{nil, true},
+		{[]string{"--" + DiscoveryV5Flag.Name + "=false"}, false},
+		{[]string{"--" + NoDiscoverFlag.Name}, false},
+		{[]string{"--" + NoDiscoverFlag.Name, "--" + DiscoveryV5Flag.Name}, true},
+	}
+	for _, tt := range tests {
+		set := flag.NewFlagSet("test", flag.ContinueOnError)
+		for _, f := range []cli.Flag{NoDiscoverFlag, DiscoveryV5Flag} {
+			f.Apply(set)
+		}
+		if err := set.Parse(tt.args); err != nil {
+			t.Fatal(err)
+		}
+		cfg := p2p.Config{ListenAddr: ":30333"}
+		setDiscoveryV5(cli.NewContext(nil, set, nil), &cfg)
+		if cfg.DiscoveryV5 != tt.wantV5 {
+			t.Errorf("%v: v5 discovery %t, want %t", tt.args, cfg.DiscoveryV5, tt.wantV5)
+		}
+		// The v5 setting never affects v4 discovery.
+		if cfg.NoDiscovery {
+			t.Errorf("%v: v4 discovery disabled", tt.args)
+		}
+	}
+}
+
+func TestMakeXchainPassphraceEnv(t *testing.T) {
+	old, had := os.LookupEnv(XchainPasswordEnv)
+	defer func() {
+		if had {
+			os.Setenv(XchainPasswordEnv, old)
+		} else {
+			os.Unsetenv(XchainPasswordEnv)
+		}
+	}()
+
+	set := flag.NewFlagSet("test", flag.ContinueOnError)
+	XchainPasswordFlag.Apply(set)
+	ctx := cli.NewContext(nil, set, nil)
+
+	os.Unsetenv(XchainPasswordEnv)
+	if have := MakeXchainPassphrace(ctx); have != XchainPassphrace {
+		t.Errorf("without env: have %q, want default %q", have, XchainPassphrace)
+	}
+	os.Setenv(XchainPasswordEnv, "from-env")
+	if have := MakeXchainPassphrace(ctx); have != "from-env" {
+		t.Errorf("with env: have %q, want %q", have, "from-env")
+	}
+
+	// The flag takes precedence over the environment.
+	if err := set.Parse([]string{"--" + XchainPasswordFlag.Name, "from-flag"}); err != nil {
+		t.Fatalf("can't parse flags: %v", err)
+	}
+	if have := MakeXchainPassphrace(ctx); have != "from-flag" {
+		t.Errorf("with flag: have %q, want %q", have, "from-flag")
+	}
+}
@@ -255,10 +255,7 @@ func accountCreateX(ctx *cli.Context) error {
 	keystore.SetXBasePath(datadir)
 
 	// prepare passphrace
-	passphrace := utils.XchainPassphrace
-	if ctx.GlobalIsSet(utils.XchainPasswordFlag.Name) {
-		passphrace = utils.XchainPasswordFlag.Name
-	}
+	passphrace := utils.MakeXchainPassphrace(ctx)
 	if err := keystore.SaveXPassphrace(passphrace); err != nil {
 		log.Errorf("SavePassphrace() err: %v", err)
 	}
@@ -41,15 +41,19 @@ var (
 		Name:        "dumpconfig",
 		Usage:       "Show configuration values",
 		ArgsUsage:   "",
-		Flags:       append(nodeFlags, rpcFlags...),
+		Flags:       append(append([]cli.Flag{resolvedConfigFlag}, nodeFlags...), rpcFlags...),
 		Category:    "MISCELLANEOUS COMMANDS",
-		Description: `The dumpconfig command shows configuration values.`,
+		Description: `The dumpconfig command shows configuration values, or the resolved mc configuration as redacted JSON with --resolved.`,
 	}
 
 	configFileFlag = cli.StringFlag{
 		Name:  "config",
 		Usage: "TOML configuration file",
 	}
+	resolvedConfigFlag = cli.BoolFlag{
+		Name:  "resolved",
+		Usage: "Show the resolved mc configuration as JSON with secrets redacted",
+	}
 )
 
 // These settings ensure that TOML keys use the same names as Go struct fields.
@@ -113,6 +117,8 @@ func makeNodeFromConfig(ctx *cli.Context) (*node.Node, moacConfig) {
 		Mc:   mc.DefaultConfig,
 		Node: defaultNodeConfig(),
 	}
+	// The sync mode follows the network unless the config file sets it.
+	cfg.Mc.SyncMode = utils.NetworkSyncMode
 
 	// Load config file.
 	if file := ctx.GlobalString(configFileFlag.Name); file != "" {
@@ -158,6 +164,15 @@ func makeFullNode(ctx *cli.Context) *node.Node {
 // dumpConfig is the dumpconfig command.
 func dumpConfig(ctx *cli.Context) error {
 	_, cfg := makeNodeFromConfig(ctx)
+	if ctx.Bool(resolvedConfigFlag.Name) {
+		out, err := utils.DumpResolvedConfig(ctx, &cfg.Mc)
+		if err != nil {
+			return err
+		}
+		os.Stdout.Write(out)
+		io.WriteString(os.Stdout, "\n")
+		return nil
+	}
 	comment := ""
 
 	if cfg.Mc.Genesis != nil {
@@ -63,6 +63,8 @@ var (
 		utils.BootnodesV4Flag,
 		utils.BootnodesV5Flag,
 		utils.SubnetBootnodesFlag,
+		utils.DiscoveryTrustedNodesFlag,
+		utils.SubnetPortFlag,
 		utils.DataDirFlag,
 		utils.KeyStoreDirFlag,
 		utils.NoUSBFlag,
@@ -85,6 +87,7 @@ var (
 		utils.FastSyncFlag,
 		utils.SyncModeFlag,
 		utils.CacheFlag,
+		utils.FdLimitFlag,
 		utils.TrieCacheGenFlag,
 		utils.ListenPortFlag,
 		utils.MaxPeersFlag,
@@ -94,16 +97,33 @@ var (
 		utils.MinerThreadsFlag,
 		utils.MiningEnabledFlag,
 		utils.TargetGasLimitFlag,
+		utils.MinerQuietUnconfirmedFlag,
+		utils.MinerNotifyFlag,
+		utils.MinerRetryUnconfirmedFlag,
 		utils.NATFlag,
 		utils.NoDiscoverFlag,
 		utils.DiscoveryV5Flag,
 		utils.NetrestrictFlag,
+		utils.SubnetDisableFlag,
 		utils.NodeKeyFileFlag,
 		utils.NodeKeyHexFlag,
 		utils.DevModeFlag,
 		utils.TestnetFlag,
 		utils.VMEnableDebugFlag,
+		utils.ScsNotifyCallersFlag,
+		utils.WhiteListReadOnlyFlag,
+		utils.WhiteListFailClosedFlag,
 		utils.NetworkIdFlag,
+		utils.DiscoveryNetworkIdFlag,
+		utils.DiscoveryRefreshIntervalFlag,
+		utils.DiscoveryPreferFreshFlag,
+		utils.DiscoveryReapIntervalFlag,
+		utils.DiscoveryReapFailuresFlag,
+		utils.DiscoveryMaxLookupsFlag,
+		utils.DiscoveryLookupCacheTTLFlag,
+		utils.DiscoveryAlienMismatchesFlag,
+		utils.DiscoveryAlienWindowFlag,
+		utils.DiscoveryMinVersionFlag,
 		utils.RPCCORSDomainFlag,
 		utils.MoacStatusURLFlag,
 		utils.MetricsEnabledFlag,
@@ -131,6 +151,9 @@ var (
 
 	vnodeConfigFlags = []cli.Flag{
 		utils.VnodeConfigFlag,
+		utils.VnodeProbeFlag,
+		utils.VnodeProbeTimeoutFlag,
+		utils.VnodeProbeStrictFlag,
 	}
 
 	vaultsConfigFlags = []cli.Flag{
@@ -108,6 +108,7 @@ var AppHelpFlagGroups = []flagGroup{
 		Name: "PERFORMANCE TUNING",
 		Flags: []cli.Flag{
 			utils.CacheFlag,
+			utils.FdLimitFlag,
 			utils.TrieCacheGenFlag,
 		},
 	},
@@ -145,6 +146,8 @@ var AppHelpFlagGroups = []flagGroup{
 			utils.BootnodesV4Flag,
 			utils.BootnodesV5Flag,
 			utils.SubnetBootnodesFlag,
+			utils.DiscoveryTrustedNodesFlag,
+			utils.SubnetPortFlag,
 			utils.ListenPortFlag,
 			utils.MaxPeersFlag,
 			utils.MaxPendingPeersFlag,
@@ -152,6 +155,17 @@ var AppHelpFlagGroups = []flagGroup{
 			utils.NoDiscoverFlag,
 			utils.DiscoveryV5Flag,
 			utils.NetrestrictFlag,
+			utils.DiscoveryNetworkIdFlag,
+			utils.DiscoveryRefreshIntervalFlag,
+			utils.DiscoveryPreferFreshFlag,
+			utils.DiscoveryReapIntervalFlag,
+			utils.DiscoveryReapFailuresFlag,
+			utils.DiscoveryMaxLookupsFlag,
+			utils.DiscoveryLookupCacheTTLFlag,
+			utils.DiscoveryAlienMismatchesFlag,
+			utils.DiscoveryAlienWindowFlag,
+			utils.DiscoveryMinVersionFlag,
+			utils.SubnetDisableFlag,
 			utils.NodeKeyFileFlag,
 			utils.NodeKeyHexFlag,
 		},
@@ -165,6 +179,9 @@ var AppHelpFlagGroups = []flagGroup{
 			utils.TargetGasLimitFlag,
 			utils.GasPriceFlag,
 			utils.ExtraDataFlag,
+			utils.MinerQuietUnconfirmedFlag,
+			utils.MinerNotifyFlag,
+			utils.MinerRetryUnconfirmedFlag,
 		},
 	},
 	{
@@ -178,6 +195,9 @@ var AppHelpFlagGroups = []flagGroup{
 		Name: "VIRTUAL MACHINE",
 		Flags: []cli.Flag{
 			utils.VMEnableDebugFlag,
+			utils.ScsNotifyCallersFlag,
+			utils.WhiteListReadOnlyFlag,
+			utils.WhiteListFailClosedFlag,
 		},
 	},
 	{
@@ -0,0 +1,89 @@
+// Copyright 2021 The MOAC-core Authors
+// This file is part of the MOAC-core library.
+//
+// The MOAC-core library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The MOAC-core library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the MOAC-core library. If not, see <http://www.gnu.org/licenses/>.
+
+package contracts
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/MOACChain/MoacLib/common"
+	"github.com/MOACChain/MoacLib/params"
+)
+
+// blake2FVector builds an EIP-152 test input compressing "abc" with the
+// given round count and final flag.
+func blake2FVector(rounds string, final string) []byte {
+	return common.FromHex(rounds +
+		"48c9bdf267e6096a3ba7ca8485ae67bb2bf894fe72f36e3cf1361d5f3af54fa5d182e6ad7f520e511f6c3e2b8c68059b6bbd41fbabd9831f79217e1319cde05b" +
+		"6162630000000000000000000000000000000000000000000000000000000000" +
+		"0000000000000000000000000000000000000000000000000000000000000000" +
+		"0000000000000000000000000000000000000000000000000000000000000000" +
+		"0000000000000000000000000000000000000000000000000000000000000000" +
+		"0300000000000000" + "0000000000000000" + final)
+}
+
+func TestBlake2F(t *testing.T) {
+	config := params.TestnetChainConfig
+	p, ok := GetInstance().PrecompiledContractsByBlock(config.EnableFuxiPrecompiled, config)[common.BytesToAddress([]byte{71})]
+	if !ok {
+		t.Fatal("blake2F precompile not active at the Fuxi fork")
+	}
+	tests := []struct {
+		input []byte
+		gas   uint64
+		want  string
+	}{
+		{blake2FVector("00000000", "01"), 0, "08c9bcf367e6096a3ba7ca8485ae67bb2bf894fe72f36e3cf1361d5f3af54fa5d282e6ad7f520e511f6c3e2b8c68059b9442be0454267ce079217e1319cde05b"},
+		{blake2FVector("0000000c", "01"), 12, "ba80a53f981c4d0d6a2797b69f12f6e94c212f14685ac4b74b12bb6fdbffa2d17d87c5392aab792dc252d5de4533cc9518d38aa8dbf1925ab92386edd4009923"},
+		{blake2FVector("0000000c", "00"), 12, "75ab69d3190a562c51aef8d88f1c2775876944407270c42c9844252c26d2875298743e7f6d5ea2f2d3e8d226039cd31b4e426ac4f2d3d666a610c2116fde4735"},
+		{blake2FVector("00000001", "01"), 1, "b63a380cb2897d521994a85234ee2c181b5f844d2c624c002677e9703449d2fba551b3a8333bcdf5f2f7e08993d53923de3d64fcc68c034e717b9293fed7a421"},
+	}
+	for i, tt := range tests {
+		if gas := p.RequiredGas(tt.input); gas != tt.gas {
+			t.Errorf("test %d: gas mismatch: have %d, want %d", i, gas, tt.gas)
+		}
+		ret, err := p.Run(nil, 0, nil, tt.input, nil)
+		if err != nil {
+			t.Errorf("test %d: unexpected error: %v", i, err)
+			continue
+		}
+		if want := common.FromHex(tt.want); !bytes.Equal(ret, want) {
+			t.Errorf("test %d: result mismatch:\nhave %x\nwant %x", i, ret, want)
+		}
+	}
+}
+
+func TestBlake2FInvalidInput(t *testing.T) {
+	p := &blake2F{}
+	valid := blake2FVector("0000000c", "01")
+
+	if _, err := p.Run(nil, 0, nil, nil, nil); err != errBlake2FInvalidInputLength {
+		t.Errorf("empty input: have %v, want %v", err, errBlake2FInvalidInputLength)
+	}
+	if _, err := p.Run(nil, 0, nil, valid[1:], nil); err != errBlake2FInvalidInputLength {
+		t.Errorf("short input: have %v, want %v", err, errBlake2FInvalidInputLength)
+	}
+	if _, err := p.Run(nil, 0, nil, append(valid, 0), nil); err != errBlake2FInvalidInputLength {
+		t.Errorf("long input: have %v, want %v", err, errBlake2FInvalidInputLength)
+	}
+	if gas := p.RequiredGas(valid[1:]); gas != 0 {
+		t.Errorf("short input gas mismatch: have %d, want 0", gas)
+	}
+	if _, err := p.Run(nil, 0, nil, blake2FVector("0000000c", "02"), nil); err != errBlake2FInvalidFinalFlag {
+		t.Errorf("bad final flag: have %v, want %v", err, errBlake2FInvalidFinalFlag)
+	}
+}
@@ -0,0 +1,125 @@
+// Copyright 2021 The MOAC-core Authors
+// This file is part of the MOAC-core library.
+//
+// The MOAC-core library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The MOAC-core library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the MOAC-core library. If not, see <http://www.gnu.org/licenses/>.
+
+package contracts
+
+import (
+	"crypto/sha256"
+	"errors"
+	"math/big"
+
+	"github.com/MOACChain/MoacLib/crypto/bls12381"
+)
+
+var (
+	errBLSNoPubkeys = errors.New("no public keys to aggregate")
+
+	// blsHashDomain separates the message hashes of the relayer quorum from
+	// other uses of the hash to curve below.
+	blsHashDomain = []byte("XCHAIN-RELAYER-BLS12381G2")
+
+	// blsModulus is the base field modulus p of BLS12-381.
+	blsModulus, _ = new(big.Int).SetString("1a0111ea397fe69a4b1ba7b6434bacd764774b84f38512bf6730d2a0f6b0f6241eabfffeb153ffffb9feffffffffaaab", 16)
+)
+
+// HashToG2 maps a message to a point of G2. Relayers have to sign this point
+// for VerifyAggregateBLS to accept their signatures.
+func HashToG2(message []byte) (*bls12381.PointG2, error) {
+	g := bls12381.NewG2()
+	r := g.Zero()
+	for i := byte(0); i < 2; i++ {
+		// Each map takes an element c0 + c1*u of the quadratic extension
+		// field, encoded as c1 || c0 like the MapG2 precompile does.
+		fe := make([]byte, 96)
+		copy(fe[48:], hashToFieldElement(message, 2*i))
+		copy(fe[:48], hashToFieldElement(message, 2*i+1))
+
+		p, err := g.MapToCurve(fe)
+		if err != nil {
+			return nil, err
+		}
+		g.Add(r, r, p)
+	}
+	return r, nil
+}
+
+// hashToFieldElement derives the 48 byte base field element number index from
+// the message. 64 bytes of hash output are reduced, keeping the bias negligible.
+func hashToFieldElement(message []byte, index byte) []byte {
+	var digest []byte
+	for half := byte(0); half < 2; half++ {
+		h := sha256.New()
+		h.Write(blsHashDomain)
+		h.Write([]byte{index, half})
+		h.Write(message)
+		digest = h.Sum(digest)
+	}
+	fe := new(big.Int).SetBytes(digest)
+	fe.Mod(fe, blsModulus)
+
+	out := make([]byte, 48)
+	b := fe.Bytes()
+	copy(out[48-len(b):], b)
+	return out
+}
+
+// VerifyAggregateBLS checks an aggregate BLS12-381 signature of the given
+// signers over message, so that relayers can validate a quorum signature
+// before submitting it. Public keys are 128 byte G1 points and the signature
+// is a 256 byte G2 point, encoded as for the EIP-2537 precompiles. The message
+// is mapped to G2 with HashToG2.
+func VerifyAggregateBLS(pubkeys [][]byte, message []byte, aggSig []byte) (bool, error) {
+	if len(pubkeys) == 0 {
+		return false, errBLSNoPubkeys
+	}
+	e := bls12381.NewPairingEngine()
+	g1, g2 := e.G1, e.G2
+
+	// Aggregate the public keys
+	aggPub := g1.Zero()
+	for _, pubkey := range pubkeys {
+		if err := requireExactLen(pubkey, bls12381G1PointLength, errBLS12381InvalidInputLength); err != nil {
+			return false, err
+		}
+		p, err := g1.DecodePoint(pubkey)
+		if err != nil {
+			return false, err
+		}
+		if !g1.InCorrectSubgroup(p) {
+			return false, errBLS12381G1PointSubgroup
+		}
+		g1.Add(aggPub, aggPub, p)
+	}
+	// Decode the signature
+	if err := requireExactLen(aggSig, bls12381G2PointLength, errBLS12381InvalidInputLength); err != nil {
+		return false, err
+	}
+	sig, err := g2.DecodePoint(aggSig)
+	if err != nil {
+		return false, err
+	}
+	if !g2.InCorrectSubgroup(sig) {
+		return false, errBLS12381G2PointSubgroup
+	}
+	h, err := HashToG2(message)
+	if err != nil {
+		return false, err
+	}
+	// e(aggPub, H(m)) == e(g1, sig)
+	e.AddPair(aggPub, h)
+	e.AddPairInv(g1.One(), sig)
+	return e.Check(), nil
+}
@@ -0,0 +1,68 @@
+// Copyright 2021 The MOAC-core Authors
+// This file is part of the MOAC-core library.
+//
+// The MOAC-core library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The MOAC-core library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the MOAC-core library. If not, see <http://www.gnu.org/licenses/>.
+
+package contracts
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/MOACChain/MoacLib/crypto/bls12381"
+)
+
+func TestVerifyAggregateBLS(t *testing.T) {
+	var (
+		g1      = bls12381.NewG1()
+		g2      = bls12381.NewG2()
+		message = []byte("vault event batch")
+		pubkeys [][]byte
+		aggSig  = g2.Zero()
+	)
+	h, err := HashToG2(message)
+	if err != nil {
+		t.Fatalf("can't hash message: %v", err)
+	}
+	for _, secret := range []int64{11, 12345, 987654321} {
+		sk := big.NewInt(secret)
+
+		pub := g1.New()
+		g1.MulScalar(pub, g1.One(), sk)
+		pubkeys = append(pubkeys, g1.EncodePoint(pub))
+
+		sig := g2.New()
+		g2.MulScalar(sig, h, sk)
+		g2.Add(aggSig, aggSig, sig)
+	}
+	encSig := g2.EncodePoint(aggSig)
+
+	if ok, err := VerifyAggregateBLS(pubkeys, message, encSig); err != nil || !ok {
+		t.Fatalf("valid aggregate rejected: ok %v, err %v", ok, err)
+	}
+	// A different message must not verify.
+	if ok, err := VerifyAggregateBLS(pubkeys, []byte("tampered batch"), encSig); err != nil || ok {
+		t.Errorf("tampered message accepted: ok %v, err %v", ok, err)
+	}
+	// Neither must a quorum with a signer missing.
+	if ok, err := VerifyAggregateBLS(pubkeys[1:], message, encSig); err != nil || ok {
+		t.Errorf("incomplete quorum accepted: ok %v, err %v", ok, err)
+	}
+	if _, err := VerifyAggregateBLS(pubkeys, message, encSig[:128]); err != errBLS12381InvalidInputLength {
+		t.Errorf("short signature: have %v, want %v", err, errBLS12381InvalidInputLength)
+	}
+	if _, err := VerifyAggregateBLS(nil, message, encSig); err != errBLSNoPubkeys {
+		t.Errorf("no signers: have %v, want %v", err, errBLSNoPubkeys)
+	}
+}
@@ -20,7 +20,9 @@ import (
 	"bytes"
 	"crypto/sha256"
 	"errors"
+	"fmt"
 	"math/big"
+	"sort"
 
 	"sync"
 
@@ -32,8 +34,10 @@ import (
 	"github.com/MOACChain/MoacLib/crypto/bls12381"
 	"github.com/MOACChain/MoacLib/crypto/bn256"
 	"github.com/MOACChain/MoacLib/log"
+	"github.com/MOACChain/MoacLib/metrics"
 	"github.com/MOACChain/MoacLib/params"
 	"github.com/MOACChain/MoacLib/vm"
+	gometrics "github.com/rcrowley/go-metrics"
 	"golang.org/x/crypto/ripemd160"
 )
 
@@ -67,9 +71,50 @@ var (
 	removeFuncHash            = "21b04c93"
 )
 
+// Input lengths of the precompiles. Precompiles taking a list of items
+// require a non-empty multiple of the item length.
+const (
+	ecRecoverInputLength                = 128
+	checkShardValidInputLength          = 100
+	localShardCheckAndEnrollInputLength = 132
+
+	bls12381FieldElementLength = 64  // padded encoding of a base field element
+	bls12381G1PointLength      = 128 // encoding of a G1 point
+	bls12381G2PointLength      = 256 // encoding of a G2 point
+	bls12381ScalarLength       = 32  // encoding of a scalar
+
+	bls12381G1AddInputLength     = 2 * bls12381G1PointLength
+	bls12381G1MulInputLength     = bls12381G1PointLength + bls12381ScalarLength
+	bls12381G2AddInputLength     = 2 * bls12381G2PointLength
+	bls12381G2MulInputLength     = bls12381G2PointLength + bls12381ScalarLength
+	bls12381PairingInputLength   = bls12381G1PointLength + bls12381G2PointLength
+	bls12381MapG1InputLength     = bls12381FieldElementLength
+	bls12381MapG2InputLength     = 2 * bls12381FieldElementLength
+	bls12381G1MultiExpItemLength = bls12381G1MulInputLength
+	bls12381G2MultiExpItemLength = bls12381G2MulInputLength
+)
+
+// requireExactLen returns err unless the input is exactly want bytes long.
+func requireExactLen(input []byte, want int, err error) error {
+	if len(input) != want {
+		return err
+	}
+	return nil
+}
+
+// requireMultipleLen returns err unless the input is a non-empty multiple of
+// item bytes long.
+func requireMultipleLen(input []byte, item int, err error) error {
+	if len(input) == 0 || len(input)%item != 0 {
+		return err
+	}
+	return nil
+}
+
 var mu sync.Mutex
 
 type PrecompiledContracts struct {
+	systemDepth int // nesting of unmetered system contract calls
 }
 
 var instance *PrecompiledContracts
@@ -145,16 +190,17 @@ var precompiledContractsByzantium = map[common.Address]vm.PrecompiledContract{
 }
 
 // PrecompiledContractsFuxi contains the set of pre-compiled bls12381
-// contracts specified in EIP-2537.
+// contracts specified in EIP-2537, with the bn256 precompiles priced as
+// specified in EIP-1108.
 var precompiledContractsFuxi = map[common.Address]vm.PrecompiledContract{
 	common.BytesToAddress([]byte{1}):  &ecrecover{},
 	common.BytesToAddress([]byte{2}):  &sha256hash{},
 	common.BytesToAddress([]byte{3}):  &ripemd160hash{},
 	common.BytesToAddress([]byte{4}):  &dataCopy{},
 	common.BytesToAddress([]byte{5}):  &bigModExp{},
-	common.BytesToAddress([]byte{6}):  &bn256Add{},
-	common.BytesToAddress([]byte{7}):  &bn256ScalarMul{},
-	common.BytesToAddress([]byte{8}):  &bn256Pairing{},
+	common.BytesToAddress([]byte{6}):  &bn256AddIstanbul{},
+	common.BytesToAddress([]byte{7}):  &bn256ScalarMulIstanbul{},
+	common.BytesToAddress([]byte{8}):  &bn256PairingIstanbul{},
 	common.BytesToAddress([]byte{9}):  &localShardCheckAndEnroll{},
 	common.BytesToAddress([]byte{10}): &checkShardValid{},
 	common.BytesToAddress([]byte{11}): &queryContract{},
@@ -170,6 +216,9 @@ var precompiledContractsFuxi = map[common.Address]vm.PrecompiledContract{
 	common.BytesToAddress([]byte{66}): &bls12381Pairing{},
 	common.BytesToAddress([]byte{67}): &bls12381MapG1{},
 	common.BytesToAddress([]byte{68}): &bls12381MapG2{},
+	common.BytesToAddress([]byte{69}): &chainID{},
+	common.BytesToAddress([]byte{70}): &batchEcrecover{},
+	common.BytesToAddress([]byte{71}): &blake2F{},
 	//system contract
 	systemContractEntryAddrV1: &systemContract{},
 }
@@ -194,6 +243,42 @@ func (pc *PrecompiledContracts) PrecompiledContractsByBlock(blockNumber *big.Int
 	}
 }
 
+// RequiredGasAt returns the gas the precompiled contract at addr, as active
+// at the given block, charges for the input. The bool is false if addr is
+// not a precompiled contract at that block.
+func (pc *PrecompiledContracts) RequiredGasAt(blockNumber *big.Int, cfg *params.ChainConfig, addr common.Address, input []byte) (uint64, bool) {
+	p, ok := pc.PrecompiledContractsByBlock(blockNumber, cfg)[addr]
+	if !ok {
+		return 0, false
+	}
+	return p.RequiredGas(input), true
+}
+
+// InactivePrecompileAddresses returns the addresses that are precompiled
+// contracts in some fork but not at the given block, ordered by address.
+func (pc *PrecompiledContracts) InactivePrecompileAddresses(blockNumber *big.Int, cfg *params.ChainConfig) []common.Address {
+	active := pc.PrecompiledContractsByBlock(blockNumber, cfg)
+	seen := make(map[common.Address]bool)
+	var inactive []common.Address
+	for _, set := range []map[common.Address]vm.PrecompiledContract{
+		precompiledContractsPangu,
+		precompiledContractsByzantium,
+		precompiledContractsFuxi,
+	} {
+		for addr := range set {
+			if _, ok := active[addr]; ok || seen[addr] {
+				continue
+			}
+			seen[addr] = true
+			inactive = append(inactive, addr)
+		}
+	}
+	sort.Slice(inactive, func(i, j int) bool {
+		return bytes.Compare(inactive[i][:], inactive[j][:]) < 0
+	})
+	return inactive
+}
+
 func (pc *PrecompiledContracts) SystemContractCallAddr() common.Address {
 	return systemContractCallAddr
 }
@@ -213,12 +298,121 @@ func (pc *PrecompiledContracts) RunPrecompiledContract(evm *vm.EVM, snapshot int
 	gas := p.RequiredGas(input)
 	if contract.UseGas(gas) {
 		log.Debugf("RunPrecompiledContract contract.UseGas(gas)")
+		if sc, ok := p.(*systemContract); ok {
+			return sc.run(pc, evm, snapshot, contract, input, hash)
+		}
 		return p.Run(evm, snapshot, contract, input, hash)
 	}
 	log.Debugf("RunPrecompiledContract ErrOutOfGas")
 	return nil, vm.ErrOutOfGas
 }
 
+// TracePrecompile runs a precompiled contract without deducting any gas and
+// returns its output together with the gas a regular run would have charged.
+// It is meant for tracers annotating precompile calls.
+func TracePrecompile(evm *vm.EVM, p vm.PrecompiledContract, input []byte) ([]byte, uint64, error) {
+	gas := p.RequiredGas(input)
+	contract := vm.NewContract(vm.AccountRef(common.Address{}), vm.AccountRef(common.Address{}), new(big.Int), gas)
+	snapshot := 0
+	if evm.StateDB != nil {
+		snapshot = evm.StateDB.Snapshot()
+	}
+	ret, err := p.Run(evm, snapshot, contract, input, nil)
+	return ret, gas, err
+}
+
+// PrecompileInfo describes a precompiled contract for RPC consumers.
+type PrecompileInfo struct {
+	Address common.Address `json:"address"`
+	Name    string         `json:"name"`
+	Gas     string         `json:"gas"` // description of the gas model
+}
+
+// Describe lists the precompiled contracts active at the given block, ordered
+// by address.
+func (pc *PrecompiledContracts) Describe(blockNumber *big.Int, cfg *params.ChainConfig) []PrecompileInfo {
+	set := pc.PrecompiledContractsByBlock(blockNumber, cfg)
+	infos := make([]PrecompileInfo, 0, len(set))
+	for addr, p := range set {
+		name, gas := describePrecompile(p)
+		infos = append(infos, PrecompileInfo{Address: addr, Name: name, Gas: gas})
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return bytes.Compare(infos[i].Address[:], infos[j].Address[:]) < 0
+	})
+	return infos
+}
+
+// describePrecompile returns the human name and the gas model of a precompile.
+func describePrecompile(p vm.PrecompiledContract) (string, string) {
+	flat := func(gas uint64) string { return fmt.Sprintf("flat %d", gas) }
+	perWord := func(base, word uint64) string { return fmt.Sprintf("%d + %d per 32 byte word", base, word) }
+
+	switch p.(type) {
+	case *ecrecover:
+		return "ecrecover", flat(params.EcrecoverGas)
+	case *batchEcrecover:
+		return "batchEcrecover", fmt.Sprintf("%d per record, less %d per additional record", params.EcrecoverGas, batchEcrecoverDiscount)
+	case *sha256hash:
+		return "sha256", perWord(params.Sha256BaseGas, params.Sha256PerWordGas)
+	case *ripemd160hash:
+		return "ripemd160", perWord(params.Ripemd160BaseGas, params.Ripemd160PerWordGas)
+	case *dataCopy:
+		return "identity", perWord(params.IdentityBaseGas, params.IdentityPerWordGas)
+	case *bigModExp:
+		return "modexp", "variable, EIP-198 complexity over base, exponent and modulus lengths"
+	case *bn256Add:
+		return "bn256Add", flat(params.Bn256AddGas)
+	case *bn256AddIstanbul:
+		return "bn256Add", flat(bn256AddGasIstanbul)
+	case *bn256ScalarMul:
+		return "bn256ScalarMul", flat(params.Bn256ScalarMulGas)
+	case *bn256ScalarMulIstanbul:
+		return "bn256ScalarMul", flat(bn256ScalarMulGasIstanbul)
+	case *bn256Pairing:
+		return "bn256Pairing", fmt.Sprintf("%d + %d per point", params.Bn256PairingBaseGas, params.Bn256PairingPerPointGas)
+	case *bn256PairingIstanbul:
+		return "bn256Pairing", fmt.Sprintf("%d + %d per point", bn256PairingBaseGasIstanbul, bn256PairingPerPointGasIstanbul)
+	case *localShardCheckAndEnroll:
+		return "localShardCheckAndEnroll", flat(100000)
+	case *checkShardValid:
+		return "checkShardValid", flat(100000)
+	case *queryContract:
+		return "queryContract", flat(100000)
+	case *delegateSend:
+		return "delegateSend", flat(100000)
+	case *notifySCS:
+		return "notifySCS", flat(params.NotifyScsGas)
+	case *spendGas:
+		return "spendGas", "variable, grows with the requested amount"
+	case *systemContract:
+		return "systemContract", flat(params.SystemContractGas)
+	case *chainID:
+		return "chainID", flat(chainIDGas)
+	case *blake2F:
+		return "blake2F", fmt.Sprintf("%d per round", blake2FRoundGas)
+	case *bls12381G1Add:
+		return "bls12381G1Add", flat(params.Bls12381G1AddGas)
+	case *bls12381G1Mul:
+		return "bls12381G1Mul", flat(params.Bls12381G1MulGas)
+	case *bls12381G1MultiExp:
+		return "bls12381G1MultiExp", fmt.Sprintf("%d per pair with EIP-2537 discount", params.Bls12381G1MulGas)
+	case *bls12381G2Add:
+		return "bls12381G2Add", flat(params.Bls12381G2AddGas)
+	case *bls12381G2Mul:
+		return "bls12381G2Mul", flat(params.Bls12381G2MulGas)
+	case *bls12381G2MultiExp:
+		return "bls12381G2MultiExp", fmt.Sprintf("%d per pair with EIP-2537 discount", params.Bls12381G2MulGas)
+	case *bls12381Pairing:
+		return "bls12381Pairing", fmt.Sprintf("%d + %d per pair", params.Bls12381PairingBaseGas, params.Bls12381PairingPerPairGas)
+	case *bls12381MapG1:
+		return "bls12381MapG1", flat(params.Bls12381MapG1Gas)
+	case *bls12381MapG2:
+		return "bls12381MapG2", flat(params.Bls12381MapG2Gas)
+	}
+	return fmt.Sprintf("%T", p), "unknown"
+}
+
 // ECRECOVER implemented as a native contract.
 type ecrecover struct{}
 
@@ -227,9 +421,22 @@ func (c *ecrecover) RequiredGas(input []byte) uint64 {
 }
 
 func (c *ecrecover) Run(evm *vm.EVM, snapshot int, contract *vm.Contract, input []byte, hash *common.Hash) ([]byte, error) {
-	const ecRecoverInputLength = 128
+	return recoverAddress(common.RightPadBytes(input, ecRecoverInputLength)), nil
+}
 
-	input = common.RightPadBytes(input, ecRecoverInputLength)
+var (
+	// ecrecoverInvalidCounter counts records rejected before recovery because
+	// of malformed v, r or s values.
+	ecrecoverInvalidCounter gometrics.Counter = metrics.NewCounter("precompile/ecrecover/invalid")
+	// ecrecoverFailedCounter counts well formed records the curve recovery
+	// still failed on.
+	ecrecoverFailedCounter gometrics.Counter = metrics.NewCounter("precompile/ecrecover/failed")
+)
+
+// recoverAddress recovers the left padded signer address from a 128 byte
+// ecrecover record, or returns nil if the signature is invalid. Failures are
+// only counted, the result seen by the EVM does not depend on the cause.
+func recoverAddress(input []byte) []byte {
 	// "input" is (hash, v, r, s), each 32 bytes
 	// but for ecrecover we want (r, s, v)
 
@@ -239,17 +446,82 @@ func (c *ecrecover) Run(evm *vm.EVM, snapshot int, contract *vm.Contract, input
 
 	// tighter sig s values input pangu only apply to tx sigs
 	if !vm.AllZero(input[32:63]) || !crypto.ValidateSignatureValues(v, r, s, false) {
-		return nil, nil
+		ecrecoverInvalidCounter.Inc(1)
+		return nil
 	}
-	// v needs to be at the end for libsecp256k1
-	pubKey, err := crypto.Ecrecover(input[:32], append(input[64:128], v))
+	// v needs to be at the end for libsecp256k1, the capped slice keeps
+	// append from overwriting the record following input
+	pubKey, err := crypto.Ecrecover(input[:32], append(input[64:128:128], v))
 	// make sure the public key is a valid one
 	if err != nil {
-		return nil, nil
+		ecrecoverFailedCounter.Inc(1)
+		log.Debug("Ecrecover precompile failed to recover key", "err", err)
+		return nil
 	}
 
 	// the first byte of pubkey is bitcoin heritage
-	return common.LeftPadBytes(crypto.Keccak256(pubKey[1:])[12:], 32), nil
+	return common.LeftPadBytes(crypto.Keccak256(pubKey[1:])[12:], 32)
+}
+
+// batchEcrecoverDiscount is the gas discount for every ecrecover record after
+// the first one of a batchEcrecover call.
+const batchEcrecoverDiscount uint64 = 500
+
+var errBatchEcrecoverInputLength = errors.New("batch ecrecover input not a multiple of 128 bytes")
+
+// batchEcrecover implements a native contract recovering the signers of many
+// signatures at once. The input is N concatenated 128 byte (hash, v, r, s)
+// records, the output N concatenated 32 byte addresses. Invalid signatures
+// yield the zero address.
+type batchEcrecover struct{}
+
+// RequiredGas returns the gas required to execute the pre-compiled contract.
+func (c *batchEcrecover) RequiredGas(input []byte) uint64 {
+	n := uint64(len(input)+ecRecoverInputLength-1) / ecRecoverInputLength
+	if n == 0 {
+		return 0
+	}
+	return n*params.EcrecoverGas - (n-1)*batchEcrecoverDiscount
+}
+
+func (c *batchEcrecover) Run(evm *vm.EVM, snapshot int, contract *vm.Contract, input []byte, hash *common.Hash) ([]byte, error) {
+	if len(input)%ecRecoverInputLength != 0 {
+		return nil, errBatchEcrecoverInputLength
+	}
+	n := len(input) / ecRecoverInputLength
+	output := make([]byte, 32*n)
+	for i := 0; i < n; i++ {
+		if addr := recoverAddress(input[i*ecRecoverInputLength : (i+1)*ecRecoverInputLength]); addr != nil {
+			copy(output[32*i:], addr)
+		}
+	}
+	return output, nil
+}
+
+var (
+	errBadSignatureLength = errors.New("bad signature length")
+	errBadSignatureV      = errors.New("bad signature recovery id")
+)
+
+// EncodeEcrecoverInput lays out a 65 byte [R || S || V] signature over hash
+// as the 128 byte (hash, v, r, s) input of the ecrecover precompile. V may be
+// given either as the raw recovery id (0 or 1) or with the 27 offset.
+func EncodeEcrecoverInput(hash common.Hash, sig []byte) ([]byte, error) {
+	if len(sig) != 65 {
+		return nil, errBadSignatureLength
+	}
+	v := sig[64]
+	if v < 27 {
+		v += 27
+	}
+	if v != 27 && v != 28 {
+		return nil, errBadSignatureV
+	}
+	input := make([]byte, 128)
+	copy(input[:32], hash[:])
+	input[63] = v
+	copy(input[64:128], sig[:64])
+	return input, nil
 }
 
 // SHA256 implemented as a native contract.
@@ -441,15 +713,16 @@ func newTwistPoint(blob []byte) (*bn256.G2, error) {
 	return p, nil
 }
 
-// bn256Add implements a native elliptic curve point addition.
-type bn256Add struct{}
-
-// RequiredGas returns the gas required to execute the pre-compiled contract.
-func (c *bn256Add) RequiredGas(input []byte) uint64 {
-	return params.Bn256AddGas
-}
+// Gas prices of the bn256 precompiles as repriced by EIP-1108.
+const (
+	bn256AddGasIstanbul             uint64 = 150   // Gas needed for an elliptic curve addition
+	bn256ScalarMulGasIstanbul       uint64 = 6000  // Gas needed for an elliptic curve scalar multiplication
+	bn256PairingBaseGasIstanbul     uint64 = 45000 // Base price for an elliptic curve pairing check
+	bn256PairingPerPointGasIstanbul uint64 = 34000 // Per-point price for an elliptic curve pairing check
+)
 
-func (c *bn256Add) Run(evm *vm.EVM, snapshot int, contract *vm.Contract, input []byte, hash *common.Hash) ([]byte, error) {
+// runBn256Add implements the bn256Add precompile, shared by all gas schedules.
+func runBn256Add(input []byte) ([]byte, error) {
 	x, err := newCurvePoint(vm.GetData(input, 0, 64))
 	if err != nil {
 		return nil, err
@@ -463,15 +736,34 @@ func (c *bn256Add) Run(evm *vm.EVM, snapshot int, contract *vm.Contract, input [
 	return res.Marshal(), nil
 }
 
-// bn256ScalarMul implements a native elliptic curve scalar multiplication.
-type bn256ScalarMul struct{}
+// bn256Add implements a native elliptic curve point addition.
+type bn256Add struct{}
 
 // RequiredGas returns the gas required to execute the pre-compiled contract.
-func (c *bn256ScalarMul) RequiredGas(input []byte) uint64 {
-	return params.Bn256ScalarMulGas
+func (c *bn256Add) RequiredGas(input []byte) uint64 {
+	return params.Bn256AddGas
 }
 
-func (c *bn256ScalarMul) Run(evm *vm.EVM, snapshot int, contract *vm.Contract, input []byte, hash *common.Hash) ([]byte, error) {
+func (c *bn256Add) Run(evm *vm.EVM, snapshot int, contract *vm.Contract, input []byte, hash *common.Hash) ([]byte, error) {
+	return runBn256Add(input)
+}
+
+// bn256AddIstanbul implements a native elliptic curve point addition
+// conforming to the EIP-1108 gas costs.
+type bn256AddIstanbul struct{}
+
+// RequiredGas returns the gas required to execute the pre-compiled contract.
+func (c *bn256AddIstanbul) RequiredGas(input []byte) uint64 {
+	return bn256AddGasIstanbul
+}
+
+func (c *bn256AddIstanbul) Run(evm *vm.EVM, snapshot int, contract *vm.Contract, input []byte, hash *common.Hash) ([]byte, error) {
+	return runBn256Add(input)
+}
+
+// runBn256ScalarMul implements the bn256ScalarMul precompile, shared by all
+// gas schedules.
+func runBn256ScalarMul(input []byte) ([]byte, error) {
 	p, err := newCurvePoint(vm.GetData(input, 0, 64))
 	if err != nil {
 		return nil, err
@@ -481,6 +773,31 @@ func (c *bn256ScalarMul) Run(evm *vm.EVM, snapshot int, contract *vm.Contract, i
 	return res.Marshal(), nil
 }
 
+// bn256ScalarMul implements a native elliptic curve scalar multiplication.
+type bn256ScalarMul struct{}
+
+// RequiredGas returns the gas required to execute the pre-compiled contract.
+func (c *bn256ScalarMul) RequiredGas(input []byte) uint64 {
+	return params.Bn256ScalarMulGas
+}
+
+func (c *bn256ScalarMul) Run(evm *vm.EVM, snapshot int, contract *vm.Contract, input []byte, hash *common.Hash) ([]byte, error) {
+	return runBn256ScalarMul(input)
+}
+
+// bn256ScalarMulIstanbul implements a native elliptic curve scalar
+// multiplication conforming to the EIP-1108 gas costs.
+type bn256ScalarMulIstanbul struct{}
+
+// RequiredGas returns the gas required to execute the pre-compiled contract.
+func (c *bn256ScalarMulIstanbul) RequiredGas(input []byte) uint64 {
+	return bn256ScalarMulGasIstanbul
+}
+
+func (c *bn256ScalarMulIstanbul) Run(evm *vm.EVM, snapshot int, contract *vm.Contract, input []byte, hash *common.Hash) ([]byte, error) {
+	return runBn256ScalarMul(input)
+}
+
 var (
 	// true32Byte is returned if the bn256 pairing check succeeds.
 	true32Byte = []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1}
@@ -492,15 +809,9 @@ var (
 	errBadPairingInput = errors.New("bad elliptic curve pairing size")
 )
 
-// bn256Pairing implements a pairing pre-compile for the bn256 curve
-type bn256Pairing struct{}
-
-// RequiredGas returns the gas required to execute the pre-compiled contract.
-func (c *bn256Pairing) RequiredGas(input []byte) uint64 {
-	return params.Bn256PairingBaseGas + uint64(len(input)/192)*params.Bn256PairingPerPointGas
-}
-
-func (c *bn256Pairing) Run(evm *vm.EVM, snapshot int, contract *vm.Contract, input []byte, hash *common.Hash) ([]byte, error) {
+// runBn256Pairing implements the bn256Pairing precompile, shared by all gas
+// schedules.
+func runBn256Pairing(input []byte) ([]byte, error) {
 	// Handle some corner cases cheaply
 	if len(input)%192 > 0 {
 		return nil, errBadPairingInput
@@ -529,6 +840,31 @@ func (c *bn256Pairing) Run(evm *vm.EVM, snapshot int, contract *vm.Contract, inp
 	return false32Byte, nil
 }
 
+// bn256Pairing implements a pairing pre-compile for the bn256 curve
+type bn256Pairing struct{}
+
+// RequiredGas returns the gas required to execute the pre-compiled contract.
+func (c *bn256Pairing) RequiredGas(input []byte) uint64 {
+	return params.Bn256PairingBaseGas + uint64(len(input)/192)*params.Bn256PairingPerPointGas
+}
+
+func (c *bn256Pairing) Run(evm *vm.EVM, snapshot int, contract *vm.Contract, input []byte, hash *common.Hash) ([]byte, error) {
+	return runBn256Pairing(input)
+}
+
+// bn256PairingIstanbul implements a pairing pre-compile for the bn256 curve
+// conforming to the EIP-1108 gas costs.
+type bn256PairingIstanbul struct{}
+
+// RequiredGas returns the gas required to execute the pre-compiled contract.
+func (c *bn256PairingIstanbul) RequiredGas(input []byte) uint64 {
+	return bn256PairingBaseGasIstanbul + uint64(len(input)/192)*bn256PairingPerPointGasIstanbul
+}
+
+func (c *bn256PairingIstanbul) Run(evm *vm.EVM, snapshot int, contract *vm.Contract, input []byte, hash *common.Hash) ([]byte, error) {
+	return runBn256Pairing(input)
+}
+
 var (
 	// errBadPairingInput is returned if the bn256 pairing input is invalid.
 	errBadEnrollCheckArgs = errors.New("bad check enroll args")
@@ -544,8 +880,8 @@ func (c *checkShardValid) RequiredGas(input []byte) uint64 {
 
 func (c *checkShardValid) Run(evm *vm.EVM, snapshot int, contract *vm.Contract, input []byte, hash *common.Hash) ([]byte, error) {
 	// Handle some corner cases cheaply
-	if len(input) != 100 {
-		return false32Byte, errBadEnrollCheckArgs
+	if err := requireExactLen(input, checkShardValidInputLength, errBadEnrollCheckArgs); err != nil {
+		return false32Byte, err
 	}
 
 	return false32Byte, nil
@@ -566,8 +902,8 @@ func (c *localShardCheckAndEnroll) RequiredGas(input []byte) uint64 {
 
 func (c *localShardCheckAndEnroll) Run(evm *vm.EVM, snapshot int, contract *vm.Contract, input []byte, hash *common.Hash) ([]byte, error) {
 	// Handle some corner cases cheaply
-	if len(input) != 132 {
-		return false32Byte, errBadEnrollArgs
+	if err := requireExactLen(input, localShardCheckAndEnrollInputLength, errBadEnrollArgs); err != nil {
+		return false32Byte, err
 	}
 
 	return false32Byte, nil
@@ -583,14 +919,60 @@ func (c *systemContract) RequiredGas(input []byte) uint64 {
 }
 
 func (c *systemContract) Run(evm *vm.EVM, snapshot int, contract *vm.Contract, input []byte, msgHash *common.Hash) ([]byte, error) {
-	caller := vm.AccountRef(contract.CallerAddress)
+	return c.run(GetInstance(), evm, snapshot, contract, input, msgHash)
+}
 
-	if IsSystemCaller(caller) {
+// run runs the system contract in the call context of pc. Calls of the system
+// caller are unmetered and run one level deeper; without gas metering nothing
+// else bounds the recursion.
+func (c *systemContract) run(pc *PrecompiledContracts, evm *vm.EVM, snapshot int, contract *vm.Contract, input []byte, msgHash *common.Hash) ([]byte, error) {
+	if IsSystemCaller(vm.AccountRef(contract.CallerAddress)) {
+		if pc.systemDepth >= MaxSystemCallDepth {
+			return nil, ErrSystemCallDepth
+		}
 		evm.Interpreter().Cfg.DisableGasMetering = true
+		pc = &PrecompiledContracts{systemDepth: pc.systemDepth + 1}
 	}
+	return evm.Interpreter().Run(snapshot, contract, input, pc, msgHash)
+}
+
+// MaxSystemCallDepth caps the nesting of unmetered system contract calls
+// within one call context.
+const MaxSystemCallDepth = 64
+
+// ErrSystemCallDepth is returned when unmetered system contract calls nest
+// deeper than MaxSystemCallDepth.
+var ErrSystemCallDepth = errors.New("system contract call depth exceeded")
+
+var errNoStateDB = errors.New("evm has no state")
 
-	ret, err := evm.Interpreter().Run(snapshot, contract, input, GetInstance(), msgHash)
-	return ret, err
+// EstimateSystemContractGas runs a call of the system contract in a metering
+// only mode and returns the gas it consumes, including the precompile base
+// cost. Gas is metered even for the system caller, whose calls are otherwise
+// unmetered, and all state changes are reverted afterwards. gas caps the
+// execution like the gas limit of a transaction.
+func EstimateSystemContractGas(evm *vm.EVM, caller common.Address, input []byte, gas uint64) (uint64, error) {
+	if evm == nil || evm.StateDB == nil {
+		return 0, errNoStateDB
+	}
+	if gas < params.SystemContractGas {
+		return 0, vm.ErrOutOfGas
+	}
+	snapshot := evm.StateDB.Snapshot()
+	defer evm.StateDB.RevertToSnapshot(snapshot)
+
+	metering := evm.Interpreter().Cfg.DisableGasMetering
+	evm.Interpreter().Cfg.DisableGasMetering = false
+	defer func() { evm.Interpreter().Cfg.DisableGasMetering = metering }()
+
+	entry := systemContractEntryAddrV1
+	contract := vm.NewContract(vm.AccountRef(caller), vm.AccountRef(entry), big.NewInt(0), gas-params.SystemContractGas)
+	contract.SetCallCode(&entry, evm.StateDB.GetCodeHash(entry), evm.StateDB.GetCode(entry))
+
+	if _, err := evm.Interpreter().Run(snapshot, contract, input, GetInstance(), nil); err != nil {
+		return 0, err
+	}
+	return gas - contract.Gas, nil
 }
 
 // queryContract implements the query contract features
@@ -626,6 +1008,32 @@ func (c *delegateSend) Run(evm *vm.EVM, snapshot int, contract *vm.Contract, inp
 	return false32Byte, nil
 }
 
+// ScsNotifier relays the notifications of the notifySCS precompile to the
+// SCS servers. The network relay of the EVM implements it.
+type ScsNotifier interface {
+	NotifyScs(address common.Address, msg []byte, hash common.Hash, block *big.Int)
+}
+
+// scsRelayOverride receives the notifications of the notifySCS precompile in
+// place of the network relay of the EVM if set. It is replaced in tests.
+var scsRelayOverride ScsNotifier
+
+// ScsNotifyCallers restricts, if non-empty, the callers whose notifications
+// are relayed to the SCS servers further than the whitelist does. It is a
+// local relay policy and doesn't change the result of the precompile.
+var ScsNotifyCallers map[common.Address]bool
+
+// scsRelay returns the relay notifySCS notifies, or nil if there is none.
+func scsRelay(evm *vm.EVM) ScsNotifier {
+	if scsRelayOverride != nil {
+		return scsRelayOverride
+	}
+	if evm.Nr != nil {
+		return evm.Nr
+	}
+	return nil
+}
+
 type notifySCS struct{}
 
 // RequiredGas returns the gas required to execute the pre-compiled contract.
@@ -638,9 +1046,14 @@ func (c *notifySCS) Run(evm *vm.EVM, snapshot int, contract *vm.Contract, input
 	log.Debugf("[core/vm/contracts.go->notifySCS.Run] from:%v input:%v", contract.CallerAddress.String(), common.Bytes2Hex(input))
 
 	//Use networkRelay to notify SCS about the transaction.
-	if evm.Nr != nil && hash != nil && IsInWhiteList(evm, contract.CallerAddress) {
-		log.Debugf("[core/vm/contracts.go->notifySCS.Run] hash:%v", hash.String())
-		evm.Nr.NotifyScs(contract.CallerAddress, input, *hash, evm.BlockNumber)
+	// The caller filter is a local policy, it only decides whether the
+	// notification is relayed and must not change the result.
+	relay := scsRelay(evm)
+	if relay != nil && hash != nil && IsInWhiteList(evm, contract.CallerAddress) {
+		if len(ScsNotifyCallers) == 0 || ScsNotifyCallers[contract.CallerAddress] {
+			log.Debugf("[core/vm/contracts.go->notifySCS.Run] hash:%v", hash.String())
+			relay.NotifyScs(contract.CallerAddress, input, *hash, evm.BlockNumber)
+		}
 		if evm.ChainConfig().IsNuwa(evm.BlockNumber) {
 			return true32Byte, nil
 		}
@@ -667,18 +1080,88 @@ func IsWhiteListCall(addr common.Address, data []byte) bool {
 	return false
 }
 
+// WhiteListStatus is the result of a whitelist lookup.
+type WhiteListStatus int
+
+const (
+	WhiteListDenied      WhiteListStatus = iota // Caller is not whitelisted
+	WhiteListAllowed                            // Caller is whitelisted, or the chain has no whitelist
+	WhiteListUnavailable                        // No whitelist contract is deployed
+)
+
+// WhiteListFailOpen sets the policy while the whitelist contract is not
+// deployed: every caller is treated as whitelisted if true and as not
+// whitelisted otherwise. It defaults to the historical fail-open result.
+var WhiteListFailOpen = true
+
+// whiteListUnavailableOnce limits the missing whitelist warning to one per
+// process, as every notifySCS call would log it otherwise.
+var whiteListUnavailableOnce sync.Once
+
 func IsInWhiteList(evm *vm.EVM, callerAddress common.Address) bool {
+	switch LookupWhiteList(evm, callerAddress) {
+	case WhiteListAllowed:
+		return true
+	case WhiteListUnavailable:
+		whiteListUnavailableOnce.Do(func() {
+			log.Warn("Whitelist contract not deployed, applying policy", "addr", whiteListContractCallAddr, "failopen", WhiteListFailOpen)
+		})
+		return WhiteListFailOpen
+	}
+	return false
+}
+
+// WhiteListReadOnly makes LookupWhiteList run the whitelist contract on a
+// read-only view of the state instead of under a snapshot, see
+// LookupWhiteListReadOnly.
+var WhiteListReadOnly = false
+
+// LookupWhiteList checks whether the caller is whitelisted, telling a missing
+// whitelist contract apart from a caller that is not on the list.
+func LookupWhiteList(evm *vm.EVM, callerAddress common.Address) WhiteListStatus {
+	if WhiteListReadOnly {
+		return LookupWhiteListReadOnly(evm, callerAddress)
+	}
 	if evm == nil {
 		evm = vm.GetEVM()
-		if evm == nil {
-			log.Debug("[core/vm/contracts.go->IsInWhiteList] evm nil")
-			return false
-		}
+	}
+	return lookupWhiteList(evm, callerAddress)
+}
+
+// LookupWhiteListReadOnly is LookupWhiteList running the whitelist contract
+// on a read-only view of the state. It takes no snapshot of the state and
+// discards any state change of the contract. Lookups only serialize their
+// reads of the same state, so they can run concurrently as long as nothing
+// else modifies the state meanwhile.
+func LookupWhiteListReadOnly(evm *vm.EVM, callerAddress common.Address) WhiteListStatus {
+	if evm == nil {
+		evm = vm.GetEVM()
+	}
+	if evm == nil || evm.StateDB == nil {
+		return lookupWhiteList(evm, callerAddress)
+	}
+	view := newReadOnlyState(evm.StateDB)
+	defer view.release()
+	roEVM := vm.NewEVM(evm.Context, view, evm.ChainConfig(), vm.Config{}, nil)
+	return lookupWhiteList(roEVM, callerAddress)
+}
+
+// lookupWhiteList checks whether the caller is whitelisted by calling isValid
+// of the whitelist contract under a snapshot of the state of evm.
+func lookupWhiteList(evm *vm.EVM, callerAddress common.Address) WhiteListStatus {
+	if evm == nil {
+		log.Debug("[core/vm/contracts.go->IsInWhiteList] evm nil")
+		return WhiteListDenied
 	}
 
 	networkId := evm.ChainConfig().ChainId.Uint64()
 	if !params.PriorityChain(networkId) {
-		return true
+		return WhiteListAllowed
+	}
+	// Running empty code returns nothing, which would read as "not
+	// whitelisted" for every caller.
+	if len(evm.StateDB.GetCode(whiteListContractCallAddr)) == 0 {
+		return WhiteListUnavailable
 	}
 	snapshot := evm.StateDB.Snapshot()
 	whiteListContract := vm.NewContract(vm.AccountRef(callerAddress), vm.AccountRef(whiteListContractCallAddr), big.NewInt(0), 1000000)
@@ -697,16 +1180,16 @@ func IsInWhiteList(evm *vm.EVM, callerAddress common.Address) bool {
 	ret, err := vm.Run(evm, snapshot, whiteListContract, input, precompiledContracts, nil)
 	if err != nil {
 		log.Errorf("IsInWhiteList error %v", err.Error())
-		return false
+		return WhiteListDenied
 	}
 	retValue := common.Bytes2Hex(ret)
 	log.Debugf("IsInWhiteList retValue %v, ret %v", retValue, ret)
 	if retValue != "0000000000000000000000000000000000000000000000000000000000000000" {
 		log.Debugf("IsInWhiteList returning true")
-		return true
+		return WhiteListAllowed
 	} else {
 		log.Debugf("IsInWhiteList returning false")
-		return false
+		return WhiteListDenied
 	}
 }
 
@@ -811,6 +1294,64 @@ func PrintWhiteList(evm *vm.EVM, callerAddress common.Address) {
 	log.Debugf("IsInWhiteList retValue %v, ret %v", retValue, ret)
 }
 
+// chainIDGas is the fixed price of reading the chain id through the precompile.
+const chainIDGas uint64 = 20
+
+// chainID implements a native contract returning the chain id, for forks
+// where the CHAINID opcode is not available.
+type chainID struct{}
+
+// RequiredGas returns the gas required to execute the pre-compiled contract.
+func (c *chainID) RequiredGas(input []byte) uint64 {
+	return chainIDGas
+}
+
+func (c *chainID) Run(evm *vm.EVM, snapshot int, contract *vm.Contract, input []byte, hash *common.Hash) ([]byte, error) {
+	return common.LeftPadBytes(evm.ChainConfig().ChainId.Bytes(), 32), nil
+}
+
+// SpendGasTier is a step of the spendGas schedule: every unit of the input
+// number above Threshold costs PerUnit gas on top of the lower tiers.
+type SpendGasTier struct {
+	Threshold int64
+	PerUnit   int64
+}
+
+var errSpendGasTiers = errors.New("spendGas tiers must have increasing thresholds and non-negative prices")
+
+// DefaultSpendGasBase and DefaultSpendGasTiers are the spendGas schedule of
+// the MOAC mainnet.
+var (
+	DefaultSpendGasBase  int64 = 1000
+	DefaultSpendGasTiers       = []SpendGasTier{
+		{10, 2000}, {15, 4000}, {20, 8000}, {25, 16000},
+		{30, 32000}, {35, 64000}, {40, 128000}, {45, 256000},
+	}
+)
+
+// spendGasBase and spendGasTiers are the schedule spendGas charges by.
+var (
+	spendGasBase  = DefaultSpendGasBase
+	spendGasTiers = DefaultSpendGasTiers
+)
+
+// SetSpendGasTiers replaces the spendGas schedule, e.g. to tune it for a
+// private network. The tiers must be ordered by increasing threshold and have
+// non-negative prices, so the gas never decreases with the input number.
+func SetSpendGasTiers(base int64, tiers []SpendGasTier) error {
+	if base < 0 {
+		return errSpendGasTiers
+	}
+	for i, tier := range tiers {
+		if tier.PerUnit < 0 || (i > 0 && tier.Threshold <= tiers[i-1].Threshold) {
+			return errSpendGasTiers
+		}
+	}
+	spendGasBase = base
+	spendGasTiers = append([]SpendGasTier(nil), tiers...)
+	return nil
+}
+
 type spendGas struct{}
 
 // RequiredGas returns the gas required to execute the pre-compiled contract.
@@ -827,31 +1368,11 @@ func (c *spendGas) RequiredGas(input []byte) uint64 {
 	num := bnum.Int64()
 
 	//if pangu version
-	gas := int64(1000)
-
-	if num > 10 {
-		gas += 2000 * (num - 10)
-	}
-	if num > 15 {
-		gas += 4000 * (num - 15)
-	}
-	if num > 20 {
-		gas += 8000 * (num - 20)
-	}
-	if num > 25 {
-		gas += 16000 * (num - 25)
-	}
-	if num > 30 {
-		gas += 32000 * (num - 30)
-	}
-	if num > 35 {
-		gas += 64000 * (num - 35)
-	}
-	if num > 40 {
-		gas += 128000 * (num - 40)
-	}
-	if num > 45 {
-		gas += 256000 * (num - 45)
+	gas := spendGasBase
+	for _, tier := range spendGasTiers {
+		if num > tier.Threshold {
+			gas += tier.PerUnit * (num - tier.Threshold)
+		}
 	}
 
 	log.Debugf("[core/vm/contracts.go->RequiredGas.RequiredGas] input:%v output:%v", input, gas)
@@ -888,8 +1409,8 @@ func (c *bls12381G1Add) Run(evm *vm.EVM, snapshot int, contract *vm.Contract, in
 	// Implements EIP-2537 G1Add precompile.
 	// > G1 addition call expects `256` bytes as an input that is interpreted as byte concatenation of two G1 points (`128` bytes each).
 	// > Output is an encoding of addition operation result - single G1 point (`128` bytes).
-	if len(input) != 256 {
-		return nil, errBLS12381InvalidInputLength
+	if err := requireExactLen(input, bls12381G1AddInputLength, errBLS12381InvalidInputLength); err != nil {
+		return nil, err
 	}
 	var err error
 	var p0, p1 *bls12381.PointG1
@@ -926,8 +1447,8 @@ func (c *bls12381G1Mul) Run(evm *vm.EVM, snapshot int, contract *vm.Contract, in
 	// Implements EIP-2537 G1Mul precompile.
 	// > G1 multiplication call expects `160` bytes as an input that is interpreted as byte concatenation of encoding of G1 point (`128` bytes) and encoding of a scalar value (`32` bytes).
 	// > Output is an encoding of multiplication operation result - single G1 point (`128` bytes).
-	if len(input) != 160 {
-		return nil, errBLS12381InvalidInputLength
+	if err := requireExactLen(input, bls12381G1MulInputLength, errBLS12381InvalidInputLength); err != nil {
+		return nil, err
 	}
 	var err error
 	var p0 *bls12381.PointG1
@@ -950,24 +1471,37 @@ func (c *bls12381G1Mul) Run(evm *vm.EVM, snapshot int, contract *vm.Contract, in
 	return g.EncodePoint(r), nil
 }
 
+// bls12381MultiExpDiscount returns the EIP-2537 discount for a multi
+// exponentiation of k pairs. The table index is clamped into its bounds, so
+// any k (including zero) is safe.
+func bls12381MultiExpDiscount(k int) uint64 {
+	dLen := len(params.Bls12381MultiExpDiscountTable)
+	if dLen == 0 {
+		return 1000
+	}
+	index := k - 1
+	if index < 0 {
+		index = 0
+	}
+	if index > dLen-1 {
+		index = dLen - 1
+	}
+	return params.Bls12381MultiExpDiscountTable[index]
+}
+
 // bls12381G1MultiExp implements EIP-2537 G1MultiExp precompile.
 type bls12381G1MultiExp struct{}
 
 // RequiredGas returns the gas required to execute the pre-compiled contract.
 func (c *bls12381G1MultiExp) RequiredGas(input []byte) uint64 {
 	// Calculate G1 point, scalar value pair length
-	k := len(input) / 160
+	k := len(input) / bls12381G1MultiExpItemLength
 	if k == 0 {
 		// Return 0 gas for small input length
 		return 0
 	}
 	// Lookup discount value for G1 point, scalar value pair length
-	var discount uint64
-	if dLen := len(params.Bls12381MultiExpDiscountTable); k < dLen {
-		discount = params.Bls12381MultiExpDiscountTable[k-1]
-	} else {
-		discount = params.Bls12381MultiExpDiscountTable[dLen-1]
-	}
+	discount := bls12381MultiExpDiscount(k)
 	// Calculate gas and return the result
 	return (uint64(k) * params.Bls12381G1MulGas * discount) / 1000
 }
@@ -976,9 +1510,9 @@ func (c *bls12381G1MultiExp) Run(evm *vm.EVM, snapshot int, contract *vm.Contrac
 	// Implements EIP-2537 G1MultiExp precompile.
 	// G1 multiplication call expects `160*k` bytes as an input that is interpreted as byte concatenation of `k` slices each of them being a byte concatenation of encoding of G1 point (`128` bytes) and encoding of a scalar value (`32` bytes).
 	// Output is an encoding of multiexponentiation operation result - single G1 point (`128` bytes).
-	k := len(input) / 160
-	if len(input) == 0 || len(input)%160 != 0 {
-		return nil, errBLS12381InvalidInputLength
+	k := len(input) / bls12381G1MultiExpItemLength
+	if err := requireMultipleLen(input, bls12381G1MultiExpItemLength, errBLS12381InvalidInputLength); err != nil {
+		return nil, err
 	}
 	var err error
 	points := make([]*bls12381.PointG1, k)
@@ -1019,8 +1553,8 @@ func (c *bls12381G2Add) Run(evm *vm.EVM, snapshot int, contract *vm.Contract, in
 	// Implements EIP-2537 G2Add precompile.
 	// > G2 addition call expects `512` bytes as an input that is interpreted as byte concatenation of two G2 points (`256` bytes each).
 	// > Output is an encoding of addition operation result - single G2 point (`256` bytes).
-	if len(input) != 512 {
-		return nil, errBLS12381InvalidInputLength
+	if err := requireExactLen(input, bls12381G2AddInputLength, errBLS12381InvalidInputLength); err != nil {
+		return nil, err
 	}
 	var err error
 	var p0, p1 *bls12381.PointG2
@@ -1057,8 +1591,8 @@ func (c *bls12381G2Mul) Run(evm *vm.EVM, snapshot int, contract *vm.Contract, in
 	// Implements EIP-2537 G2MUL precompile logic.
 	// > G2 multiplication call expects `288` bytes as an input that is interpreted as byte concatenation of encoding of G2 point (`256` bytes) and encoding of a scalar value (`32` bytes).
 	// > Output is an encoding of multiplication operation result - single G2 point (`256` bytes).
-	if len(input) != 288 {
-		return nil, errBLS12381InvalidInputLength
+	if err := requireExactLen(input, bls12381G2MulInputLength, errBLS12381InvalidInputLength); err != nil {
+		return nil, err
 	}
 	var err error
 	var p0 *bls12381.PointG2
@@ -1087,18 +1621,13 @@ type bls12381G2MultiExp struct{}
 // RequiredGas returns the gas required to execute the pre-compiled contract.
 func (c *bls12381G2MultiExp) RequiredGas(input []byte) uint64 {
 	// Calculate G2 point, scalar value pair length
-	k := len(input) / 288
+	k := len(input) / bls12381G2MultiExpItemLength
 	if k == 0 {
 		// Return 0 gas for small input length
 		return 0
 	}
 	// Lookup discount value for G2 point, scalar value pair length
-	var discount uint64
-	if dLen := len(params.Bls12381MultiExpDiscountTable); k < dLen {
-		discount = params.Bls12381MultiExpDiscountTable[k-1]
-	} else {
-		discount = params.Bls12381MultiExpDiscountTable[dLen-1]
-	}
+	discount := bls12381MultiExpDiscount(k)
 	// Calculate gas and return the result
 	return (uint64(k) * params.Bls12381G2MulGas * discount) / 1000
 }
@@ -1107,9 +1636,9 @@ func (c *bls12381G2MultiExp) Run(evm *vm.EVM, snapshot int, contract *vm.Contrac
 	// Implements EIP-2537 G2MultiExp precompile logic
 	// > G2 multiplication call expects `288*k` bytes as an input that is interpreted as byte concatenation of `k` slices each of them being a byte concatenation of encoding of G2 point (`256` bytes) and encoding of a scalar value (`32` bytes).
 	// > Output is an encoding of multiexponentiation operation result - single G2 point (`256` bytes).
-	k := len(input) / 288
-	if len(input) == 0 || len(input)%288 != 0 {
-		return nil, errBLS12381InvalidInputLength
+	k := len(input) / bls12381G2MultiExpItemLength
+	if err := requireMultipleLen(input, bls12381G2MultiExpItemLength, errBLS12381InvalidInputLength); err != nil {
+		return nil, err
 	}
 	var err error
 	points := make([]*bls12381.PointG2, k)
@@ -1153,9 +1682,9 @@ func (c *bls12381Pairing) Run(evm *vm.EVM, snapshot int, contract *vm.Contract,
 	// > - `256` bytes of G2 point encoding
 	// > Output is a `32` bytes where last single byte is `0x01` if pairing result is equal to multiplicative identity in a pairing target field and `0x00` otherwise
 	// > (which is equivalent of Big Endian encoding of Solidity values `uint256(1)` and `uin256(0)` respectively).
-	k := len(input) / 384
-	if len(input) == 0 || len(input)%384 != 0 {
-		return nil, errBLS12381InvalidInputLength
+	k := len(input) / bls12381PairingInputLength
+	if err := requireMultipleLen(input, bls12381PairingInputLength, errBLS12381InvalidInputLength); err != nil {
+		return nil, err
 	}
 
 	// Initialize BLS12-381 pairing engine
@@ -1229,8 +1758,8 @@ func (c *bls12381MapG1) Run(evm *vm.EVM, snapshot int, contract *vm.Contract, in
 	// Implements EIP-2537 Map_To_G1 precompile.
 	// > Field-to-curve call expects `64` bytes an an input that is interpreted as a an element of the base field.
 	// > Output of this call is `128` bytes and is G1 point following respective encoding rules.
-	if len(input) != 64 {
-		return nil, errBLS12381InvalidInputLength
+	if err := requireExactLen(input, bls12381MapG1InputLength, errBLS12381InvalidInputLength); err != nil {
+		return nil, err
 	}
 
 	// Decode input field element
@@ -1264,8 +1793,8 @@ func (c *bls12381MapG2) Run(evm *vm.EVM, snapshot int, contract *vm.Contract, in
 	// Implements EIP-2537 Map_FP2_TO_G2 precompile logic.
 	// > Field-to-curve call expects `128` bytes an an input that is interpreted as a an element of the quadratic extension field.
 	// > Output of this call is `256` bytes and is G2 point following respective encoding rules.
-	if len(input) != 128 {
-		return nil, errBLS12381InvalidInputLength
+	if err := requireExactLen(input, bls12381MapG2InputLength, errBLS12381InvalidInputLength); err != nil {
+		return nil, err
 	}
 
 	// Decode input field element
@@ -0,0 +1,79 @@
+// Copyright 2021 The MOAC-core Authors
+// This file is part of the MOAC-core library.
+//
+// The MOAC-core library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The MOAC-core library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the MOAC-core library. If not, see <http://www.gnu.org/licenses/>.
+
+//go:build go1.18
+// +build go1.18
+
+package contracts
+
+import (
+	"bytes"
+	"math/big"
+	"sort"
+	"testing"
+
+	"github.com/MOACChain/MoacLib/common"
+	"github.com/MOACChain/MoacLib/vm"
+)
+
+// fuzzGasCap mirrors the gas check of RunPrecompiledContract: inputs whose
+// price is above it never reach Run on a live chain.
+const fuzzGasCap = 100000000
+
+// fuzzedPrecompiles returns every native precompile in a stable order. The
+// system contract is left out as it runs the interpreter rather than native
+// code.
+func fuzzedPrecompiles() ([]common.Address, map[common.Address]vm.PrecompiledContract) {
+	set := precompiledContractsFuxi
+	var addrs []common.Address
+	for addr := range set {
+		if addr != systemContractEntryAddrV1 {
+			addrs = append(addrs, addr)
+		}
+	}
+	sort.Slice(addrs, func(i, j int) bool { return bytes.Compare(addrs[i][:], addrs[j][:]) < 0 })
+	return addrs, set
+}
+
+func FuzzPrecompiles(f *testing.F) {
+	// Seed corpus: empty input, bigModExp length headers and the boundary
+	// sizes of the fixed-length precompiles.
+	f.Add([]byte{})
+	f.Add(make([]byte, 96))
+	f.Add(common.FromHex("0x0000000000000000000000000000000000000000000000000000000000000001" +
+		"0000000000000000000000000000000000000000000000000000000000000001" +
+		"0000000000000000000000000000000000000000000000000000000000000001"))
+	f.Add(bytes.Repeat([]byte{0xff}, 96))
+	for _, size := range []int{36, 63, 64, 65, 100, 127, 128, 129, 132, 159, 160, 161, 191, 192, 193, 255, 256, 257, 287, 288, 289, 383, 384, 385, 511, 512, 513} {
+		f.Add(make([]byte, size))
+		f.Add(bytes.Repeat([]byte{0xff}, size))
+	}
+
+	addrs, set := fuzzedPrecompiles()
+	evm := newTestEVM(1, 99)
+	f.Fuzz(func(t *testing.T, input []byte) {
+		for _, addr := range addrs {
+			p := set[addr]
+			gas := p.RequiredGas(input)
+			if gas > fuzzGasCap {
+				continue
+			}
+			contract := vm.NewContract(vm.AccountRef(common.Address{}), vm.AccountRef(addr), new(big.Int), gas)
+			// Errors are fine, the harness only catches panics.
+			p.Run(evm, 0, contract, common.CopyBytes(input), nil)
+		}
+	})
+}
@@ -0,0 +1,726 @@
+// Copyright 2021 The MOAC-core Authors
+// This file is part of the MOAC-core library.
+//
+// The MOAC-core library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The MOAC-core library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the MOAC-core library. If not, see <http://www.gnu.org/licenses/>.
+
+package contracts
+
+import (
+	"bytes"
+	"math/big"
+	"sync"
+	"testing"
+
+	"github.com/MOACChain/MoacLib/common"
+	"github.com/MOACChain/MoacLib/crypto"
+	"github.com/MOACChain/MoacLib/mcdb"
+	"github.com/MOACChain/MoacLib/params"
+	"github.com/MOACChain/MoacLib/state"
+	"github.com/MOACChain/MoacLib/vm"
+	gometrics "github.com/rcrowley/go-metrics"
+)
+
+// newTestEVM creates an EVM at the given block with the given chain id.
+func newTestEVM(number int64, chainId int64) *vm.EVM {
+	config := &params.ChainConfig{
+		ChainId:               big.NewInt(chainId),
+		EnableFuxiPrecompiled: big.NewInt(0),
+	}
+	return vm.NewEVM(vm.Context{BlockNumber: big.NewInt(number)}, nil, config, vm.Config{}, nil)
+}
+
+func TestChainIDPrecompile(t *testing.T) {
+	evm := newTestEVM(1, 99)
+	addr := common.BytesToAddress([]byte{69})
+
+	for _, config := range []*params.ChainConfig{params.MainnetChainConfig, params.TestnetChainConfig} {
+		fork := config.EnableFuxiPrecompiled
+		if fork.Sign() > 0 {
+			if _, ok := GetInstance().PrecompiledContractsByBlock(new(big.Int).Sub(fork, big.NewInt(1)), config)[addr]; ok {
+				t.Fatalf("chain %v: chain id precompile active before the Fuxi fork", config.ChainId)
+			}
+		}
+		if _, ok := GetInstance().PrecompiledContractsByBlock(fork, config)[addr]; !ok {
+			t.Fatalf("chain %v: chain id precompile not active at the Fuxi fork", config.ChainId)
+		}
+	}
+	p, ok := GetInstance().PrecompiledContractsByBlock(big.NewInt(1), evm.ChainConfig())[addr]
+	if !ok {
+		t.Fatal("chain id precompile not registered")
+	}
+	if gas := p.RequiredGas(nil); gas != chainIDGas {
+		t.Fatalf("gas mismatch: have %d, want %d", gas, chainIDGas)
+	}
+	ret, err := p.Run(evm, 0, nil, nil, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := common.LeftPadBytes([]byte{99}, 32); !bytes.Equal(ret, want) {
+		t.Fatalf("result mismatch: have %x, want %x", ret, want)
+	}
+}
+
+func TestBn256RepricingFork(t *testing.T) {
+	pairingInput := make([]byte, 2*192)
+	tests := []struct {
+		addr   byte
+		input  []byte
+		legacy uint64
+		gas    uint64
+	}{
+		{6, nil, params.Bn256AddGas, bn256AddGasIstanbul},
+		{7, nil, params.Bn256ScalarMulGas, bn256ScalarMulGasIstanbul},
+		{8, pairingInput,
+			params.Bn256PairingBaseGas + 2*params.Bn256PairingPerPointGas,
+			bn256PairingBaseGasIstanbul + 2*bn256PairingPerPointGasIstanbul},
+	}
+	for _, config := range []*params.ChainConfig{params.MainnetChainConfig, params.TestnetChainConfig} {
+		fork := config.EnableFuxiPrecompiled
+		for _, test := range tests {
+			addr := common.BytesToAddress([]byte{test.addr})
+			if fork.Sign() > 0 {
+				before := new(big.Int).Sub(fork, big.NewInt(1))
+				if _, ok := GetInstance().PrecompiledContractsByBlock(before, config)[addr]; ok {
+					t.Errorf("chain %v: precompile %d active before the Fuxi fork", config.ChainId, test.addr)
+				}
+			}
+			for _, number := range []*big.Int{fork, new(big.Int).Add(fork, big.NewInt(1))} {
+				p := GetInstance().PrecompiledContractsByBlock(number, config)[addr]
+				if gas := p.RequiredGas(test.input); gas != test.gas {
+					t.Errorf("chain %v: precompile %d at block %v: gas mismatch: have %d, want %d", config.ChainId, test.addr, number, gas, test.gas)
+				}
+			}
+			// The Byzantium set keeps the legacy prices.
+			if gas := GetInstance().PrecompiledContractsByzantium()[addr].RequiredGas(test.input); gas != test.legacy {
+				t.Errorf("byzantium precompile %d: gas mismatch: have %d, want %d", test.addr, gas, test.legacy)
+			}
+		}
+	}
+}
+
+func TestTracePrecompile(t *testing.T) {
+	evm := newTestEVM(1, 99)
+	input := []byte("trace me")
+
+	for _, addr := range []byte{2, 3, 4} {
+		p := GetInstance().PrecompiledContractsFuxi()[common.BytesToAddress([]byte{addr})]
+
+		ret, gas, err := TracePrecompile(evm, p, input)
+		if err != nil {
+			t.Fatalf("precompile %d: trace failed: %v", addr, err)
+		}
+		if want := p.RequiredGas(input); gas != want {
+			t.Errorf("precompile %d: gas mismatch: have %d, want %d", addr, gas, want)
+		}
+
+		contract := vm.NewContract(vm.AccountRef(common.Address{}), vm.AccountRef(common.Address{}), new(big.Int), gas)
+		want, err := GetInstance().RunPrecompiledContract(evm, 0, p, input, contract, nil)
+		if err != nil {
+			t.Fatalf("precompile %d: run failed: %v", addr, err)
+		}
+		if !bytes.Equal(ret, want) {
+			t.Errorf("precompile %d: output mismatch: have %x, want %x", addr, ret, want)
+		}
+		if contract.Gas != 0 {
+			t.Errorf("precompile %d: regular run left %d gas, want 0", addr, contract.Gas)
+		}
+	}
+}
+
+func TestBLS12381MultiExpDiscountClamp(t *testing.T) {
+	dLen := len(params.Bls12381MultiExpDiscountTable)
+	last := params.Bls12381MultiExpDiscountTable[dLen-1]
+
+	for _, k := range []int{-1, 0, 1} {
+		if have, want := bls12381MultiExpDiscount(k), params.Bls12381MultiExpDiscountTable[0]; have != want {
+			t.Errorf("k=%d: discount mismatch: have %d, want %d", k, have, want)
+		}
+	}
+	for _, k := range []int{dLen - 1, dLen, dLen + 1, 10 * dLen} {
+		want := last
+		if k < dLen {
+			want = params.Bls12381MultiExpDiscountTable[k-1]
+		}
+		if have := bls12381MultiExpDiscount(k); have != want {
+			t.Errorf("k=%d: discount mismatch: have %d, want %d", k, have, want)
+		}
+	}
+
+	// Inputs at and just past the upper edge of the table must not panic.
+	for _, k := range []int{dLen, dLen + 1} {
+		g1 := new(bls12381G1MultiExp).RequiredGas(make([]byte, 160*k))
+		if want := uint64(k) * params.Bls12381G1MulGas * last / 1000; g1 != want {
+			t.Errorf("G1 k=%d: gas mismatch: have %d, want %d", k, g1, want)
+		}
+		g2 := new(bls12381G2MultiExp).RequiredGas(make([]byte, 288*k))
+		if want := uint64(k) * params.Bls12381G2MulGas * last / 1000; g2 != want {
+			t.Errorf("G2 k=%d: gas mismatch: have %d, want %d", k, g2, want)
+		}
+	}
+}
+
+func TestEncodeEcrecoverInput(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	hash := crypto.Keccak256Hash([]byte("ecrecover"))
+	sig, err := crypto.Sign(hash[:], key)
+	if err != nil {
+		t.Fatalf("can't sign: %v", err)
+	}
+
+	input, err := EncodeEcrecoverInput(hash, sig)
+	if err != nil {
+		t.Fatalf("can't encode input: %v", err)
+	}
+	ret, err := new(ecrecover).Run(nil, 0, nil, input, nil)
+	if err != nil {
+		t.Fatalf("ecrecover failed: %v", err)
+	}
+	want := common.LeftPadBytes(crypto.PubkeyToAddress(key.PublicKey).Bytes(), 32)
+	if !bytes.Equal(ret, want) {
+		t.Fatalf("recovered address mismatch: have %x, want %x", ret, want)
+	}
+
+	if _, err := EncodeEcrecoverInput(hash, sig[:64]); err != errBadSignatureLength {
+		t.Errorf("short signature: have %v, want %v", err, errBadSignatureLength)
+	}
+	bad := common.CopyBytes(sig)
+	bad[64] = 5
+	if _, err := EncodeEcrecoverInput(hash, bad); err != errBadSignatureV {
+		t.Errorf("bad recovery id: have %v, want %v", err, errBadSignatureV)
+	}
+}
+
+func TestEcrecoverFailureCounters(t *testing.T) {
+	invalid, failed := ecrecoverInvalidCounter, ecrecoverFailedCounter
+	defer func() { ecrecoverInvalidCounter, ecrecoverFailedCounter = invalid, failed }()
+	ecrecoverInvalidCounter, ecrecoverFailedCounter = gometrics.NewCounter(), gometrics.NewCounter()
+
+	key, _ := crypto.GenerateKey()
+	hash := crypto.Keccak256Hash([]byte("ecrecover"))
+	sig, err := crypto.Sign(hash[:], key)
+	if err != nil {
+		t.Fatalf("can't sign: %v", err)
+	}
+	input, err := EncodeEcrecoverInput(hash, sig)
+	if err != nil {
+		t.Fatalf("can't encode input: %v", err)
+	}
+	input[63] = 29 // recovery id out of range
+
+	ret, err := new(ecrecover).Run(nil, 0, nil, input, nil)
+	if err != nil {
+		t.Fatalf("ecrecover failed: %v", err)
+	}
+	if len(ret) != 0 {
+		t.Errorf("invalid signature returned %x, want empty", ret)
+	}
+	if n := ecrecoverInvalidCounter.Count(); n != 1 {
+		t.Errorf("invalid counter: have %d, want 1", n)
+	}
+	if n := ecrecoverFailedCounter.Count(); n != 0 {
+		t.Errorf("failed counter: have %d, want 0", n)
+	}
+}
+
+func TestBatchEcrecover(t *testing.T) {
+	var (
+		input []byte
+		want  []byte
+	)
+	for i := 0; i < 4; i++ {
+		key, _ := crypto.GenerateKey()
+		hash := crypto.Keccak256Hash([]byte{byte(i)})
+		sig, err := crypto.Sign(hash[:], key)
+		if err != nil {
+			t.Fatalf("can't sign: %v", err)
+		}
+		record, err := EncodeEcrecoverInput(hash, sig)
+		if err != nil {
+			t.Fatalf("can't encode input: %v", err)
+		}
+		addr := common.LeftPadBytes(crypto.PubkeyToAddress(key.PublicKey).Bytes(), 32)
+		if i%2 == 1 {
+			// Invalidate every other record by corrupting its recovery id.
+			record[63] = 30
+			addr = make([]byte, 32)
+		}
+		input = append(input, record...)
+		want = append(want, addr...)
+	}
+
+	config := params.MainnetChainConfig
+	p, ok := GetInstance().PrecompiledContractsByBlock(config.EnableFuxiPrecompiled, config)[common.BytesToAddress([]byte{70})]
+	if !ok {
+		t.Fatal("batch ecrecover precompile not active at the Fuxi fork")
+	}
+	if gas, want := p.RequiredGas(input), 4*params.EcrecoverGas-3*batchEcrecoverDiscount; gas != want {
+		t.Errorf("gas mismatch: have %d, want %d", gas, want)
+	}
+	if gas := p.RequiredGas(input[:128]); gas != params.EcrecoverGas {
+		t.Errorf("single record gas mismatch: have %d, want %d", gas, params.EcrecoverGas)
+	}
+	ret, err := p.Run(nil, 0, nil, input, nil)
+	if err != nil {
+		t.Fatalf("batch ecrecover failed: %v", err)
+	}
+	if !bytes.Equal(ret, want) {
+		t.Fatalf("recovered addresses mismatch:\nhave %x\nwant %x", ret, want)
+	}
+	if _, err := p.Run(nil, 0, nil, input[:200], nil); err != errBatchEcrecoverInputLength {
+		t.Errorf("truncated input: have %v, want %v", err, errBatchEcrecoverInputLength)
+	}
+}
+
+func TestEstimateSystemContractGas(t *testing.T) {
+	db, _ := mcdb.NewMemDatabase()
+	statedb, _ := state.New(common.Hash{}, state.NewDatabase(db))
+
+	// A system contract storing 1 into slot 0.
+	code := []byte{byte(vm.PUSH1), 1, byte(vm.PUSH1), 0, byte(vm.SSTORE), byte(vm.STOP)}
+	statedb.SetCode(systemContractEntryAddrV1, code)
+
+	config := &params.ChainConfig{ChainId: big.NewInt(99), EnableFuxiPrecompiled: big.NewInt(0)}
+	evm := vm.NewEVM(vm.Context{BlockNumber: big.NewInt(1)}, statedb, config, vm.Config{}, nil)
+
+	caller := common.HexToAddress("0x1234")
+	estimate, err := EstimateSystemContractGas(evm, caller, nil, 1000000)
+	if err != nil {
+		t.Fatalf("estimate failed: %v", err)
+	}
+	if slot := statedb.GetState(systemContractEntryAddrV1, common.Hash{}); slot != (common.Hash{}) {
+		t.Fatalf("estimate left state changes behind: slot 0 = %x", slot)
+	}
+
+	// Compare against a metered run of the precompile.
+	const gas = 1000000
+	contract := vm.NewContract(vm.AccountRef(caller), vm.AccountRef(systemContractEntryAddrV1), big.NewInt(0), gas)
+	contract.SetCallCode(&systemContractEntryAddrV1, statedb.GetCodeHash(systemContractEntryAddrV1), code)
+	p := new(systemContract)
+	if _, err := GetInstance().RunPrecompiledContract(evm, statedb.Snapshot(), p, nil, contract, nil); err != nil {
+		t.Fatalf("system contract run failed: %v", err)
+	}
+	if actual := gas - contract.Gas; estimate != actual {
+		t.Errorf("estimate mismatch: have %d, actual run used %d", estimate, actual)
+	}
+	if estimate <= params.SystemContractGas {
+		t.Errorf("estimate %d does not cover the inner execution", estimate)
+	}
+
+	if _, err := EstimateSystemContractGas(evm, caller, nil, params.SystemContractGas-1); err != vm.ErrOutOfGas {
+		t.Errorf("low gas cap: have %v, want %v", err, vm.ErrOutOfGas)
+	}
+}
+
+func TestSystemCallDepthGuard(t *testing.T) {
+	db, _ := mcdb.NewMemDatabase()
+	statedb, _ := state.New(common.Hash{}, state.NewDatabase(db))
+
+	// A system contract counting its invocations in slot 0 and re-entering
+	// itself through a delegate call, which keeps the system caller.
+	code := []byte{
+		byte(vm.PUSH1), 0, byte(vm.SLOAD), byte(vm.PUSH1), 1, byte(vm.ADD), byte(vm.PUSH1), 0, byte(vm.SSTORE),
+		byte(vm.PUSH1), 0, byte(vm.PUSH1), 0, byte(vm.PUSH1), 0, byte(vm.PUSH1), 0,
+		byte(vm.PUSH20),
+	}
+	code = append(code, systemContractEntryAddrV1.Bytes()...)
+	code = append(code, byte(vm.GAS), byte(vm.DELEGATECALL), byte(vm.POP), byte(vm.STOP))
+	statedb.SetCode(systemContractEntryAddrV1, code)
+
+	config := &params.ChainConfig{ChainId: big.NewInt(99), EnableFuxiPrecompiled: big.NewInt(0)}
+	evm := vm.NewEVM(vm.Context{BlockNumber: big.NewInt(1)}, statedb, config, vm.Config{}, nil)
+	contract := vm.NewContract(vm.AccountRef(systemContractCallAddr), vm.AccountRef(systemContractEntryAddrV1), big.NewInt(0), 1<<40)
+	contract.SetCallCode(&systemContractEntryAddrV1, statedb.GetCodeHash(systemContractEntryAddrV1), code)
+	if _, err := new(systemContract).Run(evm, statedb.Snapshot(), contract, nil, nil); err != nil {
+		t.Fatalf("system call failed: %v", err)
+	}
+	// The innermost call is refused and reverted, every level above it ran.
+	want := common.BigToHash(big.NewInt(MaxSystemCallDepth))
+	if have := statedb.GetState(systemContractEntryAddrV1, common.Hash{}); have != want {
+		t.Fatalf("nested system calls: have %d, want %d", have.Big(), want.Big())
+	}
+}
+
+func TestRequiredGasAt(t *testing.T) {
+	config := &params.ChainConfig{ChainId: big.NewInt(99), EnableFuxiPrecompiled: big.NewInt(0)}
+	sha := common.BytesToAddress([]byte{2})
+	for _, size := range []int{0, 1, 32, 33, 100} {
+		gas, ok := GetInstance().RequiredGasAt(big.NewInt(1), config, sha, make([]byte, size))
+		if !ok {
+			t.Fatalf("sha256 not reported as precompile")
+		}
+		words := uint64(size+31) / 32
+		if want := params.Sha256BaseGas + words*params.Sha256PerWordGas; gas != want {
+			t.Errorf("sha256 of %d bytes: have gas %d, want %d", size, gas, want)
+		}
+	}
+	if gas, ok := GetInstance().RequiredGasAt(big.NewInt(1), config, common.HexToAddress("0x1234"), nil); ok {
+		t.Errorf("non-precompile address reported as precompile with gas %d", gas)
+	}
+}
+
+func TestInactivePrecompileAddresses(t *testing.T) {
+	config := &params.ChainConfig{ChainId: big.NewInt(99), EnableFuxiPrecompiled: big.NewInt(100)}
+	inactive := make(map[common.Address]bool)
+	for _, addr := range GetInstance().InactivePrecompileAddresses(big.NewInt(1), config) {
+		inactive[addr] = true
+	}
+	for b := byte(60); b <= 68; b++ {
+		if addr := common.BytesToAddress([]byte{b}); !inactive[addr] {
+			t.Errorf("BLS precompile %x not reported as inactive before Fuxi", addr)
+		}
+	}
+	for addr := range precompiledContractsPangu {
+		if inactive[addr] {
+			t.Errorf("Pangu precompile %x reported as inactive", addr)
+		}
+	}
+}
+
+func TestWhiteListNotDeployed(t *testing.T) {
+	db, _ := mcdb.NewMemDatabase()
+	statedb, _ := state.New(common.Hash{}, state.NewDatabase(db))
+
+	config := &params.ChainConfig{ChainId: big.NewInt(99), EnableFuxiPrecompiled: big.NewInt(0)}
+	if !params.PriorityChain(config.ChainId.Uint64()) {
+		t.Fatalf("chain %d has no whitelist", config.ChainId)
+	}
+	evm := vm.NewEVM(vm.Context{BlockNumber: big.NewInt(1)}, statedb, config, vm.Config{}, nil)
+	caller := common.HexToAddress("0x1234")
+
+	if status := LookupWhiteList(evm, caller); status != WhiteListUnavailable {
+		t.Fatalf("status mismatch: have %d, want %d", status, WhiteListUnavailable)
+	}
+	defer func(policy bool) { WhiteListFailOpen = policy }(WhiteListFailOpen)
+	for _, failOpen := range []bool{false, true} {
+		WhiteListFailOpen = failOpen
+		if allowed := IsInWhiteList(evm, caller); allowed != failOpen {
+			t.Errorf("fail open %v: caller allowed %v", failOpen, allowed)
+		}
+	}
+}
+
+func TestDescribeFuxi(t *testing.T) {
+	evm := newTestEVM(1, 99)
+	infos := GetInstance().Describe(big.NewInt(1), evm.ChainConfig())
+
+	names := make(map[common.Address]string)
+	for i, info := range infos {
+		if i > 0 && bytes.Compare(infos[i-1].Address[:], info.Address[:]) >= 0 {
+			t.Errorf("infos not ordered by address at %d", i)
+		}
+		if info.Gas == "" || info.Gas == "unknown" {
+			t.Errorf("%s: missing gas model", info.Name)
+		}
+		names[info.Address] = info.Name
+	}
+	want := map[byte]string{
+		60: "bls12381G1Add",
+		61: "bls12381G1Mul",
+		62: "bls12381G1MultiExp",
+		63: "bls12381G2Add",
+		64: "bls12381G2Mul",
+		65: "bls12381G2MultiExp",
+		66: "bls12381Pairing",
+		67: "bls12381MapG1",
+		68: "bls12381MapG2",
+	}
+	for addr, name := range want {
+		if have := names[common.BytesToAddress([]byte{addr})]; have != name {
+			t.Errorf("address %d: name mismatch: have %q, want %q", addr, have, name)
+		}
+	}
+}
+
+func TestPrecompileInputLengths(t *testing.T) {
+	tests := []struct {
+		name     string
+		contract vm.PrecompiledContract
+		length   int
+		multiple bool
+		err      error
+	}{
+		{"checkShardValid", &checkShardValid{}, 100, false, errBadEnrollCheckArgs},
+		{"localShardCheckAndEnroll", &localShardCheckAndEnroll{}, 132, false, errBadEnrollArgs},
+		{"bls12381G1Add", &bls12381G1Add{}, 256, false, errBLS12381InvalidInputLength},
+		{"bls12381G1Mul", &bls12381G1Mul{}, 160, false, errBLS12381InvalidInputLength},
+		{"bls12381G1MultiExp", &bls12381G1MultiExp{}, 160, true, errBLS12381InvalidInputLength},
+		{"bls12381G2Add", &bls12381G2Add{}, 512, false, errBLS12381InvalidInputLength},
+		{"bls12381G2Mul", &bls12381G2Mul{}, 288, false, errBLS12381InvalidInputLength},
+		{"bls12381G2MultiExp", &bls12381G2MultiExp{}, 288, true, errBLS12381InvalidInputLength},
+		{"bls12381Pairing", &bls12381Pairing{}, 384, true, errBLS12381InvalidInputLength},
+		{"bls12381MapG1", &bls12381MapG1{}, 64, false, errBLS12381InvalidInputLength},
+		{"bls12381MapG2", &bls12381MapG2{}, 128, false, errBLS12381InvalidInputLength},
+	}
+	for _, tt := range tests {
+		bad := []int{0, tt.length - 1, tt.length + 1}
+		if !tt.multiple {
+			bad = append(bad, 2*tt.length)
+		}
+		for _, n := range bad {
+			if _, err := tt.contract.Run(nil, 0, nil, make([]byte, n), nil); err != tt.err {
+				t.Errorf("%s: input length %d: have error %v, want %v", tt.name, n, err, tt.err)
+			}
+		}
+	}
+}
+
+// newWhiteListEVM creates an EVM on a priority chain with a whitelist contract
+// returning the storage slot named by the first argument, after writing to
+// slot 1. The given callers are whitelisted.
+func newWhiteListEVM(callers ...common.Address) (*vm.EVM, *state.StateDB) {
+	db, _ := mcdb.NewMemDatabase()
+	statedb, _ := state.New(common.Hash{}, state.NewDatabase(db))
+
+	code := []byte{
+		byte(vm.PUSH1), 1, byte(vm.PUSH1), 1, byte(vm.SSTORE),
+		byte(vm.PUSH1), 4, byte(vm.CALLDATALOAD), byte(vm.SLOAD),
+		byte(vm.PUSH1), 0, byte(vm.MSTORE),
+		byte(vm.PUSH1), 32, byte(vm.PUSH1), 0, byte(vm.RETURN),
+	}
+	statedb.SetCode(whiteListContractCallAddr, code)
+	for _, caller := range callers {
+		var key common.Hash
+		copy(key[:], caller.Bytes())
+		statedb.SetState(whiteListContractCallAddr, key, common.BytesToHash([]byte{1}))
+	}
+	config := &params.ChainConfig{ChainId: big.NewInt(99), EnableFuxiPrecompiled: big.NewInt(0)}
+	return vm.NewEVM(vm.Context{BlockNumber: big.NewInt(1)}, statedb, config, vm.Config{}, nil), statedb
+}
+
+func TestLookupWhiteListReadOnly(t *testing.T) {
+	allowed := common.HexToAddress("0x1234")
+	evm, statedb := newWhiteListEVM(allowed)
+
+	callers := []common.Address{allowed, common.HexToAddress("0x5678"), {}}
+	for _, caller := range callers {
+		readOnly := LookupWhiteListReadOnly(evm, caller)
+		if slot := statedb.GetState(whiteListContractCallAddr, common.BytesToHash([]byte{1})); slot != (common.Hash{}) {
+			t.Fatalf("read-only lookup wrote to the state: slot 1 = %x", slot)
+		}
+		if snapshot := LookupWhiteList(evm, caller); readOnly != snapshot {
+			t.Errorf("caller %x: read-only status %d, snapshot status %d", caller, readOnly, snapshot)
+		}
+	}
+	if status := LookupWhiteListReadOnly(evm, allowed); status != WhiteListAllowed {
+		t.Errorf("whitelisted caller: have %d, want %d", status, WhiteListAllowed)
+	}
+
+	// Concurrent lookups agree with the sequential ones.
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(caller common.Address, want WhiteListStatus) {
+			defer wg.Done()
+			if status := LookupWhiteListReadOnly(evm, caller); status != want {
+				t.Errorf("concurrent lookup of %x: have %d, want %d", caller, status, want)
+			}
+		}(callers[i%2], WhiteListStatus(1-i%2))
+	}
+	wg.Wait()
+
+	stateLocks.Lock()
+	defer stateLocks.Unlock()
+	if n := len(stateLocks.locks); n != 0 {
+		t.Errorf("state locks left after lookups: %d", n)
+	}
+}
+
+func TestLookupWhiteListReadOnlyState(t *testing.T) {
+	allowed := common.HexToAddress("0x1234")
+	evm, statedb := newWhiteListEVM(allowed)
+	root := statedb.IntermediateRoot(true)
+
+	// The whitelist contract writes slot 1 on every lookup.
+	for _, caller := range []common.Address{allowed, common.HexToAddress("0x5678")} {
+		LookupWhiteListReadOnly(evm, caller)
+	}
+	if slot := statedb.GetState(whiteListContractCallAddr, common.BytesToHash([]byte{1})); slot != (common.Hash{}) {
+		t.Errorf("storage write reached the state: slot 1 = %x", slot)
+	}
+	if have := statedb.IntermediateRoot(true); have != root {
+		t.Errorf("state root changed by lookups: have %x, want %x", have, root)
+	}
+}
+
+func BenchmarkLookupWhiteList(b *testing.B) {
+	caller := common.HexToAddress("0x1234")
+	evm, _ := newWhiteListEVM(caller)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		LookupWhiteList(evm, caller)
+	}
+}
+
+func BenchmarkLookupWhiteListReadOnly(b *testing.B) {
+	caller := common.HexToAddress("0x1234")
+	evm, _ := newWhiteListEVM(caller)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		LookupWhiteListReadOnly(evm, caller)
+	}
+}
+
+func TestReadOnlyState(t *testing.T) {
+	db, _ := mcdb.NewMemDatabase()
+	statedb, _ := state.New(common.Hash{}, state.NewDatabase(db))
+	addr := common.HexToAddress("0x1234")
+	key := common.BytesToHash([]byte{1})
+	statedb.SetState(addr, key, common.BytesToHash([]byte{1}))
+
+	view := newReadOnlyState(statedb)
+	defer view.release()
+
+	snapshot := view.Snapshot()
+	view.SetState(addr, key, common.BytesToHash([]byte{2}))
+	view.AddRefund(big.NewInt(10))
+	inner := view.Snapshot()
+	view.SetState(addr, key, common.BytesToHash([]byte{3}))
+	view.AddRefund(big.NewInt(5))
+
+	view.RevertToSnapshot(inner)
+	if value := view.GetState(addr, key); value != common.BytesToHash([]byte{2}) {
+		t.Errorf("state after inner revert: have %x, want 2", value)
+	}
+	if refund := view.GetRefund(); refund.Int64() != 10 {
+		t.Errorf("refund after inner revert: have %v, want 10", refund)
+	}
+	view.RevertToSnapshot(snapshot)
+	if value := view.GetState(addr, key); value != common.BytesToHash([]byte{1}) {
+		t.Errorf("state after revert: have %x, want 1", value)
+	}
+	if refund := view.GetRefund(); refund.Sign() != 0 {
+		t.Errorf("refund after revert: have %v, want 0", refund)
+	}
+
+	// Writes never reach the underlying state.
+	view.SetState(addr, key, common.BytesToHash([]byte{4}))
+	view.AddRefund(big.NewInt(7))
+	view.AddBalance(addr, big.NewInt(1))
+	view.AddPreimage(common.Hash{}, []byte{1})
+	if value := statedb.GetState(addr, key); value != common.BytesToHash([]byte{1}) {
+		t.Errorf("underlying state written: have %x, want 1", value)
+	}
+	if refund := statedb.GetRefund(); refund.Sign() != 0 {
+		t.Errorf("underlying refund written: have %v, want 0", refund)
+	}
+	if balance := statedb.GetBalance(addr); balance.Sign() != 0 {
+		t.Errorf("underlying balance written: have %v, want 0", balance)
+	}
+}
+
+// mockScsRelay records the notifications of the notifySCS precompile.
+type mockScsRelay struct {
+	callers []common.Address
+	msgs    [][]byte
+	hashes  []common.Hash
+}
+
+func (r *mockScsRelay) NotifyScs(address common.Address, msg []byte, hash common.Hash, block *big.Int) {
+	r.callers = append(r.callers, address)
+	r.msgs = append(r.msgs, msg)
+	r.hashes = append(r.hashes, hash)
+}
+
+func TestNotifySCSRelay(t *testing.T) {
+	allowed, other := common.HexToAddress("0x1234"), common.HexToAddress("0x5678")
+	evm, _ := newWhiteListEVM(allowed, other)
+	evm.ChainConfig().NuwaBlock = big.NewInt(0)
+
+	relay := new(mockScsRelay)
+	defer func(r ScsNotifier, callers map[common.Address]bool) {
+		scsRelayOverride, ScsNotifyCallers = r, callers
+	}(scsRelayOverride, ScsNotifyCallers)
+	scsRelayOverride = relay
+	ScsNotifyCallers = map[common.Address]bool{allowed: true}
+
+	p := GetInstance().PrecompiledContractsFuxi()[common.BytesToAddress([]byte{13})]
+	hash := common.HexToHash("0xabcd")
+	input := []byte("notify")
+	tests := []struct {
+		caller common.Address
+		want   []byte
+	}{
+		{allowed, true32Byte},
+		{other, true32Byte}, // filtered, but still whitelisted
+		{common.HexToAddress("0x9999"), false32Byte},
+	}
+	for _, tt := range tests {
+		contract := vm.NewContract(vm.AccountRef(tt.caller), vm.AccountRef(common.BytesToAddress([]byte{13})), new(big.Int), p.RequiredGas(input))
+		ret, err := p.Run(evm, 0, contract, input, &hash)
+		if err != nil {
+			t.Fatalf("caller %x: notify failed: %v", tt.caller, err)
+		}
+		if !bytes.Equal(ret, tt.want) {
+			t.Errorf("caller %x: result mismatch: have %x, want %x", tt.caller, ret, tt.want)
+		}
+	}
+	if len(relay.callers) != 1 {
+		t.Fatalf("notifications: have %d, want 1", len(relay.callers))
+	}
+	if relay.callers[0] != allowed || !bytes.Equal(relay.msgs[0], input) || relay.hashes[0] != hash {
+		t.Errorf("notification mismatch: caller %x, msg %q, hash %x", relay.callers[0], relay.msgs[0], relay.hashes[0])
+	}
+}
+
+// spendGasInput encodes num as the input of the spendGas precompile.
+func spendGasInput(num int64) []byte {
+	return append(make([]byte, 4), common.LeftPadBytes(big.NewInt(num).Bytes(), 32)...)
+}
+
+func TestSpendGasDefaultTiers(t *testing.T) {
+	// The schedule before it was made configurable.
+	legacy := func(num int64) uint64 {
+		gas := int64(1000)
+		for i, threshold := range []int64{10, 15, 20, 25, 30, 35, 40, 45} {
+			if num > threshold {
+				gas += (2000 << uint(i)) * (num - threshold)
+			}
+		}
+		return uint64(gas)
+	}
+	p := new(spendGas)
+	for num := int64(0); num <= 60; num++ {
+		if have, want := p.RequiredGas(spendGasInput(num)), legacy(num); have != want {
+			t.Errorf("num %d: gas mismatch: have %d, want %d", num, have, want)
+		}
+	}
+	if gas := p.RequiredGas(nil); gas != 8000000 {
+		t.Errorf("malformed input: gas %d, want 8000000", gas)
+	}
+}
+
+func TestSpendGasCustomTiers(t *testing.T) {
+	defer SetSpendGasTiers(DefaultSpendGasBase, DefaultSpendGasTiers)
+
+	if err := SetSpendGasTiers(100, []SpendGasTier{{5, 10}, {3, 20}}); err != errSpendGasTiers {
+		t.Errorf("decreasing thresholds: have %v, want %v", err, errSpendGasTiers)
+	}
+	if err := SetSpendGasTiers(100, []SpendGasTier{{5, -10}}); err != errSpendGasTiers {
+		t.Errorf("negative price: have %v, want %v", err, errSpendGasTiers)
+	}
+	if err := SetSpendGasTiers(100, []SpendGasTier{{5, 10}, {8, 20}}); err != nil {
+		t.Fatalf("valid tiers rejected: %v", err)
+	}
+	p := new(spendGas)
+	for _, tt := range []struct {
+		num  int64
+		want uint64
+	}{
+		{0, 100}, {5, 100}, {6, 110}, {8, 130}, {9, 160}, {10, 190},
+	} {
+		if gas := p.RequiredGas(spendGasInput(tt.num)); gas != tt.want {
+			t.Errorf("num %d: gas mismatch: have %d, want %d", tt.num, gas, tt.want)
+		}
+	}
+}
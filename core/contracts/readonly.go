@@ -0,0 +1,230 @@
+// Copyright 2021 The MOAC-core Authors
+// This file is part of the MOAC-core library.
+//
+// The MOAC-core library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The MOAC-core library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the MOAC-core library. If not, see <http://www.gnu.org/licenses/>.
+
+package contracts
+
+import (
+	"math/big"
+	"sync"
+
+	"github.com/MOACChain/MoacLib/common"
+	"github.com/MOACChain/MoacLib/types"
+	"github.com/MOACChain/MoacLib/vm"
+)
+
+// stateLocks holds a lock for each state read through read-only views.
+// Reading fills the caches of the state, so it isn't safe for concurrent use
+// by itself. Views of different states don't wait for each other.
+var stateLocks = struct {
+	sync.Mutex
+	locks map[vm.StateDB]*stateLock
+}{locks: make(map[vm.StateDB]*stateLock)}
+
+// stateLock serializes the reads of one state, refs counts the views using it.
+type stateLock struct {
+	sync.Mutex
+	refs int
+}
+
+// acquireStateLock returns the lock of db, registering a new view of it.
+func acquireStateLock(db vm.StateDB) *stateLock {
+	stateLocks.Lock()
+	defer stateLocks.Unlock()
+	l := stateLocks.locks[db]
+	if l == nil {
+		l = new(stateLock)
+		stateLocks.locks[db] = l
+	}
+	l.refs++
+	return l
+}
+
+// releaseStateLock unregisters a view of db, dropping the lock with the last.
+func releaseStateLock(db vm.StateDB) {
+	stateLocks.Lock()
+	defer stateLocks.Unlock()
+	if l := stateLocks.locks[db]; l != nil {
+		if l.refs--; l.refs == 0 {
+			delete(stateLocks.locks, db)
+		}
+	}
+}
+
+// readOnlyState is a vm.StateDB view which keeps writes to itself. Storage
+// writes and refunds are visible to later reads of the view and are undone
+// by RevertToSnapshot like on the state. All other writes are discarded.
+// Reads of the underlying state are cached, so repeated reads don't contend
+// for its lock. The view must be released after use.
+//
+// The underlying state is only reachable through the read methods below, so
+// a method added to vm.StateDB fails to compile here instead of writing
+// through the view.
+type readOnlyState struct {
+	db   vm.StateDB
+	lock *stateLock
+
+	reads   map[common.Address]map[common.Hash]common.Hash // storage read from the state
+	storage map[common.Address]map[common.Hash]common.Hash // storage written to the view
+	code    map[common.Address][]byte
+	refund  *big.Int
+
+	journal   []func() // undoes the writes of the view, in order
+	snapshots []int    // journal length at each snapshot
+}
+
+var _ vm.StateDB = (*readOnlyState)(nil)
+
+func newReadOnlyState(db vm.StateDB) *readOnlyState {
+	return &readOnlyState{
+		db:      db,
+		lock:    acquireStateLock(db),
+		reads:   make(map[common.Address]map[common.Hash]common.Hash),
+		storage: make(map[common.Address]map[common.Hash]common.Hash),
+		code:    make(map[common.Address][]byte),
+		refund:  new(big.Int),
+	}
+}
+
+// release drops the view's registration of the underlying state lock.
+func (s *readOnlyState) release() {
+	releaseStateLock(s.db)
+}
+
+func (s *readOnlyState) GetState(addr common.Address, key common.Hash) common.Hash {
+	if value, ok := s.storage[addr][key]; ok {
+		return value
+	}
+	if value, ok := s.reads[addr][key]; ok {
+		return value
+	}
+	s.lock.Lock()
+	value := s.db.GetState(addr, key)
+	s.lock.Unlock()
+	if s.reads[addr] == nil {
+		s.reads[addr] = make(map[common.Hash]common.Hash)
+	}
+	s.reads[addr][key] = value
+	return value
+}
+
+func (s *readOnlyState) SetState(addr common.Address, key common.Hash, value common.Hash) {
+	if s.storage[addr] == nil {
+		s.storage[addr] = make(map[common.Hash]common.Hash)
+	}
+	prev, written := s.storage[addr][key]
+	s.journal = append(s.journal, func() {
+		if written {
+			s.storage[addr][key] = prev
+		} else {
+			delete(s.storage[addr], key)
+		}
+	})
+	s.storage[addr][key] = value
+}
+
+func (s *readOnlyState) AddRefund(gas *big.Int) {
+	prev := new(big.Int).Set(s.refund)
+	s.journal = append(s.journal, func() { s.refund = prev })
+	s.refund = new(big.Int).Add(s.refund, gas)
+}
+
+func (s *readOnlyState) GetRefund() *big.Int {
+	return new(big.Int).Set(s.refund)
+}
+
+func (s *readOnlyState) Snapshot() int {
+	s.snapshots = append(s.snapshots, len(s.journal))
+	return len(s.snapshots) - 1
+}
+
+func (s *readOnlyState) RevertToSnapshot(id int) {
+	if id < 0 || id >= len(s.snapshots) {
+		return
+	}
+	for i := len(s.journal) - 1; i >= s.snapshots[id]; i-- {
+		s.journal[i]()
+	}
+	s.journal = s.journal[:s.snapshots[id]]
+	s.snapshots = s.snapshots[:id]
+}
+
+func (s *readOnlyState) GetBalance(addr common.Address) *big.Int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return new(big.Int).Set(s.db.GetBalance(addr))
+}
+
+func (s *readOnlyState) GetNonce(addr common.Address) uint64 {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.db.GetNonce(addr)
+}
+
+func (s *readOnlyState) GetCode(addr common.Address) []byte {
+	if code, ok := s.code[addr]; ok {
+		return code
+	}
+	s.lock.Lock()
+	code := s.db.GetCode(addr)
+	s.lock.Unlock()
+	s.code[addr] = code
+	return code
+}
+
+func (s *readOnlyState) GetCodeHash(addr common.Address) common.Hash {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.db.GetCodeHash(addr)
+}
+
+func (s *readOnlyState) GetCodeSize(addr common.Address) int {
+	return len(s.GetCode(addr))
+}
+
+func (s *readOnlyState) Exist(addr common.Address) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.db.Exist(addr)
+}
+
+func (s *readOnlyState) Empty(addr common.Address) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.db.Empty(addr)
+}
+
+func (s *readOnlyState) HasSuicided(addr common.Address) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.db.HasSuicided(addr)
+}
+
+// ForEachStorage iterates the storage of the underlying state, writes to the
+// view are not included.
+func (s *readOnlyState) ForEachStorage(addr common.Address, cb func(key, value common.Hash) bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.db.ForEachStorage(addr, cb)
+}
+
+func (s *readOnlyState) CreateAccount(common.Address)        {}
+func (s *readOnlyState) AddBalance(common.Address, *big.Int) {}
+func (s *readOnlyState) SubBalance(common.Address, *big.Int) {}
+func (s *readOnlyState) SetNonce(common.Address, uint64)     {}
+func (s *readOnlyState) SetCode(common.Address, []byte)      {}
+func (s *readOnlyState) Suicide(common.Address) bool         { return false }
+func (s *readOnlyState) AddLog(*types.Log)                   {}
+func (s *readOnlyState) AddPreimage(common.Hash, []byte)     {}
@@ -38,6 +38,7 @@ import (
 	"github.com/MOACChain/xchain/accounts/keystore"
 	"github.com/MOACChain/xchain/consensus/ethash"
 	"github.com/MOACChain/xchain/core"
+	"github.com/MOACChain/xchain/core/contracts"
 	"github.com/MOACChain/xchain/p2p"
 	"github.com/MOACChain/xchain/p2p/discover"
 	"github.com/MOACChain/xchain/rpc"
@@ -795,6 +796,10 @@ func (s *PublicBlockChainAPI) EstimateGas(ctx context.Context, args CallArgs) (*
 		}
 		hi = block.GasLimit().Uint64()
 	}
+	// System contract calls may run unmetered, meter them explicitly
+	if args.To != nil && *args.To == contracts.GetInstance().SystemContractEntryAddr(nil) {
+		return s.estimateSystemContractGas(ctx, args, hi)
+	}
 
 	for lo+1 < hi {
 		// Take a guess at the gas, and check transaction validity
@@ -812,6 +817,27 @@ func (s *PublicBlockChainAPI) EstimateGas(ctx context.Context, args CallArgs) (*
 	return (*hexutil.Big)(new(big.Int).SetUint64(hi)), nil
 }
 
+// estimateSystemContractGas estimates the gas of a call to the system contract
+// by metering a single run of it on the pending state.
+func (s *PublicBlockChainAPI) estimateSystemContractGas(ctx context.Context, args CallArgs, cap uint64) (*hexutil.Big, error) {
+	state, header, err := s.b.StateAndHeaderByNumber(ctx, rpc.PendingBlockNumber)
+	if state == nil || err != nil {
+		return nil, err
+	}
+	msg := types.NewMessage(args.From, args.To, 0, args.Value.ToInt(), new(big.Int).SetUint64(cap), args.GasPrice.ToInt(), args.Data,
+		false, false, false, big.NewInt(0), args.ShardingFlag, args.Via, nil)
+	evm, _, err := s.b.GetEVM(ctx, msg, state, header, vm.Config{})
+	if err != nil {
+		return nil, err
+	}
+	gas, err := contracts.EstimateSystemContractGas(evm, args.From, args.Data, cap)
+	if err != nil {
+		return nil, err
+	}
+	gas += core.IntrinsicGas(args.Data, false, evm.ChainConfig().IsPangu(evm.BlockNumber)).Uint64()
+	return (*hexutil.Big)(new(big.Int).SetUint64(gas)), nil
+}
+
 // ExecutionResult groups all structured logs emitted by the EVM
 // while replaying a transaction in debug mode as well as the amount of
 // gas used and the return value
@@ -198,6 +198,9 @@ func New(ctx *node.ServiceContext, config *Config) (*MoacService, error) {
 
 	mcSrv.miner = miner.New(mcSrv, mcSrv.chainConfig, mcSrv.EventMux(), mcSrv.engine)
 	mcSrv.miner.SetExtra(makeExtraData(config.ExtraData))
+	mcSrv.miner.SetQuietUnconfirmed(config.MinerQuietUnconfirmed)
+	mcSrv.miner.SetWebhook(config.MinerNotify)
+	mcSrv.miner.SetRetryUnconfirmed(config.MinerRetryUnconfirmed)
 
 	mcSrv.ApiBackend = &MoacApiBackend{mcSrv, nil}
 	gpoParams := config.GPO
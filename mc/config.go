@@ -101,6 +101,18 @@ type Config struct {
 	ExtraData    []byte         `toml:",omitempty"`
 	GasPrice     *big.Int
 
+	// MinerQuietUnconfirmed demotes the per block canonical/side fork logs
+	// of mined blocks to debug level and logs a periodic summary instead.
+	MinerQuietUnconfirmed bool `toml:",omitempty"`
+
+	// MinerNotify is an HTTP endpoint notified of mined blocks reaching the
+	// canonical chain or becoming side forks.
+	MinerNotify string `toml:",omitempty"`
+
+	// MinerRetryUnconfirmed keeps mined blocks whose header can't be retrieved
+	// for up to this many blocks past the confirmation depth.
+	MinerRetryUnconfirmed uint64 `toml:",omitempty"`
+
 	// Ethash options
 	EthashCacheDir       string
 	EthashCachesInMem    int
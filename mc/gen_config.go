@@ -28,6 +28,9 @@ func (c Config) MarshalTOML() (interface{}, error) {
 		MinerThreads            int            `toml:",omitempty"`
 		ExtraData               hexutil.Bytes  `toml:",omitempty"`
 		GasPrice                *big.Int
+		MinerQuietUnconfirmed   bool   `toml:",omitempty"`
+		MinerNotify             string `toml:",omitempty"`
+		MinerRetryUnconfirmed   uint64 `toml:",omitempty"`
 		EthashCacheDir          string
 		EthashCachesInMem       int
 		EthashCachesOnDisk      int
@@ -55,6 +58,9 @@ func (c Config) MarshalTOML() (interface{}, error) {
 	enc.MinerThreads = c.MinerThreads
 	enc.ExtraData = c.ExtraData
 	enc.GasPrice = c.GasPrice
+	enc.MinerQuietUnconfirmed = c.MinerQuietUnconfirmed
+	enc.MinerNotify = c.MinerNotify
+	enc.MinerRetryUnconfirmed = c.MinerRetryUnconfirmed
 	enc.EthashCacheDir = c.EthashCacheDir
 	enc.EthashCachesInMem = c.EthashCachesInMem
 	enc.EthashCachesOnDisk = c.EthashCachesOnDisk
@@ -88,6 +94,9 @@ func (c *Config) UnmarshalTOML(unmarshal func(interface{}) error) error {
 		MinerThreads            *int            `toml:",omitempty"`
 		ExtraData               hexutil.Bytes   `toml:",omitempty"`
 		GasPrice                *big.Int
+		MinerQuietUnconfirmed   *bool   `toml:",omitempty"`
+		MinerNotify             *string `toml:",omitempty"`
+		MinerRetryUnconfirmed   *uint64 `toml:",omitempty"`
 		EthashCacheDir          *string
 		EthashCachesInMem       *int
 		EthashCachesOnDisk      *int
@@ -142,6 +151,15 @@ func (c *Config) UnmarshalTOML(unmarshal func(interface{}) error) error {
 	if dec.GasPrice != nil {
 		c.GasPrice = dec.GasPrice
 	}
+	if dec.MinerQuietUnconfirmed != nil {
+		c.MinerQuietUnconfirmed = *dec.MinerQuietUnconfirmed
+	}
+	if dec.MinerNotify != nil {
+		c.MinerNotify = *dec.MinerNotify
+	}
+	if dec.MinerRetryUnconfirmed != nil {
+		c.MinerRetryUnconfirmed = *dec.MinerRetryUnconfirmed
+	}
 	if dec.EthashCacheDir != nil {
 		c.EthashCacheDir = *dec.EthashCacheDir
 	}
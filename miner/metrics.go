@@ -0,0 +1,56 @@
+// Copyright 2021 The MOAC-core Authors
+// This file is part of the MOAC-core library.
+//
+// The MOAC-core library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The MOAC-core library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the MOAC-core library. If not, see <http://www.gnu.org/licenses/>.
+
+// Contains the metrics collected by the miner.
+
+package miner
+
+import (
+	"github.com/MOACChain/MoacLib/metrics"
+	gometrics "github.com/rcrowley/go-metrics"
+)
+
+var (
+	// confirmDepthHistogram records how many blocks deep a mined block was
+	// when it was confirmed as part of the canonical chain.
+	confirmDepthHistogram = newHistogram("miner/unconfirmed/confirmdepth")
+	// sideForkCounter counts the mined blocks that became side forks.
+	sideForkCounter = metrics.NewCounter("miner/unconfirmed/sidefork")
+	// reorgDepthGauge reports the deepest side fork of a mined block within
+	// the reorg window.
+	reorgDepthGauge = newGauge("miner/unconfirmed/reorgdepth")
+	// webhookDropCounter counts the block status events dropped because the
+	// webhook endpoint fell behind.
+	webhookDropCounter = metrics.NewCounter("miner/webhook/dropped")
+)
+
+// newHistogram creates and registers a histogram, or returns a no-op one if
+// the metrics system is disabled.
+func newHistogram(name string) gometrics.Histogram {
+	if !metrics.Enabled {
+		return gometrics.NilHistogram{}
+	}
+	return gometrics.GetOrRegisterHistogram(name, gometrics.DefaultRegistry, gometrics.NewExpDecaySample(1028, 0.015))
+}
+
+// newGauge creates and registers a gauge, or returns a no-op one if the
+// metrics system is disabled.
+func newGauge(name string) gometrics.Gauge {
+	if !metrics.Enabled {
+		return gometrics.NilGauge{}
+	}
+	return gometrics.GetOrRegisterGauge(name, gometrics.DefaultRegistry)
+}
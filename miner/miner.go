@@ -173,6 +173,32 @@ func (self *Miner) SetExtra(extra []byte) error {
 	return nil
 }
 
+// SetQuietUnconfirmed demotes the logs of mined blocks reaching the canonical
+// chain or becoming side forks to debug level, keeping a periodic summary.
+func (self *Miner) SetQuietUnconfirmed(quiet bool) {
+	self.worker.unconfirmed.SetQuiet(quiet)
+}
+
+// SetRetryUnconfirmed keeps mined blocks whose header can't be retrieved for up
+// to the given number of blocks past the confirmation depth, instead of
+// reporting them missing right away.
+func (self *Miner) SetRetryUnconfirmed(blocks uint64) {
+	self.worker.unconfirmed.SetRetryMissing(blocks)
+}
+
+// RetrieverUnavailable returns whether the header of a mined block past the
+// confirmation depth couldn't be retrieved on the last check, so the block
+// was kept for a retry.
+func (self *Miner) RetrieverUnavailable() bool {
+	return self.worker.unconfirmed.RetrieverUnavailable()
+}
+
+// SetWebhook POSTs the status of mined blocks reaching the canonical chain or
+// becoming side forks to the given URL. An empty URL disables the webhook.
+func (self *Miner) SetWebhook(url string) {
+	self.worker.unconfirmed.SetWebhook(url)
+}
+
 // Pending returns the currently pending block and associated state.
 func (self *Miner) Pending() (*types.Block, *state.StateDB) {
 	return self.worker.pending()
@@ -19,17 +19,75 @@ package miner
 import (
 	"container/ring"
 	"sync"
+	"time"
 
 	"github.com/MOACChain/MoacLib/common"
 	"github.com/MOACChain/MoacLib/log"
 	"github.com/MOACChain/MoacLib/types"
+	gometrics "github.com/rcrowley/go-metrics"
 )
 
+// unconfirmedSummaryInterval is the interval between summaries of shifted out
+// blocks when the individual logs are demoted.
+const unconfirmedSummaryInterval = 10 * time.Minute
+
+// blockStatusEventBuffer is the number of block status events buffered for a
+// slow consumer before further events are dropped.
+const blockStatusEventBuffer = 64
+
+// reorgDepthWindow is the period over which the maximum reorg depth is
+// reported.
+const reorgDepthWindow = time.Hour
+
+// BlockStatus is the inclusion status of a locally mined block.
+type BlockStatus int
+
+const (
+	BlockMined     BlockStatus = iota // Block was mined locally, not yet confirmed
+	BlockCanonical                    // Block reached the canonical chain
+	BlockSideFork                     // Block became a side fork or was orphaned
+	BlockMissing                      // Header of the block could not be retrieved
+)
+
+// String implements fmt.Stringer.
+func (s BlockStatus) String() string {
+	switch s {
+	case BlockMined:
+		return "mined"
+	case BlockCanonical:
+		return "canonical"
+	case BlockSideFork:
+		return "sidefork"
+	case BlockMissing:
+		return "missing"
+	default:
+		return "unknown"
+	}
+}
+
+// MarshalText implements encoding.TextMarshaler, so that JSON encoded events
+// carry the status name instead of its number.
+func (s BlockStatus) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// BlockStatusEvent is emitted whenever a locally mined block is inserted into
+// or shifted out of the unconfirmed set.
+type BlockStatusEvent struct {
+	Index  uint64      `json:"index"`
+	Hash   common.Hash `json:"hash"`
+	Status BlockStatus `json:"status"`
+}
+
 // headerRetriever is used by the unconfirmed block set to verify whether a previously
 // mined block is part of the canonical chain or not.
 type headerRetriever interface {
 	// GetHeaderByNumber retrieves the canonical header associated with a block number.
 	GetHeaderByNumber(number uint64) *types.Header
+
+	// GetHeaderByHash retrieves a header by hash, regardless of whether it is
+	// canonical or not.
+	GetHeaderByHash(hash common.Hash) *types.Header
 }
 
 // unconfirmedBlock is a small collection of metadata about a locally mined block
@@ -39,6 +97,13 @@ type unconfirmedBlock struct {
 	hash  common.Hash
 }
 
+// reorgSample is the depth of the chain head when a mined block was found to
+// be a side fork.
+type reorgSample struct {
+	time  time.Time
+	depth uint64
+}
+
 // unconfirmedBlocks implements a data structure to maintain locally mined blocks
 // have have not yet reached enough maturity to guarantee chain inclusion. It is
 // used by the miner to provide logs to the user when a previously mined block
@@ -48,16 +113,151 @@ type unconfirmedBlocks struct {
 	depth  uint            // Depth after which to discard previous blocks
 	blocks *ring.Ring      // Block infos to allow canonical chain cross checks
 	lock   sync.RWMutex    // Protects the fields from concurrent access
+
+	quiet       bool          // Whether to demote per block logs to debug level
+	interval    time.Duration // Interval between summaries in quiet mode
+	lastSummary time.Time     // Time the last summary was logged
+	confirmed   uint64        // Number of blocks reaching the canonical chain since the last summary
+	forked      uint64        // Number of blocks becoming side forks since the last summary
+
+	events  chan BlockStatusEvent // Structured status events, nil until requested
+	webhook *blockWebhook         // Endpoint notified of confirmed and forked blocks, nil if unset
+
+	retryMissing uint64 // Blocks past the depth to retry missing headers for, zero to evict right away
+	unavailable  bool   // Whether the last header retrieval of a due block failed
+
+	depthHist   gometrics.Histogram // Depth at which blocks reached the canonical chain
+	forkCounter gometrics.Counter   // Number of blocks that became side forks
+
+	reorgs      []reorgSample   // Side forks observed within the reorg window, oldest first
+	reorgWindow time.Duration   // Period over which the maximum reorg depth is reported
+	reorgGauge  gometrics.Gauge // Maximum reorg depth within the window
 }
 
 // newUnconfirmedBlocks returns new data structure to track currently unconfirmed blocks.
 func newUnconfirmedBlocks(chain headerRetriever, depth uint) *unconfirmedBlocks {
 	return &unconfirmedBlocks{
-		chain: chain,
-		depth: depth,
+		chain:       chain,
+		depth:       depth,
+		interval:    unconfirmedSummaryInterval,
+		lastSummary: time.Now(),
+		depthHist:   confirmDepthHistogram,
+		forkCounter: sideForkCounter,
+		reorgWindow: reorgDepthWindow,
+		reorgGauge:  reorgDepthGauge,
 	}
 }
 
+// SetQuiet sets whether canonical and side fork transitions are logged at debug
+// level, with a periodic summary at info level.
+func (set *unconfirmedBlocks) SetQuiet(quiet bool) {
+	set.lock.Lock()
+	defer set.lock.Unlock()
+
+	set.quiet = quiet
+}
+
+// SetRetryMissing sets for how many blocks past the depth allowance a block is
+// kept in the set when its header can't be retrieved, assuming the chain is
+// temporarily unavailable. Zero reports such blocks missing right away.
+func (set *unconfirmedBlocks) SetRetryMissing(blocks uint64) {
+	set.lock.Lock()
+	defer set.lock.Unlock()
+
+	set.retryMissing = blocks
+}
+
+// RetrieverUnavailable returns whether the last Shift failed to retrieve the
+// header of a due block and kept it for a retry.
+func (set *unconfirmedBlocks) RetrieverUnavailable() bool {
+	set.lock.RLock()
+	defer set.lock.RUnlock()
+
+	return set.unavailable
+}
+
+// SetWebhook sets an HTTP endpoint to POST a JSON encoded BlockStatusEvent to
+// whenever a block reaches the canonical chain or becomes a side fork. Events
+// are dropped if the endpoint falls behind. An empty url removes the webhook.
+func (set *unconfirmedBlocks) SetWebhook(url string) {
+	set.lock.Lock()
+	defer set.lock.Unlock()
+
+	if set.webhook != nil {
+		set.webhook.stop()
+		set.webhook = nil
+	}
+	if url != "" {
+		set.webhook = newBlockWebhook(url)
+	}
+}
+
+// Events returns a channel delivering a BlockStatusEvent for every mined block
+// and for every block shifted out of the set. Events are only produced after
+// the first call, and are dropped if the consumer falls behind.
+func (set *unconfirmedBlocks) Events() <-chan BlockStatusEvent {
+	set.lock.Lock()
+	defer set.lock.Unlock()
+
+	if set.events == nil {
+		set.events = make(chan BlockStatusEvent, blockStatusEventBuffer)
+	}
+	return set.events
+}
+
+// emit delivers a status event without blocking. The caller must hold the lock.
+func (set *unconfirmedBlocks) emit(index uint64, hash common.Hash, status BlockStatus) {
+	ev := BlockStatusEvent{Index: index, Hash: hash, Status: status}
+	if set.webhook != nil && (status == BlockCanonical || status == BlockSideFork) {
+		set.webhook.enqueue(ev)
+	}
+	if set.events == nil {
+		return
+	}
+	select {
+	case set.events <- ev:
+	default:
+		log.Debug("Dropped block status event", "number", index, "hash", hash.Hex(), "status", status)
+	}
+}
+
+// recordReorg records a mined block found to be a side fork while the head was
+// depth blocks past it. The caller must hold the lock.
+func (set *unconfirmedBlocks) recordReorg(depth uint64) {
+	set.reorgs = append(set.reorgs, reorgSample{time: time.Now(), depth: depth})
+	set.maxReorgDepth()
+}
+
+// maxReorgDepth drops the side forks which left the reorg window, updates the
+// reorg gauge and returns the maximum depth of the remaining ones. The caller
+// must hold the lock.
+func (set *unconfirmedBlocks) maxReorgDepth() uint64 {
+	cutoff := time.Now().Add(-set.reorgWindow)
+	i := 0
+	for i < len(set.reorgs) && set.reorgs[i].time.Before(cutoff) {
+		i++
+	}
+	set.reorgs = set.reorgs[i:]
+
+	var max uint64
+	for _, r := range set.reorgs {
+		if r.depth > max {
+			max = r.depth
+		}
+	}
+	set.reorgGauge.Update(int64(max))
+	return max
+}
+
+// MaxReorgDepth returns the maximum number of blocks the chain head was past a
+// mined block found to be a side fork, over the reorg window.
+func (set *unconfirmedBlocks) MaxReorgDepth() uint64 {
+	set.lock.Lock()
+	defer set.lock.Unlock()
+
+	return set.maxReorgDepth()
+}
+
 // Insert adds a new block to the set of unconfirmed ones.
 func (set *unconfirmedBlocks) Insert(index uint64, hash common.Hash) {
 	// If a new block was mined locally, shift out any old enough blocks
@@ -80,6 +280,7 @@ func (set *unconfirmedBlocks) Insert(index uint64, hash common.Hash) {
 	}
 	// Display a log for the user to notify of a new mined block unconfirmed
 	log.Infof("🔨 mined potential block number=%v hash=%v", index, hash.Hex())
+	set.emit(index, hash, BlockMined)
 }
 
 // Shift drops all unconfirmed blocks from the set which exceed the unconfirmed sets depth
@@ -89,6 +290,11 @@ func (set *unconfirmedBlocks) Shift(height uint64) {
 	set.lock.Lock()
 	defer set.lock.Unlock()
 
+	logf := log.Infof
+	if set.quiet {
+		logf = log.Debugf
+	}
+	set.unavailable = false
 	for set.blocks != nil {
 		// Retrieve the next unconfirmed block and abort if too fresh
 		next := set.blocks.Value.(*unconfirmedBlock)
@@ -97,13 +303,33 @@ func (set *unconfirmedBlocks) Shift(height uint64) {
 		}
 		// Block seems to exceed depth allowance, check for canonical status
 		header := set.chain.GetHeaderByNumber(next.index)
+		if header == nil && next.index+uint64(set.depth)+set.retryMissing > height {
+			// Keep the block, and the younger ones behind it, for a later Shift
+			log.Debug("Retrying header of mined block later", "number", next.index, "hash", next.hash.Hex())
+			set.unavailable = true
+			break
+		}
 		switch {
 		case header == nil:
 			log.Warn("Failed to retrieve header of mined block", "number", next.index, "hash", next.hash.Hex())
+			set.emit(next.index, next.hash, BlockMissing)
 		case header.Hash() == next.hash:
-			log.Infof("🔗 block reached canonical chain number=%v hash=%v", next.index, next.hash.Hex())
+			set.confirmed++
+			set.depthHist.Update(int64(height - next.index))
+			logf("🔗 block reached canonical chain number=%v hash=%v", next.index, next.hash.Hex())
+			set.emit(next.index, next.hash, BlockCanonical)
+		case set.chain.GetHeaderByHash(next.hash) != nil:
+			set.forked++
+			set.forkCounter.Inc(1)
+			set.recordReorg(height - next.index)
+			logf("⑂ block  became a side fork (still known) number=%v hash=%v", next.index, next.hash.Hex())
+			set.emit(next.index, next.hash, BlockSideFork)
 		default:
-			log.Infof("⑂ block  became a side fork number=%v hash=%v", next.index, next.hash.Hex())
+			set.forked++
+			set.forkCounter.Inc(1)
+			set.recordReorg(height - next.index)
+			logf("⑂ block  became orphaned (unknown) number=%v hash=%v", next.index, next.hash.Hex())
+			set.emit(next.index, next.hash, BlockSideFork)
 		}
 		// Drop the block out of the ring
 		if set.blocks.Value == set.blocks.Next().Value {
@@ -114,4 +340,10 @@ func (set *unconfirmedBlocks) Shift(height uint64) {
 			set.blocks = set.blocks.Move(1)
 		}
 	}
+	if set.quiet && time.Since(set.lastSummary) >= set.interval {
+		log.Infof("unconfirmed blocks summary: %d blocks confirmed, %d reorged in last %v",
+			set.confirmed, set.forked, common.PrettyDuration(time.Since(set.lastSummary)))
+		set.confirmed, set.forked = 0, 0
+		set.lastSummary = time.Now()
+	}
 }
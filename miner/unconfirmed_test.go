@@ -17,10 +17,18 @@
 package miner
 
 import (
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/MOACChain/MoacLib/common"
+	"github.com/MOACChain/MoacLib/log"
 	"github.com/MOACChain/MoacLib/types"
+	gometrics "github.com/rcrowley/go-metrics"
 )
 
 // noopHeaderRetriever is an implementation of headerRetriever that always
@@ -31,6 +39,31 @@ func (r *noopHeaderRetriever) GetHeaderByNumber(number uint64) *types.Header {
 	return nil
 }
 
+func (r *noopHeaderRetriever) GetHeaderByHash(hash common.Hash) *types.Header {
+	return nil
+}
+
+// canonicalHeaderRetriever is an implementation of headerRetriever that returns
+// the headers it was populated with. Headers in sides are known, but not
+// canonical.
+type canonicalHeaderRetriever struct {
+	headers map[uint64]*types.Header
+	sides   map[common.Hash]*types.Header
+}
+
+func (r *canonicalHeaderRetriever) GetHeaderByNumber(number uint64) *types.Header {
+	return r.headers[number]
+}
+
+func (r *canonicalHeaderRetriever) GetHeaderByHash(hash common.Hash) *types.Header {
+	for _, header := range r.headers {
+		if header.Hash() == hash {
+			return header
+		}
+	}
+	return r.sides[hash]
+}
+
 // Tests that inserting blocks into the unconfirmed set accumulates them until
 // the desired depth is reached, after which they begin to be dropped.
 func TestUnconfirmedInsertBounds(t *testing.T) {
@@ -83,3 +116,308 @@ func TestUnconfirmedShifts(t *testing.T) {
 		t.Errorf("unconfirmed count mismatch: have %d, want %d", n, 0)
 	}
 }
+
+// Tests that in quiet mode the individual canonical transitions are not logged
+// at info level, but are counted towards the periodic summary.
+func TestUnconfirmedQuietShifts(t *testing.T) {
+	var infos []string
+	handler := log.Root().GetHandler()
+	log.Root().SetHandler(log.FuncHandler(func(r *log.Record) error {
+		if r.Lvl <= log.LvlInfo {
+			infos = append(infos, r.Msg)
+		}
+		return nil
+	}))
+	defer log.Root().SetHandler(handler)
+
+	limit, start := uint(10), uint64(25)
+	chain := &canonicalHeaderRetriever{headers: make(map[uint64]*types.Header)}
+	pool := newUnconfirmedBlocks(chain, limit)
+	pool.SetQuiet(true)
+	for number := start; number < start+uint64(limit); number++ {
+		header := &types.Header{Number: new(big.Int).SetUint64(number)}
+		chain.headers[number] = header
+		pool.Insert(number, header.Hash())
+	}
+	infos = nil
+
+	pool.Shift(start + 2*uint64(limit))
+	for _, msg := range infos {
+		if strings.Contains(msg, "canonical chain") {
+			t.Errorf("transition logged at info level in quiet mode: %s", msg)
+		}
+	}
+	if pool.confirmed != uint64(limit) || pool.forked != 0 {
+		t.Errorf("summary counters mismatch: have %d/%d, want %d/%d", pool.confirmed, pool.forked, limit, 0)
+	}
+	// Elapse the summary interval and ensure the summary is logged
+	pool.interval = 0
+	pool.Shift(start + 3*uint64(limit))
+	if len(infos) != 1 || !strings.Contains(infos[0], "10 blocks confirmed") {
+		t.Errorf("summary not logged: %v", infos)
+	}
+	if pool.confirmed != 0 {
+		t.Errorf("summary counter not reset: have %d", pool.confirmed)
+	}
+}
+
+// Tests that blocks dropped from the canonical chain are reported as side forks
+// if their header is still known, and as orphaned otherwise.
+func TestUnconfirmedSideForkClassification(t *testing.T) {
+	var infos []string
+	handler := log.Root().GetHandler()
+	log.Root().SetHandler(log.FuncHandler(func(r *log.Record) error {
+		if r.Lvl <= log.LvlInfo {
+			infos = append(infos, r.Msg)
+		}
+		return nil
+	}))
+	defer log.Root().SetHandler(handler)
+
+	chain := &canonicalHeaderRetriever{
+		headers: make(map[uint64]*types.Header),
+		sides:   make(map[common.Hash]*types.Header),
+	}
+	var (
+		side     = &types.Header{Number: big.NewInt(1), Extra: []byte("side")}
+		orphan   = &types.Header{Number: big.NewInt(2), Extra: []byte("orphan")}
+		pool     = newUnconfirmedBlocks(chain, 5)
+		expected = []string{"side fork (still known)", "orphaned (unknown)"}
+	)
+	chain.headers[1] = &types.Header{Number: big.NewInt(1)}
+	chain.headers[2] = &types.Header{Number: big.NewInt(2)}
+	chain.sides[side.Hash()] = side
+
+	pool.Insert(1, side.Hash())
+	pool.Insert(2, orphan.Hash())
+	infos = nil
+
+	pool.Shift(10)
+	if len(infos) != len(expected) {
+		t.Fatalf("log count mismatch: have %d, want %d: %v", len(infos), len(expected), infos)
+	}
+	for i, want := range expected {
+		if !strings.Contains(infos[i], want) {
+			t.Errorf("block %d: classification mismatch: have %q, want %q", i+1, infos[i], want)
+		}
+	}
+}
+
+// flakyHeaderRetriever is a canonicalHeaderRetriever failing the first fails
+// number lookups.
+type flakyHeaderRetriever struct {
+	canonicalHeaderRetriever
+	fails int
+}
+
+func (r *flakyHeaderRetriever) GetHeaderByNumber(number uint64) *types.Header {
+	if r.fails > 0 {
+		r.fails--
+		return nil
+	}
+	return r.canonicalHeaderRetriever.GetHeaderByNumber(number)
+}
+
+// Tests that blocks whose header can't be retrieved are retried by later shifts
+// when enabled, up to the retry limit.
+func TestUnconfirmedRetryMissing(t *testing.T) {
+	header := &types.Header{Number: big.NewInt(1)}
+	chain := &flakyHeaderRetriever{
+		canonicalHeaderRetriever: canonicalHeaderRetriever{
+			headers: map[uint64]*types.Header{1: header},
+			sides:   make(map[common.Hash]*types.Header),
+		},
+		fails: 1,
+	}
+	pool := newUnconfirmedBlocks(chain, 5)
+	pool.SetRetryMissing(3)
+	events := pool.Events()
+	pool.Insert(1, header.Hash())
+	<-events // mined
+
+	pool.Shift(6)
+	if pool.blocks == nil {
+		t.Fatal("block evicted although the retriever was unavailable")
+	}
+	if !pool.RetrieverUnavailable() {
+		t.Error("retriever not reported unavailable")
+	}
+	pool.Shift(7)
+	if pool.blocks != nil {
+		t.Fatal("block not evicted after successful retry")
+	}
+	if pool.RetrieverUnavailable() {
+		t.Error("retriever still reported unavailable")
+	}
+	if ev := <-events; ev.Status != BlockCanonical {
+		t.Errorf("retried block status %v, want %v", ev.Status, BlockCanonical)
+	}
+
+	// Past the retry limit blocks are reported missing.
+	chain.fails = 100
+	pool.Insert(2, common.Hash{2})
+	<-events // mined
+	if pool.Shift(9); pool.blocks == nil {
+		t.Fatal("block evicted before the retry limit")
+	}
+	if pool.Shift(10); pool.blocks != nil {
+		t.Fatal("block not evicted at the retry limit")
+	}
+	if ev := <-events; ev.Status != BlockMissing {
+		t.Errorf("expired block status %v, want %v", ev.Status, BlockMissing)
+	}
+}
+
+// Tests that status events are emitted for an insert-then-shift flow.
+func TestUnconfirmedEvents(t *testing.T) {
+	chain := &canonicalHeaderRetriever{
+		headers: make(map[uint64]*types.Header),
+		sides:   make(map[common.Hash]*types.Header),
+	}
+	var (
+		canon = &types.Header{Number: big.NewInt(1)}
+		side  = &types.Header{Number: big.NewInt(2), Extra: []byte("side")}
+		lost  = &types.Header{Number: big.NewInt(3)}
+		pool  = newUnconfirmedBlocks(chain, 5)
+	)
+	chain.headers[1] = canon
+	chain.headers[2] = &types.Header{Number: big.NewInt(2)}
+	chain.sides[side.Hash()] = side
+
+	events := pool.Events()
+	pool.Insert(1, canon.Hash())
+	pool.Insert(2, side.Hash())
+	pool.Insert(3, lost.Hash())
+	pool.Shift(10)
+
+	expected := []BlockStatusEvent{
+		{1, canon.Hash(), BlockMined},
+		{2, side.Hash(), BlockMined},
+		{3, lost.Hash(), BlockMined},
+		{1, canon.Hash(), BlockCanonical},
+		{2, side.Hash(), BlockSideFork},
+		{3, lost.Hash(), BlockMissing},
+	}
+	for i, want := range expected {
+		select {
+		case have := <-events:
+			if have != want {
+				t.Errorf("event %d: have %+v, want %+v", i, have, want)
+			}
+		default:
+			t.Fatalf("event %d: missing, want %+v", i, want)
+		}
+	}
+	select {
+	case ev := <-events:
+		t.Errorf("unexpected event: %+v", ev)
+	default:
+	}
+}
+
+// Tests that the confirmation depth of canonical blocks and the number of side
+// forks are recorded in the metrics.
+func TestUnconfirmedMetrics(t *testing.T) {
+	chain := &canonicalHeaderRetriever{headers: make(map[uint64]*types.Header)}
+	pool := newUnconfirmedBlocks(chain, 5)
+	pool.depthHist = gometrics.NewHistogram(gometrics.NewUniformSample(16))
+	pool.forkCounter = gometrics.NewCounter()
+
+	canon := &types.Header{Number: big.NewInt(1)}
+	chain.headers[1] = canon
+	chain.headers[2] = &types.Header{Number: big.NewInt(2)}
+	pool.Insert(1, canon.Hash())
+	pool.Insert(2, common.Hash{0xff})
+
+	pool.Shift(8)
+	if n := pool.depthHist.Count(); n != 1 {
+		t.Fatalf("histogram sample count mismatch: have %d, want %d", n, 1)
+	}
+	if depth := pool.depthHist.Max(); depth != 7 {
+		t.Errorf("confirmation depth mismatch: have %d, want %d", depth, 7)
+	}
+	if n := pool.forkCounter.Count(); n != 1 {
+		t.Errorf("side fork count mismatch: have %d, want %d", n, 1)
+	}
+}
+
+// Tests that the depth of side forks is reported as the maximum reorg depth,
+// and that side forks leaving the window are forgotten.
+func TestUnconfirmedMaxReorgDepth(t *testing.T) {
+	chain := &canonicalHeaderRetriever{headers: make(map[uint64]*types.Header)}
+	pool := newUnconfirmedBlocks(chain, 5)
+	pool.reorgGauge = gometrics.NewGauge()
+
+	for i := uint64(1); i <= 3; i++ {
+		chain.headers[i] = &types.Header{Number: new(big.Int).SetUint64(i)}
+	}
+	pool.Insert(1, common.Hash{0x01})
+	pool.Insert(3, common.Hash{0x03})
+
+	// Block 1 is forked out with the head at 9, block 3 with the head at 10.
+	pool.Shift(9)
+	if depth := pool.MaxReorgDepth(); depth != 8 {
+		t.Fatalf("reorg depth mismatch: have %d, want %d", depth, 8)
+	}
+	pool.Shift(10)
+	if depth := pool.MaxReorgDepth(); depth != 8 {
+		t.Errorf("reorg depth mismatch: have %d, want %d", depth, 8)
+	}
+	if depth := pool.reorgGauge.Value(); depth != 8 {
+		t.Errorf("reorg gauge mismatch: have %d, want %d", depth, 8)
+	}
+
+	pool.reorgWindow = 0
+	if depth := pool.MaxReorgDepth(); depth != 0 {
+		t.Errorf("reorg depth after window passed: have %d, want %d", depth, 0)
+	}
+	if depth := pool.reorgGauge.Value(); depth != 0 {
+		t.Errorf("reorg gauge after window passed: have %d, want %d", depth, 0)
+	}
+}
+
+// Tests that the webhook receives a POST once a block reaches the canonical
+// chain, and nothing for blocks that were only mined.
+func TestUnconfirmedWebhook(t *testing.T) {
+	posts := make(chan map[string]interface{}, 4)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			t.Errorf("method mismatch: have %s, want POST", r.Method)
+		}
+		var payload map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			t.Errorf("failed to decode payload: %v", err)
+		}
+		posts <- payload
+	}))
+	defer srv.Close()
+
+	header := &types.Header{Number: big.NewInt(1)}
+	chain := &canonicalHeaderRetriever{headers: map[uint64]*types.Header{1: header}}
+	pool := newUnconfirmedBlocks(chain, 5)
+	pool.SetWebhook(srv.URL)
+	defer pool.SetWebhook("")
+
+	pool.Insert(1, header.Hash())
+	pool.Shift(6)
+
+	select {
+	case payload := <-posts:
+		if payload["index"] != float64(1) {
+			t.Errorf("index mismatch: have %v, want 1", payload["index"])
+		}
+		if payload["hash"] != header.Hash().Hex() {
+			t.Errorf("hash mismatch: have %v, want %s", payload["hash"], header.Hash().Hex())
+		}
+		if payload["status"] != "canonical" {
+			t.Errorf("status mismatch: have %v, want canonical", payload["status"])
+		}
+	case <-time.After(time.Second):
+		t.Fatal("webhook not called")
+	}
+	select {
+	case payload := <-posts:
+		t.Fatalf("unexpected webhook call: %v", payload)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
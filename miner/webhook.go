@@ -0,0 +1,106 @@
+// Copyright 2016 The MOAC-core Authors
+// This file is part of the MOAC-core library.
+//
+// The MOAC-core library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The MOAC-core library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the MOAC-core library. If not, see <http://www.gnu.org/licenses/>.
+
+package miner
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/MOACChain/MoacLib/log"
+	gometrics "github.com/rcrowley/go-metrics"
+)
+
+const (
+	// webhookQueueSize is the number of block status events queued for the
+	// webhook endpoint before further events are dropped.
+	webhookQueueSize = 64
+
+	// webhookTimeout is the timeout of a single webhook POST.
+	webhookTimeout = 5 * time.Second
+)
+
+// blockWebhook POSTs the JSON encoded status events of mined blocks reaching
+// the canonical chain or becoming side forks to an HTTP endpoint. Events are
+// delivered from a bounded queue, so a slow endpoint never stalls mining.
+type blockWebhook struct {
+	url     string
+	client  *http.Client
+	queue   chan BlockStatusEvent
+	quit    chan struct{}
+	dropped gometrics.Counter // Number of events dropped on a full queue
+}
+
+// newBlockWebhook creates a webhook posting to url and starts its delivery loop.
+func newBlockWebhook(url string) *blockWebhook {
+	w := &blockWebhook{
+		url:     url,
+		client:  &http.Client{Timeout: webhookTimeout},
+		queue:   make(chan BlockStatusEvent, webhookQueueSize),
+		quit:    make(chan struct{}),
+		dropped: webhookDropCounter,
+	}
+	go w.loop()
+	return w
+}
+
+// enqueue queues an event for delivery without blocking.
+func (w *blockWebhook) enqueue(ev BlockStatusEvent) {
+	select {
+	case w.queue <- ev:
+	default:
+		w.dropped.Inc(1)
+		log.Debug("Dropped block webhook event", "number", ev.Index, "hash", ev.Hash.Hex(), "status", ev.Status)
+	}
+}
+
+// stop terminates the delivery loop. Queued events are discarded.
+func (w *blockWebhook) stop() {
+	close(w.quit)
+}
+
+func (w *blockWebhook) loop() {
+	for {
+		select {
+		case ev := <-w.queue:
+			if err := w.post(ev); err != nil {
+				log.Warn("Failed to deliver block webhook", "url", w.url, "number", ev.Index, "err", err)
+			}
+		case <-w.quit:
+			return
+		}
+	}
+}
+
+// post delivers a single event to the endpoint.
+func (w *blockWebhook) post(ev BlockStatusEvent) error {
+	body, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	resp, err := w.client.Post(w.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
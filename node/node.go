@@ -184,6 +184,16 @@ func (n *Node) GetSubnetServerListenPort() string {
 	}
 }
 
+// subnetDiscoveryAddr returns the UDP discovery address of the next subnet
+// server, or "" to discover on its listen address if no subnet discovery port
+// is configured.
+func (n *Node) subnetDiscoveryAddr() string {
+	if n.config.P2P.SubnetDiscoveryPort == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%s:%d", params.VnodeIP, n.config.P2P.SubnetDiscoveryPort+len(n.subnetServers))
+}
+
 func (n *Node) HasSubnetServer(subnetid string) bool {
 	ret := n.subnetServers[subnetid] != nil
 	log.Debugf("subnet has server for %s: %t", subnetid, ret)
@@ -237,6 +247,7 @@ func (n *Node) StartSubnetP2PServer(subnetid string) {
 		subnetServerConfig.MaxPeers = params.SubnetP2PConnectionMin
 	}
 	subnetServerConfig.ListenAddr = n.GetSubnetServerListenPort() // e.g. ":40333"
+	subnetServerConfig.DiscoveryAddr = n.subnetDiscoveryAddr()
 	if n.serverConfig.NodeDatabase == "" {
 		n.serverConfig.NodeDatabase = n.config.NodeDB(subnetid)
 	}
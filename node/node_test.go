@@ -21,6 +21,7 @@ import (
 	"io/ioutil"
 	"os"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 
@@ -573,3 +574,27 @@ func TestAPIGather(t *testing.T) {
 		}
 	}
 }
+
+func TestSubnetDiscoveryAddr(t *testing.T) {
+	n := &Node{
+		config:        &Config{P2P: p2p.Config{ListenAddr: ":30333"}},
+		subnetServers: make(map[string]*p2p.Server),
+	}
+	if addr := n.subnetDiscoveryAddr(); addr != "" {
+		t.Fatalf("discovery address without subnet port: have %q, want \"\"", addr)
+	}
+
+	n.config.P2P.SubnetDiscoveryPort = 30340
+	first := n.subnetDiscoveryAddr()
+	if !strings.HasSuffix(first, ":30340") {
+		t.Fatalf("subnet port not used: %q", first)
+	}
+	if strings.HasSuffix(first, n.config.P2P.ListenAddr) {
+		t.Fatalf("subnet discovery address %q collides with the main one %q", first, n.config.P2P.ListenAddr)
+	}
+	// A second subnet server must not collide with the first one.
+	n.subnetServers["subnet"] = &p2p.Server{}
+	if second := n.subnetDiscoveryAddr(); second == first || !strings.HasSuffix(second, ":30341") {
+		t.Errorf("second subnet discovery address: have %q, first %q", second, first)
+	}
+}
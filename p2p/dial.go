@@ -83,6 +83,7 @@ type discoverTable interface {
 	RefreshSubnetBootNode(subnetID discover.NodeID, nodesToRefresh []*discover.Node)
 	GetOurEndpoint() string
 	FindBootNodes(subnetID discover.NodeID, toNodes []*discover.Node)
+	AnnounceSubnet(subnetID discover.NodeID, closest func(discover.NodeID) []*discover.Node)
 	GetKey([]byte) (map[string]string, error)
 	SetFallbackNodes([]*discover.Node) error
 	Bond(pinged bool, id discover.NodeID, addr *net.UDPAddr, tcpPort uint16) (*discover.Node, error)
@@ -0,0 +1,304 @@
+// Copyright 2021 The MOAC-core Authors
+// This file is part of the MOAC-core library.
+//
+// The MOAC-core library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The MOAC-core library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the MOAC-core library. If not, see <http://www.gnu.org/licenses/>.
+
+package discover
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// simClock is a clock whose time only moves when Run is called.
+type simClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []*simTimer
+}
+
+// simTimer is a timer of a simClock.
+type simTimer struct {
+	clock    *simClock
+	c        chan time.Time
+	deadline time.Time
+	active   bool
+}
+
+func newSimClock() *simClock {
+	return &simClock{now: time.Now()}
+}
+
+func (c *simClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *simClock) After(d time.Duration) <-chan time.Time {
+	return c.NewTimer(d).C()
+}
+
+func (c *simClock) NewTimer(d time.Duration) timer {
+	t := &simTimer{clock: c, c: make(chan time.Time, 1)}
+	t.Reset(d)
+	return t
+}
+
+// Run advances the clock by d, firing the timers expiring in between.
+func (c *simClock) Run(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	c.fire()
+	c.mu.Unlock()
+}
+
+// activeTimers returns the number of timers yet to fire.
+func (c *simClock) activeTimers() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	n := 0
+	for _, t := range c.timers {
+		if t.active {
+			n++
+		}
+	}
+	return n
+}
+
+// fire expires the due timers, c.mu must be held.
+func (c *simClock) fire() {
+	timers := c.timers[:0]
+	for _, t := range c.timers {
+		if t.active && !t.deadline.After(c.now) {
+			t.active = false
+			select {
+			case t.c <- c.now:
+			default:
+			}
+		}
+		if t.active {
+			timers = append(timers, t)
+		}
+	}
+	c.timers = timers
+}
+
+func (t *simTimer) C() <-chan time.Time { return t.c }
+
+func (t *simTimer) Reset(d time.Duration) bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+
+	wasActive := t.active
+	t.deadline, t.active = t.clock.now.Add(d), true
+	if !wasActive {
+		t.clock.timers = append(t.clock.timers, t)
+	}
+	t.clock.fire()
+	return wasActive
+}
+
+func (t *simTimer) Stop() bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+
+	wasActive := t.active
+	t.active = false
+	return wasActive
+}
+
+// newSimClockUDP creates a test transport driven by a simulated clock.
+func newSimClockUDP(t *testing.T) (*Table, *udp, *dgramPipe, *simClock) {
+	clk := newSimClock()
+	defer func(c clock) { defaultClock = c }(defaultClock)
+	defaultClock = clk
+
+	tab, udp, pipe := newTestUDP(t)
+	return tab, udp, pipe, clk
+}
+
+func TestUDP_simulatedTimeout(t *testing.T) {
+	tab, udp, _, clk := newSimClockUDP(t)
+	defer tab.Close()
+
+	errc := udp.addPending(udp.nextReqID(), NodeID{1}, PONGPACKET, func(interface{}) bool { return true })
+
+	// Wait for the loop to arm the timer for the pending reply.
+	for start := time.Now(); clk.activeTimers() == 0; time.Sleep(time.Millisecond) {
+		if time.Since(start) > time.Second {
+			t.Fatal("timeout timer not armed")
+		}
+	}
+	select {
+	case err := <-errc:
+		t.Fatalf("pending finished before its deadline: %v", err)
+	default:
+	}
+
+	clk.Run(respTimeout)
+	select {
+	case err := <-errc:
+		if err != errTimeout {
+			t.Fatalf("wrong error: got %v, want %v", err, errTimeout)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("pending did not time out")
+	}
+}
+
+func TestUDP_adaptiveTimeout(t *testing.T) {
+	tab, udp, _, clk := newSimClockUDP(t)
+	defer tab.Close()
+
+	var (
+		unknown = NodeID{1}
+		slow    = NodeID{2}
+		fast    = NodeID{3}
+	)
+	udp.updateRTT(slow, 300*time.Millisecond)
+	udp.updateRTT(fast, 10*time.Millisecond)
+	if have, want := udp.respTimeout(slow), 4*300*time.Millisecond; have != want {
+		t.Errorf("slow peer timeout: have %v, want %v", have, want)
+	}
+	if have := udp.respTimeout(fast); have != minRespTimeout {
+		t.Errorf("fast peer timeout: have %v, want %v", have, minRespTimeout)
+	}
+
+	// addPending returns once the loop took the request, so the loop
+	// assigned each deadline before the next call returns.
+	slowc := udp.addPending(udp.nextReqID(), slow, PONGPACKET, func(interface{}) bool { return true })
+	unknownc := udp.addPending(udp.nextReqID(), unknown, PONGPACKET, func(interface{}) bool { return true })
+	fastc := udp.addPending(udp.nextReqID(), fast, PONGPACKET, func(interface{}) bool { return true })
+	udp.addPending(udp.nextReqID(), NodeID{4}, PINGPACKET, func(interface{}) bool { return true })
+
+	expectTimeout := func(name string, errc <-chan error, fired bool) {
+		t.Helper()
+		if !fired {
+			select {
+			case err := <-errc:
+				t.Fatalf("%s peer: pending finished before its deadline: %v", name, err)
+			case <-time.After(50 * time.Millisecond):
+			}
+			return
+		}
+		select {
+		case err := <-errc:
+			if err != errTimeout {
+				t.Fatalf("%s peer: wrong error: have %v, want %v", name, err, errTimeout)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("%s peer: pending did not time out", name)
+		}
+	}
+	clk.Run(minRespTimeout)
+	expectTimeout("fast", fastc, true)
+	expectTimeout("unknown", unknownc, false)
+
+	clk.Run(respTimeout - minRespTimeout)
+	expectTimeout("unknown", unknownc, true)
+	expectTimeout("slow", slowc, false)
+
+	clk.Run(4*300*time.Millisecond - respTimeout)
+	expectTimeout("slow", slowc, true)
+}
+
+func TestUDP_updateRTT(t *testing.T) {
+	_, udp, _ := newTestUDP(t)
+	defer udp.close()
+
+	id := NodeID{1}
+	if _, ok := udp.RTT(id); ok {
+		t.Fatal("RTT known before any measurement")
+	}
+	udp.updateRTT(id, 800*time.Millisecond)
+	udp.updateRTT(id, 0)
+	if rtt, _ := udp.RTT(id); rtt != 700*time.Millisecond {
+		t.Errorf("smoothed RTT: have %v, want %v", rtt, 700*time.Millisecond)
+	}
+}
+
+func TestUDP_announceSubnet(t *testing.T) {
+	tab, udp, pipe, clk := newSimClockUDP(t)
+	defer tab.Close()
+
+	subnetID := NodeID{0x23, 0x90}
+	target := NewNode(PubkeyID(&newkey().PublicKey), net.IP{10, 0, 9, 1}, 30303, 30303, nil, nil, false, nil)
+	var (
+		mu     sync.Mutex
+		cycles int
+	)
+	udp.announceSubnet(subnetID, func(id NodeID) []*Node {
+		mu.Lock()
+		defer mu.Unlock()
+		cycles++
+		return []*Node{target}
+	})
+
+	clk.Run(udp.announceEvery / 2)
+	mu.Lock()
+	if cycles != 0 {
+		t.Fatal("subnet announced before the interval passed")
+	}
+	mu.Unlock()
+	for i := 1; i <= 2; i++ {
+		clk.Run(udp.announceEvery)
+		p, _, _, err := decodePacket(pipe.waitPacketOut())
+		if err != nil {
+			t.Fatalf("sent packet decode error: %v", err)
+		}
+		req, ok := p.(*store)
+		if !ok {
+			t.Fatalf("cycle %d: wrong packet sent: got %T, want *store", i, p)
+		}
+		if req.Key != subnetID {
+			t.Errorf("cycle %d: stored under key %x, want %x", i, req.Key[:4], subnetID[:4])
+		}
+		mu.Lock()
+		if cycles != i {
+			t.Errorf("closest nodes looked up %d times, want %d", cycles, i)
+		}
+		mu.Unlock()
+	}
+}
+
+func TestUDP_rttForgotten(t *testing.T) {
+	tab, udp, _ := newTestUDP(t)
+	defer tab.Close()
+
+	// Removing a node from the table drops its RTT.
+	n := NewNode(PubkeyID(&newkey().PublicKey), net.IP{10, 0, 9, 10}, 30303, 30303, nil, nil, false, nil)
+	tab.mutex.Lock()
+	tab.stuff([]*Node{n})
+	tab.mutex.Unlock()
+	udp.updateRTT(n.ID, 100*time.Millisecond)
+	tab.DeleteWithNodeId(n.ID)
+	if _, ok := udp.RTT(n.ID); ok {
+		t.Error("RTT of removed node still known")
+	}
+
+	// Only the RTTs of the most recent maxTrackedNodes peers are kept.
+	for i := 0; i <= maxTrackedNodes; i++ {
+		udp.updateRTT(NodeID{byte(i >> 8), byte(i)}, time.Duration(i)*time.Millisecond)
+	}
+	if n := udp.rtts.Len(); n != maxTrackedNodes {
+		t.Errorf("tracked RTTs: have %d, want %d", n, maxTrackedNodes)
+	}
+	if _, ok := udp.RTT(NodeID{}); ok {
+		t.Error("RTT of oldest peer not evicted")
+	}
+}
@@ -0,0 +1,138 @@
+// Copyright 2021 The MOAC-core Authors
+// This file is part of the MOAC-core library.
+//
+// The MOAC-core library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The MOAC-core library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the MOAC-core library. If not, see <http://www.gnu.org/licenses/>.
+
+package discover
+
+import (
+	"time"
+
+	"github.com/MOACChain/MoacLib/common"
+)
+
+const (
+	defaultWriteTimeout        = time.Second
+	defaultAlienMismatches     = 3
+	defaultAlienMismatchWindow = 10 * time.Minute
+)
+
+// Config holds the settings of a discovery table and its UDP transport.
+// Every table gets its own copy, so several servers in one process can run
+// discovery with different settings. Zero values select the defaults.
+type Config struct {
+	// Attributes advertised in the record of the local node.
+	BeneficialAddress *common.Address
+	ServiceCfg        *string
+	ShowToPublic      bool
+	Ip                *string
+
+	// NoSubnet disables processing of the STORE and FINDVALUE packets of
+	// the subnet DHT, for deployments without subnets.
+	NoSubnet bool
+
+	// MinPeerVersion is the lowest discovery protocol version a node has to
+	// advertise in its ping for us to answer and bond with it. Zero accepts
+	// all versions.
+	MinPeerVersion uint
+
+	// BondExpiration is the time after the last pong from a bonded node at
+	// which its bond is re-verified before privileged packets are accepted.
+	BondExpiration time.Duration
+
+	// WriteTimeout bounds how long writing a packet to the socket may block.
+	WriteTimeout time.Duration
+
+	// RefreshInterval is the cadence of the table refresh. Values below
+	// minRefreshInterval are raised to it, so the refresh can't flood the
+	// network.
+	RefreshInterval time.Duration
+
+	// PreferFreshNeighbors makes findnode replies order nodes at the same log
+	// distance from the target by the time of their last pong, most recent
+	// first, instead of by their exact XOR distance.
+	PreferFreshNeighbors bool
+
+	// MaxSubnetValues caps the number of values stored under a single subnet
+	// key. Storing beyond the cap evicts the oldest value.
+	MaxSubnetValues int
+
+	// SubnetAnnounceInterval is the time between re-announcements of the
+	// subnet membership of the local node. It has to stay below the lifetime
+	// of records in the kvstore of other nodes.
+	SubnetAnnounceInterval time.Duration
+
+	// ReapInterval is the time between pings of the least recently seen node
+	// of every bucket. Nodes failing ReapFailures consecutive pings are
+	// evicted. Nil selects the default, zero disables the reaper.
+	ReapInterval *time.Duration
+	ReapFailures int
+
+	// MaxConcurrentLookups caps the number of lookups running at the same
+	// time. Further lookups wait for a running one to finish.
+	MaxConcurrentLookups int
+
+	// LookupCacheTTL is how long the result of a lookup is reused for further
+	// lookups of the same target, sparing the findnode fan-out. Nil selects
+	// the default, zero disables the cache. The lookups of the table refresh
+	// never use the cache.
+	LookupCacheTTL *time.Duration
+
+	// AlienMismatches is the number of consecutive network id mismatches
+	// within AlienMismatchWindow after which a node is classified alien, so a
+	// single bad packet doesn't cut off an otherwise good peer. A matching
+	// network id resets the count.
+	AlienMismatches     int
+	AlienMismatchWindow time.Duration
+}
+
+// withDefaults returns a copy of cfg with the unset fields filled in.
+func (cfg Config) withDefaults() Config {
+	if cfg.BondExpiration <= 0 {
+		cfg.BondExpiration = nodeDBNodeExpiration
+	}
+	if cfg.WriteTimeout <= 0 {
+		cfg.WriteTimeout = defaultWriteTimeout
+	}
+	if cfg.RefreshInterval <= 0 {
+		cfg.RefreshInterval = autoRefreshInterval
+	}
+	if cfg.MaxSubnetValues <= 0 {
+		cfg.MaxSubnetValues = bucketSize
+	}
+	if cfg.SubnetAnnounceInterval <= 0 {
+		cfg.SubnetAnnounceInterval = KvstoreCacheUpdateInterval
+	}
+	if cfg.ReapInterval == nil {
+		d := defaultReapInterval
+		cfg.ReapInterval = &d
+	}
+	if cfg.ReapFailures <= 0 {
+		cfg.ReapFailures = defaultReapFailures
+	}
+	if cfg.MaxConcurrentLookups <= 0 {
+		cfg.MaxConcurrentLookups = defaultMaxLookups
+	}
+	if cfg.LookupCacheTTL == nil {
+		d := defaultLookupCacheTTL
+		cfg.LookupCacheTTL = &d
+	}
+	if cfg.AlienMismatches <= 0 {
+		cfg.AlienMismatches = defaultAlienMismatches
+	}
+	if cfg.AlienMismatchWindow <= 0 {
+		cfg.AlienMismatchWindow = defaultAlienMismatchWindow
+	}
+	return cfg
+}
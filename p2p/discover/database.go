@@ -48,6 +48,7 @@ var (
 type nodeDB struct {
 	lvl    *leveldb.DB   // Interface to the database itself
 	self   NodeID        // Own node id to prevent adding it into the database
+	selfMu sync.RWMutex  // Protects self, which changes on key rotation
 	runner sync.Once     // Ensures we can start at most one expirer
 	quit   chan struct{} // Channel to signal the expiring thread to stop
 }
@@ -61,8 +62,15 @@ var (
 	nodeDBDiscoverPing      = nodeDBDiscoverRoot + ":lastping"
 	nodeDBDiscoverPong      = nodeDBDiscoverRoot + ":lastpong"
 	nodeDBDiscoverFindFails = nodeDBDiscoverRoot + ":findfail"
+	nodeDBDiscoverNodeType  = nodeDBDiscoverRoot + ":nodetype"
 )
 
+// nodeTypeEntry is the persisted classification of a node.
+type nodeTypeEntry struct {
+	Type    uint
+	Expires uint64 // unix time after which the classification is stale
+}
+
 // newNodeDB creates a new node database for storing and retrieving infos about
 // known peers in the network. If no path is given, an in-memory, temporary
 // database is constructed.
@@ -89,6 +97,20 @@ func newMemoryNodeDB(self NodeID) (*nodeDB, error) {
 	}, nil
 }
 
+// selfID returns the id of the local node.
+func (db *nodeDB) selfID() NodeID {
+	db.selfMu.RLock()
+	defer db.selfMu.RUnlock()
+	return db.self
+}
+
+// setSelf changes the id of the local node after a key rotation.
+func (db *nodeDB) setSelf(id NodeID) {
+	db.selfMu.Lock()
+	db.self = id
+	db.selfMu.Unlock()
+}
+
 // newPersistentNodeDB creates/opens a leveldb backed persistent node database,
 // also flushing its contents in case of a version mismatch.
 func newPersistentNodeDB(path string, version int, self NodeID) (*nodeDB, error) {
@@ -212,6 +234,42 @@ func (db *nodeDB) deleteNode(id NodeID) error {
 	return nil
 }
 
+// updateNodeType stores the classification of a node until the given time.
+func (db *nodeDB) updateNodeType(id NodeID, nodeType int, expires time.Time) error {
+	blob, err := rlp.EncodeToBytes(&nodeTypeEntry{Type: uint(nodeType), Expires: uint64(expires.Unix())})
+	if err != nil {
+		return err
+	}
+	return db.lvl.Put(makeKey(id, nodeDBDiscoverNodeType), blob, nil)
+}
+
+// deleteNodeType drops the stored classification of a node.
+func (db *nodeDB) deleteNodeType(id NodeID) error {
+	return db.lvl.Delete(makeKey(id, nodeDBDiscoverNodeType), nil)
+}
+
+// nodeTypes retrieves all stored node classifications that are still valid at
+// the given time. Stale entries are deleted.
+func (db *nodeDB) nodeTypes(now time.Time) map[NodeID]nodeTypeEntry {
+	types := make(map[NodeID]nodeTypeEntry)
+
+	it := db.lvl.NewIterator(util.BytesPrefix(nodeDBItemPrefix), nil)
+	defer it.Release()
+	for it.Next() {
+		id, field := splitKey(it.Key())
+		if field != nodeDBDiscoverNodeType {
+			continue
+		}
+		var entry nodeTypeEntry
+		if err := rlp.DecodeBytes(it.Value(), &entry); err != nil || int64(entry.Expires) <= now.Unix() {
+			db.lvl.Delete(it.Key(), nil)
+			continue
+		}
+		types[id] = entry
+	}
+	return types
+}
+
 // ensureExpirer is a small helper method ensuring that the data expiration
 // mechanism is running. If the expiration goroutine is already running, this
 // method simply returns.
@@ -258,7 +316,7 @@ func (db *nodeDB) expireNodes() error {
 			continue
 		}
 		// Skip the node if not expired yet (and not self)
-		if !bytes.Equal(id[:], db.self[:]) {
+		if self := db.selfID(); !bytes.Equal(id[:], self[:]) {
 			if seen := db.lastPong(id); seen.After(threshold) {
 				continue
 			}
@@ -326,7 +384,7 @@ seek:
 			id[0] = 0
 			continue seek // iterator exhausted
 		}
-		if n.ID == db.self {
+		if n.ID == db.selfID() {
 			continue seek
 		}
 		if now.Sub(db.lastPong(n.ID)) > maxAge {
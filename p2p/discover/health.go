@@ -0,0 +1,98 @@
+// Copyright 2021 The MOAC-core Authors
+// This file is part of the MOAC-core library.
+//
+// The MOAC-core library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The MOAC-core library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the MOAC-core library. If not, see <http://www.gnu.org/licenses/>.
+
+package discover
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// healthWindow is the number of most recent requests the timeout rate of
+// DiscoveryHealth is computed over.
+const healthWindow = 64
+
+// DiscoveryHealth is a snapshot of the state of the discovery subsystem.
+type DiscoveryHealth struct {
+	BucketFill   float64   // fraction of the table slots in use
+	BrotherNodes int       // number of brother nodes in the table
+	LastLookup   time.Time // end of the last lookup answered by a node, zero if none
+	Pending      int       // number of requests waiting for a reply
+	TimeoutRate  float64   // fraction of the recent requests which timed out
+}
+
+// Healthy reports whether discovery knows any brother node.
+func (h DiscoveryHealth) Healthy() bool {
+	return h.BrotherNodes > 0
+}
+
+// Health returns the current state of the discovery subsystem.
+func (u *udp) Health() DiscoveryHealth {
+	h := DiscoveryHealth{
+		Pending:     int(atomic.LoadInt32(&u.npending)),
+		TimeoutRate: u.outcomes.rate(),
+	}
+	u.Table.mutex.Lock()
+	defer u.Table.mutex.Unlock()
+
+	entries := 0
+	for _, b := range u.Table.buckets {
+		entries += len(b.entries)
+		for _, n := range b.entries {
+			if u.Table.GetNodeType(n.ID) == BrotherNode {
+				h.BrotherNodes++
+			}
+		}
+	}
+	h.BucketFill = float64(entries) / float64(nBuckets*bucketSize)
+	h.LastLookup = u.Table.lastLookup
+	return h
+}
+
+// outcomeWindow records whether the most recent requests timed out.
+type outcomeWindow struct {
+	mu       sync.Mutex
+	timeouts [healthWindow]bool
+	next     int // index of the next outcome
+	n        int // number of recorded outcomes, up to healthWindow
+}
+
+func (w *outcomeWindow) add(timeout bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.timeouts[w.next] = timeout
+	w.next = (w.next + 1) % healthWindow
+	if w.n < healthWindow {
+		w.n++
+	}
+}
+
+// rate returns the fraction of the recorded outcomes which are timeouts.
+func (w *outcomeWindow) rate() float64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.n == 0 {
+		return 0
+	}
+	timeouts := 0
+	for i := 0; i < w.n; i++ {
+		if w.timeouts[i] {
+			timeouts++
+		}
+	}
+	return float64(timeouts) / float64(w.n)
+}
@@ -0,0 +1,231 @@
+// Copyright 2021 The MOAC-core Authors
+// This file is part of the MOAC-core library.
+//
+// The MOAC-core library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The MOAC-core library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the MOAC-core library. If not, see <http://www.gnu.org/licenses/>.
+
+package discover
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memConns routes datagrams between the memConns by their address.
+var memConns = struct {
+	sync.Mutex
+	port  int
+	conns map[string]*memConn
+}{port: 30000, conns: make(map[string]*memConn)}
+
+// memDatagram is a datagram in flight between memConns.
+type memDatagram struct {
+	data []byte
+	from *net.UDPAddr
+}
+
+// memConn is an in-memory conn. Datagrams written to the address of another
+// memConn are delivered to it, all others are dropped like lost UDP packets.
+type memConn struct {
+	addr    *net.UDPAddr
+	in      chan memDatagram
+	closing chan struct{}
+	once    sync.Once
+}
+
+// newMemConn creates a memConn listening on a fresh loopback port.
+func newMemConn() *memConn {
+	memConns.Lock()
+	defer memConns.Unlock()
+
+	memConns.port++
+	c := &memConn{
+		addr:    &net.UDPAddr{IP: net.IP{127, 0, 0, 1}, Port: memConns.port},
+		in:      make(chan memDatagram, 64),
+		closing: make(chan struct{}),
+	}
+	memConns.conns[c.addr.String()] = c
+	return c
+}
+
+// ReadFromUDP blocks until a datagram arrives or the conn is closed.
+func (c *memConn) ReadFromUDP(b []byte) (n int, addr *net.UDPAddr, err error) {
+	select {
+	case d := <-c.in:
+		return copy(b, d.data), d.from, nil
+	case <-c.closing:
+		return 0, nil, io.EOF
+	}
+}
+
+// WriteToUDP delivers a copy of the datagram to the memConn listening on to.
+func (c *memConn) WriteToUDP(b []byte, to *net.UDPAddr) (n int, err error) {
+	memConns.Lock()
+	dst := memConns.conns[to.String()]
+	memConns.Unlock()
+
+	if dst != nil {
+		d := memDatagram{data: append([]byte(nil), b...), from: c.addr}
+		select {
+		case dst.in <- d:
+		case <-dst.closing:
+		default: // queue full, drop
+		}
+	}
+	return len(b), nil
+}
+
+func (c *memConn) Close() error {
+	c.once.Do(func() {
+		memConns.Lock()
+		delete(memConns.conns, c.addr.String())
+		memConns.Unlock()
+		close(c.closing)
+	})
+	return nil
+}
+
+func (c *memConn) LocalAddr() net.Addr {
+	return c.addr
+}
+
+func TestUDP_memConnBond(t *testing.T) {
+	connA, connB := newMemConn(), newMemConn()
+	tabA, _, err := newUDP(newkey(), connA, nil, "", nil, 99, false, Config{})
+	if err != nil {
+		t.Fatalf("can't create udp transport: %v", err)
+	}
+	defer tabA.Close()
+	tabB, _, err := newUDP(newkey(), connB, nil, "", nil, 99, false, Config{})
+	if err != nil {
+		t.Fatalf("can't create udp transport: %v", err)
+	}
+	defer tabB.Close()
+
+	// A pings B and waits for B to ping back, completing the bond.
+	n, err := tabA.bond(false, tabB.Self().ID, connB.addr, 30303)
+	if err != nil {
+		t.Fatalf("bond failed: %v", err)
+	}
+	if n.ID != tabB.Self().ID {
+		t.Fatalf("bonded with wrong node %x", n.ID[:8])
+	}
+	if tabA.db.node(tabB.Self().ID) == nil {
+		t.Error("B missing from the database of A")
+	}
+	// B bonds with A in the background after answering the ping.
+	deadline := time.Now().Add(2 * time.Second)
+	for tabB.db.node(tabA.Self().ID) == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("A missing from the database of B")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestUDP_PingBootnode(t *testing.T) {
+	connA, connB, connC := newMemConn(), newMemConn(), newMemConn()
+	_, udpA, err := newUDP(newkey(), connA, nil, "", nil, 99, false, Config{})
+	if err != nil {
+		t.Fatalf("can't create udp transport: %v", err)
+	}
+	defer udpA.close()
+	tabB, _, err := newUDP(newkey(), connB, nil, "", nil, 99, false, Config{})
+	if err != nil {
+		t.Fatalf("can't create udp transport: %v", err)
+	}
+	defer tabB.Close()
+	tabC, _, err := newUDP(newkey(), connC, nil, "", nil, 101, false, Config{})
+	if err != nil {
+		t.Fatalf("can't create udp transport: %v", err)
+	}
+	defer tabC.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	urlB := NewNode(tabB.Self().ID, connB.addr.IP, uint16(connB.addr.Port), 30303, nil, nil, false, nil).String()
+	rtt, err := udpA.PingBootnode(ctx, urlB)
+	if err != nil {
+		t.Fatalf("ping failed: %v", err)
+	}
+	if rtt <= 0 {
+		t.Errorf("non-positive round trip time %v", rtt)
+	}
+	// A node of another network replies, but is reported as a mismatch.
+	urlC := NewNode(tabC.Self().ID, connC.addr.IP, uint16(connC.addr.Port), 30303, nil, nil, false, nil).String()
+	if _, err := udpA.PingBootnode(ctx, urlC); !errors.Is(err, errNetworkMismatch) {
+		t.Errorf("wrong error for other network: have %v, want %v", err, errNetworkMismatch)
+	}
+	if _, err := udpA.PingBootnode(ctx, "enode://bad"); err == nil {
+		t.Error("no error for invalid enode URL")
+	}
+}
+
+func TestUDP_ParseSubnetNode(t *testing.T) {
+	connA, connB, connC := newMemConn(), newMemConn(), newMemConn()
+	_, udpA, err := newUDP(newkey(), connA, nil, "", nil, 99, false, Config{})
+	if err != nil {
+		t.Fatalf("can't create udp transport: %v", err)
+	}
+	defer udpA.close()
+	tabB, _, err := newUDP(newkey(), connB, nil, "", nil, 99, false, Config{})
+	if err != nil {
+		t.Fatalf("can't create udp transport: %v", err)
+	}
+	defer tabB.Close()
+	tabC, udpC, err := newUDP(newkey(), connC, nil, "", nil, 101, false, Config{})
+	if err != nil {
+		t.Fatalf("can't create udp transport: %v", err)
+	}
+	defer tabC.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	urlB := NewNode(tabB.Self().ID, connB.addr.IP, uint16(connB.addr.Port), 30303, nil, nil, false, nil).String()
+	if n, err := udpA.ParseSubnetNode(ctx, urlB, 99); err != nil {
+		t.Fatalf("node of our network rejected: %v", err)
+	} else if n.ID != tabB.Self().ID {
+		t.Errorf("node id mismatch: have %x, want %x", n.ID[:8], tabB.Self().ID[:8])
+	}
+	if _, err := udpA.ParseSubnetNode(ctx, urlB, 101); !errors.Is(err, errAlienNode) {
+		t.Errorf("node outside the expected network: have error %v, want %v", err, errAlienNode)
+	}
+	// C replies to the ping with its own network id.
+	urlC := NewNode(tabC.Self().ID, connC.addr.IP, uint16(connC.addr.Port), 30303, nil, nil, false, nil).String()
+	if _, err := udpA.ParseSubnetNode(ctx, urlC, 99); !errors.Is(err, errAlienNode) {
+		t.Fatalf("node of another network: have error %v, want %v", err, errAlienNode)
+	}
+
+	// C keeps pinging A with its network id until A classifies it alien.
+	// The bonds started by the pings count as well, so A may drop the
+	// last ping once C is classified.
+	idC := tabC.Self().ID
+	for i := 0; udpA.Table.GetNodeType(idC) != AlienNode; i++ {
+		if i == udpA.alienMismatches {
+			t.Fatalf("C not classified alien after %d pings", i)
+		}
+		if err := udpC.ping(udpA.Self().ID, connA.addr); err != nil && udpA.Table.GetNodeType(idC) != AlienNode {
+			t.Fatalf("ping %d failed: %v", i, err)
+		}
+	}
+	// The classification alone rejects C, no ping is needed anymore.
+	connC.Close()
+	if _, err := udpA.ParseSubnetNode(ctx, urlC, 99); !errors.Is(err, errAlienNode) {
+		t.Errorf("node classified alien: have error %v, want %v", err, errAlienNode)
+	}
+}
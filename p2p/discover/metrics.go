@@ -0,0 +1,46 @@
+// Copyright 2021 The MOAC-core Authors
+// This file is part of the MOAC-core library.
+//
+// The MOAC-core library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The MOAC-core library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the MOAC-core library. If not, see <http://www.gnu.org/licenses/>.
+
+// Contains the meters used by the discovery protocol.
+
+package discover
+
+import (
+	"github.com/MOACChain/MoacLib/metrics"
+)
+
+// Reasons for rejecting a node received in a neighbors reply.
+const (
+	rejectLowPort     = "lowport"
+	rejectRelayIP     = "relayip"
+	rejectNetrestrict = "netrestrict"
+	rejectIncomplete  = "incomplete"
+	rejectSelf        = "self"
+)
+
+var rejectMeters = map[string]metrics.Meter{
+	rejectLowPort:     metrics.NewMeter("discover/reject/lowport"),
+	rejectRelayIP:     metrics.NewMeter("discover/reject/relayip"),
+	rejectNetrestrict: metrics.NewMeter("discover/reject/netrestrict"),
+	rejectIncomplete:  metrics.NewMeter("discover/reject/incomplete"),
+	rejectSelf:        metrics.NewMeter("discover/reject/self"),
+}
+
+// lookupsInFlightCounter tracks the number of running lookups.
+var lookupsInFlightCounter = metrics.NewCounter("discover/lookups/inflight")
+
+// lookupCacheHitMeter counts the lookups served from the lookup cache.
+var lookupCacheHitMeter = metrics.NewMeter("discover/lookups/cachehit")
@@ -121,10 +121,21 @@ func (n *Node) GetIp() string {
 	return *n.ip
 }
 
+// GetServiceCfg returns the service config of the node. For discovered nodes
+// it is only set if the node advertised it, see IsShowToPublic.
 func (n *Node) GetServiceCfg() *string {
 	return n.serviceCfg
 }
 
+// hasAttributes reports whether the node has a beneficial address or shows
+// its service config to the public.
+func (n *Node) hasAttributes() bool {
+	if n.beneficialAddress != nil && *n.beneficialAddress != (common.Address{}) {
+		return true
+	}
+	return n.showToPublic && n.serviceCfg != nil
+}
+
 func (n *Node) addr() *net.UDPAddr {
 	return &net.UDPAddr{IP: n.IP, Port: int(n.UDP)}
 }
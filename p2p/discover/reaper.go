@@ -0,0 +1,79 @@
+// Copyright 2021 The MOAC-core Authors
+// This file is part of the MOAC-core library.
+//
+// The MOAC-core library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The MOAC-core library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the MOAC-core library. If not, see <http://www.gnu.org/licenses/>.
+
+package discover
+
+import (
+	"time"
+
+	"github.com/MOACChain/MoacLib/log"
+)
+
+const (
+	defaultReapInterval = 10 * time.Minute
+	defaultReapFailures = 3
+)
+
+// reapLoop runs reap every interval until the table is closed.
+func (tab *Table) reapLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			tab.reap()
+		case <-tab.closed:
+			return
+		}
+	}
+}
+
+// reap pings the least recently seen node of every bucket, evicting the
+// ones which failed to answer reapFailures times in a row.
+func (tab *Table) reap() {
+	tab.mutex.Lock()
+	var idle []*Node
+	for _, b := range tab.buckets {
+		if len(b.entries) > 0 {
+			idle = append(idle, b.entries[len(b.entries)-1])
+		}
+	}
+	tab.mutex.Unlock()
+
+	for _, n := range idle {
+		err := tab.ping(n.ID, n.addr())
+
+		tab.mutex.Lock()
+		bIndex, found := tab.nodeBucket[n.ID]
+		if err == nil || !found {
+			// Nodes removed during the ping are no longer tracked.
+			delete(tab.reapFails, n.ID)
+			tab.mutex.Unlock()
+			continue
+		}
+		tab.reapFails[n.ID]++
+		fails := tab.reapFails[n.ID]
+		if fails >= tab.reapFailures {
+			// The delete also drops the failure count.
+			tab._deleteWithNodeIdAndIndex(n.ID, bIndex)
+		}
+		tab.mutex.Unlock()
+
+		if fails >= tab.reapFailures {
+			log.Debug("Reaped idle discovery node", "id", n.ID.String()[:16], "fails", fails, "err", err)
+		}
+	}
+}
@@ -23,6 +23,7 @@
 package discover
 
 import (
+	"bytes"
 	"encoding/binary"
 	"errors"
 	"fmt"
@@ -30,11 +31,13 @@ import (
 	"net"
 	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/MOACChain/MoacLib/common"
 	"github.com/MOACChain/MoacLib/crypto"
 	"github.com/MOACChain/MoacLib/log"
+	lru "github.com/hashicorp/golang-lru"
 	gocache "github.com/patrickmn/go-cache"
 )
 
@@ -45,7 +48,10 @@ const (
 	nBuckets                           = hashBits + 1 // Number of buckets
 	maxBondingPingPongs                = 16
 	maxFindnodeFailures                = 5
+	defaultMaxLookups                  = 8
+	defaultLookupCacheTTL              = 10 * time.Second
 	autoRefreshInterval                = 1 * time.Hour // seems too long, maybe change for subchain p2p network
+	minRefreshInterval                 = 5 * time.Second
 	bucketCleanupInterval              = 30 * time.Second
 	seedCount                          = 30
 	seedMaxAge                         = 5 * 24 * time.Hour
@@ -58,6 +64,12 @@ const (
 	kvstoreCacheTTL                    = 5 * time.Minute
 	defaultPurgeInterval               = 10 * time.Minute
 
+	// peer scoring
+	scorePenalty        = -1  // score change for a misbehaving packet
+	scoreBondReward     = 1   // score change for a successful bond
+	scoreMax            = 10  // upper bound so old good behavior can't hide new bad behavior
+	scoreAlienThreshold = -10 // nodes scoring below this are classified alien
+
 	// exported
 	KvstoreCacheUpdateInterval = 3 * time.Minute
 )
@@ -76,11 +88,6 @@ const (
 	AllExceptAlien        = 2 // send to brother, uncle and unknown node
 )
 
-var VnodeBeneficialAddress *common.Address
-var VnodeServiceCfg *string
-var ShowToPublic bool
-var Ip *string
-
 type Table struct {
 	mutex      sync.Mutex        // protects buckets, their content, and nursery
 	buckets    [nBuckets]*bucket // index of known nodes by distance
@@ -99,12 +106,31 @@ type Table struct {
 	nodeAddedHook func(*Node) // for testing
 
 	net  transport
-	self *Node // metadata of the local node
+	self atomic.Value // *Node, metadata of the local node, replaced by key rotation
 
 	totalNodes uint64         // total number of nodes in the bucket
 	nodeTypes  *gocache.Cache // a flag if a seen node in the p2p network a moac node
 
-	kvstore *gocache.Cache // k/v store using gocache
+	kvstore      *gocache.Cache // k/v store using gocache
+	kvseq        uint64         // number of values stored, accessed atomically
+	maxKeyValues int            // cap on the values per kvstore key, zero means no limit
+
+	refreshInterval time.Duration  // time between automatic refreshes
+	reapFailures    int            // failed pings after which the reaper evicts a node
+	reapFails       map[NodeID]int // consecutive failed reaper pings, protected by mutex
+
+	lookupSlots chan struct{} // limits the number of running lookups, nil means no limit
+	lookups     int32         // number of running lookups, accessed atomically
+	lastLookup  time.Time     // end of the last lookup answered by a node, protected by mutex
+
+	lookupCacheTTL time.Duration                  // lifetime of cached lookup results, zero disables the cache
+	lookupCache    map[lookupKey]lookupCacheEntry // recent lookup results, protected by mutex
+
+	scoreMu sync.Mutex // serializes the updates of scores
+	scores  *lru.Cache // reputation by NodeID of the nodes we have talked to most recently
+
+	trustedMu sync.RWMutex        // protects trusted
+	trusted   map[NodeID]struct{} // nodes never classified alien
 }
 
 // NodesByDistance is a list of nodes, ordered by
@@ -115,14 +141,28 @@ type NodesByDistance struct {
 }
 
 type bondproc struct {
-	err  error
-	n    *Node
-	done chan struct{}
+	err       error
+	n         *Node
+	done      chan struct{}
+	cancelled bool // set by CancelBond, protected by bondmu
+}
+
+// lookupKey identifies the cached result of a lookup.
+type lookupKey struct {
+	target NodeID
+	strict bool
+}
+
+// lookupCacheEntry is a lookup result kept for reuse until it expires.
+type lookupCacheEntry struct {
+	nodes   []*Node
+	expires time.Time
 }
 
 type BootNodeCacheItem struct {
 	url        string    // bootnode url in enode format
 	expireTime time.Time // expire time
+	seq        uint64    // store order, used to evict the oldest value
 }
 
 // transport is implemented by the UDP transport.
@@ -134,7 +174,9 @@ type transport interface {
 	findnode(toid NodeID, addr *net.UDPAddr, target NodeID, strictNodeCheck bool) ([]*Node, error)
 	store(key NodeID, value []byte, toNodes []*Node)
 	findvalue(key NodeID, toNodes []*Node)
+	announceSubnet(subnetID NodeID, closest func(NodeID) []*Node)
 	getOurEndpoint() rpcEndpoint
+	forget(NodeID) // drops the per node state of a node removed from the table
 	close()
 }
 
@@ -144,7 +186,8 @@ type bucket struct {
 	entries []*Node
 }
 
-func newTable(t transport, ourID NodeID, ourAddr *net.UDPAddr, nodeDBPath string, beneficialAddress *common.Address, serviceCfg *string, showToPublic bool, ip *string) (*Table, error) {
+func newTable(t transport, ourID NodeID, ourAddr *net.UDPAddr, nodeDBPath string, cfg Config) (*Table, error) {
+	cfg = cfg.withDefaults()
 	// If no node database was given, use an in-memory one
 	db, err := newNodeDB(nodeDBPath, Version, ourID)
 	if err != nil {
@@ -153,7 +196,6 @@ func newTable(t transport, ourID NodeID, ourAddr *net.UDPAddr, nodeDBPath string
 	tab := &Table{
 		net:        t,
 		db:         db,
-		self:       NewNode(ourID, ourAddr.IP, uint16(ourAddr.Port), uint16(ourAddr.Port), beneficialAddress, serviceCfg, showToPublic, ip),
 		bonding:    make(map[NodeID]*bondproc),
 		bondslots:  make(chan struct{}, maxBondingPingPongs),
 		refreshReq: make(chan chan struct{}),
@@ -163,22 +205,46 @@ func newTable(t transport, ourID NodeID, ourAddr *net.UDPAddr, nodeDBPath string
 		nodeTypes:  gocache.New(nodeTypesCacheTTL, defaultPurgeInterval),
 		nodeBucket: make(map[NodeID]int),
 		kvstore:    gocache.New(kvstoreCacheTTL, defaultPurgeInterval),
+		trusted:    make(map[NodeID]struct{}),
+
+		refreshInterval: clampRefreshInterval(cfg.RefreshInterval),
+		maxKeyValues:    cfg.MaxSubnetValues,
+		reapFailures:    cfg.ReapFailures,
+		reapFails:       make(map[NodeID]int),
+		lookupCacheTTL:  *cfg.LookupCacheTTL,
+		lookupCache:     make(map[lookupKey]lookupCacheEntry),
 	}
+	tab.self.Store(NewNode(ourID, ourAddr.IP, uint16(ourAddr.Port), uint16(ourAddr.Port), cfg.BeneficialAddress, cfg.ServiceCfg, cfg.ShowToPublic, cfg.Ip))
+	tab.scores, _ = lru.New(maxTrackedNodes)
 	for i := 0; i < cap(tab.bondslots); i++ {
 		tab.bondslots <- struct{}{}
 	}
+	if cfg.MaxConcurrentLookups > 0 {
+		tab.lookupSlots = make(chan struct{}, cfg.MaxConcurrentLookups)
+		for i := 0; i < cap(tab.lookupSlots); i++ {
+			tab.lookupSlots <- struct{}{}
+		}
+	}
+	// Restore the node classifications of the previous run.
+	now := time.Now()
+	for id, entry := range db.nodeTypes(now) {
+		tab.nodeTypes.Set(tab.NodeTypeKey(id), int(entry.Type), time.Unix(int64(entry.Expires), 0).Sub(now))
+	}
 	for i := range tab.buckets {
 		tab.buckets[i] = new(bucket)
 	}
 	go tab.refreshLoop()
 	go tab.cleanUpBuckets()
+	if *cfg.ReapInterval > 0 {
+		go tab.reapLoop(*cfg.ReapInterval)
+	}
 	return tab, nil
 }
 
 // Self returns the local node.
 // The returned node should not be modified by the caller.
 func (tab *Table) Self() *Node {
-	return tab.self
+	return tab.self.Load().(*Node)
 }
 
 func (tab *Table) GetAllNodes() []*Node {
@@ -216,6 +282,9 @@ func (tab *Table) DumpNodeTypes() *gocache.Cache {
 
 // SetNodeType set if a node is a moac node
 func (tab *Table) SetNodeType(id NodeID, flag int) error {
+	if flag == AlienNode && tab.IsTrusted(id) {
+		return nil
+	}
 	existingNodeType := tab.GetNodeType(id)
 	// if node is already set to higher type, don't downgrade it
 	if existingNodeType > flag {
@@ -227,6 +296,11 @@ func (tab *Table) SetNodeType(id NodeID, flag int) error {
 	// so that not all caches expire at the same time
 	expireTime := nodeTypesCacheTTLMin + time.Duration(rand.Intn(nodeTypesCacheTTLDropWindow))*time.Second
 	tab.nodeTypes.Set(_id, flag, expireTime)
+	// Only persist changes, refreshing the expiry on every packet
+	// would write to the database far too often.
+	if existingNodeType != flag {
+		tab.db.updateNodeType(id, flag, time.Now().Add(expireTime))
+	}
 	return nil
 }
 
@@ -237,6 +311,78 @@ func (tab *Table) SetNodeTypeStr(id string, flag int) error {
 	return nil
 }
 
+// AddTrustedNode adds a node to the trusted set. Trusted nodes are never
+// classified alien and their packets are always processed, an existing
+// alien classification is dropped.
+func (tab *Table) AddTrustedNode(id NodeID) {
+	tab.trustedMu.Lock()
+	tab.trusted[id] = struct{}{}
+	tab.trustedMu.Unlock()
+
+	if tab.GetNodeType(id) == AlienNode {
+		tab.nodeTypes.Delete(tab.NodeTypeKey(id))
+		tab.db.deleteNodeType(id)
+	}
+}
+
+// IsTrusted returns whether a node is in the trusted set.
+func (tab *Table) IsTrusted(id NodeID) bool {
+	tab.trustedMu.RLock()
+	defer tab.trustedMu.RUnlock()
+	_, ok := tab.trusted[id]
+	return ok
+}
+
+// Score returns the reputation of a node. Nodes start at zero, misbehaving
+// packets lower the score and successful bonds raise it.
+func (tab *Table) Score(id NodeID) int {
+	if score, ok := tab.scores.Get(id); ok {
+		return score.(int)
+	}
+	return 0
+}
+
+// Scores returns a copy of all known node scores, e.g. for metrics. Only the
+// scores of the maxTrackedNodes most recently scored nodes are kept.
+func (tab *Table) Scores() map[NodeID]int {
+	tab.scoreMu.Lock()
+	defer tab.scoreMu.Unlock()
+	scores := make(map[NodeID]int, tab.scores.Len())
+	for _, id := range tab.scores.Keys() {
+		if score, ok := tab.scores.Peek(id); ok {
+			scores[id.(NodeID)] = score.(int)
+		}
+	}
+	return scores
+}
+
+// adjustScore changes the score of a node by delta. A node whose score
+// drops below scoreAlienThreshold is classified alien, whatever type it had.
+func (tab *Table) adjustScore(id NodeID, delta int) {
+	tab.scoreMu.Lock()
+	score := delta
+	if old, ok := tab.scores.Get(id); ok {
+		score += old.(int)
+	}
+	if score > scoreMax {
+		score = scoreMax
+	}
+	tab.scores.Add(id, score)
+	tab.scoreMu.Unlock()
+
+	if score < scoreAlienThreshold && tab.GetNodeType(id) != AlienNode && !tab.IsTrusted(id) {
+		log.Debug("Node score below threshold, marking alien", "id", id.String()[:16], "score", score)
+		tab.markAlien(id)
+	}
+}
+
+// markAlien classifies a node alien whatever type it had. The classification
+// is persisted, so it survives restarts until it expires.
+func (tab *Table) markAlien(id NodeID) {
+	tab.nodeTypes.Set(tab.NodeTypeKey(id), AlienNode, nodeTypesCacheTTL)
+	tab.db.updateNodeType(id, AlienNode, time.Now().Add(nodeTypesCacheTTL))
+}
+
 // SetNodeType set if a node is a moac node
 func (tab *Table) NodeTypeSize() int {
 	return tab.nodeTypes.ItemCount()
@@ -317,77 +463,47 @@ func (tab *Table) SetKey(key []byte, value []byte, fromID NodeID) bool {
 	}
 
 	cachekey := tab.GetSubnetBootnodeKey(common.Bytes2Hex(key[:]))
-	existValue, found := tab.kvstore.Get(cachekey)
 	log.Debugf("subnet setkey [%s]", cachekey)
-	// if key already exists
-	if found {
-		// not enough slots: find the oldest node in the map,
-		// and ping it, if it does not response, replace it with the
-		// value from new node, otherwise do nothing
-		bootnodes := existValue.(map[string]BootNodeCacheItem)
-		notExpiredBootnodes := make(map[string]BootNodeCacheItem)
+	bootnodes := make(map[string]BootNodeCacheItem)
+	if existValue, found := tab.kvstore.Get(cachekey); found {
 		now := time.Now()
-		for k, bootnode := range bootnodes {
+		for k, bootnode := range existValue.(map[string]BootNodeCacheItem) {
 			if bootnode.expireTime.After(now) {
-				notExpiredBootnodes[k] = bootnode
-			}
-		}
-		if len(notExpiredBootnodes) >= 16 {
-			var oldest *Node
-			oldestLastPong := time.Now()
-			for id, _ := range notExpiredBootnodes {
-				nodeid, err := HexID(id)
-				if err == nil {
-					nodeLastpong := tab.db.lastPong(nodeid)
-					if oldestLastPong.After(nodeLastpong) {
-						oldestLastPong = nodeLastpong
-						oldest = tab.db.node(nodeid)
-					}
-				}
-			}
-			if oldest == nil {
-				return false
-			}
-			err := tab.ping(oldest.ID, oldest.addr())
-			// if the node does not response, replace it with the new one
-			// otherwise, do not replace
-			if err != nil {
-				nodeid := common.Bytes2Hex(oldest.ID[:])
-				delete(notExpiredBootnodes, nodeid)
-				fromid := common.Bytes2Hex(fromID[:])
-				bootnodeItem := BootNodeCacheItem{
-					url:        nodeURL,
-					expireTime: time.Now().Add(kvstoreCacheTTL),
-				}
-				notExpiredBootnodes[fromid] = bootnodeItem
-				tab.kvstore.Set(cachekey, notExpiredBootnodes, gocache.DefaultExpiration)
-				printKV(notExpiredBootnodes)
-			}
-		} else {
-			// still have slots left, just update
-			fromid := common.Bytes2Hex(fromID[:])
-			bootnodeItem := BootNodeCacheItem{
-				url:        nodeURL,
-				expireTime: time.Now().Add(kvstoreCacheTTL),
+				bootnodes[k] = bootnode
 			}
-			notExpiredBootnodes[fromid] = bootnodeItem
-			tab.kvstore.Set(cachekey, notExpiredBootnodes, gocache.DefaultExpiration)
-			printKV(notExpiredBootnodes)
 		}
-	} else {
-		// if not found, just store the value
-		bootnodes := make(map[string]BootNodeCacheItem)
-		bootnodeItem := BootNodeCacheItem{
-			url:        nodeURL,
-			expireTime: time.Now().Add(kvstoreCacheTTL),
-		}
-		bootnodes[nodeID] = bootnodeItem
-		tab.kvstore.Set(cachekey, bootnodes, gocache.DefaultExpiration)
-		printKV(bootnodes)
 	}
+	// a repeated store refreshes the value and makes it the newest one
+	fromid := common.Bytes2Hex(fromID[:])
+	delete(bootnodes, fromid)
+	// not enough slots: evict the oldest values to make room
+	for tab.maxKeyValues > 0 && len(bootnodes) >= tab.maxKeyValues {
+		delete(bootnodes, oldestBootnode(bootnodes))
+	}
+	bootnodes[fromid] = BootNodeCacheItem{
+		url:        nodeURL,
+		expireTime: time.Now().Add(kvstoreCacheTTL),
+		seq:        atomic.AddUint64(&tab.kvseq, 1),
+	}
+	tab.kvstore.Set(cachekey, bootnodes, gocache.DefaultExpiration)
+	printKV(bootnodes)
 	return true
 }
 
+// oldestBootnode returns the key of the earliest stored value.
+func oldestBootnode(bootnodes map[string]BootNodeCacheItem) string {
+	var (
+		oldest string
+		minSeq uint64
+	)
+	for k, bootnode := range bootnodes {
+		if oldest == "" || bootnode.seq < minSeq {
+			oldest, minSeq = k, bootnode.seq
+		}
+	}
+	return oldest
+}
+
 // ReadRandomNodes fills the given slice with random nodes from the
 // table. It will not write the same node more than once. The nodes in
 // the slice are copies and can be modified by the caller.
@@ -503,7 +619,7 @@ func (tab *Table) Resolve(targetID NodeID) *Node {
 func (tab *Table) Lookup(
 	targetID NodeID, lookupID int, strictNodeCheck bool,
 ) []*Node {
-	return tab.lookup(targetID, true, lookupID, strictNodeCheck)
+	return tab.lookup(targetID, true, lookupID, strictNodeCheck, true)
 }
 
 func printNodesByDist(nodeByDist *NodesByDistance, where string, lookupID int, targetID NodeID) {
@@ -513,10 +629,30 @@ func printNodesByDist(nodeByDist *NodesByDistance, where string, lookupID int, t
 	}
 }
 
+// LookupsInFlight returns the number of lookups currently running.
+func (tab *Table) LookupsInFlight() int {
+	return int(atomic.LoadInt32(&tab.lookups))
+}
+
+// lookup performs a network search for nodes close to the given target.
+// Refresh lookups pass useCache false, they must reach the network to keep
+// the buckets fresh and must not replace the results served to others.
 func (tab *Table) lookup(
 	targetID NodeID, refreshIfEmpty bool,
-	lookupID int, strictNodeCheck bool,
+	lookupID int, strictNodeCheck bool, useCache bool,
 ) []*Node {
+	// serve repeated lookups of the same target from the cache.
+	key := lookupKey{targetID, strictNodeCheck}
+	if useCache {
+		tab.mutex.Lock()
+		cached, ok := tab.cachedLookup(key)
+		tab.mutex.Unlock()
+		if ok {
+			lookupCacheHitMeter.Mark(1)
+			return cached
+		}
+	}
+
 	var (
 		target          = crypto.Keccak256Hash(targetID[:])
 		asked           = make(map[NodeID]bool)
@@ -527,7 +663,7 @@ func (tab *Table) lookup(
 	)
 	// don't query further if we hit ourself.
 	// unlikely to happen often in practice.
-	asked[tab.self.ID] = true
+	asked[tab.Self().ID] = true
 
 	for {
 		tab.mutex.Lock()
@@ -557,7 +693,26 @@ func (tab *Table) lookup(
 	}
 	log.Debugf("all found nodes closest result: %v, strict=%t", nodesByDist.entries, strictNodeCheck)
 
+	// wait for a free lookup slot, so bursts of lookups can't
+	// flood the network with findnode requests. The slot is taken
+	// only after the refresh above, whose lookups need slots too.
+	if tab.lookupSlots != nil {
+		select {
+		case <-tab.lookupSlots:
+			defer func() { tab.lookupSlots <- struct{}{} }()
+		case <-tab.closed:
+			return nil
+		}
+	}
+	atomic.AddInt32(&tab.lookups, 1)
+	lookupsInFlightCounter.Inc(1)
+	defer func() {
+		atomic.AddInt32(&tab.lookups, -1)
+		lookupsInFlightCounter.Dec(1)
+	}()
+
 	findNodeCount := 0
+	var replies int32 // findnode requests answered, accessed atomically
 	for {
 		// ask the alpha closest nodes that we haven't asked yet
 		for i := 0; i < len(nodesByDist.entries) && pendingQueries < alpha; i++ {
@@ -575,6 +730,9 @@ func (tab *Table) lookup(
 						log.Debugf("all found nodes [%d/%d]: %v with target: %s", i+1, totalFoundNodes, node, targetID.String()[:16])
 					}
 					findNodeCount++
+					if err == nil {
+						atomic.AddInt32(&replies, 1)
+					}
 					// handle error
 					if err != nil {
 						// Bump the failure counter to detect and evacuate non-bonded entries
@@ -616,9 +774,64 @@ func (tab *Table) lookup(
 		pendingQueries--
 		printNodesByDist(nodesByDist, "network search", lookupID, targetID)
 	}
+	if len(nodesByDist.entries) > 0 {
+		tab.mutex.Lock()
+		if atomic.LoadInt32(&replies) > 0 {
+			tab.lastLookup = time.Now()
+		}
+		if useCache {
+			tab.cacheLookup(key, nodesByDist.entries)
+		}
+		tab.mutex.Unlock()
+	}
 	return nodesByDist.entries
 }
 
+// cachedLookup returns a copy of the unexpired cached result of a lookup.
+// The caller must hold tab.mutex.
+func (tab *Table) cachedLookup(key lookupKey) ([]*Node, bool) {
+	entry, ok := tab.lookupCache[key]
+	if !ok {
+		return nil, false
+	}
+	if time.Now().After(entry.expires) {
+		delete(tab.lookupCache, key)
+		return nil, false
+	}
+	return append([]*Node(nil), entry.nodes...), true
+}
+
+// cacheLookup keeps the result of a lookup for lookupCacheTTL, dropping
+// expired results on the way. The caller must hold tab.mutex.
+func (tab *Table) cacheLookup(key lookupKey, nodes []*Node) {
+	if tab.lookupCacheTTL <= 0 {
+		return
+	}
+	now := time.Now()
+	for k, entry := range tab.lookupCache {
+		if now.After(entry.expires) {
+			delete(tab.lookupCache, k)
+		}
+	}
+	tab.lookupCache[key] = lookupCacheEntry{
+		nodes:   append([]*Node(nil), nodes...),
+		expires: now.Add(tab.lookupCacheTTL),
+	}
+}
+
+// forgetLookups drops the cached lookup results containing the given node,
+// so a removed node is not handed out again. The caller must hold tab.mutex.
+func (tab *Table) forgetLookups(id NodeID) {
+	for k, entry := range tab.lookupCache {
+		for _, n := range entry.nodes {
+			if n.ID == id {
+				delete(tab.lookupCache, k)
+				break
+			}
+		}
+	}
+}
+
 func (tab *Table) refresh() <-chan struct{} {
 	done := make(chan struct{})
 	select {
@@ -705,10 +918,26 @@ func (tab *Table) FindBootNodes(subnetID NodeID, toNodes []*Node) {
 	tab.net.findvalue(subnetID, toNodes)
 }
 
+// AnnounceSubnet periodically stores the local node under the subnet key at
+// the nodes returned by closest, until the table is closed. See
+// SubnetAnnounceInterval.
+func (tab *Table) AnnounceSubnet(subnetID NodeID, closest func(NodeID) []*Node) {
+	tab.net.announceSubnet(subnetID, closest)
+}
+
+// clampRefreshInterval raises a refresh interval to minRefreshInterval.
+func clampRefreshInterval(d time.Duration) time.Duration {
+	if d < minRefreshInterval {
+		log.Warn("Discovery refresh interval too low, clamping", "interval", d, "min", minRefreshInterval)
+		return minRefreshInterval
+	}
+	return d
+}
+
 // refreshLoop schedules doRefresh runs and coordinates shutdown.
 func (tab *Table) refreshLoop() {
 	var (
-		timer   = time.NewTicker(autoRefreshInterval)
+		timer   = time.NewTicker(tab.refreshInterval)
 		waiting []chan struct{} // accumulates waiting callers while doRefresh runs
 		done    chan struct{}   // where doRefresh reports completion
 	)
@@ -764,7 +993,7 @@ func (tab *Table) doRefresh(done chan struct{}) {
 	// We perform a lookup with a random target instead.
 	var target NodeID
 	rand.Read(target[:])
-	result := tab.lookup(target, false, 0, false)
+	result := tab.lookup(target, false, 0, false, false)
 	if len(result) > 0 {
 		return
 	}
@@ -787,7 +1016,7 @@ func (tab *Table) doRefresh(done chan struct{}) {
 	tab.mutex.Unlock()
 
 	// Finally, do a self lookup to fill up the buckets.
-	tab.lookup(tab.self.ID, false, 0, false)
+	tab.lookup(tab.Self().ID, false, 0, false, false)
 }
 
 // closest returns the n nodes in the table that are closest to the
@@ -825,6 +1054,22 @@ func (tab *Table) closest(
 	return close
 }
 
+// nodeTypeRank orders node types by dialing preference, lower is better.
+var nodeTypeRank = map[int]int{
+	BrotherNode: 0,
+	UncleNode:   1,
+	UnknownNode: 2,
+	AlienNode:   3,
+}
+
+// rankByNodeType sorts nodes by node type preference, keeping the existing
+// order of nodes with the same type.
+func (tab *Table) rankByNodeType(nodes []*Node) {
+	sort.SliceStable(nodes, func(i, j int) bool {
+		return nodeTypeRank[tab.GetNodeType(nodes[i].ID)] < nodeTypeRank[tab.GetNodeType(nodes[j].ID)]
+	})
+}
+
 func (tab *Table) len() (n int) {
 	for _, b := range tab.buckets {
 		n += len(b.entries)
@@ -873,7 +1118,7 @@ func (tab *Table) Bond(pinged bool, id NodeID, addr *net.UDPAddr, tcpPort uint16
 // If pinged is true, the remote node has just pinged us and one half
 // of the process can be skipped.
 func (tab *Table) bond(pinged bool, id NodeID, addr *net.UDPAddr, tcpPort uint16) (*Node, error) {
-	if id == tab.self.ID {
+	if id == tab.Self().ID {
 		return nil, errors.New("is self")
 	}
 
@@ -912,6 +1157,10 @@ func (tab *Table) bond(pinged bool, id NodeID, addr *net.UDPAddr, tcpPort uint16
 		}
 		// Retrieve the bonding results
 		result = w.err
+		if result == errBondCancelled {
+			// Nothing was learned about the node, leave it alone.
+			return nil, result
+		}
 		if result == nil {
 			node = w.n
 		}
@@ -923,6 +1172,9 @@ func (tab *Table) bond(pinged bool, id NodeID, addr *net.UDPAddr, tcpPort uint16
 		tab.add(node)
 		tab.db.updateFindFails(id, 0)
 	}
+	if result == nil && node != nil {
+		tab.adjustScore(id, scoreBondReward)
+	}
 	return node, result
 }
 
@@ -931,6 +1183,17 @@ func (tab *Table) pingpong(w *bondproc, pinged bool, id NodeID, addr *net.UDPAdd
 	<-tab.bondslots
 	defer func() { tab.bondslots <- struct{}{} }()
 
+	// The node may have been classified alien or the bond cancelled
+	// while waiting for a slot, don't waste a ping round trip on it.
+	tab.bondmu.Lock()
+	cancelled := w.cancelled
+	tab.bondmu.Unlock()
+	if cancelled || (tab.GetNodeType(id) == AlienNode && !tab.IsTrusted(id)) {
+		log.Trace("Bonding cancelled", "id", id.String()[:16])
+		w.err = errBondCancelled
+		close(w.done)
+		return
+	}
 	// Ping the remote side and wait for a pong.
 	if w.err = tab.ping(id, addr); w.err != nil {
 		close(w.done)
@@ -973,12 +1236,12 @@ func (tab *Table) ping(id NodeID, addr *net.UDPAddr) error {
 //
 // The caller must not hold tab.mutex.
 func (tab *Table) add(new *Node) {
-	if tab.GetNodeType(new.ID) == AlienNode {
+	if tab.GetNodeType(new.ID) == AlienNode && !tab.IsTrusted(new.ID) {
 		log.Debugf("node bucket add abort, not brother node %s", new.ID.String()[:16])
 		return
 	}
 
-	bIndex := logdist(tab.self.sha, new.sha)
+	bIndex := logdist(tab.Self().sha, new.sha)
 	b := tab.buckets[bIndex]
 	tab.mutex.Lock()
 	defer tab.mutex.Unlock()
@@ -987,6 +1250,11 @@ func (tab *Table) add(new *Node) {
 		return
 	}
 
+	// a brother node takes the place of a lower priority node first
+	if len(b.entries) == bucketSize && tab.GetNodeType(new.ID) == BrotherNode {
+		tab.evictForBrother(b)
+	}
+
 	// otherwise, find the oldest node and see if we can replace
 	var oldest *Node
 	// if bucket is full
@@ -1011,6 +1279,9 @@ func (tab *Table) add(new *Node) {
 	}
 	replaced := b.replace(new, oldest)
 	if replaced {
+		if oldest != nil {
+			tab.forgetLookups(oldest.ID)
+		}
 		tab.nodeBucket[new.ID] = bIndex
 		if tab.nodeAddedHook != nil {
 			tab.nodeAddedHook(new)
@@ -1024,10 +1295,10 @@ func (tab *Table) add(new *Node) {
 func (tab *Table) stuff(nodes []*Node) {
 outer:
 	for _, node := range nodes {
-		if node.ID == tab.self.ID {
+		if node.ID == tab.Self().ID {
 			continue // don't add self
 		}
-		bIndex := logdist(tab.self.sha, node.sha)
+		bIndex := logdist(tab.Self().sha, node.sha)
 		bucket := tab.buckets[bIndex]
 		for i := range bucket.entries {
 			if bucket.entries[i].ID == node.ID {
@@ -1050,13 +1321,14 @@ outer:
 func (tab *Table) delete(node *Node) {
 	tab.mutex.Lock()
 	defer tab.mutex.Unlock()
-	bIndex := logdist(tab.self.sha, node.sha)
+	bIndex := logdist(tab.Self().sha, node.sha)
 	tab._deleteWithNodeIdAndIndex(node.ID, bIndex)
 }
 
 func (tab *Table) DeleteWithNodeId(id NodeID) {
 	tab.mutex.Lock()
 	defer tab.mutex.Unlock()
+	tab.forgetLookups(id)
 	if bIndex, found := tab.nodeBucket[id]; found {
 		tab._deleteWithNodeIdAndIndex(id, bIndex)
 	}
@@ -1068,14 +1340,24 @@ func (tab *Table) _deleteWithNodeIdAndIndex(id NodeID, index int) {
 	bucket := tab.buckets[index]
 	for i := range bucket.entries {
 		if bucket.entries[i].ID == id {
-			bucket.entries = append(bucket.entries[:i], bucket.entries[i+1:]...)
+			tab._removeEntry(bucket, i)
 			tab.totalNodes--
-			delete(tab.nodeBucket, id)
 			return
 		}
 	}
 }
 
+// _removeEntry drops entry i of b along with the per node state kept for it.
+// It leaves totalNodes to the caller. The caller must hold tab.mutex.
+func (tab *Table) _removeEntry(b *bucket, i int) {
+	id := b.entries[i].ID
+	b.entries = append(b.entries[:i], b.entries[i+1:]...)
+	delete(tab.nodeBucket, id)
+	delete(tab.reapFails, id)
+	tab.forgetLookups(id)
+	tab.net.forget(id)
+}
+
 func (b *bucket) replace(n *Node, last *Node) bool {
 	// Don't add if b already contains n.
 	for i := range b.entries {
@@ -1097,6 +1379,39 @@ func (b *bucket) replace(n *Node, last *Node) bool {
 	return true
 }
 
+// evictionRank orders node types by how readily their nodes are evicted to
+// make room for a brother node, lowest first.
+var evictionRank = map[int]int{
+	AlienNode:   0,
+	UnknownNode: 1,
+	UncleNode:   2,
+	BrotherNode: 3,
+}
+
+// evictForBrother removes the lowest priority entry of b to make room for a
+// brother node: alien nodes go first, then unknown and uncle nodes, the least
+// recently active one among equals. Brother nodes are never removed here, a
+// stale one is only replaced if it fails to answer a ping. It reports whether
+// an entry was removed. The caller must hold tab.mutex.
+func (tab *Table) evictForBrother(b *bucket) bool {
+	victim, rank := -1, evictionRank[BrotherNode]
+	for i := len(b.entries) - 1; i >= 0; i-- {
+		if b.entries[i].contested {
+			continue
+		}
+		if r := evictionRank[tab.GetNodeType(b.entries[i].ID)]; r < rank {
+			victim, rank = i, r
+		}
+	}
+	if victim < 0 {
+		return false
+	}
+	id := b.entries[victim].ID
+	log.Debugf("node bucket evict %s for brother node, type %d", id.String()[:16], tab.GetNodeType(id))
+	tab._removeEntry(b, victim)
+	return true
+}
+
 func (b *bucket) bump(n *Node) bool {
 	for i := range b.entries {
 		if b.entries[i].ID == n.ID {
@@ -1109,15 +1424,54 @@ func (b *bucket) bump(n *Node) bool {
 	return false
 }
 
+// sortFresh orders the entries by log distance to the target. Entries at the
+// same log distance are ordered by the time returned by lastSeen, most
+// recent first, falling back to the order of sortStable.
+func (h *NodesByDistance) sortFresh(lastSeen func(NodeID) time.Time) {
+	seen := make(map[NodeID]time.Time, len(h.entries))
+	for _, n := range h.entries {
+		seen[n.ID] = lastSeen(n.ID)
+	}
+	sort.Slice(h.entries, func(i, j int) bool {
+		a, b := h.entries[i], h.entries[j]
+		if da, db := logdist(h.Target, a.sha), logdist(h.Target, b.sha); da != db {
+			return da < db
+		}
+		if ta, tb := seen[a.ID], seen[b.ID]; !ta.Equal(tb) {
+			return ta.After(tb)
+		}
+		if d := distcmp(h.Target, a.sha, b.sha); d != 0 {
+			return d < 0
+		}
+		return bytes.Compare(a.ID[:], b.ID[:]) < 0
+	})
+}
+
 // push adds the given node to the list, keeping the total size below maxElems.
 func (h *NodesByDistance) GetEntries() []*Node {
 	return h.entries
 }
 
+// sortStable orders the entries by distance to the target, using the node id
+// as a tiebreaker, so the order doesn't depend on insertion history.
+func (h *NodesByDistance) sortStable() {
+	sort.Slice(h.entries, func(i, j int) bool {
+		if d := distcmp(h.Target, h.entries[i].sha, h.entries[j].sha); d != 0 {
+			return d < 0
+		}
+		return bytes.Compare(h.entries[i].ID[:], h.entries[j].ID[:]) < 0
+	})
+}
+
 // push adds the given node to the list, keeping the total size below maxElems.
+// Nodes at the same distance are ordered by node id, so the result doesn't
+// depend on the order the nodes are pushed in.
 func (h *NodesByDistance) Push(n *Node, maxElems int) {
 	ix := sort.Search(len(h.entries), func(i int) bool {
-		return distcmp(h.Target, h.entries[i].sha, n.sha) > 0
+		if d := distcmp(h.Target, h.entries[i].sha, n.sha); d != 0 {
+			return d > 0
+		}
+		return bytes.Compare(h.entries[i].ID[:], n.ID[:]) > 0
 	})
 	if len(h.entries) < maxElems {
 		h.entries = append(h.entries, n)
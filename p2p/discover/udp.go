@@ -19,13 +19,17 @@ package discover
 import (
 	"bytes"
 	"container/list"
+	"context"
 	"crypto/ecdsa"
 	"errors"
 	"fmt"
+	"io"
 	"math/rand"
 	"net"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/MOACChain/MoacLib/common"
@@ -35,6 +39,7 @@ import (
 	"github.com/MOACChain/MoacLib/rlp"
 	"github.com/MOACChain/xchain/p2p/nat"
 	"github.com/MOACChain/xchain/p2p/netutil"
+	lru "github.com/hashicorp/golang-lru"
 )
 
 const Version = 4
@@ -43,6 +48,7 @@ const BootNodeLimits = 5
 // Errors
 var (
 	errPacketTooSmall   = errors.New("too small")
+	errPacketTooLarge   = errors.New("declared content too large")
 	errBadHash          = errors.New("bad hash")
 	errExpired          = errors.New("expired")
 	errUnsolicitedReply = errors.New("unsolicited reply")
@@ -50,18 +56,55 @@ var (
 	errTimeout          = errors.New("RPC timeout")
 	errClockWarp        = errors.New("reply deadline too far in the future")
 	errClosed           = errors.New("socket closed")
+	errTooManyPending   = errors.New("too many pending replies")
+	errSubnetDisabled   = errors.New("subnet store disabled")
+	errBondExpired      = errors.New("bond expired")
+	errRotatingKey      = errors.New("key rotation in progress")
+	errDrainTimeout     = errors.New("timeout draining pending replies")
+	errOldVersion       = errors.New("peer version too old")
+	errTooManyNeighbors = errors.New("too many nodes in neighbors packet")
+	errBondCancelled    = errors.New("bond cancelled")
+	errNetworkMismatch  = errors.New("network id mismatch")
+	errAlienNode        = errors.New("node belongs to another network")
+	errWriteTimeout     = errors.New("packet write timeout")
+	errBanned           = errors.New("node banned")
 )
 
+// MaxPendingReplies caps the number of requests waiting for a reply at
+// the same time. Requests above the cap fail immediately. Zero means
+// no limit.
+var MaxPendingReplies = 1024
+
+// RecordStore persists subnet key/value records, which otherwise only live
+// in the in-memory kvstore of the table and are lost on shutdown.
+type RecordStore interface {
+	// PutRecords stores the bootnode records of a subnet, keyed by node id.
+	PutRecords(subnet string, records map[string]string) error
+}
+
+// SubnetRecordStore receives the subnet records on shutdown. A nil store
+// keeps the records in memory only.
+var SubnetRecordStore RecordStore
+
 // Timeouts
 const (
-	respTimeout = 500 * time.Millisecond
-	expiration  = 20 * time.Second
+	respTimeout  = 500 * time.Millisecond
+	drainTimeout = 5 * time.Second // Max wait for pending replies on key rotation
+	expiration   = 20 * time.Second
+
+	minRespTimeout   = 100 * time.Millisecond // Lower bound of the RTT based reply timeout
+	maxRespTimeout   = 2 * time.Second        // Upper bound of the RTT based reply timeout
+	rttTimeoutFactor = 4                      // Multiple of the measured RTT to wait for a reply
 
 	ntpFailureThreshold = 32               // Continuous timeouts after which to check NTP
 	ntpWarningCooldown  = 10 * time.Minute // Minimum amount of time to pass before repeating NTP warning
 	driftThreshold      = 10 * time.Second // Allowed clock drift before warning user
 )
 
+// maxTrackedNodes caps the number of nodes whose per node state, such as
+// advertised attributes, is kept by the transport.
+const maxTrackedNodes = 1024
+
 // RPC packet types
 const (
 	PINGPACKET = iota + 1 // zero is 'reserved'
@@ -149,6 +192,18 @@ type (
 		ip                *string
 	}
 
+	// rpcNodeExt carries the optional attributes of the node with the same
+	// ID in a neighbors packet. The list of them is sent in the tail of the
+	// packet, so nodes not knowing it ignore it.
+	rpcNodeExt struct {
+		ID                NodeID
+		BeneficialAddress []byte // 20 bytes, empty if not advertised
+		ServiceCfg        string // service config, empty unless ShowToPublic
+		ShowToPublic      bool   // whether the node advertises its service config
+		// Ignore additional fields (for forward compatibility).
+		Rest []rlp.RawValue `rlp:"tail"`
+	}
+
 	rpcEndpoint struct {
 		IP  net.IP // len 4 for IPv4 or 16 for IPv6
 		UDP uint16 // for discovery protocol
@@ -165,18 +220,63 @@ func makeEndpoint(addr *net.UDPAddr, tcpPort uint16) rpcEndpoint {
 }
 
 func (u *udp) nodeFromRPC(sender *net.UDPAddr, rn rpcNode) (*Node, error) {
+	// A peer may echo ourselves back, which would waste a bucket slot
+	// and make us bond with ourselves.
+	if ours := u.getOurEndpoint(); rn.ID == u.Self().ID || (rn.IP.Equal(ours.IP) && rn.UDP == ours.UDP) {
+		u.countReject(rejectSelf)
+		return nil, errors.New("is self")
+	}
 	if rn.UDP <= 1024 {
+		u.countReject(rejectLowPort)
 		return nil, errors.New("low port")
 	}
 	if err := netutil.CheckRelayIP(sender.IP, rn.IP); err != nil {
+		u.countReject(rejectRelayIP)
 		return nil, err
 	}
 	if u.netrestrict != nil && !u.netrestrict.Contains(rn.IP) {
+		u.countReject(rejectNetrestrict)
 		return nil, errors.New("not contained in netrestrict whitelists")
 	}
 	n := NewNode(rn.ID, rn.IP, rn.UDP, rn.TCP, rn.beneficialAddress, rn.serviceCfg, rn.showToPublic, rn.ip)
 	err := n.validateComplete()
-	return n, err
+	if err != nil {
+		u.countReject(rejectIncomplete)
+		return n, err
+	}
+	if rn.beneficialAddress != nil || rn.showToPublic {
+		u.advertised.Add(n.ID, n)
+	}
+	return n, nil
+}
+
+// countReject tallies a node rejected by nodeFromRPC under the given reason.
+func (u *udp) countReject(reason string) {
+	u.rejectMu.Lock()
+	u.rejects[reason]++
+	u.rejectMu.Unlock()
+	rejectMeters[reason].Mark(1)
+}
+
+// PeerVersion returns the discovery protocol version the node advertised in
+// its last ping, and whether the node pinged us at all.
+func (u *udp) PeerVersion(id NodeID) (uint, bool) {
+	if version, ok := u.versions.Get(id); ok {
+		return version.(uint), true
+	}
+	return 0, false
+}
+
+// RejectCounts returns how many neighbor nodes were rejected so far,
+// keyed by the rejection reason.
+func (u *udp) RejectCounts() map[string]uint64 {
+	u.rejectMu.Lock()
+	defer u.rejectMu.Unlock()
+	counts := make(map[string]uint64, len(u.rejects))
+	for reason, n := range u.rejects {
+		counts[reason] = n
+	}
+	return counts
 }
 
 func nodeToRPC(n *Node) rpcNode {
@@ -188,6 +288,100 @@ func nodeToRPC(n *Node) rpcNode {
 	}
 }
 
+// nodeExtToRPC returns the optional attributes advertised for n, and whether
+// there are any. Attributes are taken from n or, for nodes which were
+// discovered through neighbors packets, from the node as received. The
+// service config is only advertised for nodes showing it to the public.
+func (u *udp) nodeExtToRPC(n *Node) (rpcNodeExt, bool) {
+	src := n
+	if !n.hasAttributes() {
+		if rec, ok := u.advertised.Get(n.ID); ok {
+			src = rec.(*Node)
+		}
+	}
+	if !src.hasAttributes() {
+		return rpcNodeExt{}, false
+	}
+	ext := rpcNodeExt{ID: n.ID}
+	if addr := src.beneficialAddress; addr != nil && *addr != (common.Address{}) {
+		ext.BeneficialAddress = addr.Bytes()
+	}
+	if src.showToPublic && src.serviceCfg != nil && len(*src.serviceCfg) <= maxServiceCfgSize {
+		ext.ServiceCfg = *src.serviceCfg
+		ext.ShowToPublic = true
+	}
+	return ext, len(ext.BeneficialAddress) > 0 || ext.ShowToPublic
+}
+
+// setNodeExts stores the optional node attributes in the tail of p.
+func (p *neighbors) setNodeExts(exts []rpcNodeExt) error {
+	p.Rest = p.Rest[:0]
+	if len(exts) == 0 {
+		return nil
+	}
+	enc, err := rlp.EncodeToBytes(exts)
+	if err != nil {
+		return err
+	}
+	p.Rest = append(p.Rest, enc)
+	return nil
+}
+
+// nodeExts returns the optional node attributes in the tail of p by node ID.
+// Malformed entries are skipped, the well-formed ones are still returned.
+func (p *neighbors) nodeExts() map[NodeID]rpcNodeExt {
+	if len(p.Rest) == 0 {
+		return nil
+	}
+	stream := rlp.NewStream(bytes.NewReader(p.Rest[0]), uint64(len(p.Rest[0])))
+	if _, err := stream.List(); err != nil {
+		return nil
+	}
+	byID := make(map[NodeID]rpcNodeExt)
+	for {
+		raw, err := stream.Raw()
+		if err != nil {
+			break
+		}
+		var ext rpcNodeExt
+		if err := rlp.DecodeBytes(raw, &ext); err != nil {
+			continue
+		}
+		byID[ext.ID] = ext
+	}
+	return byID
+}
+
+// applyExt copies the attributes of ext to rn. Service configs are only
+// accepted from nodes showing them to the public.
+func (rn *rpcNode) applyExt(ext rpcNodeExt) {
+	if len(ext.BeneficialAddress) == common.AddressLength {
+		addr := common.BytesToAddress(ext.BeneficialAddress)
+		rn.beneficialAddress = &addr
+	}
+	if ext.ShowToPublic && len(ext.ServiceCfg) <= maxServiceCfgSize {
+		cfg := ext.ServiceCfg
+		rn.serviceCfg = &cfg
+		rn.showToPublic = true
+	}
+}
+
+// NodesWithBeneficiary returns the nodes discovered through neighbors packets
+// which advertised addr as their beneficial address.
+func (u *udp) NodesWithBeneficiary(addr common.Address) []*Node {
+	var nodes []*Node
+	for _, id := range u.advertised.Keys() {
+		rec, ok := u.advertised.Peek(id)
+		if !ok {
+			continue
+		}
+		if n := rec.(*Node); n.beneficialAddress != nil && *n.beneficialAddress == addr {
+			nodes = append(nodes, n)
+		}
+	}
+	return nodes
+}
+
 type packet interface {
 	handle(u *udp, from *net.UDPAddr, fromID NodeID, mac []byte) error
 	name() string
@@ -200,21 +394,90 @@ type conn interface {
 	LocalAddr() net.Addr
 }
 
+// deadlineConn is implemented by conns supporting write deadlines, such as
+// *net.UDPConn.
+type deadlineConn interface {
+	SetWriteDeadline(t time.Time) error
+}
+
 // udp implements the RPC protocol.
 type udp struct {
 	conn            conn
 	netrestrict     *netutil.Netlist
-	priv            *ecdsa.PrivateKey
+	priv            *ecdsa.PrivateKey // protected by keyMu
+	keyMu           sync.RWMutex
+	rotating        int32        // non-zero while the key is rotated, accessed atomically
+	endpointMu      sync.RWMutex // protects ourEndpoint
 	ourEndpoint     rpcEndpoint
 	pendings        chan *pending
 	gotreply        chan reply
+	banned          chan NodeID             // nodes whose pending replies are dropped
+	pendingReq      chan chan []PendingInfo // requests for a snapshot of the pending replies
 	closing         chan struct{}
 	nat             nat.Interface
 	networkid       uint64
 	strictNodeCheck bool
+	reqSeq          uint64 // last request id handed out, accessed atomically
+	npending        int32  // number of queued pending replies, accessed atomically
+	maxPending      int32  // cap on npending, zero means no limit
+	subnetEnabled   bool   // whether subnet STORE/FINDVALUE packets are handled
+	bondExpiration  time.Duration
+	writeTimeout    time.Duration // write deadline of packets, zero means none
+	preferFresh     bool          // order equally close neighbors by last pong
+	announceEvery   time.Duration // interval of subnet membership announcements
+	clock           clock         // time source for deadlines and expiry, replaced in tests
+	codec           packetCodec   // wire crypto of packets
+
+	rejectMu sync.Mutex        // protects rejects
+	rejects  map[string]uint64 // number of rejected neighbor nodes by reason
+
+	minVersion uint       // lowest accepted peer version
+	versions   *lru.Cache // protocol version advertised by the most recent peers, by NodeID
+
+	records RecordStore // persistent store for subnet records, may be nil
+
+	rttMu sync.Mutex // serializes the updates of rtts
+	rtts  *lru.Cache // smoothed ping round trip time by NodeID of the most recent peers
+
+	advertised *lru.Cache // *Node by NodeID, discovered nodes advertising optional attributes
+
+	outcomes outcomeWindow // whether the most recent pending replies timed out
+
+	alienMismatches int                        // consecutive mismatches classifying a node alien
+	alienWindow     time.Duration              // time within which the mismatches have to happen
+	mismatchMu      sync.Mutex                 // protects mismatches and mismatchSweep
+	mismatches      map[NodeID]networkMismatch // network id mismatches of nodes not yet alien
+	mismatchSweep   time.Time                  // time of the last sweep of expired mismatches
+
 	*Table
 }
 
+// networkMismatch tracks the consecutive network id mismatches of a node.
+type networkMismatch struct {
+	count int
+	first time.Time // time of the first mismatch of the current run
+}
+
+// PendingInfo describes a reply the udp transport is waiting for.
+type PendingInfo struct {
+	ReqID     uint64        // id of the request the reply belongs to
+	From      NodeID        // node the reply is expected from
+	PType     byte          // expected packet type of the reply
+	Remaining time.Duration // time until the reply times out
+}
+
+// PendingReplies returns the replies currently waiting, ordered by deadline.
+// It is meant for diagnosing stuck lookups.
+func (u *udp) PendingReplies() []PendingInfo {
+	ch := make(chan []PendingInfo, 1)
+	select {
+	case u.pendingReq <- ch:
+		return <-ch
+	case <-u.closing:
+		return nil
+	}
+}
+
 // pending represents a pending reply.
 //
 // some implementations of the protocol wish to send more than one
@@ -225,9 +488,14 @@ type udp struct {
 // each pending reply. incoming packets from a node are dispatched
 // to all the callback functions for that node.
 type pending struct {
-	// these fields must match in the reply.
-	from  NodeID
-	ptype byte
+	// reqid correlates the request with its reply in the logs.
+	reqid uint64
+
+	// these fields must match in the reply. replyTok is only
+	// checked if set.
+	from     NodeID
+	ptype    byte
+	replyTok []byte
 
 	// time when the request must complete
 	deadline time.Time
@@ -246,6 +514,15 @@ type pending struct {
 	createAt time.Time
 }
 
+// matchTok reports whether a reply carries the reply token of the request.
+func (p *pending) matchTok(data interface{}) bool {
+	if p.replyTok == nil {
+		return true
+	}
+	pong, ok := data.(*pong)
+	return ok && bytes.Equal(pong.ReplyTok, p.replyTok)
+}
+
 type reply struct {
 	from  NodeID
 	ptype byte
@@ -264,6 +541,7 @@ func ListenUDP(
 	netrestrict *netutil.Netlist,
 	networkid uint64,
 	strictNodeCheck bool,
+	cfg Config,
 ) (*Table, error) {
 	addr, err := net.ResolveUDPAddr("udp", laddr)
 	if err != nil {
@@ -276,12 +554,12 @@ func ListenUDP(
 
 	tab, _, err := newUDP(
 		priv, conn, natm, nodeDBPath,
-		netrestrict, networkid, strictNodeCheck,
+		netrestrict, networkid, strictNodeCheck, cfg,
 	)
 	if err != nil {
 		return nil, err
 	}
-	log.Infof("UDP listener up self=%v", tab.self)
+	log.Infof("UDP listener up self=%v", tab.Self())
 
 	return tab, nil
 }
@@ -294,17 +572,38 @@ func newUDP(
 	netrestrict *netutil.Netlist,
 	networkid uint64,
 	strictNodeCheck bool,
+	cfg Config,
 ) (*Table, *udp, error) {
+	cfg = cfg.withDefaults()
 	udp := &udp{
 		conn:            c,
 		priv:            priv,
 		netrestrict:     netrestrict,
 		closing:         make(chan struct{}),
 		gotreply:        make(chan reply),
+		banned:          make(chan NodeID),
+		pendingReq:      make(chan chan []PendingInfo),
 		pendings:        make(chan *pending),
 		networkid:       networkid,
 		strictNodeCheck: strictNodeCheck,
+		maxPending:      int32(MaxPendingReplies),
+		subnetEnabled:   !cfg.NoSubnet,
+		bondExpiration:  cfg.BondExpiration,
+		writeTimeout:    cfg.WriteTimeout,
+		preferFresh:     cfg.PreferFreshNeighbors,
+		announceEvery:   cfg.SubnetAnnounceInterval,
+		clock:           defaultClock,
+		codec:           v4Codec{},
+		rejects:         make(map[string]uint64),
+		minVersion:      cfg.MinPeerVersion,
+		records:         SubnetRecordStore,
+		alienMismatches: cfg.AlienMismatches,
+		alienWindow:     cfg.AlienMismatchWindow,
+		mismatches:      make(map[NodeID]networkMismatch),
 	}
+	udp.versions, _ = lru.New(maxTrackedNodes)
+	udp.rtts, _ = lru.New(maxTrackedNodes)
+	udp.advertised, _ = lru.New(maxTrackedNodes)
 	realaddr := c.LocalAddr().(*net.UDPAddr)
 	if natm != nil {
 		if !realaddr.IP.IsLoopback() {
@@ -316,8 +615,8 @@ func newUDP(
 		}
 	}
 	// TODO: separate TCP port
-	udp.ourEndpoint = makeEndpoint(realaddr, uint16(realaddr.Port))
-	tab, err := newTable(udp, PubkeyID(&priv.PublicKey), realaddr, nodeDBPath, VnodeBeneficialAddress, VnodeServiceCfg, ShowToPublic, Ip)
+	udp.setOurEndpoint(makeEndpoint(realaddr, uint16(realaddr.Port)))
+	tab, err := newTable(udp, PubkeyID(&priv.PublicKey), realaddr, nodeDBPath, cfg)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -329,38 +628,210 @@ func newUDP(
 	return udp.Table, udp, nil
 }
 
+// forget drops the RTT measured for a node removed from the table.
+func (u *udp) forget(id NodeID) {
+	u.rtts.Remove(id)
+}
+
 func (u *udp) close() {
+	if err := u.FlushRecords(); err != nil {
+		log.Warn("Failed to flush subnet records", "err", err)
+	}
 	close(u.closing)
 	u.conn.Close()
 	// TODO: wait for the loops to end.
 }
 
+// FlushRecords snapshots all live subnet records and hands them to the
+// record store. It does nothing if no persistent store is configured.
+func (u *udp) FlushRecords() error {
+	if u.records == nil {
+		return nil
+	}
+	var (
+		now      = time.Now()
+		prefix   = u.GetSubnetBootnodeKey("")
+		firstErr error
+	)
+	for key, item := range u.kvstore.Items() {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		records := make(map[string]string)
+		for id, bootnode := range item.Object.(map[string]BootNodeCacheItem) {
+			if bootnode.expireTime.After(now) {
+				records[id] = bootnode.url
+			}
+		}
+		if len(records) == 0 {
+			continue
+		}
+		if err := u.records.PutRecords(strings.TrimPrefix(key, prefix), records); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// RTT returns the smoothed ping round trip time of a peer, and whether
+// any round trip was measured.
+func (u *udp) RTT(id NodeID) (time.Duration, bool) {
+	if rtt, ok := u.rtts.Get(id); ok {
+		return rtt.(time.Duration), true
+	}
+	return 0, false
+}
+
+// updateRTT folds a measured round trip time into the smoothed RTT of a
+// peer, weighting the new sample by 1/8 like TCP does.
+func (u *udp) updateRTT(id NodeID, sample time.Duration) {
+	u.rttMu.Lock()
+	defer u.rttMu.Unlock()
+	if rtt, ok := u.rtts.Get(id); ok {
+		sample = rtt.(time.Duration) + (sample-rtt.(time.Duration))/8
+	}
+	u.rtts.Add(id, sample)
+}
+
+// respTimeout returns how long to wait for a reply from a peer. It is a
+// multiple of the peer's RTT within [minRespTimeout, maxRespTimeout], or
+// the fixed respTimeout for peers without RTT history.
+func (u *udp) respTimeout(id NodeID) time.Duration {
+	rtt, ok := u.RTT(id)
+	if !ok {
+		return respTimeout
+	}
+	timeout := rttTimeoutFactor * rtt
+	if timeout < minRespTimeout {
+		timeout = minRespTimeout
+	} else if timeout > maxRespTimeout {
+		timeout = maxRespTimeout
+	}
+	return timeout
+}
+
 // The following three functions: ping, waitping, findnode
 // are the interface of the transport defined in table.go
 
 func (u *udp) getOurEndpoint() rpcEndpoint {
+	u.endpointMu.RLock()
+	defer u.endpointMu.RUnlock()
 	return u.ourEndpoint
 }
 
+// setOurEndpoint updates the endpoint advertised in outgoing packets, e.g.
+// after the external address changed.
+func (u *udp) setOurEndpoint(ep rpcEndpoint) {
+	u.endpointMu.Lock()
+	defer u.endpointMu.Unlock()
+	u.ourEndpoint = ep
+}
+
 // ping sends a ping message to the given node and waits for a reply.
 func (u *udp) ping(toid NodeID, toaddr *net.UDPAddr) error {
-	// TODO: maybe check for ReplyTo field in callback to measure RTT
-	errc := u.addPending(toid, PONGPACKET, func(interface{}) bool { return true })
+	reqid := u.nextReqID()
 	msg, _ := rlp.EncodeToBytes(fmt.Sprintf("%d\t", u.networkid))
 	Rest := []rlp.RawValue{msg}
-	u.send(toid, toaddr, PINGPACKET, &ping{
+	ourEndpoint := u.getOurEndpoint()
+	req := &ping{
 		Version:    Version,
-		From:       u.ourEndpoint,
+		From:       ourEndpoint,
 		To:         makeEndpoint(toaddr, 0), // TODO: maybe use known TCP port from DB
-		Expiration: uint64(time.Now().Add(expiration).Unix()),
+		Expiration: uint64(u.clock.Now().Add(expiration).Unix()),
 		Rest:       Rest,
-	})
-	log.Debugf(">> PING our point: %v, remote point: %v", u.ourEndpoint, toaddr)
+	}
+	packet, hash, err := u.encodeReq(PINGPACKET, req)
+	if err != nil {
+		return err
+	}
+	// The pong has to echo the hash of the ping packet.
+	errc := u.addPendingTok(reqid, toid, PONGPACKET, hash, func(interface{}) bool { return true })
+	u.writeReq(reqid, toid, toaddr, req, packet)
+	log.Debugf(">> PING our point: %v, remote point: %v", ourEndpoint, toaddr)
 	return <-errc
 }
 
+// PingBootnode pings the node with the given enode URL and returns the round
+// trip time of the ping. It fails if the URL is invalid, the node doesn't
+// reply in time or the node replies with a different network id.
+func (u *udp) PingBootnode(ctx context.Context, url string) (time.Duration, error) {
+	n, err := ParseNode(url)
+	if err != nil {
+		return 0, err
+	}
+	rtt, remote, err := u.pingNetwork(ctx, n)
+	if err != nil {
+		return 0, err
+	}
+	if remote != 0 && remote != u.networkid {
+		return rtt, fmt.Errorf("%w: remote %d, local %d", errNetworkMismatch, remote, u.networkid)
+	}
+	return rtt, nil
+}
+
+// ParseSubnetNode parses a node URL like ParseNode, rejecting nodes which
+// don't belong to the network with the given id. Nodes discovery already
+// classified as alien to our network are rejected right away, all others
+// are pinged and rejected if they reply with another network id.
+func (u *udp) ParseSubnetNode(ctx context.Context, rawurl string, expectedNetworkID uint64) (*Node, error) {
+	n, err := ParseNode(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	if expectedNetworkID == u.networkid && u.Table.GetNodeType(n.ID) == AlienNode && !u.IsTrusted(n.ID) {
+		return nil, fmt.Errorf("%w: classified alien by discovery", errAlienNode)
+	}
+	_, remote, err := u.pingNetwork(ctx, n)
+	if err != nil {
+		return nil, err
+	}
+	if remote != 0 && remote != expectedNetworkID {
+		return nil, fmt.Errorf("%w: network %d, want %d", errAlienNode, remote, expectedNetworkID)
+	}
+	return n, nil
+}
+
+// pingNetwork pings the node and returns the round trip time of the ping and
+// the network id sent in the pong, 0 if the node didn't send one.
+func (u *udp) pingNetwork(ctx context.Context, n *Node) (time.Duration, uint64, error) {
+	if n.ID == u.Self().ID {
+		return 0, 0, errors.New("is self")
+	}
+	var remote uint64
+	reqid := u.nextReqID()
+	msg, _ := rlp.EncodeToBytes(fmt.Sprintf("%d\t", u.networkid))
+	start := u.clock.Now()
+	req := &ping{
+		Version:    Version,
+		From:       u.getOurEndpoint(),
+		To:         makeEndpoint(n.addr(), n.TCP),
+		Expiration: uint64(start.Add(expiration).Unix()),
+		Rest:       []rlp.RawValue{msg},
+	}
+	packet, hash, err := u.encodeReq(PINGPACKET, req)
+	if err != nil {
+		return 0, 0, err
+	}
+	errc := u.addPendingTok(reqid, n.ID, PONGPACKET, hash, func(p interface{}) bool {
+		remote = restNetworkID(p.(*pong).Rest)
+		return true
+	})
+	if err = u.writeReq(reqid, n.ID, n.addr(), req, packet); err != nil {
+		return 0, 0, err
+	}
+	select {
+	case err = <-errc:
+	case <-ctx.Done():
+		return 0, 0, ctx.Err()
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+	return u.clock.Now().Sub(start), remote, nil
+}
+
 func (u *udp) waitping(from NodeID) error {
-	return <-u.addPending(from, PINGPACKET, func(interface{}) bool { return true })
+	return <-u.addPending(u.nextReqID(), from, PINGPACKET, func(interface{}) bool { return true })
 }
 
 // findnode sends a findnode request to the given node and waits until
@@ -368,16 +839,22 @@ func (u *udp) waitping(from NodeID) error {
 func (u *udp) findnode(toid NodeID, toaddr *net.UDPAddr, target NodeID, strictNodeCheck bool) ([]*Node, error) {
 	nodes := make([]*Node, 0, bucketSize)
 	nreceived := 0
+	reqid := u.nextReqID()
 	errc := u.addPending(
+		reqid,
 		toid,
 		NEIGHBORSPACKET,
 		func(r interface{}) bool {
 			// this is the callback function which is called
 			// upon receiving neighbors reply
 			reply := r.(*neighbors)
+			exts := reply.nodeExts()
 			discarded := 0
 			for _, rn := range reply.Nodes {
 				nreceived++
+				if ext, ok := exts[rn.ID]; ok {
+					rn.applyExt(ext)
+				}
 				n, err := u.nodeFromRPC(toaddr, rn)
 				if err != nil {
 					log.Trace("Invalid neighbor node received", "ip", rn.IP, "addr", toaddr, "err", err)
@@ -411,9 +888,9 @@ func (u *udp) findnode(toid NodeID, toaddr *net.UDPAddr, target NodeID, strictNo
 	Rest := []rlp.RawValue{msg}
 
 	// send msg
-	u.send(toid, toaddr, FINDNODEPACKET, &findnode{
+	u.sendReq(reqid, toid, toaddr, FINDNODEPACKET, &findnode{
 		Target:     target,
-		Expiration: uint64(time.Now().Add(expiration).Unix()),
+		Expiration: uint64(u.clock.Now().Add(expiration).Unix()),
 		Rest:       Rest,
 	})
 	err := <-errc
@@ -427,7 +904,9 @@ func (u *udp) findnode(toid NodeID, toaddr *net.UDPAddr, target NodeID, strictNo
 func (u *udp) findvalue(key NodeID, toNodes []*Node) {
 	for _, node := range toNodes {
 		go func(_key NodeID, _node *Node) {
+			reqid := u.nextReqID()
 			errc := u.addPending(
+				reqid,
 				_node.ID,
 				FINDVALUEREPLYPACKET,
 				func(r interface{}) bool {
@@ -446,9 +925,9 @@ func (u *udp) findvalue(key NodeID, toNodes []*Node) {
 					return true
 				},
 			)
-			u.send(_node.ID, _node.addr(), FINDVALUEPACKET, &findvalue{
+			u.sendReq(reqid, _node.ID, _node.addr(), FINDVALUEPACKET, &findvalue{
 				Key:        _key,
-				Expiration: uint64(time.Now().Add(expiration).Unix()),
+				Expiration: uint64(u.clock.Now().Add(expiration).Unix()),
 			})
 			err := <-errc
 			log.Debugf("subnet udp send findvalue to node %v, key:%s, addpending err: %v", _node.addr(), common.Bytes2Hex(_key[:]), err)
@@ -469,22 +948,71 @@ func (u *udp) store(key NodeID, value []byte, toNodes []*Node) {
 				&store{
 					Key:        _key,
 					Value:      _value,
-					From:       u.ourEndpoint,
-					Expiration: uint64(time.Now().Add(expiration).Unix()),
+					From:       u.getOurEndpoint(),
+					Expiration: uint64(u.clock.Now().Add(expiration).Unix()),
 				})
 		}(key, value, node)
 	}
 }
 
+// announceSubnet starts storing the local node under the subnet key every
+// announce interval, so its record doesn't expire at the other nodes. The
+// nodes to store at are rediscovered by calling closest in every cycle.
+// Announcing stops when the transport is closed.
+func (u *udp) announceSubnet(subnetID NodeID, closest func(NodeID) []*Node) {
+	// The timer is created before returning, so it can't miss clock
+	// changes right after the call.
+	timer := u.clock.NewTimer(u.announceEvery)
+	go func() {
+		defer timer.Stop()
+		for {
+			select {
+			case <-timer.C():
+				timer.Reset(u.announceEvery)
+				nodes := closest(subnetID)
+				log.Debugf("subnet announce membership to %d nodes", len(nodes))
+				u.store(subnetID, []byte{}, nodes)
+			case <-u.closing:
+				return
+			}
+		}
+	}()
+}
+
+// nextReqID returns a fresh id used to correlate a request with its reply.
+func (u *udp) nextReqID() uint64 {
+	return atomic.AddUint64(&u.reqSeq, 1)
+}
+
 // pending adds a reply callback to the pending reply queue.
 // see the documentation of type pending for a detailed explanation.
-func (u *udp) addPending(id NodeID, ptype byte, callback func(interface{}) bool) <-chan error {
+func (u *udp) addPending(reqid uint64, id NodeID, ptype byte, callback func(interface{}) bool) <-chan error {
+	return u.addPendingTok(reqid, id, ptype, nil, callback)
+}
+
+// addPendingTok is addPending for a request whose reply has to carry the
+// given reply token, as pongs echo the hash of their ping.
+func (u *udp) addPendingTok(reqid uint64, id NodeID, ptype byte, replyTok []byte, callback func(interface{}) bool) <-chan error {
 	ch := make(chan error, 1)
-	p := &pending{from: id, ptype: ptype, callback: callback, errc: ch}
+	if n := atomic.AddInt32(&u.npending, 1); u.maxPending > 0 && n > u.maxPending {
+		atomic.AddInt32(&u.npending, -1)
+		log.Debug("rpc pending rejected, queue full", "reqid", reqid, "ptype", int(ptype), "pending", n-1)
+		ch <- errTooManyPending
+		return ch
+	}
+	// Checked after counting the request, so RotateKey either sees it
+	// pending or the request sees the rotation.
+	if atomic.LoadInt32(&u.rotating) != 0 {
+		atomic.AddInt32(&u.npending, -1)
+		ch <- errRotatingKey
+		return ch
+	}
+	p := &pending{reqid: reqid, from: id, ptype: ptype, replyTok: replyTok, callback: callback, errc: ch}
 	select {
 	case u.pendings <- p: // loop() will call callback on the reply
 
 	case <-u.closing:
+		atomic.AddInt32(&u.npending, -1)
 		ch <- errClosed
 	}
 	return ch
@@ -507,12 +1035,12 @@ func (u *udp) handleReply(from NodeID, ptype byte, req packet) bool {
 func (u *udp) loop() {
 	var (
 		plist        = list.New()
-		timeout      = time.NewTimer(0)
+		timeout      = u.clock.NewTimer(0)
 		nextTimeout  *pending // head of plist when timeout was last reset
 		contTimeouts = 0      // number of continuous timeouts to do NTP checks
 		ntpWarnTime  = time.Unix(0, 0)
 	)
-	<-timeout.C // ignore first timeout
+	<-timeout.C() // ignore first timeout
 	defer timeout.Stop()
 
 	resetTimeout := func() {
@@ -520,10 +1048,10 @@ func (u *udp) loop() {
 			return
 		}
 		// Start the timer so it fires when the next pending reply has expired.
-		now := time.Now()
+		now := u.clock.Now()
 		for el := plist.Front(); el != nil; el = el.Next() {
 			nextTimeout = el.Value.(*pending)
-			if dist := nextTimeout.deadline.Sub(now); dist < 2*respTimeout {
+			if dist := nextTimeout.deadline.Sub(now); dist < 2*maxRespTimeout {
 				timeout.Reset(dist)
 				return
 			}
@@ -532,10 +1060,11 @@ func (u *udp) loop() {
 			// backwards after the deadline was assigned.
 			nextTimeout.errc <- errClockWarp
 			plist.Remove(el)
-			log.Debugf(
-				"rpc pending removed deadline too far after %d ms [%d]",
-				now.Sub(nextTimeout.createAt)/time.Millisecond,
-				int(nextTimeout.ptype),
+			atomic.AddInt32(&u.npending, -1)
+			log.Debug(
+				"rpc pending removed, deadline too far",
+				"reqid", nextTimeout.reqid, "ptype", int(nextTimeout.ptype),
+				"elapsed", now.Sub(nextTimeout.createAt),
 			)
 		}
 		nextTimeout = nil
@@ -553,28 +1082,44 @@ func (u *udp) loop() {
 			return
 
 		case p := <-u.pendings:
-			now := time.Now()
-			p.deadline = now.Add(respTimeout)
+			now := u.clock.Now()
+			p.deadline = now.Add(u.respTimeout(p.from))
 			p.createAt = now
-			plist.PushBack(p)
+			// Keep the list ordered by deadline, the timer is only
+			// armed for the head.
+			el := plist.Back()
+			for el != nil && el.Value.(*pending).deadline.After(p.deadline) {
+				el = el.Prev()
+			}
+			if el == nil {
+				plist.PushFront(p)
+			} else {
+				plist.InsertAfter(p, el)
+			}
+			log.Debug("rpc pending added", "reqid", p.reqid, "ptype", int(p.ptype), "id", p.from.String()[:16])
 
 		case r := <-u.gotreply:
 			var matched bool
 			for el := plist.Front(); el != nil; el = el.Next() {
 				p := el.Value.(*pending)
-				if p.from == r.from && p.ptype == r.ptype {
+				if p.from == r.from && p.ptype == r.ptype && p.matchTok(r.data) {
 					matched = true
 					// Remove the matcher if its callback indicates
 					// that all replies have been received. This is
 					// required for packet types that expect multiple
 					// reply packets.
 					if p.callback(r.data) {
+						u.outcomes.add(false)
 						p.errc <- nil
 						plist.Remove(el)
-						log.Debugf(
-							"rpc pending got replay after %d ms [%d]",
-							time.Now().Sub(p.createAt)/time.Millisecond,
-							int(p.ptype),
+						atomic.AddInt32(&u.npending, -1)
+						if p.ptype == PONGPACKET {
+							u.updateRTT(p.from, u.clock.Now().Sub(p.createAt))
+						}
+						log.Debug(
+							"rpc pending got reply",
+							"reqid", p.reqid, "ptype", int(p.ptype),
+							"elapsed", u.clock.Now().Sub(p.createAt),
 						)
 					}
 					// Reset the continuous timeout counter (time drift detection)
@@ -583,7 +1128,28 @@ func (u *udp) loop() {
 			}
 			r.matched <- matched
 
-		case now := <-timeout.C:
+		case ch := <-u.pendingReq:
+			now := u.clock.Now()
+			infos := make([]PendingInfo, 0, plist.Len())
+			for el := plist.Front(); el != nil; el = el.Next() {
+				p := el.Value.(*pending)
+				infos = append(infos, PendingInfo{ReqID: p.reqid, From: p.from, PType: p.ptype, Remaining: p.deadline.Sub(now)})
+			}
+			ch <- infos
+
+		case id := <-u.banned:
+			for el := plist.Front(); el != nil; {
+				next := el.Next()
+				if p := el.Value.(*pending); p.from == id {
+					p.errc <- errBanned
+					plist.Remove(el)
+					atomic.AddInt32(&u.npending, -1)
+					log.Debug("rpc pending removed, node banned", "reqid", p.reqid, "ptype", int(p.ptype))
+				}
+				el = next
+			}
+
+		case now := <-timeout.C():
 			nextTimeout = nil
 
 			// Notify and remove callbacks whose deadline is in the past.
@@ -591,12 +1157,14 @@ func (u *udp) loop() {
 				p := el.Value.(*pending)
 				if now.After(p.deadline) || now.Equal(p.deadline) {
 					log.Debugf("rpc behind in %d ms", now.Sub(p.deadline)/time.Millisecond)
+					u.outcomes.add(true)
 					p.errc <- errTimeout
 					plist.Remove(el)
-					log.Debugf(
-						"rpc pending timeout after %d ms [%d]",
-						now.Sub(p.createAt)/time.Millisecond,
-						int(p.ptype),
+					atomic.AddInt32(&u.npending, -1)
+					log.Debug(
+						"rpc pending timeout",
+						"reqid", p.reqid, "ptype", int(p.ptype),
+						"elapsed", now.Sub(p.createAt),
 					)
 					contTimeouts++
 				}
@@ -617,6 +1185,13 @@ const (
 	macSize  = 256 / 8
 	sigSize  = 520 / 8
 	headSize = macSize + sigSize // space of packet frame data
+
+	// Discovery packets are defined to be no larger than 1280 bytes.
+	maxPacketSize = 1280
+
+	// maxServiceCfgSize is the longest service config advertised in
+	// neighbors packets.
+	maxServiceCfgSize = 32
 )
 
 var (
@@ -626,6 +1201,10 @@ var (
 	// stay below the 1280 byte limit. We compute the maximum number
 	// of entries by stuffing a packet until it grows too large.
 	maxNeighbors int
+
+	// maxNeighborsExt is the number of entries fitting into a neighbors
+	// packet if every node carries its optional attributes.
+	maxNeighborsExt int
 )
 
 func init() {
@@ -649,19 +1228,108 @@ func init() {
 			break
 		}
 	}
+
+	p = neighbors{Expiration: ^uint64(0)}
+	maxSizeExt := rpcNodeExt{
+		BeneficialAddress: make([]byte, common.AddressLength),
+		ServiceCfg:        strings.Repeat("0", maxServiceCfgSize),
+		ShowToPublic:      true,
+	}
+	var exts []rpcNodeExt
+	for n := 0; ; n++ {
+		p.Nodes = append(p.Nodes, maxSizeNode)
+		exts = append(exts, maxSizeExt)
+		if err := p.setNodeExts(exts); err != nil {
+			panic("cannot encode: " + err.Error())
+		}
+		size, _, err := rlp.EncodeToReader(p)
+		if err != nil {
+			panic("cannot encode: " + err.Error())
+		}
+		if headSize+size+1 >= 1280 {
+			maxNeighborsExt = n
+			break
+		}
+	}
 }
 
+// send writes a packet that does not expect a reply, e.g. a reply itself.
 func (u *udp) send(toID NodeID, toaddr *net.UDPAddr, ptype byte, req packet) error {
-	packet, err := encodePacket(u.priv, ptype, req)
+	return u.sendReq(0, toID, toaddr, ptype, req)
+}
+
+// sendReq writes a packet tagged in the logs with the id of the request
+// it belongs to.
+func (u *udp) sendReq(reqid uint64, toID NodeID, toaddr *net.UDPAddr, ptype byte, req packet) error {
+	packet, _, err := u.encodeReq(ptype, req)
 	if err != nil {
-		log.Debugf("error in encode udp packet: %s, %v", req.name(), err)
 		return err
 	}
-	_, err = u.conn.WriteToUDP(packet, toaddr)
-	log.Debug(">> "+req.name(), "addr", toaddr, "err", err, "id", toID.String()[:16])
+	return u.writeReq(reqid, toID, toaddr, req, packet)
+}
+
+// encodeReq encodes and signs a packet with the current key. It returns the
+// packet and its hash, which replies echo.
+func (u *udp) encodeReq(ptype byte, req packet) ([]byte, []byte, error) {
+	u.keyMu.RLock()
+	packet, hash, err := u.codec.encode(u.priv, ptype, req)
+	u.keyMu.RUnlock()
+	if err != nil {
+		log.Debugf("error in encode udp packet: %s, %v", req.name(), err)
+	}
+	return packet, hash, err
+}
+
+// writeReq writes an encoded packet, see sendReq.
+func (u *udp) writeReq(reqid uint64, toID NodeID, toaddr *net.UDPAddr, req packet, packet []byte) error {
+	_, err := u.write(packet, toaddr)
+	log.Debug(">> "+req.name(), "addr", toaddr, "err", err, "id", toID.String()[:16], "reqid", reqid)
 	return err
 }
 
+// write writes a packet to the socket, giving up after the write timeout if
+// the conn supports deadlines.
+func (u *udp) write(packet []byte, toaddr *net.UDPAddr) (int, error) {
+	if dc, ok := u.conn.(deadlineConn); ok && u.writeTimeout > 0 {
+		// Deadlines of sockets use the wall clock, not u.clock.
+		if err := dc.SetWriteDeadline(time.Now().Add(u.writeTimeout)); err != nil {
+			return 0, err
+		}
+	}
+	n, err := u.conn.WriteToUDP(packet, toaddr)
+	if nerr, ok := err.(net.Error); ok && nerr.Timeout() {
+		return n, errWriteTimeout
+	}
+	return n, err
+}
+
+// packetCodec implements the wire crypto of discovery packets, i.e. how
+// packets are hashed and signed. It is replaceable per udp instance so a
+// future discovery version can use a different scheme.
+type packetCodec interface {
+	// encode signs a packet with priv. It returns the packet and its hash.
+	encode(priv *ecdsa.PrivateKey, ptype byte, req interface{}) ([]byte, []byte, error)
+	// decode verifies a packet and returns it with the sender id and the
+	// packet hash.
+	decode(buf []byte) (packet, NodeID, []byte, error)
+}
+
+// v4Codec is the default packet codec. Packets are hashed with Keccak256 and
+// signed with secp256k1.
+type v4Codec struct{}
+
+func (v4Codec) encode(priv *ecdsa.PrivateKey, ptype byte, req interface{}) ([]byte, []byte, error) {
+	packet, err := encodePacket(priv, ptype, req)
+	if err != nil {
+		return nil, nil, err
+	}
+	return packet, packet[:macSize], nil
+}
+
+func (v4Codec) decode(buf []byte) (packet, NodeID, []byte, error) {
+	return decodePacket(buf)
+}
+
 func encodePacket(priv *ecdsa.PrivateKey, ptype byte, req interface{}) ([]byte, error) {
 	b := new(bytes.Buffer)
 	b.Write(headSpace)
@@ -687,10 +1355,9 @@ func encodePacket(priv *ecdsa.PrivateKey, ptype byte, req interface{}) ([]byte,
 // readLoop runs in its own goroutine. it handles incoming UDP packets.
 func (u *udp) readLoop() {
 	defer u.conn.Close()
-	// Discovery packets are defined to be no larger than 1280 bytes.
-	// Packets larger than this size will be cut at the end and treated
-	// as invalid because their hash won't match.
-	buf := make([]byte, 1280)
+	// Packets larger than maxPacketSize will be cut at the end and
+	// treated as invalid because their hash won't match.
+	buf := make([]byte, maxPacketSize)
 	for {
 		nbytes, from, err := u.conn.ReadFromUDP(buf)
 		if netutil.IsTemporaryError(err) {
@@ -707,10 +1374,10 @@ func (u *udp) readLoop() {
 }
 
 func (u *udp) handlePacket(from *net.UDPAddr, buf []byte) error {
-	packet, fromID, hash, err := decodePacket(buf)
+	packet, fromID, hash, err := u.codec.decode(buf)
 
-	// ignore any packet sent from alien node
-	if u.Table.GetNodeType(fromID) == AlienNode {
+	// ignore any packet sent from alien node, unless it is trusted
+	if u.Table.GetNodeType(fromID) == AlienNode && !u.IsTrusted(fromID) {
 		return errors.New("Node seen before as alien node")
 	}
 
@@ -722,9 +1389,39 @@ func (u *udp) handlePacket(from *net.UDPAddr, buf []byte) error {
 	// call different handle func base on the type of the packet
 	err = packet.handle(u, from, fromID, hash)
 	log.Trace("<< "+packet.name(), "addr", from, "err", err, "id", fromID.String()[:16])
+	u.scorePacketErr(fromID, err)
 	return err
 }
 
+// scorePacketErr lowers the score of a node that sent a misbehaving packet.
+// Packets with a bad hash are rejected before the sender is recovered, so
+// they carry no node id and can't be attributed.
+func (u *udp) scorePacketErr(fromID NodeID, err error) {
+	if fromID == (NodeID{}) {
+		return
+	}
+	switch err {
+	case errUnsolicitedReply, errExpired, errTooManyNeighbors:
+		u.adjustScore(fromID, scorePenalty)
+	}
+}
+
+// checkPacketSize inspects the RLP header of the packet content and rejects
+// contents declaring more data than fits into a packet, before the decoder
+// allocates anything for them. The reader is wrapped so that the stream
+// reports the declared size instead of bounding it by the input length.
+func checkPacketSize(content []byte) error {
+	s := rlp.NewStream(struct{ io.Reader }{bytes.NewReader(content)}, 0)
+	_, size, err := s.Kind()
+	if err != nil {
+		return err
+	}
+	if size > maxPacketSize-headSize-1 {
+		return errPacketTooLarge
+	}
+	return nil
+}
+
 func decodePacket(buf []byte) (packet, NodeID, []byte, error) {
 
 	if len(buf) < headSize+1 {
@@ -735,6 +1432,9 @@ func decodePacket(buf []byte) (packet, NodeID, []byte, error) {
 	if !bytes.Equal(hash, shouldhash) {
 		return nil, NodeID{}, nil, errBadHash
 	}
+	if err := checkPacketSize(sigdata[1:]); err != nil {
+		return nil, NodeID{}, hash, err
+	}
 	fromID, err := recoverNodeID(crypto.Keccak256(buf[headSize:]), sig)
 	if err != nil {
 		return nil, NodeID{}, hash, err
@@ -765,26 +1465,29 @@ func decodePacket(buf []byte) (packet, NodeID, []byte, error) {
 	return req, fromID, hash, err
 }
 
+// restNetworkID extracts the network id sent in the rest of a ping or pong,
+// it returns 0 if the remote node didn't send one.
+func restNetworkID(Rest []rlp.RawValue) uint64 {
+	if len(Rest) == 0 || len(Rest[0]) == 0 {
+		return 0
+	}
+	restList := strings.Split(string(Rest[0][1:]), "\t")
+	if len(restList) >= 1 {
+		if networkID, err := strconv.Atoi(restList[0]); err == nil {
+			return uint64(networkID)
+		}
+	}
+	return 0
+}
+
 // processRestInPingPong process the rest field in the ping/pong request
 // and return the node type of the remote node
 func processRestInPingPong(
 	Rest []rlp.RawValue, u *udp, reqName string,
 	from *net.UDPAddr, fromID NodeID,
 ) int {
-	var rest string
-	network_id := uint64(0)
-
-	// extract network id from rest
+	network_id := restNetworkID(Rest)
 	if len(Rest) > 0 {
-		rest = string(Rest[0][1:])
-		restList := strings.Split(string(rest), "\t")
-		if len(restList) >= 1 {
-			_network_id, err := strconv.Atoi(restList[0])
-			if err == nil {
-				network_id = uint64(_network_id)
-			}
-		}
-
 		log.Debug(
 			"<< "+reqName, "addr", from,
 			"id", fromID.String()[:16], "network_id", network_id,
@@ -796,12 +1499,14 @@ func processRestInPingPong(
 	// if remote network id is set and equals to ours, it's a brother node
 	if network_id == u.networkid {
 		remoteNodeType = BrotherNode
+		u.resetMismatches(fromID)
 		// theoretically, we still need to check genesis
 		// but usually if network ids are the same, so are genesis
 		u.Table.SetNodeType(fromID, BrotherNode)
 	} else {
-		// if remote network id is set and it's different
-		if network_id != 0 {
+		// if remote network id is set and it's different, the node is
+		// alien once it keeps sending a different id
+		if network_id != 0 && u.recordMismatch(fromID) {
 			remoteNodeType = AlienNode
 			u.Table.SetNodeType(fromID, AlienNode)
 		}
@@ -810,10 +1515,59 @@ func processRestInPingPong(
 	return remoteNodeType
 }
 
+// recordMismatch counts a network id mismatch of a node and reports whether
+// it reached alienMismatches within alienWindow.
+func (u *udp) recordMismatch(id NodeID) bool {
+	u.mismatchMu.Lock()
+	defer u.mismatchMu.Unlock()
+
+	now := u.clock.Now()
+	u.expireMismatches(now)
+	m := u.mismatches[id]
+	if m.count == 0 || now.Sub(m.first) > u.alienWindow {
+		m = networkMismatch{first: now}
+	}
+	m.count++
+	if m.count >= u.alienMismatches {
+		delete(u.mismatches, id)
+		return true
+	}
+	u.mismatches[id] = m
+	log.Debug("Network id mismatch", "id", id.String()[:16], "count", m.count)
+	return false
+}
+
+// expireMismatches drops the mismatch runs that started more than
+// alienWindow ago, so nodes that stop sending don't stay tracked. The map is
+// swept at most once per alienWindow. The caller must hold mismatchMu.
+func (u *udp) expireMismatches(now time.Time) {
+	if now.Sub(u.mismatchSweep) < u.alienWindow {
+		return
+	}
+	u.mismatchSweep = now
+	for id, m := range u.mismatches {
+		if now.Sub(m.first) > u.alienWindow {
+			delete(u.mismatches, id)
+		}
+	}
+}
+
+// resetMismatches forgets the network id mismatches of a node.
+func (u *udp) resetMismatches(id NodeID) {
+	u.mismatchMu.Lock()
+	delete(u.mismatches, id)
+	u.mismatchMu.Unlock()
+}
+
 func (req *ping) handle(u *udp, from *net.UDPAddr, fromID NodeID, mac []byte) error {
-	if expired(req.Expiration) {
+	if u.expired(req.Expiration) {
 		return errExpired
 	}
+	u.versions.Add(fromID, req.Version)
+	if req.Version < u.minVersion {
+		log.Debug("Refusing ping from old peer", "id", fromID.String()[:16], "version", req.Version, "min", u.minVersion)
+		return errOldVersion
+	}
 
 	// newer client should send 'network id' in 'rest' in ping msg
 	remoteNodeType := processRestInPingPong(req.Rest, u, req.name(), from, fromID)
@@ -831,7 +1585,7 @@ func (req *ping) handle(u *udp, from *net.UDPAddr, fromID NodeID, mac []byte) er
 		u.send(fromID, from, PONGPACKET, &pong{
 			To:         makeEndpoint(from, req.From.TCP),
 			ReplyTok:   mac,
-			Expiration: uint64(time.Now().Add(expiration).Unix()),
+			Expiration: uint64(u.clock.Now().Add(expiration).Unix()),
 			Rest:       PongRest,
 		})
 		if !u.handleReply(fromID, PINGPACKET, req) {
@@ -846,7 +1600,7 @@ func (req *ping) handle(u *udp, from *net.UDPAddr, fromID NodeID, mac []byte) er
 func (req *ping) name() string { return "PING/v4" }
 
 func (req *pong) handle(u *udp, from *net.UDPAddr, fromID NodeID, mac []byte) error {
-	if expired(req.Expiration) {
+	if u.expired(req.Expiration) {
 		return errExpired
 	}
 
@@ -861,21 +1615,184 @@ func (req *pong) handle(u *udp, from *net.UDPAddr, fromID NodeID, mac []byte) er
 
 func (req *pong) name() string { return "PONG/v4" }
 
+// RotateKey replaces the discovery key, and with it the local NodeID.
+// New requests are refused while the requests waiting for a reply
+// drain, then packets are signed with the new key and all nodes in
+// the table are pinged to announce the new NodeID.
+//
+// Only the discovery identity changes, the caller is responsible for
+// using the same key for the RLPx transport.
+func (u *udp) RotateKey(newKey *ecdsa.PrivateKey) error {
+	if newKey == nil {
+		return errors.New("nil key")
+	}
+	if !atomic.CompareAndSwapInt32(&u.rotating, 0, 1) {
+		return errRotatingKey
+	}
+	defer atomic.StoreInt32(&u.rotating, 0)
+
+	deadline := time.Now().Add(drainTimeout)
+	for atomic.LoadInt32(&u.npending) > 0 {
+		if time.Now().After(deadline) {
+			return errDrainTimeout
+		}
+		select {
+		case <-u.closing:
+			return errClosed
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+
+	u.keyMu.Lock()
+	u.priv = newKey
+	u.keyMu.Unlock()
+
+	// Buckets are organized by distance to our own id, so all nodes
+	// have to be sorted in again.
+	newID := PubkeyID(&newKey.PublicKey)
+	u.mutex.Lock()
+	var nodes []*Node
+	for i, b := range u.buckets {
+		nodes = append(nodes, b.entries...)
+		u.buckets[i] = new(bucket)
+	}
+	old := u.Self()
+	u.self.Store(NewNode(newID, old.IP, old.UDP, old.TCP, old.beneficialAddress, old.serviceCfg, old.showToPublic, old.ip))
+	u.db.setSelf(newID)
+	u.nodeBucket = make(map[NodeID]int)
+	u.totalNodes = 0
+	u.stuff(nodes)
+	u.mutex.Unlock()
+	log.Info("Rotated discovery key", "old", old.ID.String()[:16], "new", newID.String()[:16], "nodes", len(nodes))
+
+	// Re-announce ourselves, the remote nodes bond back with the new id.
+	for _, n := range nodes {
+		go u.Table.ping(n.ID, n.addr())
+	}
+	return nil
+}
+
+// LookupRanked performs a network search for nodes close to the given
+// target and returns them ordered by node type preference: brothers
+// first, then uncles, then anyone else. Nodes of the same type stay
+// ordered by distance to the target.
+func (u *udp) LookupRanked(target NodeID) []*Node {
+	nodes := u.lookup(target, true, 0, false, true)
+	ranked := make([]*Node, len(nodes))
+	copy(ranked, nodes)
+	u.rankByNodeType(ranked)
+	return ranked
+}
+
+// BrotherEnodes returns the enode URLs of up to max known brother nodes,
+// suitable for the --bootnodes flag of a new node. Nodes without a valid
+// complete record are skipped.
+func (u *udp) BrotherEnodes(max int) []string {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	var urls []string
+	for _, b := range u.buckets {
+		for _, n := range b.entries {
+			if len(urls) >= max {
+				return urls
+			}
+			if u.GetNodeType(n.ID) != BrotherNode || n.validateComplete() != nil {
+				continue
+			}
+			urls = append(urls, n.String())
+		}
+	}
+	return urls
+}
+
+// checkBond verifies that a bond with the sender of a privileged packet
+// exists and is recent enough. Expired bonds are re-verified by pinging
+// the sender's current address in the background, the packet itself is
+// rejected.
+func (u *udp) checkBond(fromID NodeID, from *net.UDPAddr) error {
+	node := u.db.node(fromID)
+	if node == nil {
+		return errUnknownNode
+	}
+	if u.bondExpiration > 0 && u.clock.Now().Sub(u.db.lastPong(fromID)) > u.bondExpiration {
+		go u.rebond(fromID, from, node.TCP)
+		return errBondExpired
+	}
+	return nil
+}
+
+// rebond pings a node whose bond expired and, on success, stores the
+// node with the address it was reached at.
+func (u *udp) rebond(id NodeID, addr *net.UDPAddr, tcpPort uint16) {
+	u.bondmu.Lock()
+	if u.bonding[id] != nil {
+		// A bonding process is already running.
+		u.bondmu.Unlock()
+		return
+	}
+	w := &bondproc{done: make(chan struct{})}
+	u.bonding[id] = w
+	u.bondmu.Unlock()
+
+	log.Trace("Re-bonding expired node", "id", id.String()[:16], "addr", addr)
+	u.pingpong(w, true, id, addr, tcpPort)
+
+	u.bondmu.Lock()
+	delete(u.bonding, id)
+	u.bondmu.Unlock()
+	if w.err == nil {
+		u.add(w.n)
+	}
+}
+
+// CancelBond aborts an in-progress bond with the given node. A bond
+// that has not sent its ping yet fails with errBondCancelled, a bond
+// whose ping is already in flight is not affected.
+func (u *udp) CancelBond(id NodeID) {
+	u.bondmu.Lock()
+	defer u.bondmu.Unlock()
+	if w := u.bonding[id]; w != nil {
+		w.cancelled = true
+	}
+}
+
+// Ban evicts a node known to be malicious right away instead of waiting for
+// liveness checks to fail. The node is classified alien, even if it was
+// trusted, so its packets are dropped from now on. It is also removed from
+// the routing table, its bonding is cancelled and requests waiting for its
+// replies fail with errBanned. The alien classification is persisted in
+// the node database.
+func (u *udp) Ban(id NodeID) {
+	u.Table.trustedMu.Lock()
+	delete(u.Table.trusted, id)
+	u.Table.trustedMu.Unlock()
+	u.Table.markAlien(id)
+	u.Table.DeleteWithNodeId(id)
+	u.CancelBond(id)
+
+	select {
+	case u.banned <- id:
+	case <-u.closing:
+	}
+	log.Info("Banned discovery node", "id", id.String()[:16])
+}
+
 // handle findnode request and reply with neighbors
 func (req *findnode) handle(u *udp, from *net.UDPAddr, fromID NodeID, mac []byte) error {
 	t1 := time.Now()
-	if expired(req.Expiration) {
+	if u.expired(req.Expiration) {
 		return errExpired
 	}
-	if u.db.node(fromID) == nil {
-		// No bond exists, we don't process the packet. This prevents
-		// an attack vector where the discovery protocol could be used
-		// to amplify traffic in a DDOS attack. A malicious actor
-		// would send a findnode request with the IP address and UDP
-		// port of the target as the source address. The recipient of
-		// the findnode packet would then send a neighbors packet
-		// (which is a much bigger packet than findnode) to the victim.
-		return errUnknownNode
+	// No valid bond exists, we don't process the packet. This prevents
+	// an attack vector where the discovery protocol could be used
+	// to amplify traffic in a DDOS attack. A malicious actor
+	// would send a findnode request with the IP address and UDP
+	// port of the target as the source address. The recipient of
+	// the findnode packet would then send a neighbors packet
+	// (which is a much bigger packet than findnode) to the victim.
+	if err := u.checkBond(fromID, from); err != nil {
+		return err
 	}
 
 	// by default, search all uncle and brother nodes, but if it is
@@ -900,43 +1817,74 @@ func (req *findnode) handle(u *udp, from *net.UDPAddr, fromID NodeID, mac []byte
 
 	target := crypto.Keccak256Hash(req.Target[:])
 	u.mutex.Lock()
-	closest := u.closest(
+	nodesByDist := u.closest(
 		target, bucketSize, matchType,
-	).entries
+	)
 	u.mutex.Unlock()
-
-	p := neighbors{Expiration: uint64(time.Now().Add(expiration).Unix())}
+	if u.preferFresh {
+		nodesByDist.sortFresh(u.db.lastPong)
+	} else {
+		nodesByDist.sortStable()
+	}
+	closest := nodesByDist.entries
+
+	p := neighbors{Expiration: uint64(u.clock.Now().Add(expiration).Unix())}
+	var exts []rpcNodeExt
+	flush := func() {
+		log.Debugf(
+			"findnode handle took %.3f ms to finish",
+			float64(time.Now().Sub(t1))/float64(time.Millisecond),
+		)
+		t2 := time.Now()
+		if err := p.setNodeExts(exts); err != nil {
+			log.Debug("Dropped neighbor attributes", "err", err)
+			p.Rest = nil
+		}
+		u.send(fromID, from, NEIGHBORSPACKET, &p)
+		log.Debugf(
+			"findnode handle took %.3f ms to send",
+			float64(time.Now().Sub(t2))/float64(time.Millisecond),
+		)
+		p.Nodes = p.Nodes[:0]
+		exts = exts[:0]
+	}
 	// Send neighbors in chunks with at most maxNeighbors per packet
-	// to stay below the 1280 byte limit.
-	for i, n := range closest {
+	// to stay below the 1280 byte limit. Chunks carrying node attributes
+	// hold at most maxNeighborsExt nodes.
+	for _, n := range closest {
 		if netutil.CheckRelayIP(from.IP, n.IP) != nil {
 			continue
 		}
+		ext, hasExt := u.nodeExtToRPC(n)
+		limit := maxNeighbors
+		if hasExt || len(exts) > 0 {
+			limit = maxNeighborsExt
+		}
+		if len(p.Nodes) >= limit {
+			flush()
+		}
 		p.Nodes = append(p.Nodes, nodeToRPC(n))
-		// send neighbors in chunks of size maxNeighbors
-		if len(p.Nodes) == maxNeighbors || i == len(closest)-1 {
-			log.Debugf(
-				"findnode handle took %.3f ms to finish",
-				float64(time.Now().Sub(t1))/float64(time.Millisecond),
-			)
-			t2 := time.Now()
-			u.send(fromID, from, NEIGHBORSPACKET, &p)
-			log.Debugf(
-				"findnode handle took %.3f ms to send",
-				float64(time.Now().Sub(t2))/float64(time.Millisecond),
-			)
-			p.Nodes = p.Nodes[:0]
+		if hasExt {
+			exts = append(exts, ext)
 		}
 	}
+	if len(p.Nodes) > 0 {
+		flush()
+	}
 	return nil
 }
 
 func (req *findnode) name() string { return "FINDNODE/v4" }
 
 func (req *neighbors) handle(u *udp, from *net.UDPAddr, fromID NodeID, mac []byte) error {
-	if expired(req.Expiration) {
+	if u.expired(req.Expiration) {
 		return errExpired
 	}
+	// Honest nodes never send more than fit into a single packet, see
+	// maxNeighbors. Larger packets would inflate the lookup counters.
+	if len(req.Nodes) > maxNeighbors {
+		return errTooManyNeighbors
+	}
 	if !u.handleReply(fromID, NEIGHBORSPACKET, req) {
 		return errUnsolicitedReply
 	}
@@ -947,21 +1895,23 @@ func (req *neighbors) name() string { return "NEIGHBORS/v4" }
 
 // handle store request
 func (req *store) handle(u *udp, from *net.UDPAddr, fromID NodeID, mac []byte) error {
-	if expired(req.Expiration) {
+	if !u.subnetEnabled {
+		return errSubnetDisabled
+	}
+	if u.expired(req.Expiration) {
 		return errExpired
 	}
 	key := req.Key[:]
-	node := u.db.node(fromID)
 
-	// No bond exists, we don't process the packet.
-	if node == nil {
-		return errUnknownNode
+	// No valid bond exists, we don't process the packet.
+	if err := u.checkBond(fromID, from); err != nil {
+		return err
 	}
 	// Example: "enode://8db......d74@172.20.0.13:40333"
 	value := []byte(fmt.Sprintf("enode://%s@%s", fromID, from))
 	log.Debugf("subnet store kv received from %v: %s", from, value)
 	success := u.SetKey(key, value, fromID)
-	p := storeReply{Expiration: uint64(time.Now().Add(expiration).Unix())}
+	p := storeReply{Expiration: uint64(u.clock.Now().Add(expiration).Unix())}
 	p.result = success
 	u.send(fromID, from, STOREREPLYPACKET, &p)
 	return nil
@@ -971,7 +1921,10 @@ func (req *store) name() string { return "STORE/v4" }
 
 // handle storereply request
 func (req *storeReply) handle(u *udp, from *net.UDPAddr, fromID NodeID, mac []byte) error {
-	if expired(req.Expiration) {
+	if !u.subnetEnabled {
+		return errSubnetDisabled
+	}
+	if u.expired(req.Expiration) {
 		return errExpired
 	}
 	log.Debugf("subnet store kv reply received from %v: %t", from, req.result)
@@ -982,11 +1935,14 @@ func (req *storeReply) name() string { return "STOREREPLY/v4" }
 
 // handle findvalue request
 func (req *findvalue) handle(u *udp, from *net.UDPAddr, fromID NodeID, mac []byte) error {
-	if expired(req.Expiration) {
+	if !u.subnetEnabled {
+		return errSubnetDisabled
+	}
+	if u.expired(req.Expiration) {
 		return errExpired
 	}
 
-	reply := findvalueReply{Expiration: uint64(time.Now().Add(expiration).Unix())}
+	reply := findvalueReply{Expiration: uint64(u.clock.Now().Add(expiration).Unix())}
 	// key is subnet id, value is a map[string]string
 	value, _ := u.GetKey(req.Key[:])
 	reply.Key = req.Key[:]
@@ -1033,7 +1989,10 @@ func (req *findvalue) name() string { return "FINDVALUE/v4" }
 
 // handle findvaluereply request
 func (req *findvalueReply) handle(u *udp, from *net.UDPAddr, fromID NodeID, mac []byte) error {
-	if expired(req.Expiration) {
+	if !u.subnetEnabled {
+		return errSubnetDisabled
+	}
+	if u.expired(req.Expiration) {
 		return errExpired
 	}
 
@@ -1047,6 +2006,6 @@ func (req *findvalueReply) handle(u *udp, from *net.UDPAddr, fromID NodeID, mac
 func (req *findvalueReply) name() string { return "FINDVALUEREPLY/v4" }
 
 // helper function to check if expired
-func expired(ts uint64) bool {
-	return time.Unix(int64(ts), 0).Before(time.Now())
+func (u *udp) expired(ts uint64) bool {
+	return time.Unix(int64(ts), 0).Before(u.clock.Now())
 }
@@ -24,8 +24,10 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"math/rand"
 	"net"
+	"os"
 	"path/filepath"
 	"reflect"
 	"runtime"
@@ -33,10 +35,12 @@ import (
 	"testing"
 	"time"
 
-	"github.com/davecgh/go-spew/spew"
 	"github.com/MOACChain/MoacLib/common"
 	"github.com/MOACChain/MoacLib/crypto"
+	"github.com/MOACChain/MoacLib/log"
 	"github.com/MOACChain/MoacLib/rlp"
+	"github.com/MOACChain/xchain/p2p/netutil"
+	"github.com/davecgh/go-spew/spew"
 )
 
 func init() {
@@ -70,7 +74,7 @@ func newUDPTest(t *testing.T) *udpTest {
 		remotekey:  newkey(),
 		remoteaddr: &net.UDPAddr{IP: net.IP{10, 0, 1, 99}, Port: 30303},
 	}
-	test.table, test.udp, _ = newUDP(test.localkey, test.pipe, nil, "", nil)
+	test.table, test.udp, _ = newUDP(test.localkey, test.pipe, nil, "", nil, 0, false, Config{})
 	return test
 }
 
@@ -239,7 +243,7 @@ func TestUDP_findnode(t *testing.T) {
 	targetHash := crypto.Keccak256Hash(testTarget[:])
 	nodes := &NodesByDistance{target: targetHash}
 	for i := 0; i < bucketSize; i++ {
-		nodes.push(nodeAtDistance(test.table.self.sha, i+2), bucketSize)
+		nodes.push(nodeAtDistance(test.table.Self().sha, i+2), bucketSize)
 	}
 	test.table.stuff(nodes.entries)
 
@@ -354,8 +358,8 @@ func TestUDP_successfulPing(t *testing.T) {
 
 	// remote is unknown, the table pings back.
 	test.waitPacketOut(func(p *ping) error {
-		if !reflect.DeepEqual(p.From, test.udp.ourEndpoint) {
-			t.Errorf("got ping.From %v, want %v", p.From, test.udp.ourEndpoint)
+		if !reflect.DeepEqual(p.From, test.udp.getOurEndpoint()) {
+			t.Errorf("got ping.From %v, want %v", p.From, test.udp.getOurEndpoint())
 		}
 		wantTo := rpcEndpoint{
 			// The mirrored UDP address is the UDP packet sender.
@@ -500,66 +504,1863 @@ func TestForwardCompatibility(t *testing.T) {
 	}
 }
 
-// dgramPipe is a fake UDP socket. It queues all sent datagrams.
-type dgramPipe struct {
-	mu      *sync.Mutex
-	cond    *sync.Cond
-	closing chan struct{}
-	closed  bool
-	queue   [][]byte
+// newTestUDP creates a transport on top of a dgramPipe with a fresh key.
+func newTestUDP(t *testing.T) (*Table, *udp, *dgramPipe) {
+	return newTestUDPConfig(t, Config{})
 }
 
-func newpipe() *dgramPipe {
-	mu := new(sync.Mutex)
-	return &dgramPipe{
-		closing: make(chan struct{}),
-		cond:    &sync.Cond{L: mu},
-		mu:      mu,
+func newTestUDPConfig(t *testing.T, cfg Config) (*Table, *udp, *dgramPipe) {
+	pipe := newpipe()
+	tab, udp, err := newUDP(newkey(), pipe, nil, "", nil, 99, false, cfg)
+	if err != nil {
+		t.Fatalf("can't create udp transport: %v", err)
 	}
+	return tab, udp, pipe
 }
 
-// WriteToUDP queues a datagram.
-func (c *dgramPipe) WriteToUDP(b []byte, to *net.UDPAddr) (n int, err error) {
-	msg := make([]byte, len(b))
-	copy(msg, b)
-	c.mu.Lock()
-	defer c.mu.Unlock()
-	if c.closed {
-		return 0, errors.New("closed")
+func TestUDP_requestIDLogged(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		records = make(map[string][]uint64)
+	)
+	handler := log.Root().GetHandler()
+	log.Root().SetHandler(log.FuncHandler(func(r *log.Record) error {
+		for i := 0; i+1 < len(r.Ctx); i += 2 {
+			if r.Ctx[i] == "reqid" {
+				mu.Lock()
+				records[r.Msg] = append(records[r.Msg], r.Ctx[i+1].(uint64))
+				mu.Unlock()
+			}
+		}
+		return nil
+	}))
+	defer log.Root().SetHandler(handler)
+
+	tab, udp, pipe := newTestUDP(t)
+	defer tab.Close()
+
+	toid := NodeID{1, 2, 3, 4}
+	toaddr := &net.UDPAddr{IP: net.ParseIP("1.2.3.4"), Port: 2222}
+	errc := make(chan error, 1)
+	go func() { errc <- udp.ping(toid, toaddr) }()
+
+	_, _, hash, err := decodePacket(pipe.waitPacketOut())
+	if err != nil {
+		t.Fatalf("sent packet decode error: %v", err)
+	}
+	if !udp.handleReply(toid, PONGPACKET, &pong{ReplyTok: hash}) {
+		t.Fatal("pong not matched")
+	}
+	if err := <-errc; err != nil {
+		t.Fatalf("ping failed: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	sent, replied := records[">> PING/v4"], records["rpc pending got reply"]
+	if len(sent) != 1 || len(replied) != 1 {
+		t.Fatalf("wrong number of log records: sent %v, replied %v", sent, replied)
+	}
+	if sent[0] == 0 || sent[0] != replied[0] {
+		t.Errorf("request id mismatch: sent %d, replied %d", sent[0], replied[0])
 	}
-	c.queue = append(c.queue, msg)
-	c.cond.Signal()
-	return len(b), nil
 }
 
-// ReadFromUDP just hangs until the pipe is closed.
-func (c *dgramPipe) ReadFromUDP(b []byte) (n int, addr *net.UDPAddr, err error) {
-	<-c.closing
-	return 0, nil, io.EOF
+func TestUDP_pongReplyTok(t *testing.T) {
+	tab, udp, pipe := newTestUDP(t)
+	defer tab.Close()
+
+	toid := NodeID{1, 2, 3, 4}
+	toaddr := &net.UDPAddr{IP: net.ParseIP("1.2.3.4"), Port: 2222}
+	errc := make(chan error, 1)
+	go func() { errc <- udp.ping(toid, toaddr) }()
+
+	_, _, hash, err := decodePacket(pipe.waitPacketOut())
+	if err != nil {
+		t.Fatalf("sent packet decode error: %v", err)
+	}
+	wrong := make([]byte, len(hash))
+	copy(wrong, hash)
+	wrong[0] ^= 0xff
+	for _, tok := range [][]byte{nil, wrong} {
+		if udp.handleReply(toid, PONGPACKET, &pong{ReplyTok: tok}) {
+			t.Errorf("pong with reply token %x matched", tok)
+		}
+	}
+	select {
+	case err := <-errc:
+		t.Fatalf("ping completed by pong with wrong reply token: %v", err)
+	default:
+	}
+	if !udp.handleReply(toid, PONGPACKET, &pong{ReplyTok: hash}) {
+		t.Fatal("pong with correct reply token not matched")
+	}
+	if err := <-errc; err != nil {
+		t.Fatalf("ping failed: %v", err)
+	}
 }
 
-func (c *dgramPipe) Close() error {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-	if !c.closed {
-		close(c.closing)
-		c.closed = true
+// markerCodec wraps the default packet codec, prefixing packets with a
+// marker byte.
+type markerCodec struct {
+	encoded, decoded int
+}
+
+const codecMarker = 0xc0
+
+var errNoMarker = errors.New("packet without marker")
+
+func (c *markerCodec) encode(priv *ecdsa.PrivateKey, ptype byte, req interface{}) ([]byte, []byte, error) {
+	c.encoded++
+	packet, hash, err := v4Codec{}.encode(priv, ptype, req)
+	if err != nil {
+		return nil, nil, err
 	}
-	return nil
+	return append([]byte{codecMarker}, packet...), hash, nil
 }
 
-func (c *dgramPipe) LocalAddr() net.Addr {
-	return &net.UDPAddr{IP: testLocal.IP, Port: int(testLocal.UDP)}
+func (c *markerCodec) decode(buf []byte) (packet, NodeID, []byte, error) {
+	c.decoded++
+	if len(buf) == 0 || buf[0] != codecMarker {
+		return nil, NodeID{}, nil, errNoMarker
+	}
+	return v4Codec{}.decode(buf[1:])
 }
 
-func (c *dgramPipe) waitPacketOut() []byte {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-	for len(c.queue) == 0 {
-		c.cond.Wait()
+func TestUDP_packetCodec(t *testing.T) {
+	tab, udp, pipe := newTestUDP(t)
+	defer tab.Close()
+	codec := new(markerCodec)
+	udp.codec = codec
+
+	toaddr := &net.UDPAddr{IP: net.IP{10, 0, 8, 1}, Port: 30303}
+	if err := udp.send(NodeID{1}, toaddr, PONGPACKET, &pong{Expiration: futureExp}); err != nil {
+		t.Fatalf("send failed: %v", err)
+	}
+	dgram := pipe.waitPacketOut()
+	if codec.encoded != 1 || dgram[0] != codecMarker {
+		t.Fatalf("packet not encoded by the codec: %d encodes, first byte %#x", codec.encoded, dgram[0])
+	}
+	if _, _, _, err := decodePacket(dgram[1:]); err != nil {
+		t.Fatalf("wrapped packet decode error: %v", err)
+	}
+
+	remotekey := newkey()
+	enc, err := encodePacket(remotekey, PONGPACKET, &pong{Expiration: futureExp})
+	if err != nil {
+		t.Fatalf("packet encode error: %v", err)
+	}
+	if err := udp.handlePacket(toaddr, enc); err != errNoMarker {
+		t.Errorf("wrong error for packet without marker: have %v, want %v", err, errNoMarker)
+	}
+	if err := udp.handlePacket(toaddr, append([]byte{codecMarker}, enc...)); err != errUnsolicitedReply {
+		t.Errorf("wrong error for marked packet: have %v, want %v", err, errUnsolicitedReply)
+	}
+	if codec.decoded != 2 {
+		t.Errorf("packets not decoded by the codec: %d decodes, want 2", codec.decoded)
+	}
+}
+
+func TestUDP_tooManyPending(t *testing.T) {
+	tab, udp, _ := newTestUDP(t)
+	defer tab.Close()
+	udp.maxPending = 2
+
+	callback := func(interface{}) bool { return true }
+	first := udp.addPending(udp.nextReqID(), NodeID{1}, PONGPACKET, callback)
+	second := udp.addPending(udp.nextReqID(), NodeID{2}, PONGPACKET, callback)
+	select {
+	case err := <-udp.addPending(udp.nextReqID(), NodeID{3}, PONGPACKET, callback):
+		if err != errTooManyPending {
+			t.Fatalf("wrong error for request above the cap: got %v, want %v", err, errTooManyPending)
+		}
+	case <-time.After(100 * time.Millisecond):
+		t.Fatal("request above the cap was not rejected immediately")
+	}
+
+	// Once the queued requests complete, new ones are accepted again.
+	udp.handleReply(NodeID{1}, PONGPACKET, nil)
+	udp.handleReply(NodeID{2}, PONGPACKET, nil)
+	if err := <-first; err != nil {
+		t.Fatalf("first request failed: %v", err)
+	}
+	if err := <-second; err != nil {
+		t.Fatalf("second request failed: %v", err)
+	}
+	errc := udp.addPending(udp.nextReqID(), NodeID{4}, PONGPACKET, callback)
+	udp.handleReply(NodeID{4}, PONGPACKET, nil)
+	if err := <-errc; err != nil {
+		t.Fatalf("request after drain failed: %v", err)
+	}
+}
+
+func TestUDP_subnetDisabled(t *testing.T) {
+	tab, udp, _ := newTestUDP(t)
+	defer tab.Close()
+	udp.subnetEnabled = false
+
+	from := &net.UDPAddr{IP: net.ParseIP("1.2.3.4"), Port: 2222}
+	packets := []packet{
+		&store{Expiration: futureExp},
+		&storeReply{Expiration: futureExp},
+		&findvalue{Expiration: futureExp},
+		&findvalueReply{Expiration: futureExp},
+	}
+	for _, p := range packets {
+		if err := p.handle(udp, from, NodeID{1}, nil); err != errSubnetDisabled {
+			t.Errorf("%s: wrong error when disabled: got %v, want %v", p.name(), err, errSubnetDisabled)
+		}
+	}
+}
+
+func TestUDP_subnetEnabled(t *testing.T) {
+	tab, udp, pipe := newTestUDP(t)
+	defer tab.Close()
+
+	from := &net.UDPAddr{IP: net.ParseIP("1.2.3.4"), Port: 2222}
+	tests := []struct {
+		p    packet
+		want error
+	}{
+		{&store{Expiration: futureExp}, errUnknownNode},
+		{&storeReply{Expiration: futureExp}, nil},
+		{&findvalue{Expiration: futureExp}, nil},
+		{&findvalueReply{Expiration: futureExp}, errUnsolicitedReply},
+	}
+	for _, test := range tests {
+		if err := test.p.handle(udp, from, NodeID{1}, nil); err != test.want {
+			t.Errorf("%s: wrong error when enabled: got %v, want %v", test.p.name(), err, test.want)
+		}
+	}
+	// findvalue is answered even if the key is unknown.
+	dgram := pipe.waitPacketOut()
+	if p, _, _, err := decodePacket(dgram); err != nil {
+		t.Fatalf("sent packet decode error: %v", err)
+	} else if _, ok := p.(*findvalueReply); !ok {
+		t.Fatalf("wrong packet sent: got %T, want *findvalueReply", p)
+	}
+}
+
+func TestUDP_rejectCounts(t *testing.T) {
+	tab, udp, pipe := newTestUDP(t)
+	defer tab.Close()
+	udp.netrestrict, _ = netutil.ParseNetlist("1.2.3.0/24")
+
+	toid := NodeID{1, 2, 3, 4}
+	toaddr := &net.UDPAddr{IP: net.ParseIP("1.2.3.1"), Port: 30303}
+	done := make(chan struct{})
+	go func() {
+		udp.findnode(toid, toaddr, testTarget, false)
+		close(done)
+	}()
+	pipe.waitPacketOut()
+
+	udp.handleReply(toid, NEIGHBORSPACKET, &neighbors{
+		Expiration: futureExp,
+		Nodes: []rpcNode{
+			{IP: net.ParseIP("1.2.3.4").To4(), UDP: 1000, TCP: 30303},   // low port
+			{IP: net.ParseIP("10.0.0.1").To4(), UDP: 30303, TCP: 30303}, // LAN from WAN
+			{IP: net.ParseIP("5.6.7.8").To4(), UDP: 30303, TCP: 30303},  // netrestrict
+			{IP: net.ParseIP("1.2.3.5").To4(), UDP: 30303, TCP: 0},      // incomplete
+			{IP: net.ParseIP("1.2.3.6").To4(), UDP: 1024, TCP: 30303},   // low port
+		},
+	})
+	<-done
+
+	want := map[string]uint64{
+		rejectLowPort:     2,
+		rejectRelayIP:     1,
+		rejectNetrestrict: 1,
+		rejectIncomplete:  1,
+	}
+	if have := udp.RejectCounts(); !reflect.DeepEqual(have, want) {
+		t.Errorf("reject counts mismatch:\n  got:  %v\n  want: %v", have, want)
+	}
+}
+
+func TestUDP_findnodeSkipsSelf(t *testing.T) {
+	tab, udp, pipe := newTestUDP(t)
+	defer tab.Close()
+	ours := rpcEndpoint{IP: net.ParseIP("1.2.3.9").To4(), UDP: 30303, TCP: 30303}
+	udp.setOurEndpoint(ours)
+
+	toid := NodeID{1, 2, 3, 4}
+	toaddr := &net.UDPAddr{IP: net.ParseIP("1.2.3.1"), Port: 30303}
+	result := make(chan []*Node, 1)
+	go func() {
+		nodes, _ := udp.findnode(toid, toaddr, testTarget, false)
+		result <- nodes
+	}()
+	pipe.waitPacketOut()
+
+	other := PubkeyID(&newkey().PublicKey)
+	udp.handleReply(toid, NEIGHBORSPACKET, &neighbors{
+		Expiration: futureExp,
+		Nodes: []rpcNode{
+			{ID: tab.Self().ID, IP: net.ParseIP("1.2.3.4").To4(), UDP: 30303, TCP: 30303}, // our id
+			{ID: PubkeyID(&newkey().PublicKey), IP: ours.IP, UDP: ours.UDP, TCP: 30303},   // our endpoint
+			{ID: other, IP: net.ParseIP("1.2.3.5").To4(), UDP: 30303, TCP: 30303},         // valid
+		},
+	})
+	// Fill up the reply so findnode returns.
+	udp.handleReply(toid, NEIGHBORSPACKET, &neighbors{Expiration: futureExp, Nodes: make([]rpcNode, bucketSize-3)})
+
+	nodes := <-result
+	if len(nodes) != 1 || nodes[0].ID != other {
+		t.Errorf("wrong lookup result: %v", nodes)
+	}
+	if n := udp.RejectCounts()[rejectSelf]; n != 2 {
+		t.Errorf("self reject count mismatch: have %d, want %d", n, 2)
+	}
+}
+
+func TestUDP_scoreUnsolicitedReplies(t *testing.T) {
+	tab, udp, _ := newTestUDP(t)
+	defer tab.Close()
+
+	remotekey := newkey()
+	remoteID := PubkeyID(&remotekey.PublicKey)
+	remoteaddr := &net.UDPAddr{IP: net.IP{10, 0, 1, 99}, Port: 30303}
+	enc, err := encodePacket(remotekey, PONGPACKET, &pong{Expiration: futureExp})
+	if err != nil {
+		t.Fatalf("packet encode error: %v", err)
+	}
+
+	for i := 0; tab.GetNodeType(remoteID) != AlienNode; i++ {
+		if i > -scoreAlienThreshold {
+			t.Fatalf("node not marked alien after %d unsolicited replies, score %d", i, tab.Score(remoteID))
+		}
+		if err := udp.handlePacket(remoteaddr, enc); err != errUnsolicitedReply {
+			t.Fatalf("wrong error: got %v, want %v", err, errUnsolicitedReply)
+		}
+	}
+	if score := tab.Score(remoteID); score >= scoreAlienThreshold {
+		t.Errorf("alien node score %d not below threshold %d", score, scoreAlienThreshold)
+	}
+	if _, ok := tab.Scores()[remoteID]; !ok {
+		t.Error("node missing from exported scores")
+	}
+}
+
+func TestTable_scoresBounded(t *testing.T) {
+	tab, _, _ := newTestUDP(t)
+	defer tab.Close()
+
+	for i := 0; i <= maxTrackedNodes; i++ {
+		tab.adjustScore(NodeID{byte(i >> 8), byte(i)}, 1)
+	}
+	scores := tab.Scores()
+	if len(scores) != maxTrackedNodes {
+		t.Fatalf("tracked scores: have %d, want %d", len(scores), maxTrackedNodes)
+	}
+	if _, ok := scores[NodeID{}]; ok {
+		t.Error("score of the least recently scored node not evicted")
+	}
+	if score := tab.Score(NodeID{byte(maxTrackedNodes >> 8), byte(maxTrackedNodes)}); score != 1 {
+		t.Errorf("score of the latest node: have %d, want 1", score)
+	}
+}
+
+func TestUDP_bondExpiration(t *testing.T) {
+	tab, udp, pipe, clk := newSimClockUDP(t)
+	defer tab.Close()
+
+	fromID := NodeID{1}
+	from := &net.UDPAddr{IP: net.ParseIP("1.2.3.4"), Port: 2222}
+	tab.db.updateNode(NewNode(fromID, from.IP, uint16(from.Port), 30303, nil, nil, false, nil))
+	tab.db.updateLastPong(fromID, time.Now())
+
+	req := &findnode{Expiration: futureExp}
+	if err := req.handle(udp, from, fromID, nil); err != nil {
+		t.Fatalf("findnode from fresh bond rejected: %v", err)
+	}
+
+	// Advance the clock past the bond TTL.
+	clk.Run(udp.bondExpiration + time.Minute)
+	req.Expiration = uint64(clk.Now().Add(time.Hour).Unix())
+	if err := req.handle(udp, from, fromID, nil); err != errBondExpired {
+		t.Fatalf("wrong error for expired bond: got %v, want %v", err, errBondExpired)
+	}
+	dgram := pipe.waitPacketOut()
+	if p, _, _, err := decodePacket(dgram); err != nil {
+		t.Fatalf("sent packet decode error: %v", err)
+	} else if _, ok := p.(*ping); !ok {
+		t.Fatalf("wrong packet sent: got %T, want *ping", p)
+	}
+}
+
+func TestUDP_BrotherEnodes(t *testing.T) {
+	tab, udp, _ := newTestUDP(t)
+	defer tab.Close()
+
+	newNode := func(i int, tcp uint16, nodeType int) *Node {
+		n := NewNode(PubkeyID(&newkey().PublicKey), net.IP{10, 0, 2, byte(i)}, 30303, tcp, nil, nil, false, nil)
+		tab.SetNodeType(n.ID, nodeType)
+		return n
+	}
+	var (
+		brothers   = []*Node{newNode(1, 30303, BrotherNode), newNode(2, 30303, BrotherNode), newNode(3, 30303, BrotherNode)}
+		incomplete = newNode(4, 0, BrotherNode)
+		aliens     = []*Node{newNode(5, 30303, AlienNode), newNode(6, 30303, AlienNode)}
+	)
+	tab.mutex.Lock()
+	tab.stuff(append(append(brothers, incomplete), aliens...))
+	tab.mutex.Unlock()
+
+	want := make(map[string]bool)
+	for _, n := range brothers {
+		want[n.String()] = true
+	}
+	all := udp.BrotherEnodes(10)
+	if len(all) != len(brothers) {
+		t.Fatalf("wrong number of enodes: got %d, want %d: %v", len(all), len(brothers), all)
+	}
+	for _, url := range all {
+		if !want[url] {
+			t.Errorf("unexpected enode %s", url)
+		}
+		if _, err := ParseNode(url); err != nil {
+			t.Errorf("enode %s doesn't parse: %v", url, err)
+		}
+	}
+	if capped := udp.BrotherEnodes(2); len(capped) != 2 {
+		t.Errorf("wrong number of capped enodes: got %d, want 2", len(capped))
+	}
+}
+
+func TestUDP_LookupRanked(t *testing.T) {
+	tab, udp, _ := newTestUDP(t)
+	defer tab.Close()
+
+	var nodes []*Node
+	for i := 0; i < 6; i++ {
+		n := NewNode(PubkeyID(&newkey().PublicKey), net.IP{10, 0, 3, byte(i)}, 30303, 30303, nil, nil, false, nil)
+		if i%2 == 0 {
+			tab.SetNodeType(n.ID, UncleNode)
+		} else {
+			tab.SetNodeType(n.ID, BrotherNode)
+		}
+		nodes = append(nodes, n)
+	}
+	tab.mutex.Lock()
+	tab.stuff(nodes)
+	tab.mutex.Unlock()
+
+	// None of the nodes answer findnode, the result is the local table.
+	target := PubkeyID(&newkey().PublicKey)
+	result := udp.LookupRanked(target)
+	if len(result) != len(nodes) {
+		t.Fatalf("wrong number of results: got %d, want %d", len(result), len(nodes))
+	}
+	for i, n := range result {
+		want := BrotherNode
+		if i >= len(nodes)/2 {
+			want = UncleNode
+		}
+		if nodeType := tab.GetNodeType(n.ID); nodeType != want {
+			t.Errorf("result %d: wrong node type: got %d, want %d", i, nodeType, want)
+		}
+	}
+	// Within each type, nodes are ordered by distance.
+	hash := crypto.Keccak256Hash(target[:])
+	for i := 1; i < len(result); i++ {
+		if i == len(nodes)/2 {
+			continue
+		}
+		if distcmp(hash, result[i-1].sha, result[i].sha) > 0 {
+			t.Errorf("results %d and %d not ordered by distance", i-1, i)
+		}
+	}
+}
+
+func TestUDP_RotateKey(t *testing.T) {
+	tab, udp, pipe := newTestUDP(t)
+	defer tab.Close()
+
+	peer := NewNode(PubkeyID(&newkey().PublicKey), net.IP{10, 0, 4, 1}, 30303, 30303, nil, nil, false, nil)
+	tab.mutex.Lock()
+	tab.stuff([]*Node{peer})
+	tab.mutex.Unlock()
+
+	newKey := newkey()
+	newID := PubkeyID(&newKey.PublicKey)
+	if err := udp.RotateKey(newKey); err != nil {
+		t.Fatalf("key rotation failed: %v", err)
+	}
+	if tab.Self().ID != newID {
+		t.Errorf("wrong self id after rotation: got %x, want %x", tab.Self().ID[:8], newID[:8])
+	}
+	if id := tab.db.selfID(); id != newID {
+		t.Errorf("wrong node database self id after rotation: got %x, want %x", id[:8], newID[:8])
+	}
+	if nodes := tab.GetAllNodes(); len(nodes) != 1 || nodes[0].ID != peer.ID {
+		t.Errorf("table not preserved across rotation: %v", nodes)
+	}
+
+	// The re-announcement ping is signed with the new key.
+	dgram := pipe.waitPacketOut()
+	p, fromID, _, err := decodePacket(dgram)
+	if err != nil {
+		t.Fatalf("sent packet decode error: %v", err)
+	}
+	if _, ok := p.(*ping); !ok {
+		t.Errorf("wrong packet sent: got %T, want *ping", p)
+	}
+	if fromID != newID {
+		t.Errorf("packet not signed with new key: got %x, want %x", fromID[:8], newID[:8])
+	}
+}
+
+func TestUDP_findnodeStableOrder(t *testing.T) {
+	tab, udp, pipe := newTestUDP(t)
+	defer tab.Close()
+
+	var nodes []*Node
+	for i := 0; i < 8; i++ {
+		n := NewNode(PubkeyID(&newkey().PublicKey), net.IP{10, 0, 5, byte(i + 10)}, 30303, 30303, nil, nil, false, nil)
+		tab.SetNodeType(n.ID, BrotherNode)
+		nodes = append(nodes, n)
+	}
+	tab.mutex.Lock()
+	tab.stuff(nodes)
+	tab.mutex.Unlock()
+
+	fromID := NodeID{1}
+	from := &net.UDPAddr{IP: net.IP{10, 0, 5, 1}, Port: 30303}
+	tab.db.updateNode(NewNode(fromID, from.IP, uint16(from.Port), 30303, nil, nil, false, nil))
+	tab.db.updateLastPong(fromID, time.Now())
+
+	req := &findnode{Target: PubkeyID(&newkey().PublicKey), Expiration: futureExp}
+	var first []NodeID
+	for i := 0; i < 3; i++ {
+		if err := req.handle(udp, from, fromID, nil); err != nil {
+			t.Fatalf("findnode failed: %v", err)
+		}
+		p, _, _, err := decodePacket(pipe.waitPacketOut())
+		if err != nil {
+			t.Fatalf("sent packet decode error: %v", err)
+		}
+		var ids []NodeID
+		for _, n := range p.(*neighbors).Nodes {
+			ids = append(ids, n.ID)
+		}
+		if len(ids) != len(nodes) {
+			t.Fatalf("wrong number of neighbors: got %d, want %d", len(ids), len(nodes))
+		}
+		if i == 0 {
+			first = ids
+			continue
+		}
+		if !reflect.DeepEqual(ids, first) {
+			t.Errorf("call %d: neighbor order changed:\ngot  %x\nwant %x", i, ids, first)
+		}
+	}
+	target := crypto.Keccak256Hash(req.Target[:])
+	for i := 1; i < len(first); i++ {
+		a, b := crypto.Keccak256Hash(first[i-1][:]), crypto.Keccak256Hash(first[i][:])
+		if distcmp(target, a, b) > 0 {
+			t.Errorf("neighbors %d and %d not ordered by distance", i-1, i)
+		}
+	}
+}
+
+func TestUDP_findnodePreferFresh(t *testing.T) {
+	tab, udp, pipe := newTestUDP(t)
+	defer tab.Close()
+
+	// Find two nodes at the same log distance from the target.
+	req := &findnode{Target: PubkeyID(&newkey().PublicKey), Expiration: futureExp}
+	target := crypto.Keccak256Hash(req.Target[:])
+	byDist := make(map[int]*Node)
+	var near, far *Node
+	for i := 0; near == nil; i++ {
+		n := NewNode(PubkeyID(&newkey().PublicKey), net.IP{10, 0, 6, byte(i + 10)}, 30303, 30303, nil, nil, false, nil)
+		d := logdist(target, n.sha)
+		if other := byDist[d]; other != nil {
+			near, far = other, n
+			if distcmp(target, near.sha, far.sha) > 0 {
+				near, far = far, near
+			}
+		}
+		byDist[d] = n
+	}
+	for _, n := range []*Node{near, far} {
+		tab.SetNodeType(n.ID, BrotherNode)
+	}
+	tab.mutex.Lock()
+	tab.stuff([]*Node{near, far})
+	tab.mutex.Unlock()
+	// The XOR-closer node was last seen long ago.
+	tab.db.updateLastPong(near.ID, time.Now().Add(-time.Hour))
+	tab.db.updateLastPong(far.ID, time.Now())
+
+	fromID := NodeID{1}
+	from := &net.UDPAddr{IP: net.IP{10, 0, 6, 1}, Port: 30303}
+	tab.db.updateNode(NewNode(fromID, from.IP, uint16(from.Port), 30303, nil, nil, false, nil))
+	tab.db.updateLastPong(fromID, time.Now())
+
+	firstNeighbor := func() NodeID {
+		if err := req.handle(udp, from, fromID, nil); err != nil {
+			t.Fatalf("findnode failed: %v", err)
+		}
+		p, _, _, err := decodePacket(pipe.waitPacketOut())
+		if err != nil {
+			t.Fatalf("sent packet decode error: %v", err)
+		}
+		nodes := p.(*neighbors).Nodes
+		if len(nodes) != 2 {
+			t.Fatalf("wrong number of neighbors: got %d, want 2", len(nodes))
+		}
+		return nodes[0].ID
+	}
+	if id := firstNeighbor(); id != near.ID {
+		t.Errorf("without preferFresh: first neighbor %x, want closest %x", id[:8], near.ID[:8])
+	}
+	udp.preferFresh = true
+	if id := firstNeighbor(); id != far.ID {
+		t.Errorf("with preferFresh: first neighbor %x, want freshest %x", id[:8], far.ID[:8])
+	}
+}
+
+func TestUDP_trustedNodeNotAlien(t *testing.T) {
+	tab, udp, _ := newTestUDP(t)
+	defer tab.Close()
+
+	remotekey := newkey()
+	remoteID := PubkeyID(&remotekey.PublicKey)
+	remoteaddr := &net.UDPAddr{IP: net.IP{10, 0, 1, 99}, Port: 30303}
+	enc, err := encodePacket(remotekey, PONGPACKET, &pong{Expiration: futureExp})
+	if err != nil {
+		t.Fatalf("packet encode error: %v", err)
+	}
+
+	tab.SetNodeType(remoteID, AlienNode)
+	if err := udp.handlePacket(remoteaddr, enc); err == nil || err == errUnsolicitedReply {
+		t.Fatalf("packet from alien node handled: %v", err)
+	}
+
+	tab.AddTrustedNode(remoteID)
+	if err := udp.handlePacket(remoteaddr, enc); err != errUnsolicitedReply {
+		t.Errorf("packet from trusted node not handled: got %v, want %v", err, errUnsolicitedReply)
+	}
+	tab.SetNodeType(remoteID, AlienNode)
+	if nodeType := tab.GetNodeType(remoteID); nodeType == AlienNode {
+		t.Error("trusted node classified alien")
+	}
+}
+
+func TestUDP_discoveryNetworkID(t *testing.T) {
+	cfg := Config{AlienMismatches: 1}
+
+	// Both nodes run on chain network id 99, but use different
+	// discovery network ids.
+	pipeA, pipeB := newpipe(), newpipe()
+	keyA, keyB := newkey(), newkey()
+	tabA, udpA, err := newUDP(keyA, pipeA, nil, "", nil, 1099, false, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tabA.Close()
+	tabB, udpB, err := newUDP(keyB, pipeB, nil, "", nil, 2099, false, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tabB.Close()
+
+	idA, idB := PubkeyID(&keyA.PublicKey), PubkeyID(&keyB.PublicKey)
+	addrA := &net.UDPAddr{IP: net.IP{10, 0, 6, 1}, Port: 30303}
+	addrB := &net.UDPAddr{IP: net.IP{10, 0, 6, 2}, Port: 30303}
+
+	go udpA.ping(idB, addrB)
+	if err := udpB.handlePacket(addrA, pipeA.waitPacketOut()); err != nil {
+		t.Fatalf("ping not handled: %v", err)
+	}
+	if nodeType := tabB.GetNodeType(idA); nodeType != AlienNode {
+		t.Errorf("B classified A as %d, want alien", nodeType)
+	}
+	if err := udpA.handlePacket(addrB, pipeB.waitPacketOut()); err != nil {
+		t.Fatalf("pong not handled: %v", err)
+	}
+	if nodeType := tabA.GetNodeType(idB); nodeType != AlienNode {
+		t.Errorf("A classified B as %d, want alien", nodeType)
+	}
+}
+
+// networkIDRest encodes a network id as sent in the rest of ping and pong.
+func networkIDRest(networkid uint64) []rlp.RawValue {
+	msg, _ := rlp.EncodeToBytes(fmt.Sprintf("%d\t", networkid))
+	return []rlp.RawValue{msg}
+}
+
+func TestUDP_alienMismatches(t *testing.T) {
+	tab, udp, _, clock := newSimClockUDP(t)
+	defer tab.Close()
+	mismatches, window := udp.alienMismatches, udp.alienWindow
+	from := &net.UDPAddr{IP: net.IP{10, 0, 12, 1}, Port: 30303}
+
+	// A single mismatch leaves the node unknown.
+	flaky := PubkeyID(&newkey().PublicKey)
+	if typ := processRestInPingPong(networkIDRest(100), udp, "PING", from, flaky); typ == AlienNode {
+		t.Fatal("node classified alien after one mismatch")
+	}
+	if typ := tab.GetNodeType(flaky); typ != UnknownNode {
+		t.Errorf("node classified as %d after one mismatch, want unknown", typ)
+	}
+	// A match resets the count. The table never downgrades the brother
+	// classification of the match, so check the reported types instead.
+	processRestInPingPong(networkIDRest(99), udp, "PING", from, flaky)
+	for i := 1; i < mismatches; i++ {
+		if typ := processRestInPingPong(networkIDRest(100), udp, "PING", from, flaky); typ == AlienNode {
+			t.Fatalf("node reported alien after a match and %d mismatches", i)
+		}
+	}
+
+	// The configured number of consecutive mismatches classify it alien.
+	alien := PubkeyID(&newkey().PublicKey)
+	for i := 1; i <= mismatches; i++ {
+		typ := processRestInPingPong(networkIDRest(100), udp, "PING", from, alien)
+		if i < mismatches && (typ == AlienNode || tab.GetNodeType(alien) == AlienNode) {
+			t.Fatalf("node classified alien after %d mismatches", i)
+		}
+		if i == mismatches && typ != AlienNode {
+			t.Fatalf("reported node type %d after %d mismatches, want alien", typ, i)
+		}
+	}
+	if typ := tab.GetNodeType(alien); typ != AlienNode {
+		t.Errorf("node classified as %d after %d mismatches, want alien", typ, mismatches)
+	}
+
+	// Mismatches spread beyond the window don't add up.
+	slow := PubkeyID(&newkey().PublicKey)
+	for i := 0; i < mismatches; i++ {
+		processRestInPingPong(networkIDRest(100), udp, "PING", from, slow)
+		clock.Run(window/2 + time.Second)
+	}
+	if typ := tab.GetNodeType(slow); typ == AlienNode {
+		t.Error("node classified alien by mismatches outside the window")
+	}
+
+	// Mismatch runs older than the window are dropped.
+	clock.Run(window + time.Second)
+	processRestInPingPong(networkIDRest(100), udp, "PING", from, PubkeyID(&newkey().PublicKey))
+	udp.mismatchMu.Lock()
+	_, tracked := udp.mismatches[slow]
+	udp.mismatchMu.Unlock()
+	if tracked {
+		t.Error("expired mismatches still tracked")
+	}
+}
+
+func TestUDP_peerVersion(t *testing.T) {
+	tab, udp, _ := newTestUDP(t)
+	defer tab.Close()
+
+	from := &net.UDPAddr{IP: net.IP{10, 0, 7, 1}, Port: 30303}
+	v3, v4 := NodeID{3}, NodeID{4}
+	for id, version := range map[NodeID]uint{v3: 3, v4: 4} {
+		req := &ping{Version: version, Expiration: futureExp}
+		if err := req.handle(udp, from, id, nil); err != nil {
+			t.Fatalf("ping with version %d not handled: %v", version, err)
+		}
+		if have, ok := udp.PeerVersion(id); !ok || have != version {
+			t.Errorf("recorded version mismatch: have %d (%t), want %d", have, ok, version)
+		}
+	}
+	if _, ok := udp.PeerVersion(NodeID{5}); ok {
+		t.Error("version recorded for unknown peer")
+	}
+
+	udp.minVersion = 4
+	if err := (&ping{Version: 3, Expiration: futureExp}).handle(udp, from, v3, nil); err != errOldVersion {
+		t.Errorf("wrong error for old peer: have %v, want %v", err, errOldVersion)
+	}
+	if err := (&ping{Version: 4, Expiration: futureExp}).handle(udp, from, v4, nil); err != nil {
+		t.Errorf("ping from current peer refused: %v", err)
+	}
+
+	// Only the versions of the most recent maxTrackedNodes peers are kept.
+	for i := 0; i < maxTrackedNodes; i++ {
+		udp.versions.Add(NodeID{0xff, byte(i >> 8), byte(i)}, uint(4))
+	}
+	if n := udp.versions.Len(); n != maxTrackedNodes {
+		t.Errorf("tracked versions: have %d, want %d", n, maxTrackedNodes)
+	}
+	if _, ok := udp.PeerVersion(v3); ok {
+		t.Error("version of the oldest peer not evicted")
+	}
+}
+
+func TestUDP_refreshInterval(t *testing.T) {
+	interval := 2 * time.Minute
+	tab, _, _ := newTestUDPConfig(t, Config{RefreshInterval: interval})
+	if tab.refreshInterval != interval {
+		t.Errorf("refresh interval not propagated: have %v, want %v", tab.refreshInterval, interval)
+	}
+	tab.Close()
+
+	tab, _, _ = newTestUDPConfig(t, Config{RefreshInterval: time.Millisecond})
+	if tab.refreshInterval != minRefreshInterval {
+		t.Errorf("refresh interval not clamped: have %v, want %v", tab.refreshInterval, minRefreshInterval)
+	}
+	tab.Close()
+}
+
+func TestUDP_configPerTable(t *testing.T) {
+	tabA, udpA, _ := newTestUDPConfig(t, Config{RefreshInterval: 2 * time.Minute, NoSubnet: true})
+	defer tabA.Close()
+	tabB, udpB, _ := newTestUDPConfig(t, Config{RefreshInterval: 3 * time.Minute})
+	defer tabB.Close()
+
+	if tabA.refreshInterval != 2*time.Minute || tabB.refreshInterval != 3*time.Minute {
+		t.Errorf("refresh intervals shared: have %v and %v", tabA.refreshInterval, tabB.refreshInterval)
+	}
+	if udpA.subnetEnabled || !udpB.subnetEnabled {
+		t.Errorf("subnet setting shared: have %v and %v", udpA.subnetEnabled, udpB.subnetEnabled)
+	}
+	if udpB.writeTimeout != defaultWriteTimeout || udpB.alienMismatches != defaultAlienMismatches {
+		t.Errorf("defaults not applied: write timeout %v, alien mismatches %d", udpB.writeTimeout, udpB.alienMismatches)
+	}
+}
+
+func TestUDP_nodeTypesPersisted(t *testing.T) {
+	root, err := ioutil.TempDir("", "nodedb-")
+	if err != nil {
+		t.Fatalf("failed to create temporary data folder: %v", err)
+	}
+	defer os.RemoveAll(root)
+
+	var (
+		path    = filepath.Join(root, "database")
+		key     = newkey()
+		brother = NodeID{1}
+		alien   = NodeID{2}
+		uncle   = NodeID{3}
+	)
+	tab, _, err := newUDP(key, newpipe(), nil, path, nil, 99, false, Config{})
+	if err != nil {
+		t.Fatalf("can't create udp transport: %v", err)
+	}
+	tab.SetNodeType(brother, BrotherNode)
+	tab.SetNodeType(alien, AlienNode)
+	tab.SetNodeType(uncle, UncleNode)
+	tab.db.updateNodeType(NodeID{4}, BrotherNode, time.Now().Add(-time.Minute))
+	tab.Close()
+
+	// Simulate a restart by reopening the database.
+	tab, _, err = newUDP(key, newpipe(), nil, path, nil, 99, false, Config{})
+	if err != nil {
+		t.Fatalf("can't recreate udp transport: %v", err)
+	}
+	defer tab.Close()
+	for id, want := range map[NodeID]int{brother: BrotherNode, alien: AlienNode, uncle: UncleNode, {4}: UnknownNode} {
+		if have := tab.GetNodeType(id); have != want {
+			t.Errorf("node %x: type mismatch after restart: have %d, want %d", id[:1], have, want)
+		}
+	}
+}
+
+// memRecordStore is a RecordStore keeping the flushed records in a map.
+type memRecordStore struct {
+	mu      sync.Mutex
+	records map[string]map[string]string
+}
+
+func (s *memRecordStore) PutRecords(subnet string, records map[string]string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.records[subnet] = records
+	return nil
+}
+
+func TestUDP_FlushRecords(t *testing.T) {
+	tab, u, _ := newTestUDP(t)
+
+	// Without a store flushing is a no-op.
+	if err := u.FlushRecords(); err != nil {
+		t.Fatalf("flush without store failed: %v", err)
+	}
+	store := &memRecordStore{records: make(map[string]map[string]string)}
+	u.records = store
+
+	var (
+		subnet = []byte{0x01, 0x02}
+		key    = newkey()
+		id     = PubkeyID(&key.PublicKey)
+		url    = fmt.Sprintf("enode://%s@10.0.1.1:30303", id)
+	)
+	if !tab.SetKey(subnet, []byte(url), id) {
+		t.Fatal("SetKey failed")
+	}
+	tab.Close()
+
+	records, ok := store.records[common.Bytes2Hex(subnet)]
+	if !ok {
+		t.Fatalf("subnet records not flushed, store has %v", store.records)
+	}
+	if want := map[string]string{common.Bytes2Hex(id[:]): url}; !reflect.DeepEqual(records, want) {
+		t.Errorf("flushed records mismatch:\nhave %v\nwant %v", records, want)
+	}
+}
+
+func TestTable_SetKeyEvictsOldest(t *testing.T) {
+	tab, _, _ := newTestUDP(t)
+	defer tab.Close()
+	tab.maxKeyValues = 3
+
+	subnet := []byte{0x23, 0x84}
+	var ids []NodeID
+	for i := 0; i < 5; i++ {
+		id := PubkeyID(&newkey().PublicKey)
+		url := fmt.Sprintf("enode://%s@10.0.1.%d:30303", id, i+1)
+		if !tab.SetKey(subnet, []byte(url), id) {
+			t.Fatalf("SetKey %d failed", i)
+		}
+		ids = append(ids, id)
+	}
+	values, err := tab.GetKey(subnet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(values) != tab.maxKeyValues {
+		t.Fatalf("wrong number of values: have %d, want %d", len(values), tab.maxKeyValues)
+	}
+	for i, id := range ids {
+		_, ok := values[common.Bytes2Hex(id[:])]
+		if evicted := i < len(ids)-tab.maxKeyValues; ok == evicted {
+			t.Errorf("value %d: stored %t, want %t", i, ok, !evicted)
+		}
+	}
+}
+
+func TestUDP_oversizedNeighbors(t *testing.T) {
+	tab, udp, pipe := newTestUDP(t)
+	defer tab.Close()
+
+	remotekey := newkey()
+	remoteID := PubkeyID(&remotekey.PublicKey)
+	remoteaddr := &net.UDPAddr{IP: net.IP{10, 0, 6, 1}, Port: 30303}
+
+	type result struct {
+		nodes []*Node
+		err   error
+	}
+	resc := make(chan result, 1)
+	go func() {
+		nodes, err := udp.findnode(remoteID, remoteaddr, NodeID{}, false)
+		resc <- result{nodes, err}
+	}()
+	pipe.waitPacketOut()
+
+	req := &neighbors{Expiration: futureExp}
+	for i := 0; i <= maxNeighbors; i++ {
+		n := NewNode(PubkeyID(&newkey().PublicKey), net.IP{10, 0, 6, byte(i + 10)}, 30303, 30303, nil, nil, false, nil)
+		req.Nodes = append(req.Nodes, nodeToRPC(n))
+	}
+	enc, err := encodePacket(remotekey, NEIGHBORSPACKET, req)
+	if err != nil {
+		t.Fatalf("packet encode error: %v", err)
+	}
+	if err := udp.handlePacket(remoteaddr, enc); err != errTooManyNeighbors {
+		t.Fatalf("wrong error: got %v, want %v", err, errTooManyNeighbors)
+	}
+	if score := tab.Score(remoteID); score >= 0 {
+		t.Errorf("sender not scored down, score %d", score)
+	}
+
+	res := <-resc
+	if res.err != errTimeout {
+		t.Errorf("findnode error mismatch: got %v, want %v", res.err, errTimeout)
+	}
+	if len(res.nodes) != 0 {
+		t.Errorf("lookup results updated from oversized packet: %d nodes", len(res.nodes))
+	}
+}
+
+func TestUDP_decodeOversizedHeader(t *testing.T) {
+	// A neighbors packet whose list header claims 64KB of content. The
+	// signature is garbage, the packet must be rejected before the sender
+	// is recovered.
+	content := []byte{NEIGHBORSPACKET, 0xf9, 0xff, 0xff, 0xc0}
+	buf := append(make([]byte, headSize), content...)
+	copy(buf, crypto.Keccak256(buf[macSize:]))
+
+	p, fromID, _, err := decodePacket(buf)
+	if err != errPacketTooLarge {
+		t.Fatalf("wrong error: got %v, want %v", err, errPacketTooLarge)
+	}
+	if p != nil || fromID != (NodeID{}) {
+		t.Errorf("oversized packet decoded: %v from %x", p, fromID[:8])
+	}
+}
+
+func TestUDP_Ban(t *testing.T) {
+	tab, udp, pipe := newTestUDP(t)
+	defer udp.close()
+
+	key := newkey()
+	n := NewNode(PubkeyID(&key.PublicKey), net.IP{10, 0, 7, 1}, 30303, 30303, nil, nil, false, nil)
+	tab.SetNodeType(n.ID, BrotherNode)
+	tab.mutex.Lock()
+	tab.stuff([]*Node{n})
+	tab.mutex.Unlock()
+
+	contains := func() bool {
+		tab.mutex.Lock()
+		defer tab.mutex.Unlock()
+		for _, e := range tab.closest(n.sha, bucketSize, AllExceptAlien).entries {
+			if e.ID == n.ID {
+				return true
+			}
+		}
+		return false
+	}
+	if !contains() {
+		t.Fatal("node missing from closest before ban")
+	}
+
+	// Queue a request waiting for a reply of the node.
+	result := make(chan error, 1)
+	go func() {
+		_, err := udp.findnode(n.ID, n.addr(), testTarget, false)
+		result <- err
+	}()
+	pipe.waitPacketOut()
+
+	udp.Ban(n.ID)
+	select {
+	case err := <-result:
+		if err != errBanned {
+			t.Errorf("wrong error for pending request: got %v, want %v", err, errBanned)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("pending request not dropped")
+	}
+	if contains() {
+		t.Error("banned node still returned by closest")
+	}
+	if tab.GetNodeType(n.ID) != AlienNode {
+		t.Errorf("banned node has type %d, want alien", tab.GetNodeType(n.ID))
+	}
+	enc, err := encodePacket(key, PONGPACKET, &pong{Expiration: futureExp})
+	if err != nil {
+		t.Fatalf("packet encode error: %v", err)
+	}
+	if err := udp.handlePacket(n.addr(), enc); err == nil || err == errUnsolicitedReply {
+		t.Errorf("packet from banned node handled: %v", err)
+	}
+}
+
+func TestUDP_cancelBond(t *testing.T) {
+	for _, cancel := range []string{"alien", "CancelBond"} {
+		t.Run(cancel, func(t *testing.T) {
+			tab, udp, pipe := newTestUDP(t)
+			defer udp.close()
+
+			// Hold all bonding slots so the bond blocks before pinging.
+			for i := 0; i < cap(tab.bondslots); i++ {
+				<-tab.bondslots
+			}
+			key := newkey()
+			remote := PubkeyID(&key.PublicKey)
+			// A known node with findnode failures, which a bond would
+			// add to the table and reset.
+			tab.db.updateNode(NewNode(remote, net.IP{10, 0, 1, 99}, 30303, 30303, nil, nil, false, nil))
+			tab.db.updateFindFails(remote, 1)
+			result := make(chan error, 1)
+			go func() {
+				_, err := udp.bond(false, remote, &net.UDPAddr{IP: net.IP{10, 0, 1, 99}, Port: 30303}, 30303)
+				result <- err
+			}()
+			for i := 0; ; i++ {
+				udp.bondmu.Lock()
+				started := udp.bonding[remote] != nil
+				udp.bondmu.Unlock()
+				if started {
+					break
+				}
+				if i > 100 {
+					t.Fatal("bond not started")
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+
+			if cancel == "alien" {
+				tab.SetNodeType(remote, AlienNode)
+			} else {
+				udp.CancelBond(remote)
+			}
+			for i := 0; i < cap(tab.bondslots); i++ {
+				tab.bondslots <- struct{}{}
+			}
+
+			select {
+			case err := <-result:
+				if err != errBondCancelled {
+					t.Errorf("wrong bond error: have %v, want %v", err, errBondCancelled)
+				}
+			case <-time.After(time.Second):
+				t.Fatal("bond did not return")
+			}
+			pipe.mu.Lock()
+			sent := len(pipe.queue)
+			pipe.mu.Unlock()
+			if sent != 0 {
+				t.Errorf("cancelled bond sent %d packets", sent)
+			}
+			tab.mutex.Lock()
+			_, added := tab.nodeBucket[remote]
+			tab.mutex.Unlock()
+			if added {
+				t.Error("cancelled bond added the node to the table")
+			}
+			if fails := tab.db.findFails(remote); fails != 1 {
+				t.Errorf("cancelled bond reset findnode failures: have %d, want 1", fails)
+			}
+		})
+	}
+}
+
+// This test is meant to be run with -race, reading the endpoint while it is
+// updated must not race.
+func TestUDP_ourEndpointConcurrent(t *testing.T) {
+	tab, udp, _ := newTestUDP(t)
+	defer udp.close()
+	udp.setOurEndpoint(makeEndpoint(&net.UDPAddr{IP: net.IP{10, 0, 0, 1}, Port: 30303}, 30303))
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				if ep := udp.getOurEndpoint(); ep.UDP < 30303 {
+					t.Errorf("bad endpoint %v", ep)
+				}
+				tab.GetOurEndpoint()
+			}
+		}()
+	}
+	for port := 30304; port < 30403; port++ {
+		udp.setOurEndpoint(makeEndpoint(&net.UDPAddr{IP: net.IP{10, 0, 0, 1}, Port: port}, uint16(port)))
+	}
+	close(stop)
+	wg.Wait()
+
+	if ep := udp.getOurEndpoint(); ep.UDP != 30402 {
+		t.Errorf("endpoint not updated: have port %d, want %d", ep.UDP, 30402)
+	}
+}
+
+// stuckConn is a conn whose writes block until the write deadline passes,
+// like a socket with a full send buffer.
+type stuckConn struct {
+	mu       sync.Mutex
+	deadline time.Time
+	closing  chan struct{}
+}
+
+// timeoutError is the net.Error returned by writes past the deadline.
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func (c *stuckConn) SetWriteDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.deadline = t
+	return nil
+}
+
+func (c *stuckConn) WriteToUDP(b []byte, to *net.UDPAddr) (int, error) {
+	c.mu.Lock()
+	deadline := c.deadline
+	c.mu.Unlock()
+	if deadline.IsZero() {
+		<-c.closing
+		return 0, errors.New("closed")
+	}
+	time.Sleep(time.Until(deadline))
+	return 0, timeoutError{}
+}
+
+func (c *stuckConn) ReadFromUDP(b []byte) (int, *net.UDPAddr, error) {
+	<-c.closing
+	return 0, nil, io.EOF
+}
+
+func (c *stuckConn) Close() error {
+	close(c.closing)
+	return nil
+}
+
+func (c *stuckConn) LocalAddr() net.Addr {
+	return &net.UDPAddr{IP: testLocal.IP, Port: int(testLocal.UDP)}
+}
+
+func TestUDP_writeTimeout(t *testing.T) {
+	timeout := 50 * time.Millisecond
+	tab, udp, err := newUDP(newkey(), &stuckConn{closing: make(chan struct{})}, nil, "", nil, 99, false, Config{WriteTimeout: timeout})
+	if err != nil {
+		t.Fatalf("can't create udp transport: %v", err)
+	}
+	defer tab.Close()
+
+	start := time.Now()
+	errc := make(chan error, 1)
+	go func() {
+		errc <- udp.send(NodeID{1}, &net.UDPAddr{IP: net.IP{10, 0, 1, 99}, Port: 30303}, PINGPACKET, &ping{
+			Version:    Version,
+			From:       testLocal,
+			To:         testRemote,
+			Expiration: futureExp,
+		})
+	}()
+	select {
+	case err := <-errc:
+		if err != errWriteTimeout {
+			t.Errorf("wrong error: have %v, want %v", err, errWriteTimeout)
+		}
+		if elapsed := time.Since(start); elapsed < timeout {
+			t.Errorf("send returned after %v, before the deadline", elapsed)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("send blocked past the write deadline")
+	}
+}
+
+// dgramPipe is a fake UDP socket. It queues all sent datagrams.
+type dgramPipe struct {
+	mu      *sync.Mutex
+	cond    *sync.Cond
+	closing chan struct{}
+	closed  bool
+	queue   [][]byte
+}
+
+func newpipe() *dgramPipe {
+	mu := new(sync.Mutex)
+	return &dgramPipe{
+		closing: make(chan struct{}),
+		cond:    &sync.Cond{L: mu},
+		mu:      mu,
+	}
+}
+
+// WriteToUDP queues a datagram.
+func (c *dgramPipe) WriteToUDP(b []byte, to *net.UDPAddr) (n int, err error) {
+	msg := make([]byte, len(b))
+	copy(msg, b)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return 0, errors.New("closed")
+	}
+	c.queue = append(c.queue, msg)
+	c.cond.Signal()
+	return len(b), nil
+}
+
+// ReadFromUDP just hangs until the pipe is closed.
+func (c *dgramPipe) ReadFromUDP(b []byte) (n int, addr *net.UDPAddr, err error) {
+	<-c.closing
+	return 0, nil, io.EOF
+}
+
+func (c *dgramPipe) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.closed {
+		close(c.closing)
+		c.closed = true
+	}
+	return nil
+}
+
+func (c *dgramPipe) LocalAddr() net.Addr {
+	return &net.UDPAddr{IP: testLocal.IP, Port: int(testLocal.UDP)}
+}
+
+func (c *dgramPipe) waitPacketOut() []byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for len(c.queue) == 0 {
+		c.cond.Wait()
+	}
+	p := c.queue[0]
+	copy(c.queue, c.queue[1:])
+	c.queue = c.queue[:len(c.queue)-1]
+	return p
+}
+
+func TestUDP_neighborsBeneficiary(t *testing.T) {
+	serverTab, server, serverPipe := newTestUDP(t)
+	defer serverTab.Close()
+	clientTab, client, clientPipe := newTestUDP(t)
+	defer clientTab.Close()
+
+	payout := common.HexToAddress("0x00000000000000000000000000000000000000b1")
+	paid := NewNode(PubkeyID(&newkey().PublicKey), net.IP{10, 0, 7, 10}, 30303, 30303, &payout, nil, false, nil)
+	plain := NewNode(PubkeyID(&newkey().PublicKey), net.IP{10, 0, 7, 11}, 30303, 30303, nil, nil, false, nil)
+	for _, n := range []*Node{paid, plain} {
+		serverTab.SetNodeType(n.ID, BrotherNode)
+	}
+	serverTab.mutex.Lock()
+	serverTab.stuff([]*Node{paid, plain})
+	serverTab.mutex.Unlock()
+
+	serverAddr := &net.UDPAddr{IP: net.IP{10, 0, 7, 1}, Port: 30303}
+	clientAddr := &net.UDPAddr{IP: net.IP{10, 0, 7, 2}, Port: 30303}
+	serverTab.db.updateNode(NewNode(client.Self().ID, clientAddr.IP, uint16(clientAddr.Port), 30303, nil, nil, false, nil))
+	serverTab.db.updateLastPong(client.Self().ID, time.Now())
+
+	done := make(chan struct{})
+	go func() {
+		client.findnode(server.Self().ID, serverAddr, NodeID{}, false)
+		close(done)
+	}()
+	clientPipe.waitPacketOut()
+
+	req := &findnode{Target: NodeID{}, Expiration: futureExp}
+	if err := req.handle(server, clientAddr, client.Self().ID, nil); err != nil {
+		t.Fatalf("findnode failed: %v", err)
+	}
+	reply := serverPipe.waitPacketOut()
+	p, _, _, err := decodePacket(reply)
+	if err != nil {
+		t.Fatalf("sent packet decode error: %v", err)
+	}
+	exts := p.(*neighbors).nodeExts()
+	if len(exts) != 1 || exts[paid.ID].ID != paid.ID {
+		t.Fatalf("wrong node attributes sent: %v", exts)
+	}
+	if err := client.handlePacket(serverAddr, reply); err != nil {
+		t.Fatalf("neighbors handling failed: %v", err)
+	}
+	<-done
+
+	found := client.NodesWithBeneficiary(payout)
+	if len(found) != 1 || found[0].ID != paid.ID {
+		t.Fatalf("wrong nodes with beneficiary: got %v, want %v", found, paid)
+	}
+	if addr := found[0].GetBeneficialAddress(); addr == nil || *addr != payout {
+		t.Errorf("beneficial address mismatch: got %v, want %x", addr, payout)
+	}
+	if found := client.NodesWithBeneficiary(common.Address{}); len(found) != 0 {
+		t.Errorf("nodes found for unknown beneficiary: %v", found)
+	}
+}
+
+func TestNeighbors_nodeExtsMalformedEntry(t *testing.T) {
+	good1 := rpcNodeExt{ID: NodeID{1}, BeneficialAddress: common.HexToAddress("0x1234").Bytes()}
+	good2 := rpcNodeExt{ID: NodeID{2}, ShowToPublic: true, ServiceCfg: "30310"}
+	enc := func(v interface{}) rlp.RawValue {
+		b, err := rlp.EncodeToBytes(v)
+		if err != nil {
+			t.Fatalf("can't encode %v: %v", v, err)
+		}
+		return b
+	}
+	// The middle entry is a string instead of a list.
+	list := enc([]rlp.RawValue{enc(good1), enc("malformed"), enc(good2)})
+
+	exts := (&neighbors{Rest: []rlp.RawValue{list}}).nodeExts()
+	if len(exts) != 2 {
+		t.Fatalf("wrong number of attributes: have %d, want 2: %v", len(exts), exts)
+	}
+	if ext := exts[good1.ID]; !bytes.Equal(ext.BeneficialAddress, good1.BeneficialAddress) {
+		t.Errorf("first entry mismatch: have %v", ext)
+	}
+	if ext := exts[good2.ID]; ext.ServiceCfg != good2.ServiceCfg || !ext.ShowToPublic {
+		t.Errorf("last entry mismatch: have %v", ext)
+	}
+}
+
+func TestUDP_advertisedBounded(t *testing.T) {
+	tab, udp, _ := newTestUDP(t)
+	defer tab.Close()
+
+	sender := &net.UDPAddr{IP: net.IP{10, 0, 9, 1}, Port: 30303}
+	payout := common.HexToAddress("0x1234")
+	var ids []NodeID
+	for i := 0; i < maxTrackedNodes+10; i++ {
+		rn := rpcNode{
+			ID:                PubkeyID(&newkey().PublicKey),
+			IP:                net.IP{10, 0, byte(10 + i/256), byte(i)},
+			UDP:               30303,
+			TCP:               30303,
+			beneficialAddress: &payout,
+		}
+		if _, err := udp.nodeFromRPC(sender, rn); err != nil {
+			t.Fatalf("node %d rejected: %v", i, err)
+		}
+		ids = append(ids, rn.ID)
+	}
+	if n := udp.advertised.Len(); n != maxTrackedNodes {
+		t.Fatalf("advertised nodes: have %d, want %d", n, maxTrackedNodes)
+	}
+	if udp.advertised.Contains(ids[0]) {
+		t.Error("oldest advertised node not evicted")
+	}
+	if !udp.advertised.Contains(ids[len(ids)-1]) {
+		t.Error("newest advertised node missing")
+	}
+}
+
+func TestUDP_neighborsServiceCfg(t *testing.T) {
+	tab, udp, pipe := newTestUDP(t)
+	defer tab.Close()
+
+	publicCfg, privateCfg := "30310", "30311"
+	public := NewNode(PubkeyID(&newkey().PublicKey), net.IP{10, 0, 8, 10}, 30303, 30303, nil, &publicCfg, true, nil)
+	private := NewNode(PubkeyID(&newkey().PublicKey), net.IP{10, 0, 8, 11}, 30303, 30303, nil, &privateCfg, false, nil)
+	for _, n := range []*Node{public, private} {
+		tab.SetNodeType(n.ID, BrotherNode)
+	}
+	tab.mutex.Lock()
+	tab.stuff([]*Node{public, private})
+	tab.mutex.Unlock()
+
+	fromID := NodeID{1}
+	from := &net.UDPAddr{IP: net.IP{10, 0, 8, 1}, Port: 30303}
+	tab.db.updateNode(NewNode(fromID, from.IP, uint16(from.Port), 30303, nil, nil, false, nil))
+	tab.db.updateLastPong(fromID, time.Now())
+
+	req := &findnode{Target: NodeID{}, Expiration: futureExp}
+	if err := req.handle(udp, from, fromID, nil); err != nil {
+		t.Fatalf("findnode failed: %v", err)
+	}
+	p, _, _, err := decodePacket(pipe.waitPacketOut())
+	if err != nil {
+		t.Fatalf("sent packet decode error: %v", err)
+	}
+	reply := p.(*neighbors)
+	if bytes.Contains(reply.Rest[0], []byte(privateCfg)) {
+		t.Errorf("service config of private node leaked")
+	}
+	exts := reply.nodeExts()
+	if _, ok := exts[private.ID]; ok {
+		t.Errorf("attributes sent for private node: %v", exts[private.ID])
+	}
+
+	for _, rn := range reply.Nodes {
+		rn.applyExt(exts[rn.ID])
+		n, err := udp.nodeFromRPC(from, rn)
+		if err != nil {
+			t.Fatalf("invalid neighbor %x: %v", rn.ID[:8], err)
+		}
+		switch n.ID {
+		case public.ID:
+			if !n.IsShowToPublic() || n.GetServiceCfg() == nil || *n.GetServiceCfg() != publicCfg {
+				t.Errorf("public node service config not propagated: %v", n.GetServiceCfg())
+			}
+		case private.ID:
+			if n.IsShowToPublic() || n.GetServiceCfg() != nil {
+				t.Errorf("private node service config propagated: %v", *n.GetServiceCfg())
+			}
+		}
+	}
+}
+
+func TestTable_reapIdleNode(t *testing.T) {
+	tab, _, _ := newTestUDP(t)
+	defer tab.Close()
+	tab.reapFailures = 2
+
+	// The pipe never delivers replies, so every ping fails.
+	idle := NewNode(PubkeyID(&newkey().PublicKey), net.IP{10, 0, 9, 10}, 30303, 30303, nil, nil, false, nil)
+	tab.mutex.Lock()
+	tab.stuff([]*Node{idle})
+	tab.mutex.Unlock()
+
+	inTable := func() bool {
+		tab.mutex.Lock()
+		defer tab.mutex.Unlock()
+		_, found := tab.nodeBucket[idle.ID]
+		return found
+	}
+	tab.reap()
+	if !inTable() {
+		t.Fatal("node evicted before reaching the failure threshold")
+	}
+	tab.reap()
+	if inTable() {
+		t.Fatal("node not evicted after reaching the failure threshold")
+	}
+	if _, ok := tab.reapFails[idle.ID]; ok {
+		t.Error("failure count of evicted node not cleared")
+	}
+}
+
+func TestTable_deleteClearsReapFailures(t *testing.T) {
+	tab, _, _ := newTestUDP(t)
+	defer tab.Close()
+	tab.reapFailures = 2
+
+	idle := NewNode(PubkeyID(&newkey().PublicKey), net.IP{10, 0, 9, 11}, 30303, 30303, nil, nil, false, nil)
+	tab.mutex.Lock()
+	tab.stuff([]*Node{idle})
+	tab.mutex.Unlock()
+
+	tab.reap()
+	tab.mutex.Lock()
+	fails := tab.reapFails[idle.ID]
+	tab.mutex.Unlock()
+	if fails != 1 {
+		t.Fatalf("failure count: have %d, want 1", fails)
+	}
+	tab.DeleteWithNodeId(idle.ID)
+	tab.mutex.Lock()
+	_, ok := tab.reapFails[idle.ID]
+	tab.mutex.Unlock()
+	if ok {
+		t.Error("failure count of deleted node not cleared")
+	}
+}
+
+func TestTable_brotherEvictsAlien(t *testing.T) {
+	tab, udp, _ := newTestUDP(t)
+	defer tab.Close()
+
+	// Fill one bucket with alien nodes, the least recently active one last.
+	var aliens []*Node
+	for i := 0; i < bucketSize; i++ {
+		n := nodeAtDistance(tab.Self().sha, 250)
+		tab.SetNodeType(n.ID, AlienNode)
+		aliens = append(aliens, n)
+	}
+	evicted := aliens[bucketSize-1]
+	tab.mutex.Lock()
+	tab.stuff(aliens)
+	tab.reapFails[evicted.ID] = 1
+	total := tab.totalNodes
+	tab.mutex.Unlock()
+	udp.rtts.Add(evicted.ID, time.Millisecond)
+
+	brother := nodeAtDistance(tab.Self().sha, 250)
+	tab.SetNodeType(brother.ID, BrotherNode)
+	tab.add(brother)
+
+	tab.mutex.Lock()
+	defer tab.mutex.Unlock()
+	b := tab.buckets[250]
+	if len(b.entries) != bucketSize {
+		t.Fatalf("bucket size %d, want %d", len(b.entries), bucketSize)
+	}
+	if b.entries[0].ID != brother.ID {
+		t.Fatal("brother node not added to the full bucket")
+	}
+	for _, n := range b.entries {
+		if n.ID == evicted.ID {
+			t.Fatal("least recently active alien node not evicted")
+		}
+	}
+	if _, ok := tab.nodeBucket[evicted.ID]; ok {
+		t.Error("evicted node still indexed")
+	}
+	if _, ok := tab.reapFails[evicted.ID]; ok {
+		t.Error("reaper failures of the evicted node kept")
+	}
+	if udp.rtts.Contains(evicted.ID) {
+		t.Error("RTT of the evicted node kept")
+	}
+	if tab.totalNodes != total {
+		t.Errorf("node count changed by the eviction: have %d, want %d", tab.totalNodes, total)
+	}
+}
+
+// lookupConn is a pipe holding back findnode packets until released. It
+// records the targets of the lookups which sent them.
+type lookupConn struct {
+	*dgramPipe
+	release chan struct{}
+
+	mu      sync.Mutex
+	targets map[NodeID]bool
+}
+
+func (c *lookupConn) WriteToUDP(b []byte, to *net.UDPAddr) (int, error) {
+	if p, _, _, err := decodePacket(b); err == nil {
+		if req, ok := p.(*findnode); ok {
+			c.mu.Lock()
+			c.targets[req.Target] = true
+			c.mu.Unlock()
+			<-c.release
+		}
+	}
+	return c.dgramPipe.WriteToUDP(b, to)
+}
+
+func (c *lookupConn) lookups() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.targets)
+}
+
+func TestTable_lookupLimit(t *testing.T) {
+	const limit = 2
+	conn := &lookupConn{dgramPipe: newpipe(), release: make(chan struct{}), targets: make(map[NodeID]bool)}
+	tab, _, err := newUDP(newkey(), conn, nil, "", nil, 99, false, Config{MaxConcurrentLookups: limit})
+	if err != nil {
+		t.Fatalf("can't create udp transport: %v", err)
+	}
+	defer tab.Close()
+	var once sync.Once
+	release := func() { once.Do(func() { close(conn.release) }) }
+	defer release()
+
+	var nodes []*Node
+	for i := 0; i < 8; i++ {
+		n := NewNode(PubkeyID(&newkey().PublicKey), net.IP{10, 0, 10, byte(i)}, 30303, 30303, nil, nil, false, nil)
+		tab.SetNodeType(n.ID, BrotherNode)
+		nodes = append(nodes, n)
+	}
+	tab.mutex.Lock()
+	tab.stuff(nodes)
+	tab.mutex.Unlock()
+
+	const total = 5
+	var wg sync.WaitGroup
+	for i := 0; i < total; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			tab.Lookup(PubkeyID(&newkey().PublicKey), i, false)
+		}(i)
+	}
+	// Wait for the lookups holding a slot, then give the others a chance
+	// to exceed the limit.
+	deadline := time.Now().Add(time.Second)
+	for conn.lookups() < limit && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if n := conn.lookups(); n != limit {
+		t.Fatalf("concurrent lookups sending findnode: have %d, want %d", n, limit)
+	}
+	if n := tab.LookupsInFlight(); n != limit {
+		t.Fatalf("lookups in flight: have %d, want %d", n, limit)
+	}
+
+	release()
+	wg.Wait()
+	if n := conn.lookups(); n != total {
+		t.Errorf("queued lookups not run: have %d targets, want %d", n, total)
+	}
+	if n := tab.LookupsInFlight(); n != 0 {
+		t.Errorf("lookups in flight after completion: %d", n)
+	}
+}
+
+func TestTable_lookupLimitEmptyTable(t *testing.T) {
+	tab, _, _ := newTestUDPConfig(t, Config{MaxConcurrentLookups: 1})
+	defer tab.Close()
+
+	// The lookup waits for the refresh of the empty table, which runs
+	// lookups of its own under the same limit.
+	done := make(chan []*Node, 1)
+	go func() { done <- tab.Lookup(PubkeyID(&newkey().PublicKey), 1, false) }()
+	select {
+	case nodes := <-done:
+		if len(nodes) != 0 {
+			t.Errorf("lookup on empty table found %d nodes", len(nodes))
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("lookup on empty table deadlocked")
+	}
+	if n := tab.LookupsInFlight(); n != 0 {
+		t.Errorf("lookups in flight after completion: %d", n)
+	}
+}
+
+// findnodePackets counts the findnode packets sent through the pipe.
+func (c *dgramPipe) findnodePackets() (n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, b := range c.queue {
+		if p, _, _, err := decodePacket(b); err == nil {
+			if _, ok := p.(*findnode); ok {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+func TestTable_lookupCache(t *testing.T) {
+	ttl := time.Minute
+	tab, udp, pipe := newTestUDPConfig(t, Config{LookupCacheTTL: &ttl})
+	defer udp.close()
+
+	var nodes []*Node
+	for i := 0; i < 3; i++ {
+		n := NewNode(PubkeyID(&newkey().PublicKey), net.IP{10, 0, 11, byte(i)}, 30303, 30303, nil, nil, false, nil)
+		tab.SetNodeType(n.ID, BrotherNode)
+		nodes = append(nodes, n)
+	}
+	tab.mutex.Lock()
+	tab.stuff(nodes)
+	tab.mutex.Unlock()
+
+	target := PubkeyID(&newkey().PublicKey)
+	first := tab.Lookup(target, 1, false)
+	if len(first) == 0 {
+		t.Fatal("first lookup found no nodes")
+	}
+	sent := pipe.findnodePackets()
+	if sent == 0 {
+		t.Fatal("first lookup sent no findnode packets")
+	}
+
+	second := tab.Lookup(target, 2, false)
+	if n := pipe.findnodePackets(); n != sent {
+		t.Fatalf("cached lookup sent findnode packets: have %d, want %d", n, sent)
+	}
+	if len(second) != len(first) {
+		t.Fatalf("cached result mismatch: have %d nodes, want %d", len(second), len(first))
+	}
+	for i := range first {
+		if second[i].ID != first[i].ID {
+			t.Errorf("cached result mismatch at %d: have %x, want %x", i, second[i].ID[:8], first[i].ID[:8])
+		}
+	}
+
+	// Banning a cached node invalidates the result.
+	udp.Ban(first[0].ID)
+	third := tab.Lookup(target, 3, false)
+	if n := pipe.findnodePackets(); n == sent {
+		t.Error("lookup after ban served from cache")
+	}
+	for _, n := range third {
+		if n.ID == first[0].ID {
+			t.Error("banned node returned by lookup")
+		}
+	}
+
+	// Refresh lookups always reach the network.
+	sent = pipe.findnodePackets()
+	tab.lookup(target, false, 0, false, false)
+	if n := pipe.findnodePackets(); n == sent {
+		t.Error("refresh lookup served from cache")
+	}
+}
+
+func TestUDP_health(t *testing.T) {
+	tab, udp, _ := newTestUDP(t)
+	defer tab.Close()
+
+	if h := udp.Health(); h.Healthy() || h.BucketFill != 0 || !h.LastLookup.IsZero() || h.TimeoutRate != 0 {
+		t.Fatalf("health of empty table: %+v", h)
+	}
+
+	var nodes []*Node
+	for i := 0; i < 4; i++ {
+		n := NewNode(PubkeyID(&newkey().PublicKey), net.IP{10, 0, 11, byte(i)}, 30303, 30303, nil, nil, false, nil)
+		if i == 0 {
+			tab.SetNodeType(n.ID, UncleNode)
+		} else {
+			tab.SetNodeType(n.ID, BrotherNode)
+		}
+		nodes = append(nodes, n)
+	}
+	tab.mutex.Lock()
+	tab.stuff(nodes)
+	tab.mutex.Unlock()
+
+	h := udp.Health()
+	if !h.Healthy() || h.BrotherNodes != 3 {
+		t.Errorf("brother nodes: have %d, want 3", h.BrotherNodes)
+	}
+	if want := float64(len(nodes)) / float64(nBuckets*bucketSize); h.BucketFill != want {
+		t.Errorf("bucket fill: have %v, want %v", h.BucketFill, want)
+	}
+
+	// None of the nodes answer findnode, the lookup still finds the
+	// local nodes while all of its requests time out. Such a lookup
+	// doesn't count as a successful one.
+	if found := udp.LookupRanked(PubkeyID(&newkey().PublicKey)); len(found) == 0 {
+		t.Fatal("lookup found no local nodes")
+	}
+	h = udp.Health()
+	if !h.LastLookup.IsZero() {
+		t.Errorf("last lookup updated without any reply: %v", h.LastLookup)
+	}
+	if h.TimeoutRate != 1 {
+		t.Errorf("timeout rate: have %v, want 1", h.TimeoutRate)
+	}
+
+	udp.addPending(udp.nextReqID(), nodes[1].ID, PONGPACKET, func(interface{}) bool { return true })
+	if h := udp.Health(); h.Pending != 1 {
+		t.Errorf("pending: have %d, want 1", h.Pending)
+	}
+}
+
+func TestNodesByDistance_pushTieBreak(t *testing.T) {
+	target := common.Hash{0x01}
+	sha := common.Hash{0x80}
+
+	// All candidates share the hash, so they are at the same distance.
+	var nodes []*Node
+	for i := 0; i < 6; i++ {
+		n := NewNode(PubkeyID(&newkey().PublicKey), net.IP{10, 0, 12, byte(i)}, 30303, 30303, nil, nil, false, nil)
+		n.sha = sha
+		nodes = append(nodes, n)
+	}
+	var first []NodeID
+	for round := 0; round < 10; round++ {
+		h := &NodesByDistance{Target: target}
+		for _, i := range rand.Perm(len(nodes)) {
+			h.Push(nodes[i], 4)
+		}
+		var ids []NodeID
+		for _, n := range h.entries {
+			ids = append(ids, n.ID)
+		}
+		if round == 0 {
+			first = ids
+			continue
+		}
+		if !reflect.DeepEqual(ids, first) {
+			t.Fatalf("round %d: targets changed:\ngot  %x\nwant %x", round, ids, first)
+		}
+	}
+	for i := 1; i < len(first); i++ {
+		if bytes.Compare(first[i-1][:], first[i][:]) >= 0 {
+			t.Errorf("targets %d and %d not ordered by node id", i-1, i)
+		}
+	}
+}
+
+func TestUDP_PendingReplies(t *testing.T) {
+	tab, udp, _ := newTestUDP(t)
+	defer tab.Close()
+
+	if infos := udp.PendingReplies(); len(infos) != 0 {
+		t.Fatalf("pending replies of idle transport: %+v", infos)
+	}
+	pinged, asked := NodeID{1}, NodeID{2}
+	udp.addPending(udp.nextReqID(), pinged, PONGPACKET, func(interface{}) bool { return true })
+	udp.addPending(udp.nextReqID(), asked, NEIGHBORSPACKET, func(interface{}) bool { return true })
+
+	infos := udp.PendingReplies()
+	if len(infos) != 2 {
+		t.Fatalf("wrong number of pending replies: got %d, want 2", len(infos))
+	}
+	want := map[NodeID]byte{pinged: PONGPACKET, asked: NEIGHBORSPACKET}
+	for _, info := range infos {
+		if ptype, ok := want[info.From]; !ok || info.PType != ptype {
+			t.Errorf("unexpected pending reply: %+v", info)
+		}
+		if info.Remaining <= 0 || info.Remaining > maxRespTimeout {
+			t.Errorf("pending reply from %x: time to deadline %v out of range", info.From[:4], info.Remaining)
+		}
+		delete(want, info.From)
 	}
-	p := c.queue[0]
-	copy(c.queue, c.queue[1:])
-	c.queue = c.queue[:len(c.queue)-1]
-	return p
 }
@@ -62,7 +62,6 @@ const (
 	defaultBoostTickerLength               = 10
 	defaultBoostTickerInterval             = 3 * time.Second
 	defaultPersistBlacklistedNodesInterval = 1 * time.Minute
-	storeSubnetBootNodeLoopInterval        = discover.KvstoreCacheUpdateInterval
 )
 
 var errServerStopped = errors.New("server stopped")
@@ -113,6 +112,11 @@ type Config struct {
 	// allowed to connect, even above the peer limit.
 	TrustedNodes []*discover.Node
 
+	// DiscoveryTrustedNodes are never classified alien by the discovery
+	// protocol, even if they announce a different network id. Trusted
+	// nodes are treated the same way.
+	DiscoveryTrustedNodes []*discover.Node `toml:",omitempty"`
+
 	// Connectivity can be restricted to certain IP networks.
 	// If this option is set to a non-nil value, only hosts which match one of the
 	// IP networks contained in the list are considered.
@@ -163,8 +167,80 @@ type Config struct {
 	// Network id for this server
 	NetworkId uint64
 
+	// DiscoveryRefreshInterval is the time between discovery table
+	// refreshes. Zero uses the default.
+	DiscoveryRefreshInterval time.Duration `toml:",omitempty"`
+
+	// DiscoveryNetworkId overrides the network id used by the discovery
+	// protocol to classify brother nodes. Zero uses NetworkId.
+	DiscoveryNetworkId uint64 `toml:",omitempty"`
+
 	// If node type will need to be matched exactly between remote and this node
 	StrictNodeCheck bool
+
+	// DiscoveryAddr is the UDP address of the discovery protocol. ListenAddr
+	// is used if empty.
+	DiscoveryAddr string `toml:",omitempty"`
+
+	// SubnetDiscoveryPort is the first UDP port used for the discovery of
+	// subnet servers, each further subnet server takes the next port. Zero
+	// makes subnet servers discover on their random listen port.
+	SubnetDiscoveryPort int `toml:",omitempty"`
+
+	// NoSubnet disables handling of the subnet STORE/FINDVALUE discovery packets.
+	NoSubnet bool `toml:",omitempty"`
+
+	// BondExpiration is the age after which the bond with a discovery
+	// node is re-verified. Zero uses the default.
+	BondExpiration time.Duration `toml:",omitempty"`
+
+	// DiscoveryWriteTimeout bounds how long sending a discovery packet may
+	// block. Zero uses the default.
+	DiscoveryWriteTimeout time.Duration `toml:",omitempty"`
+
+	// DiscoveryPreferFresh makes discovery replies prefer recently seen
+	// nodes among equally close candidates.
+	DiscoveryPreferFresh bool `toml:",omitempty"`
+
+	// SubnetMaxValues caps the number of values stored under one subnet
+	// key, evicting the oldest beyond it. Zero uses the default.
+	SubnetMaxValues int `toml:",omitempty"`
+
+	// SubnetAnnounceInterval is the time between re-announcements of the
+	// subnet membership of this node. Zero uses the default.
+	SubnetAnnounceInterval time.Duration `toml:",omitempty"`
+
+	// DiscoveryReapInterval is the time between pings of the least recently
+	// seen node of every discovery table bucket. Zero disables the reaper,
+	// nil uses the default.
+	DiscoveryReapInterval *time.Duration `toml:",omitempty"`
+
+	// DiscoveryReapFailures is the number of consecutive failed pings after
+	// which an idle node is evicted from the discovery table. Zero uses the
+	// default.
+	DiscoveryReapFailures int `toml:",omitempty"`
+
+	// DiscoveryMaxLookups is the maximum number of discovery lookups running
+	// at the same time. Zero uses the default.
+	DiscoveryMaxLookups int `toml:",omitempty"`
+
+	// DiscoveryLookupCacheTTL is how long a discovery lookup result is
+	// reused for lookups of the same target. Zero disables the cache, nil
+	// uses the default.
+	DiscoveryLookupCacheTTL *time.Duration `toml:",omitempty"`
+
+	// DiscoveryAlienMismatches is the number of consecutive network id
+	// mismatches after which a discovery node is classified alien. Zero
+	// uses the default.
+	DiscoveryAlienMismatches int `toml:",omitempty"`
+
+	// DiscoveryAlienWindow is the time within which the network id
+	// mismatches have to happen. Zero uses the default.
+	DiscoveryAlienWindow time.Duration `toml:",omitempty"`
+
+	// DiscoveryMinVersion is the lowest discovery protocol version a node
+	// has to advertise to be answered. Zero accepts all versions.
+	DiscoveryMinVersion uint `toml:",omitempty"`
 }
 
 // Server manages all peer connections.
@@ -469,14 +545,39 @@ func (srv *Server) Start() (err error) {
 
 	// node table
 	if !srv.NoDiscovery {
-		discover.VnodeBeneficialAddress = srv.VnodeBeneficialAddress
-		discover.VnodeServiceCfg = srv.VnodeServiceCfg
-		discover.ShowToPublic = srv.ShowToPublic
-		discover.Ip = srv.Ip
+		discoveryCfg := discover.Config{
+			BeneficialAddress:      srv.VnodeBeneficialAddress,
+			ServiceCfg:             srv.VnodeServiceCfg,
+			ShowToPublic:           srv.ShowToPublic,
+			Ip:                     srv.Ip,
+			NoSubnet:               srv.NoSubnet,
+			MinPeerVersion:         srv.DiscoveryMinVersion,
+			BondExpiration:         srv.BondExpiration,
+			WriteTimeout:           srv.DiscoveryWriteTimeout,
+			RefreshInterval:        srv.DiscoveryRefreshInterval,
+			PreferFreshNeighbors:   srv.DiscoveryPreferFresh,
+			MaxSubnetValues:        srv.SubnetMaxValues,
+			SubnetAnnounceInterval: srv.SubnetAnnounceInterval,
+			ReapInterval:           srv.DiscoveryReapInterval,
+			ReapFailures:           srv.DiscoveryReapFailures,
+			MaxConcurrentLookups:   srv.DiscoveryMaxLookups,
+			LookupCacheTTL:         srv.DiscoveryLookupCacheTTL,
+			AlienMismatches:        srv.DiscoveryAlienMismatches,
+			AlienMismatchWindow:    srv.DiscoveryAlienWindow,
+		}
+		discoveryNetworkId := srv.NetworkId
+		if srv.DiscoveryNetworkId != 0 {
+			discoveryNetworkId = srv.DiscoveryNetworkId
+		}
+		discoveryAddr := srv.ListenAddr
+		if srv.DiscoveryAddr != "" {
+			discoveryAddr = srv.DiscoveryAddr
+		}
 		ntab, err := discover.ListenUDP(
-			srv.PrivateKey, srv.ListenAddr, srv.NAT,
+			srv.PrivateKey, discoveryAddr, srv.NAT,
 			srv.NodeDatabase, srv.NetRestrict,
-			srv.NetworkId, srv.StrictNodeCheck,
+			discoveryNetworkId, srv.StrictNodeCheck,
+			discoveryCfg,
 		)
 		if err != nil {
 			return err
@@ -484,6 +585,9 @@ func (srv *Server) Start() (err error) {
 		if err := ntab.SetFallbackNodes(srv.BootstrapNodes); err != nil {
 			return err
 		}
+		for _, n := range append(srv.TrustedNodes, srv.DiscoveryTrustedNodes...) {
+			ntab.AddTrustedNode(n.ID)
+		}
 		srv.ntab = ntab
 	}
 
@@ -668,7 +772,8 @@ func (srv *Server) retrieveSubnetBootNodeLoop() {
 
 // storeSubnetBootNodeLoop() keeps adding this node to other nodes' kvstore
 // for the related subnet id. Otherwise, value in kvstore will expire automatically
-// after some TTL
+// after some TTL. After the boost phase the discovery table re-announces the
+// membership until it is closed.
 func (srv *Server) storeSubnetBootNodeLoop() {
 	storeSubnetBootNode := func(_srv *Server) {
 		nodes, subnetID := _srv.lookupForSubnet(_srv.Subnet)
@@ -688,10 +793,11 @@ func (srv *Server) storeSubnetBootNodeLoop() {
 		}
 	}
 
-	ticker := time.NewTicker(storeSubnetBootNodeLoopInterval)
-	for ; true; <-ticker.C {
-		storeSubnetBootNode(srv)
-	}
+	subnetID := srv.SubnetBytesToNodeID(srv.Subnet)
+	srv.ntab.AnnounceSubnet(subnetID, func(discover.NodeID) []*discover.Node {
+		nodes, _ := srv.lookupForSubnet(srv.Subnet)
+		return nodes
+	})
 }
 
 func (srv *Server) startListening() error {
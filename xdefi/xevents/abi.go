@@ -0,0 +1,38 @@
+// Copyright 2021 The MOAC-core Authors
+// This file is part of the MOAC-core library.
+//
+// The MOAC-core library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The MOAC-core library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the MOAC-core library. If not, see <http://www.gnu.org/licenses/>.
+
+package xevents
+
+import (
+	"strings"
+
+	"github.com/MOACChain/MoacLib/common"
+	"github.com/MOACChain/xchain/accounts/abi"
+	"github.com/MOACChain/xchain/accounts/abi/bind"
+)
+
+// NewXEventsWithABI creates a new instance of XEvents bound to a deployed
+// contract whose ABI differs from the embedded XEventsABI, e.g. an older or
+// newer version of the contract. Methods and events missing from abiJSON fail
+// when used.
+func NewXEventsWithABI(address common.Address, backend bind.ContractBackend, abiJSON string) (*XEvents, error) {
+	parsed, err := abi.JSON(strings.NewReader(abiJSON))
+	if err != nil {
+		return nil, err
+	}
+	contract := bind.NewBoundContract(address, parsed, backend, backend, backend)
+	return &XEvents{XEventsCaller: XEventsCaller{contract: contract}, XEventsTransactor: XEventsTransactor{contract: contract}, XEventsFilterer: XEventsFilterer{contract: contract}}, nil
+}
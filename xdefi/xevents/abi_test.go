@@ -0,0 +1,56 @@
+// Copyright 2021 The MOAC-core Authors
+// This file is part of the MOAC-core library.
+//
+// The MOAC-core library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The MOAC-core library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the MOAC-core library. If not, see <http://www.gnu.org/licenses/>.
+
+package xevents
+
+import (
+	"testing"
+
+	"github.com/MOACChain/MoacLib/common"
+)
+
+// versionABI is the ABI of a contract version only exposing a version getter.
+const versionABI = `[{"inputs":[],"name":"version","outputs":[{"internalType":"string","name":"","type":"string"}],"stateMutability":"view","type":"function"}]`
+
+func TestNewXEventsWithABI(t *testing.T) {
+	backend := newCallBackendWithABI(t, versionABI, map[string]callHandler{"version": returns("v2")})
+
+	contract, err := NewXEventsWithABI(common.Address{}, backend, versionABI)
+	if err != nil {
+		t.Fatalf("failed to bind with custom ABI: %v", err)
+	}
+	var out []interface{}
+	raw := &XEventsRaw{Contract: contract}
+	if err := raw.Call(nil, &out, "version"); err != nil {
+		t.Fatalf("call failed: %v", err)
+	}
+	if len(out) != 1 || out[0].(string) != "v2" {
+		t.Errorf("version mismatch: have %v, want v2", out)
+	}
+
+	// The embedded ABI has no version method.
+	contract, err = NewXEvents(common.Address{}, backend)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw = &XEventsRaw{Contract: contract}
+	if err := raw.Call(nil, &out, "version"); err == nil {
+		t.Error("embedded ABI unpacked a method it doesn't have")
+	}
+	if _, err := NewXEventsWithABI(common.Address{}, backend, "not json"); err == nil {
+		t.Error("no error for invalid ABI")
+	}
+}
@@ -0,0 +1,86 @@
+// Copyright 2021 The MOAC-core Authors
+// This file is part of the MOAC-core library.
+//
+// The MOAC-core library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The MOAC-core library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the MOAC-core library. If not, see <http://www.gnu.org/licenses/>.
+
+package xevents
+
+import (
+	"bytes"
+	"errors"
+	"math/big"
+	"strings"
+	"sync"
+
+	"github.com/MOACChain/MoacLib/common"
+	"github.com/MOACChain/xchain/accounts/abi"
+)
+
+var errNotStoreCall = errors.New("calldata is not a store call")
+
+// StoreCall holds the arguments of a store call.
+type StoreCall struct {
+	Sig          []byte
+	Vault        common.Address
+	Nonce        *big.Int
+	TokenMapping [32]byte
+	BlockNumber  *big.Int
+	EventData    []byte
+}
+
+var (
+	parsedABIOnce sync.Once
+	parsedABI     abi.ABI
+	parsedABIErr  error
+)
+
+// xeventsABI returns the parsed XEventsABI.
+func xeventsABI() (abi.ABI, error) {
+	parsedABIOnce.Do(func() {
+		parsedABI, parsedABIErr = abi.JSON(strings.NewReader(XEventsABI))
+	})
+	return parsedABI, parsedABIErr
+}
+
+// PackStore encodes the calldata of a store call, including the method
+// selector.
+func PackStore(sig []byte, vault common.Address, nonce *big.Int, tokenMapping [32]byte, blockNumber *big.Int, eventData []byte) ([]byte, error) {
+	parsed, err := xeventsABI()
+	if err != nil {
+		return nil, err
+	}
+	return parsed.Pack("store", sig, vault, nonce, tokenMapping, blockNumber, eventData)
+}
+
+// UnpackStore decodes the calldata of a store call, e.g. the input of a store
+// transaction seen on chain.
+func UnpackStore(data []byte) (*StoreCall, error) {
+	parsed, err := xeventsABI()
+	if err != nil {
+		return nil, err
+	}
+	method := parsed.Methods["store"]
+	if len(data) < 4 || !bytes.Equal(data[:4], method.ID) {
+		return nil, errNotStoreCall
+	}
+	values, err := method.Inputs.Unpack(data[4:])
+	if err != nil {
+		return nil, err
+	}
+	call := new(StoreCall)
+	if err := method.Inputs.Copy(call, values); err != nil {
+		return nil, err
+	}
+	return call, nil
+}
@@ -0,0 +1,55 @@
+// Copyright 2021 The MOAC-core Authors
+// This file is part of the MOAC-core library.
+//
+// The MOAC-core library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The MOAC-core library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the MOAC-core library. If not, see <http://www.gnu.org/licenses/>.
+
+package xevents
+
+import (
+	"math/big"
+	"reflect"
+	"testing"
+
+	"github.com/MOACChain/MoacLib/common"
+)
+
+func TestStoreCalldataRoundTrip(t *testing.T) {
+	want := &StoreCall{
+		Sig:          []byte{0x01, 0x02, 0x03},
+		Vault:        common.HexToAddress("0x1234567890123456789012345678901234567890"),
+		Nonce:        big.NewInt(42),
+		TokenMapping: [32]byte{0xaa, 0xbb},
+		BlockNumber:  big.NewInt(1000),
+		EventData:    []byte("event data"),
+	}
+	data, err := PackStore(want.Sig, want.Vault, want.Nonce, want.TokenMapping, want.BlockNumber, want.EventData)
+	if err != nil {
+		t.Fatalf("can't pack store call: %v", err)
+	}
+	have, err := UnpackStore(data)
+	if err != nil {
+		t.Fatalf("can't unpack store call: %v", err)
+	}
+	if !reflect.DeepEqual(have, want) {
+		t.Errorf("store call mismatch:\nhave %+v\nwant %+v", have, want)
+	}
+
+	if _, err := UnpackStore(data[:3]); err != errNotStoreCall {
+		t.Errorf("short calldata: have %v, want %v", err, errNotStoreCall)
+	}
+	data[0] ^= 0xff
+	if _, err := UnpackStore(data); err != errNotStoreCall {
+		t.Errorf("wrong selector: have %v, want %v", err, errNotStoreCall)
+	}
+}
@@ -0,0 +1,73 @@
+// Copyright 2021 The MOAC-core Authors
+// This file is part of the MOAC-core library.
+//
+// The MOAC-core library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The MOAC-core library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the MOAC-core library. If not, see <http://www.gnu.org/licenses/>.
+
+package xevents
+
+import (
+	"errors"
+	"sort"
+
+	"github.com/MOACChain/MoacLib/common"
+	"github.com/MOACChain/xchain/accounts/abi/bind"
+)
+
+var (
+	errUnboundedRange = errors.New("chunked filter needs an end block")
+	errZeroChunk      = errors.New("chunk size must be positive")
+)
+
+// FilterRoleGrantedChunked retrieves the RoleGranted events in [opts.Start,
+// opts.End], querying at most chunk blocks at a time so that large historical
+// scans stay within the log limits of the provider. Events are returned ordered
+// by block number and log index.
+func (_XEvents *XEventsFilterer) FilterRoleGrantedChunked(opts *bind.FilterOpts, chunk uint64, role [][32]byte, account []common.Address, sender []common.Address) ([]*XEventsRoleGranted, error) {
+	if opts == nil || opts.End == nil {
+		return nil, errUnboundedRange
+	}
+	if chunk == 0 {
+		return nil, errZeroChunk
+	}
+	var events []*XEventsRoleGranted
+	for start := opts.Start; start <= *opts.End; {
+		end := *opts.End
+		if *opts.End-start >= chunk {
+			end = start + chunk - 1
+		}
+		it, err := _XEvents.FilterRoleGranted(&bind.FilterOpts{Start: start, End: &end, Context: opts.Context}, role, account, sender)
+		if err != nil {
+			return nil, err
+		}
+		for it.Next() {
+			events = append(events, it.Event)
+		}
+		err = it.Error()
+		it.Close()
+		if err != nil {
+			return nil, err
+		}
+		if end == *opts.End {
+			break
+		}
+		start = end + 1
+	}
+	sort.SliceStable(events, func(i, j int) bool {
+		if events[i].Raw.BlockNumber != events[j].Raw.BlockNumber {
+			return events[i].Raw.BlockNumber < events[j].Raw.BlockNumber
+		}
+		return events[i].Raw.Index < events[j].Raw.Index
+	})
+	return events, nil
+}
@@ -0,0 +1,101 @@
+// Copyright 2021 The MOAC-core Authors
+// This file is part of the MOAC-core library.
+//
+// The MOAC-core library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The MOAC-core library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the MOAC-core library. If not, see <http://www.gnu.org/licenses/>.
+
+package xevents
+
+import (
+	"context"
+	"testing"
+
+	"github.com/MOACChain/MoacLib/common"
+	"github.com/MOACChain/MoacLib/crypto"
+	"github.com/MOACChain/MoacLib/types"
+	moaccore "github.com/MOACChain/xchain"
+	"github.com/MOACChain/xchain/accounts/abi/bind"
+)
+
+// rangeFilterer is a bind.ContractFilterer returning the logs within the
+// queried block range in reverse order, recording each queried range.
+type rangeFilterer struct {
+	logs   []types.Log
+	ranges [][2]uint64
+}
+
+func (f *rangeFilterer) FilterLogs(ctx context.Context, query moaccore.FilterQuery) ([]types.Log, error) {
+	from, to := query.FromBlock.Uint64(), query.ToBlock.Uint64()
+	f.ranges = append(f.ranges, [2]uint64{from, to})
+
+	var logs []types.Log
+	for i := len(f.logs) - 1; i >= 0; i-- {
+		if n := f.logs[i].BlockNumber; n >= from && n <= to {
+			logs = append(logs, f.logs[i])
+		}
+	}
+	return logs, nil
+}
+
+func (f *rangeFilterer) SubscribeFilterLogs(ctx context.Context, query moaccore.FilterQuery, ch chan<- types.Log) (moaccore.Subscription, error) {
+	panic("not implemented")
+}
+
+func TestFilterRoleGrantedChunked(t *testing.T) {
+	eventID := crypto.Keccak256Hash([]byte("RoleGranted(bytes32,address,address)"))
+	roleGranted := func(number uint64, index uint) types.Log {
+		return types.Log{
+			Topics:      []common.Hash{eventID, {1}, common.BytesToHash([]byte{2}), common.BytesToHash([]byte{3})},
+			BlockNumber: number,
+			Index:       index,
+		}
+	}
+	filterer := &rangeFilterer{
+		logs: []types.Log{roleGranted(3, 0), roleGranted(3, 1), roleGranted(12, 0), roleGranted(20, 4), roleGranted(20, 7), roleGranted(25, 0)},
+	}
+	contract, err := NewXEventsFilterer(common.Address{}, filterer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	end := uint64(24)
+	events, err := contract.FilterRoleGrantedChunked(&bind.FilterOpts{Start: 2, End: &end}, 10, nil, nil, nil)
+	if err != nil {
+		t.Fatalf("filter failed: %v", err)
+	}
+	wantRanges := [][2]uint64{{2, 11}, {12, 21}, {22, 24}}
+	if len(filterer.ranges) != len(wantRanges) {
+		t.Fatalf("range count mismatch: have %v, want %v", filterer.ranges, wantRanges)
+	}
+	for i, want := range wantRanges {
+		if filterer.ranges[i] != want {
+			t.Errorf("range %d mismatch: have %v, want %v", i, filterer.ranges[i], want)
+		}
+	}
+	want := [][2]uint64{{3, 0}, {3, 1}, {12, 0}, {20, 4}, {20, 7}}
+	if len(events) != len(want) {
+		t.Fatalf("event count mismatch: have %d, want %d", len(events), len(want))
+	}
+	for i, event := range events {
+		if have := [2]uint64{event.Raw.BlockNumber, uint64(event.Raw.Index)}; have != want[i] {
+			t.Errorf("event %d position mismatch: have %v, want %v", i, have, want[i])
+		}
+		if event.Account != common.BytesToAddress([]byte{2}) {
+			t.Errorf("event %d account mismatch: have %x", i, event.Account)
+		}
+	}
+
+	if _, err := contract.FilterRoleGrantedChunked(&bind.FilterOpts{Start: 2}, 10, nil, nil, nil); err != errUnboundedRange {
+		t.Errorf("open range: have %v, want %v", err, errUnboundedRange)
+	}
+}
@@ -0,0 +1,145 @@
+// Copyright 2021 The MOAC-core Authors
+// This file is part of the MOAC-core library.
+//
+// The MOAC-core library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The MOAC-core library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the MOAC-core library. If not, see <http://www.gnu.org/licenses/>.
+
+package xevents
+
+import (
+	"sync"
+
+	"github.com/MOACChain/MoacLib/common"
+	"github.com/MOACChain/MoacLib/types"
+	"github.com/MOACChain/xchain/accounts/abi/bind"
+	"github.com/MOACChain/xchain/event"
+)
+
+// LogAction tells the consumer of a deduplicated log stream what to do with
+// a single log.
+type LogAction int
+
+const (
+	LogDeliver LogAction = iota // Log is new and should be processed
+	LogSkip                     // Log was already delivered or never seen, drop it
+	LogRevert                   // Log was delivered before and is now reverted by a reorg
+)
+
+// dedupReorgDepth is the number of blocks after which a delivered log is
+// considered final and no longer tracked for reverts.
+const dedupReorgDepth = 128
+
+// logKey identifies a log within the canonical chain.
+type logKey struct {
+	blockHash common.Hash
+	index     uint
+}
+
+// LogDeduplicator tracks the logs delivered from a subscription so that a
+// chain reorg can't deliver the same event twice. Logs flagged as removed are
+// turned into revert signals for previously delivered logs. Logs more than
+// dedupReorgDepth blocks below the highest block seen are forgotten.
+type LogDeduplicator struct {
+	mu        sync.Mutex
+	delivered map[logKey]uint64 // block number of each delivered log
+	head      uint64            // highest block number seen
+}
+
+// NewLogDeduplicator creates an empty log deduplicator.
+func NewLogDeduplicator() *LogDeduplicator {
+	return &LogDeduplicator{delivered: make(map[logKey]uint64)}
+}
+
+// Check records the log and returns the action to take for it.
+func (d *LogDeduplicator) Check(log types.Log) LogAction {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	key := logKey{blockHash: log.BlockHash, index: log.Index}
+	_, seen := d.delivered[key]
+	if log.Removed {
+		if !seen {
+			return LogSkip
+		}
+		delete(d.delivered, key)
+		return LogRevert
+	}
+	if seen {
+		return LogSkip
+	}
+	d.delivered[key] = log.BlockNumber
+	if log.BlockNumber > d.head {
+		d.head = log.BlockNumber
+		d.prune()
+	}
+	return LogDeliver
+}
+
+// prune forgets the delivered logs which are final at the current head.
+// The caller must hold d.mu.
+func (d *LogDeduplicator) prune() {
+	if d.head <= dedupReorgDepth {
+		return
+	}
+	final := d.head - dedupReorgDepth
+	for key, number := range d.delivered {
+		if number < final {
+			delete(d.delivered, key)
+		}
+	}
+}
+
+// WatchLogsDeduped subscribes to the raw logs of the named XEvents event and
+// forwards them through a LogDeduplicator. New logs are sent to sink, logs
+// reverted by a reorg are sent to reverted. Callers unpack the forwarded logs
+// with the matching Parse* method.
+func (_XEvents *XEventsFilterer) WatchLogsDeduped(opts *bind.WatchOpts, name string, sink chan<- types.Log, reverted chan<- types.Log, query ...[]interface{}) (event.Subscription, error) {
+	logs, sub, err := _XEvents.contract.WatchLogs(opts, name, query...)
+	if err != nil {
+		return nil, err
+	}
+	return event.NewSubscription(func(quit <-chan struct{}) error {
+		defer sub.Unsubscribe()
+		return forwardDeduped(NewLogDeduplicator(), logs, sub.Err(), sink, reverted, quit)
+	}), nil
+}
+
+// forwardDeduped pumps logs through the deduplicator until the subscription
+// fails or quit is closed.
+func forwardDeduped(dedup *LogDeduplicator, logs <-chan types.Log, errc <-chan error, sink chan<- types.Log, reverted chan<- types.Log, quit <-chan struct{}) error {
+	for {
+		select {
+		case log := <-logs:
+			var out chan<- types.Log
+			switch dedup.Check(log) {
+			case LogDeliver:
+				out = sink
+			case LogRevert:
+				out = reverted
+			default:
+				continue
+			}
+			select {
+			case out <- log:
+			case err := <-errc:
+				return err
+			case <-quit:
+				return nil
+			}
+		case err := <-errc:
+			return err
+		case <-quit:
+			return nil
+		}
+	}
+}
@@ -0,0 +1,98 @@
+// Copyright 2021 The MOAC-core Authors
+// This file is part of the MOAC-core library.
+//
+// The MOAC-core library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The MOAC-core library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the MOAC-core library. If not, see <http://www.gnu.org/licenses/>.
+
+package xevents
+
+import (
+	"testing"
+	"time"
+
+	"github.com/MOACChain/MoacLib/common"
+	"github.com/MOACChain/MoacLib/types"
+)
+
+func TestLogDeduplicatorReorg(t *testing.T) {
+	var (
+		oldLog  = types.Log{BlockNumber: 10, BlockHash: common.HexToHash("0x01"), Index: 3}
+		removed = types.Log{BlockNumber: 10, BlockHash: common.HexToHash("0x01"), Index: 3, Removed: true}
+		newLog  = types.Log{BlockNumber: 10, BlockHash: common.HexToHash("0x02"), Index: 3}
+	)
+
+	logs := make(chan types.Log)
+	errc := make(chan error)
+	sink := make(chan types.Log, 10)
+	reverted := make(chan types.Log, 10)
+	quit := make(chan struct{})
+	done := make(chan error)
+	go func() {
+		done <- forwardDeduped(NewLogDeduplicator(), logs, errc, sink, reverted, quit)
+	}()
+
+	// Deliver the original log twice, then the reorg removal and replacement.
+	for _, log := range []types.Log{oldLog, oldLog, removed, removed, newLog, newLog} {
+		logs <- log
+	}
+	close(quit)
+	if err := <-done; err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expect := func(ch chan types.Log, want types.Log) {
+		select {
+		case have := <-ch:
+			if have.BlockHash != want.BlockHash || have.Index != want.Index || have.Removed != want.Removed {
+				t.Fatalf("log mismatch: have %+v, want %+v", have, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("log not delivered: %+v", want)
+		}
+	}
+	expect(sink, oldLog)
+	expect(reverted, removed)
+	expect(sink, newLog)
+	if len(sink) != 0 || len(reverted) != 0 {
+		t.Fatalf("duplicate logs delivered: %d new, %d reverted", len(sink), len(reverted))
+	}
+}
+
+func TestLogDeduplicatorUnknownRemoval(t *testing.T) {
+	dedup := NewLogDeduplicator()
+	if action := dedup.Check(types.Log{BlockHash: common.HexToHash("0x01"), Removed: true}); action != LogSkip {
+		t.Fatalf("removal of unseen log: have %d, want %d", action, LogSkip)
+	}
+}
+
+func TestLogDeduplicatorPrune(t *testing.T) {
+	dedup := NewLogDeduplicator()
+	old := types.Log{BlockNumber: 1, BlockHash: common.HexToHash("0x01")}
+	recent := types.Log{BlockNumber: 2, BlockHash: common.HexToHash("0x02")}
+	dedup.Check(old)
+	dedup.Check(recent)
+
+	// At head 2+dedupReorgDepth the log of block 1 is final, block 2 isn't.
+	dedup.Check(types.Log{BlockNumber: 2 + dedupReorgDepth, BlockHash: common.HexToHash("0x03")})
+	if len(dedup.delivered) != 2 {
+		t.Fatalf("tracked logs: have %d, want 2", len(dedup.delivered))
+	}
+	old.Removed = true
+	if action := dedup.Check(old); action != LogSkip {
+		t.Errorf("removal of final log: have %d, want %d", action, LogSkip)
+	}
+	recent.Removed = true
+	if action := dedup.Check(recent); action != LogRevert {
+		t.Errorf("removal of recent log: have %d, want %d", action, LogRevert)
+	}
+}
@@ -0,0 +1,50 @@
+// Copyright 2021 The MOAC-core Authors
+// This file is part of the MOAC-core library.
+//
+// The MOAC-core library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The MOAC-core library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the MOAC-core library. If not, see <http://www.gnu.org/licenses/>.
+
+package xevents
+
+import "github.com/MOACChain/xchain/accounts/abi/bind"
+
+// eventIterator is the part of the generated event iterators needed to drive
+// them to completion.
+type eventIterator interface {
+	Next() bool
+	Error() error
+	Close() error
+}
+
+// forEachEvent calls f for every event of the iterator. It stops on the first
+// error returned by f or the iterator, and always closes the iterator.
+func forEachEvent(it eventIterator, f func() error) error {
+	defer it.Close()
+	for it.Next() {
+		if err := f(); err != nil {
+			return err
+		}
+	}
+	return it.Error()
+}
+
+// ForEachRoleGranted calls f for every historical RoleGranted event matching
+// the filter options, in the order returned by the provider. Iteration stops
+// on the first error returned by f, which is passed back to the caller.
+func (_XEvents *XEventsFilterer) ForEachRoleGranted(opts *bind.FilterOpts, f func(*XEventsRoleGranted) error) error {
+	it, err := _XEvents.FilterRoleGranted(opts, nil, nil, nil)
+	if err != nil {
+		return err
+	}
+	return forEachEvent(it, func() error { return f(it.Event) })
+}
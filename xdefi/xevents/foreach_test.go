@@ -0,0 +1,115 @@
+// Copyright 2021 The MOAC-core Authors
+// This file is part of the MOAC-core library.
+//
+// The MOAC-core library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The MOAC-core library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the MOAC-core library. If not, see <http://www.gnu.org/licenses/>.
+
+package xevents
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/MOACChain/MoacLib/common"
+	"github.com/MOACChain/MoacLib/crypto"
+	"github.com/MOACChain/MoacLib/types"
+	"github.com/MOACChain/xchain/accounts/abi/bind"
+)
+
+// sliceIterator is an eventIterator over a fixed number of events, recording
+// whether it was closed.
+type sliceIterator struct {
+	n, pos int
+	closed bool
+}
+
+func (it *sliceIterator) Next() bool {
+	if it.pos >= it.n {
+		return false
+	}
+	it.pos++
+	return true
+}
+
+func (it *sliceIterator) Error() error { return nil }
+
+func (it *sliceIterator) Close() error {
+	it.closed = true
+	return nil
+}
+
+func TestForEachRoleGranted(t *testing.T) {
+	eventID := crypto.Keccak256Hash([]byte("RoleGranted(bytes32,address,address)"))
+	var logs []types.Log
+	for i := uint64(1); i <= 3; i++ {
+		logs = append(logs, types.Log{
+			Topics:      []common.Hash{eventID, {1}, common.BytesToHash([]byte{byte(i)}), common.BytesToHash([]byte{3})},
+			BlockNumber: i,
+		})
+	}
+	// rangeFilterer returns the logs in reverse order.
+	filterer := &rangeFilterer{logs: logs}
+	contract, err := NewXEventsFilterer(common.Address{}, filterer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		errStop = errors.New("stop")
+		seen    []uint64
+		end     = uint64(10)
+	)
+	err = contract.ForEachRoleGranted(&bind.FilterOpts{Start: 1, End: &end}, func(ev *XEventsRoleGranted) error {
+		seen = append(seen, ev.Raw.BlockNumber)
+		if len(seen) == 2 {
+			return errStop
+		}
+		return nil
+	})
+	if err != errStop {
+		t.Fatalf("wrong error: have %v, want %v", err, errStop)
+	}
+	if len(seen) != 2 || seen[0] != 3 || seen[1] != 2 {
+		t.Errorf("wrong events visited: %v", seen)
+	}
+	if err := contract.ForEachRoleGranted(&bind.FilterOpts{Start: 1, End: &end}, func(*XEventsRoleGranted) error { return nil }); err != nil {
+		t.Errorf("full iteration failed: %v", err)
+	}
+}
+
+func TestForEachEventCloses(t *testing.T) {
+	errStop := errors.New("stop")
+
+	it := &sliceIterator{n: 3}
+	calls := 0
+	err := forEachEvent(it, func() error {
+		if calls++; calls == 2 {
+			return errStop
+		}
+		return nil
+	})
+	if err != errStop || calls != 2 {
+		t.Errorf("iteration not stopped: err %v after %d calls", err, calls)
+	}
+	if !it.closed {
+		t.Error("iterator not closed after callback error")
+	}
+
+	it = &sliceIterator{n: 3}
+	if err := forEachEvent(it, func() error { return nil }); err != nil || it.pos != 3 {
+		t.Errorf("full iteration failed: err %v at %d", err, it.pos)
+	}
+	if !it.closed {
+		t.Error("iterator not closed after completion")
+	}
+}
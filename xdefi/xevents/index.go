@@ -0,0 +1,133 @@
+// Copyright 2021 The MOAC-core Authors
+// This file is part of the MOAC-core library.
+//
+// The MOAC-core library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The MOAC-core library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the MOAC-core library. If not, see <http://www.gnu.org/licenses/>.
+
+package xevents
+
+import (
+	"errors"
+	"math/big"
+	"sync"
+
+	"github.com/MOACChain/MoacLib/common"
+	"github.com/MOACChain/xchain/accounts/abi/bind"
+)
+
+var errBadNonceRange = errors.New("nonce range end before start")
+
+// VaultEvent is an event of a vault as stored in the XEvents contract.
+type VaultEvent struct {
+	EventData   []byte
+	Sig         []byte
+	BlockNumber *big.Int
+}
+
+// vaultMapping identifies the event stream of a token mapping of a vault.
+type vaultMapping struct {
+	vault        common.Address
+	tokenMapping [32]byte
+}
+
+// EventIndex caches vault events as they are fetched from the contract.
+// Stored events are immutable, so cached entries stay valid until the vault
+// is invalidated, e.g. after a redeployment.
+type EventIndex struct {
+	mu     sync.RWMutex
+	events map[vaultMapping]map[uint64]VaultEvent
+}
+
+// NewEventIndex creates an empty event index.
+func NewEventIndex() *EventIndex {
+	return &EventIndex{events: make(map[vaultMapping]map[uint64]VaultEvent)}
+}
+
+// Get returns the cached event of the token mapping with the given nonce.
+func (idx *EventIndex) Get(vault common.Address, tokenMapping [32]byte, nonce uint64) (VaultEvent, bool) {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	event, ok := idx.events[vaultMapping{vault, tokenMapping}][nonce]
+	return event, ok
+}
+
+// LatestNonce returns the highest cached nonce of the token mapping.
+func (idx *EventIndex) LatestNonce(vault common.Address, tokenMapping [32]byte) (uint64, bool) {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	var (
+		latest uint64
+		found  bool
+	)
+	for nonce := range idx.events[vaultMapping{vault, tokenMapping}] {
+		if !found || nonce > latest {
+			latest, found = nonce, true
+		}
+	}
+	return latest, found
+}
+
+// Invalidate drops all cached events of the vault.
+func (idx *EventIndex) Invalidate(vault common.Address) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	for key := range idx.events {
+		if key.vault == vault {
+			delete(idx.events, key)
+		}
+	}
+}
+
+// put caches an event.
+func (idx *EventIndex) put(vault common.Address, tokenMapping [32]byte, nonce uint64, event VaultEvent) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	key := vaultMapping{vault, tokenMapping}
+	if idx.events[key] == nil {
+		idx.events[key] = make(map[uint64]VaultEvent)
+	}
+	idx.events[key][nonce] = event
+}
+
+// ReadVaultEvents reads the events of the token mapping with nonces in
+// [from, to]. Events found in idx are not fetched again, fetched events are
+// added to it. idx may be nil. Nonces without a stored event yet are returned
+// as empty events and are not cached.
+func (_XEvents *XEventsCaller) ReadVaultEvents(opts *bind.CallOpts, idx *EventIndex, vault common.Address, tokenMapping [32]byte, from, to uint64) ([]VaultEvent, error) {
+	if to < from {
+		return nil, errBadNonceRange
+	}
+	events := make([]VaultEvent, 0, to-from+1)
+	for nonce := from; nonce <= to; nonce++ {
+		if idx != nil {
+			if event, ok := idx.Get(vault, tokenMapping, nonce); ok {
+				events = append(events, event)
+				continue
+			}
+		}
+		stored, err := _XEvents.VaultEvents(opts, vault, tokenMapping, new(big.Int).SetUint64(nonce))
+		if err != nil {
+			return nil, err
+		}
+		event := VaultEvent(stored)
+		if idx != nil && len(event.EventData) > 0 {
+			idx.put(vault, tokenMapping, nonce, event)
+		}
+		events = append(events, event)
+	}
+	return events, nil
+}
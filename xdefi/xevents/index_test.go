@@ -0,0 +1,81 @@
+// Copyright 2021 The MOAC-core Authors
+// This file is part of the MOAC-core library.
+//
+// The MOAC-core library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The MOAC-core library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the MOAC-core library. If not, see <http://www.gnu.org/licenses/>.
+
+package xevents
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/MOACChain/MoacLib/common"
+)
+
+// vaultEventsHandler answers vaultEvents for the nonces below stored.
+func vaultEventsHandler(stored uint64) callHandler {
+	return func(args []interface{}) (interface{}, error) {
+		nonce := args[2].(*big.Int).Uint64()
+		if nonce >= stored {
+			return []interface{}{[]byte{}, []byte{}, new(big.Int)}, nil
+		}
+		return []interface{}{[]byte{byte(nonce)}, []byte{0xff}, new(big.Int).SetUint64(100 + nonce)}, nil
+	}
+}
+
+func TestReadVaultEventsCached(t *testing.T) {
+	backend := newCallBackend(t, map[string]callHandler{"vaultEvents": vaultEventsHandler(3)})
+	caller, err := NewXEventsCaller(common.Address{}, backend)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var (
+		idx          = NewEventIndex()
+		vault        = common.HexToAddress("0x01")
+		tokenMapping = [32]byte{2}
+	)
+	events, err := caller.ReadVaultEvents(nil, idx, vault, tokenMapping, 0, 3)
+	if err != nil {
+		t.Fatalf("read failed: %v", err)
+	}
+	if len(events) != 4 || events[2].BlockNumber.Uint64() != 102 || len(events[3].EventData) != 0 {
+		t.Fatalf("unexpected events: %v", events)
+	}
+	if calls := backend.callCount("vaultEvents"); calls != 4 {
+		t.Fatalf("backend calls mismatch: have %d, want 4", calls)
+	}
+	if latest, ok := idx.LatestNonce(vault, tokenMapping); !ok || latest != 2 {
+		t.Errorf("latest nonce mismatch: have %d (%v), want 2", latest, ok)
+	}
+
+	// The stored events are served from the index, only the missing one is
+	// fetched again.
+	if _, err := caller.ReadVaultEvents(nil, idx, vault, tokenMapping, 0, 3); err != nil {
+		t.Fatalf("second read failed: %v", err)
+	}
+	if calls := backend.callCount("vaultEvents"); calls != 5 {
+		t.Errorf("backend calls after cached read: have %d, want 5", calls)
+	}
+	if event, ok := idx.Get(vault, tokenMapping, 1); !ok || event.EventData[0] != 1 {
+		t.Errorf("cached event mismatch: %v (%v)", event, ok)
+	}
+
+	idx.Invalidate(vault)
+	if _, ok := idx.Get(vault, tokenMapping, 1); ok {
+		t.Error("event still cached after invalidation")
+	}
+	if _, ok := idx.LatestNonce(vault, tokenMapping); ok {
+		t.Error("latest nonce still known after invalidation")
+	}
+}
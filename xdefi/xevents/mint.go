@@ -0,0 +1,54 @@
+// Copyright 2021 The MOAC-core Authors
+// This file is part of the MOAC-core library.
+//
+// The MOAC-core library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The MOAC-core library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the MOAC-core library. If not, see <http://www.gnu.org/licenses/>.
+
+package xevents
+
+import (
+	"errors"
+	"math/big"
+
+	"github.com/MOACChain/MoacLib/common"
+	"github.com/MOACChain/MoacLib/types"
+	"github.com/MOACChain/xchain/accounts/abi/bind"
+)
+
+// ErrAlreadyMinted is returned by DoMintIfPending if the vault event was
+// already minted or marked done.
+var ErrAlreadyMinted = errors.New("vault event already minted")
+
+// DoMintIfPending submits doMint for the vault event with the given nonce,
+// unless the event is already done or minted, i.e. nonce is below the
+// vaultEventDone or the mintWatermark of the token mapping. In that case no
+// transaction is sent.
+func (_XEvents *XEvents) DoMintIfPending(opts *bind.TransactOpts, vault common.Address, tokenMapping [32]byte, nonce *big.Int) (*types.Transaction, error) {
+	callOpts := &bind.CallOpts{From: opts.From, Context: opts.Context}
+
+	done, err := _XEvents.VaultEventDone(callOpts, vault, tokenMapping)
+	if err != nil {
+		return nil, err
+	}
+	if nonce.Cmp(done) < 0 {
+		return nil, ErrAlreadyMinted
+	}
+	minted, err := _XEvents.MintWatermark(callOpts, vault, tokenMapping)
+	if err != nil {
+		return nil, err
+	}
+	if nonce.Cmp(minted) < 0 {
+		return nil, ErrAlreadyMinted
+	}
+	return _XEvents.DoMint(opts, vault, tokenMapping, nonce)
+}
@@ -0,0 +1,71 @@
+// Copyright 2021 The MOAC-core Authors
+// This file is part of the MOAC-core library.
+//
+// The MOAC-core library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The MOAC-core library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the MOAC-core library. If not, see <http://www.gnu.org/licenses/>.
+
+package xevents
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/MOACChain/MoacLib/common"
+	"github.com/MOACChain/MoacLib/types"
+	"github.com/MOACChain/xchain/accounts/abi/bind"
+)
+
+func TestDoMintIfPending(t *testing.T) {
+	opts := &bind.TransactOpts{
+		GasPrice: big.NewInt(1),
+		GasLimit: 100000,
+		Signer:   func(_ common.Address, tx *types.Transaction) (*types.Transaction, error) { return tx, nil },
+	}
+	tests := []struct {
+		done, minted, nonce int64
+		wantErr             error
+	}{
+		{done: 5, minted: 5, nonce: 4, wantErr: ErrAlreadyMinted},
+		{done: 5, minted: 3, nonce: 4, wantErr: ErrAlreadyMinted},
+		{done: 3, minted: 5, nonce: 4, wantErr: ErrAlreadyMinted},
+		{done: 3, minted: 3, nonce: 4},
+		{done: 4, minted: 4, nonce: 4},
+	}
+	for i, tt := range tests {
+		backend := newCallBackend(t, map[string]callHandler{
+			"vaultEventDone": returns(big.NewInt(tt.done)),
+			"mintWatermark":  returns(big.NewInt(tt.minted)),
+		})
+		backend.nonce = 1
+		contract, err := NewXEvents(common.HexToAddress("0x2397"), backend)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tx, err := contract.DoMintIfPending(opts, common.Address{1}, [32]byte{2}, big.NewInt(tt.nonce))
+		if err != tt.wantErr {
+			t.Fatalf("test %d: error mismatch: have %v, want %v", i, err, tt.wantErr)
+		}
+		if tt.wantErr != nil {
+			if tx != nil || len(backend.sent) != 0 {
+				t.Errorf("test %d: transaction sent for a minted event", i)
+			}
+			continue
+		}
+		if len(backend.sent) != 1 || backend.sent[0] != tx {
+			t.Fatalf("test %d: doMint not sent", i)
+		}
+		if method, _ := backend.abi.MethodById(tx.Data()[:4]); method == nil || method.Name != "doMint" {
+			t.Errorf("test %d: sent %v, want doMint", i, method)
+		}
+	}
+}
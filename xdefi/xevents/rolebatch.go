@@ -0,0 +1,58 @@
+// Copyright 2021 The MOAC-core Authors
+// This file is part of the MOAC-core library.
+//
+// The MOAC-core library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The MOAC-core library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the MOAC-core library. If not, see <http://www.gnu.org/licenses/>.
+
+package xevents
+
+import (
+	"math/big"
+
+	"github.com/MOACChain/MoacLib/common"
+	"github.com/MOACChain/MoacLib/types"
+)
+
+// GrantRoleBatch grants role to the accounts one by one, each transaction
+// using the nonce following the previous one. If the session has no nonce
+// set, the first transaction uses the pending nonce of the sender. On the
+// first failure the batch is aborted and the transactions submitted so far
+// are returned along with the error.
+func (_XEvents *XEventsSession) GrantRoleBatch(role [32]byte, accounts []common.Address) ([]*types.Transaction, error) {
+	return _XEvents.roleBatch(accounts, func(session *XEventsSession, account common.Address) (*types.Transaction, error) {
+		return session.GrantRole(role, account)
+	})
+}
+
+// RevokeRoleBatch revokes role from the accounts one by one, like
+// GrantRoleBatch.
+func (_XEvents *XEventsSession) RevokeRoleBatch(role [32]byte, accounts []common.Address) ([]*types.Transaction, error) {
+	return _XEvents.roleBatch(accounts, func(session *XEventsSession, account common.Address) (*types.Transaction, error) {
+		return session.RevokeRole(role, account)
+	})
+}
+
+// roleBatch submits one transaction per account with consecutive nonces.
+func (_XEvents *XEventsSession) roleBatch(accounts []common.Address, submit func(*XEventsSession, common.Address) (*types.Transaction, error)) ([]*types.Transaction, error) {
+	txs := make([]*types.Transaction, 0, len(accounts))
+	nonce := _XEvents.TransactOpts.Nonce
+	for _, account := range accounts {
+		tx, err := submit(_XEvents.WithNonce(nonce), account)
+		if err != nil {
+			return txs, err
+		}
+		txs = append(txs, tx)
+		nonce = new(big.Int).SetUint64(tx.Nonce() + 1)
+	}
+	return txs, nil
+}
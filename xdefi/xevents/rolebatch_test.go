@@ -0,0 +1,185 @@
+// Copyright 2021 The MOAC-core Authors
+// This file is part of the MOAC-core library.
+//
+// The MOAC-core library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The MOAC-core library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the MOAC-core library. If not, see <http://www.gnu.org/licenses/>.
+
+package xevents
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/MOACChain/MoacLib/common"
+	"github.com/MOACChain/MoacLib/types"
+	moaccore "github.com/MOACChain/xchain"
+	"github.com/MOACChain/xchain/accounts/abi"
+	"github.com/MOACChain/xchain/accounts/abi/bind"
+)
+
+// sendBackend is a bind.ContractBackend recording sent transactions. The
+// pending nonce of every account is fixed, and sending fails from the
+// transaction with index failAt on if it's positive.
+type sendBackend struct {
+	bind.ContractCaller
+	bind.ContractTransactor
+	bind.ContractFilterer
+	nonce  uint64
+	failAt int
+	sent   []*types.Transaction
+}
+
+func (b *sendBackend) PendingNonceAt(ctx context.Context, account common.Address) (uint64, error) {
+	return b.nonce, nil
+}
+
+func (b *sendBackend) SendTransaction(ctx context.Context, tx *types.Transaction) error {
+	if b.failAt > 0 && len(b.sent) >= b.failAt {
+		return errors.New("send failed")
+	}
+	b.sent = append(b.sent, tx)
+	return nil
+}
+
+// callHandler answers a contract method call given its unpacked arguments.
+// The outputs of methods with several are answered as a []interface{}.
+type callHandler func(args []interface{}) (interface{}, error)
+
+// callBackend is a sendBackend answering contract calls with the handler
+// registered for the called method, counting the calls of each method. Calls
+// of methods without a handler fail.
+type callBackend struct {
+	*sendBackend
+	abi      abi.ABI
+	handlers map[string]callHandler
+
+	mu    sync.Mutex
+	calls map[string]int
+}
+
+func newCallBackend(t *testing.T, handlers map[string]callHandler) *callBackend {
+	return newCallBackendWithABI(t, XEventsABI, handlers)
+}
+
+// newCallBackendWithABI creates a callBackend decoding the calls with the
+// given ABI instead of the embedded one.
+func newCallBackendWithABI(t *testing.T, abiJSON string, handlers map[string]callHandler) *callBackend {
+	parsed, err := abi.JSON(strings.NewReader(abiJSON))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &callBackend{sendBackend: &sendBackend{}, abi: parsed, handlers: handlers, calls: make(map[string]int)}
+}
+
+func (b *callBackend) CodeAt(ctx context.Context, contract common.Address, blockNumber *big.Int) ([]byte, error) {
+	return []byte{1}, nil
+}
+
+func (b *callBackend) CallContract(ctx context.Context, call moaccore.CallMsg, blockNumber *big.Int) ([]byte, error) {
+	method, err := b.abi.MethodById(call.Data[:4])
+	if err != nil {
+		return nil, err
+	}
+	b.mu.Lock()
+	b.calls[method.RawName]++
+	b.mu.Unlock()
+	handler, ok := b.handlers[method.RawName]
+	if !ok {
+		return nil, errors.New("unexpected call to " + method.RawName)
+	}
+	args, err := method.Inputs.Unpack(call.Data[4:])
+	if err != nil {
+		return nil, err
+	}
+	out, err := handler(args)
+	if err != nil {
+		return nil, err
+	}
+	if len(method.Outputs) > 1 {
+		return method.Outputs.Pack(out.([]interface{})...)
+	}
+	return method.Outputs.Pack(out)
+}
+
+// callCount returns how often the named method was called.
+func (b *callBackend) callCount(name string) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.calls[name]
+}
+
+// returns is a callHandler always answering v.
+func returns(v interface{}) callHandler {
+	return func([]interface{}) (interface{}, error) { return v, nil }
+}
+
+func newBatchSession(t *testing.T, backend *sendBackend) *XEventsSession {
+	contract, err := NewXEvents(common.HexToAddress("0x2383"), backend)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &XEventsSession{
+		Contract: contract,
+		TransactOpts: bind.TransactOpts{
+			GasPrice: big.NewInt(1),
+			GasLimit: 100000,
+			Signer:   func(_ common.Address, tx *types.Transaction) (*types.Transaction, error) { return tx, nil },
+		},
+	}
+}
+
+func TestGrantRoleBatch(t *testing.T) {
+	backend := &sendBackend{nonce: 7}
+	session := newBatchSession(t, backend)
+	accounts := []common.Address{{1}, {2}, {3}, {4}}
+
+	txs, err := session.GrantRoleBatch([32]byte{1}, accounts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(txs) != len(accounts) || len(backend.sent) != len(accounts) {
+		t.Fatalf("transaction count mismatch: have %d returned, %d sent, want %d", len(txs), len(backend.sent), len(accounts))
+	}
+	for i, tx := range txs {
+		if want := uint64(7 + i); tx.Nonce() != want {
+			t.Errorf("tx %d: nonce mismatch: have %d, want %d", i, tx.Nonce(), want)
+		}
+	}
+	if session.TransactOpts.Nonce != nil {
+		t.Errorf("session nonce changed to %v", session.TransactOpts.Nonce)
+	}
+}
+
+func TestRevokeRoleBatchAbort(t *testing.T) {
+	backend := &sendBackend{failAt: 2}
+	session := newBatchSession(t, backend)
+	session.TransactOpts.Nonce = big.NewInt(3)
+	accounts := []common.Address{{1}, {2}, {3}, {4}}
+
+	txs, err := session.RevokeRoleBatch([32]byte{1}, accounts)
+	if err == nil {
+		t.Fatal("batch succeeded despite send failure")
+	}
+	if len(txs) != 2 || len(backend.sent) != 2 {
+		t.Fatalf("transaction count mismatch: have %d returned, %d sent, want 2", len(txs), len(backend.sent))
+	}
+	for i, tx := range txs {
+		if want := uint64(3 + i); tx.Nonce() != want {
+			t.Errorf("tx %d: nonce mismatch: have %d, want %d", i, tx.Nonce(), want)
+		}
+	}
+}
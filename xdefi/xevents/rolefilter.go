@@ -0,0 +1,42 @@
+// Copyright 2021 The MOAC-core Authors
+// This file is part of the MOAC-core library.
+//
+// The MOAC-core library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The MOAC-core library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the MOAC-core library. If not, see <http://www.gnu.org/licenses/>.
+
+package xevents
+
+import (
+	"github.com/MOACChain/xchain/accounts/abi/bind"
+	"github.com/MOACChain/xchain/event"
+)
+
+// FilterRoleGrantedFor retrieves the RoleGranted events of a single role.
+func (_XEvents *XEventsFilterer) FilterRoleGrantedFor(opts *bind.FilterOpts, role [32]byte) (*XEventsRoleGrantedIterator, error) {
+	return _XEvents.FilterRoleGranted(opts, [][32]byte{role}, nil, nil)
+}
+
+// WatchRoleGrantedFor subscribes to the RoleGranted events of a single role.
+func (_XEvents *XEventsFilterer) WatchRoleGrantedFor(opts *bind.WatchOpts, role [32]byte, sink chan<- *XEventsRoleGranted) (event.Subscription, error) {
+	return _XEvents.WatchRoleGranted(opts, sink, [][32]byte{role}, nil, nil)
+}
+
+// FilterRoleRevokedFor retrieves the RoleRevoked events of a single role.
+func (_XEvents *XEventsFilterer) FilterRoleRevokedFor(opts *bind.FilterOpts, role [32]byte) (*XEventsRoleRevokedIterator, error) {
+	return _XEvents.FilterRoleRevoked(opts, [][32]byte{role}, nil, nil)
+}
+
+// WatchRoleRevokedFor subscribes to the RoleRevoked events of a single role.
+func (_XEvents *XEventsFilterer) WatchRoleRevokedFor(opts *bind.WatchOpts, role [32]byte, sink chan<- *XEventsRoleRevoked) (event.Subscription, error) {
+	return _XEvents.WatchRoleRevoked(opts, sink, [][32]byte{role}, nil, nil)
+}
@@ -0,0 +1,150 @@
+// Copyright 2021 The MOAC-core Authors
+// This file is part of the MOAC-core library.
+//
+// The MOAC-core library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The MOAC-core library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the MOAC-core library. If not, see <http://www.gnu.org/licenses/>.
+
+package xevents
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/MOACChain/MoacLib/common"
+	"github.com/MOACChain/MoacLib/crypto"
+	"github.com/MOACChain/MoacLib/types"
+	moaccore "github.com/MOACChain/xchain"
+	"github.com/MOACChain/xchain/accounts/abi/bind"
+	"github.com/MOACChain/xchain/event"
+)
+
+// topicFilterer is a bind.ContractFilterer returning the logs matching the
+// topics of the query, both for filtering and subscriptions.
+type topicFilterer struct {
+	logs []types.Log
+}
+
+func (f *topicFilterer) match(query moaccore.FilterQuery) []types.Log {
+	var logs []types.Log
+	for _, log := range f.logs {
+		matched := len(query.Topics) <= len(log.Topics)
+		for i, topics := range query.Topics {
+			if !matched || len(topics) == 0 {
+				continue
+			}
+			found := false
+			for _, topic := range topics {
+				found = found || topic == log.Topics[i]
+			}
+			matched = found
+		}
+		if matched {
+			logs = append(logs, log)
+		}
+	}
+	return logs
+}
+
+func (f *topicFilterer) FilterLogs(ctx context.Context, query moaccore.FilterQuery) ([]types.Log, error) {
+	return f.match(query), nil
+}
+
+func (f *topicFilterer) SubscribeFilterLogs(ctx context.Context, query moaccore.FilterQuery, ch chan<- types.Log) (moaccore.Subscription, error) {
+	logs := f.match(query)
+	return event.NewSubscription(func(quit <-chan struct{}) error {
+		for _, log := range logs {
+			select {
+			case ch <- log:
+			case <-quit:
+				return nil
+			}
+		}
+		<-quit
+		return nil
+	}), nil
+}
+
+func TestRoleFilterFor(t *testing.T) {
+	var (
+		granted = crypto.Keccak256Hash([]byte("RoleGranted(bytes32,address,address)"))
+		revoked = crypto.Keccak256Hash([]byte("RoleRevoked(bytes32,address,address)"))
+		relayer = [32]byte{1}
+		admin   = [32]byte{2}
+	)
+	roleLog := func(id common.Hash, role [32]byte, account byte) types.Log {
+		return types.Log{Topics: []common.Hash{id, role, common.BytesToHash([]byte{account}), common.BytesToHash([]byte{9})}}
+	}
+	filterer := &topicFilterer{logs: []types.Log{
+		roleLog(granted, relayer, 1),
+		roleLog(granted, admin, 2),
+		roleLog(revoked, admin, 3),
+		roleLog(granted, relayer, 4),
+		roleLog(revoked, relayer, 5),
+	}}
+	contract, err := NewXEventsFilterer(common.Address{}, filterer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	it, err := contract.FilterRoleGrantedFor(nil, relayer)
+	if err != nil {
+		t.Fatalf("filter failed: %v", err)
+	}
+	var accounts []common.Address
+	for it.Next() {
+		if it.Event.Role != relayer {
+			t.Errorf("event of role %x delivered", it.Event.Role)
+		}
+		accounts = append(accounts, it.Event.Account)
+	}
+	it.Close()
+	if len(accounts) != 2 || accounts[0] != common.BytesToAddress([]byte{1}) || accounts[1] != common.BytesToAddress([]byte{4}) {
+		t.Errorf("granted accounts mismatch: %x", accounts)
+	}
+
+	revokedIt, err := contract.FilterRoleRevokedFor(nil, admin)
+	if err != nil {
+		t.Fatalf("filter failed: %v", err)
+	}
+	n := 0
+	for ; revokedIt.Next(); n++ {
+		if revokedIt.Event.Role != admin || revokedIt.Event.Account != common.BytesToAddress([]byte{3}) {
+			t.Errorf("wrong revoked event: %x %x", revokedIt.Event.Role, revokedIt.Event.Account)
+		}
+	}
+	revokedIt.Close()
+	if n != 1 {
+		t.Errorf("revoked event count mismatch: have %d, want %d", n, 1)
+	}
+
+	sink := make(chan *XEventsRoleGranted, 4)
+	sub, err := contract.WatchRoleGrantedFor(&bind.WatchOpts{}, admin, sink)
+	if err != nil {
+		t.Fatalf("watch failed: %v", err)
+	}
+	defer sub.Unsubscribe()
+	select {
+	case ev := <-sink:
+		if ev.Role != admin || ev.Account != common.BytesToAddress([]byte{2}) {
+			t.Errorf("wrong granted event: %x %x", ev.Role, ev.Account)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("granted event not delivered")
+	}
+	select {
+	case ev := <-sink:
+		t.Errorf("event of another role delivered: %x", ev.Role)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
@@ -0,0 +1,179 @@
+// Copyright 2021 The MOAC-core Authors
+// This file is part of the MOAC-core library.
+//
+// The MOAC-core library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The MOAC-core library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the MOAC-core library. If not, see <http://www.gnu.org/licenses/>.
+
+package xevents
+
+import (
+	"errors"
+	"math/big"
+	"sync"
+
+	"github.com/MOACChain/MoacLib/common"
+	"github.com/MOACChain/MoacLib/log"
+	"github.com/MOACChain/xchain/accounts/abi/bind"
+)
+
+var errNegativeRange = errors.New("negative offset or limit")
+
+// roleMembersWorkers bounds the number of concurrent GetRoleMembers calls.
+const roleMembersWorkers = 4
+
+// RoleMembers is a role of the contract together with its members.
+type RoleMembers struct {
+	Role     [32]byte
+	Describe string
+	Members  []common.Address
+}
+
+// AllRoles retrieves every role of the contract together with its description
+// and members, in the order returned by GetRoles.
+func (_XEvents *XEventsSession) AllRoles() ([]RoleMembers, error) {
+	roles, err := _XEvents.GetRoles()
+	if err != nil {
+		return nil, err
+	}
+	var (
+		result = make([]RoleMembers, len(roles))
+		errs   = make([]error, len(roles))
+		tasks  = make(chan int)
+		wg     sync.WaitGroup
+	)
+	for w := 0; w < roleMembersWorkers && w < len(roles); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range tasks {
+				result[i].Role = roles[i].Role
+				result[i].Describe = roles[i].Describe
+				result[i].Members, errs[i] = _XEvents.GetRoleMembers(roles[i].Role)
+			}
+		}()
+	}
+	for i := range roles {
+		tasks <- i
+	}
+	close(tasks)
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// AllRoleMembers retrieves the members of every role of the contract, keyed by
+// role hash. Use AllRoles to get the role descriptions as well.
+func (_XEvents *XEventsSession) AllRoleMembers() (map[[32]byte][]common.Address, error) {
+	roles, err := _XEvents.AllRoles()
+	if err != nil {
+		return nil, err
+	}
+	members := make(map[[32]byte][]common.Address, len(roles))
+	for _, role := range roles {
+		members[role.Role] = role.Members
+	}
+	return members, nil
+}
+
+// RolesByName retrieves the roles of the contract keyed by their description.
+// If several roles share a description, the first one returned by GetRoles is
+// kept.
+func (_XEvents *XEventsSession) RolesByName() (map[string][32]byte, error) {
+	roles, err := _XEvents.GetRoles()
+	if err != nil {
+		return nil, err
+	}
+	byName := make(map[string][32]byte, len(roles))
+	for _, role := range roles {
+		if first, ok := byName[role.Describe]; ok {
+			log.Warn("Duplicate role description", "describe", role.Describe,
+				"kept", common.Bytes2Hex(first[:]), "dropped", common.Bytes2Hex(role.Role[:]))
+			continue
+		}
+		byName[role.Describe] = role.Role
+	}
+	return byName, nil
+}
+
+// RolesByHash retrieves the descriptions of the contract roles keyed by role
+// hash. If a role is returned more than once, the first description is kept.
+func (_XEvents *XEventsSession) RolesByHash() (map[[32]byte]string, error) {
+	roles, err := _XEvents.GetRoles()
+	if err != nil {
+		return nil, err
+	}
+	byHash := make(map[[32]byte]string, len(roles))
+	for _, role := range roles {
+		if first, ok := byHash[role.Role]; ok {
+			log.Warn("Duplicate role", "role", common.Bytes2Hex(role.Role[:]),
+				"kept", first, "dropped", role.Describe)
+			continue
+		}
+		byHash[role.Role] = role.Describe
+	}
+	return byHash, nil
+}
+
+// GetRoleMembersPaged retrieves the members of a role with indexes in
+// [offset, offset+limit), clamped to the number of members of the role. The
+// members are fetched concurrently and returned in index order.
+func (_XEvents *XEventsCaller) GetRoleMembersPaged(opts *bind.CallOpts, role [32]byte, offset, limit *big.Int) ([]common.Address, error) {
+	if offset.Sign() < 0 || limit.Sign() < 0 {
+		return nil, errNegativeRange
+	}
+	count, err := _XEvents.GetRoleMemberCount(opts, role)
+	if err != nil {
+		return nil, err
+	}
+	end := new(big.Int).Add(offset, limit)
+	if end.Cmp(count) > 0 {
+		end = count
+	}
+	if offset.Cmp(end) >= 0 {
+		return []common.Address{}, nil
+	}
+	n := int(new(big.Int).Sub(end, offset).Int64())
+	var (
+		members = make([]common.Address, n)
+		errs    = make([]error, n)
+		tasks   = make(chan int)
+		wg      sync.WaitGroup
+	)
+	for w := 0; w < roleMembersWorkers && w < n; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range tasks {
+				index := new(big.Int).Add(offset, big.NewInt(int64(i)))
+				members[i], errs[i] = _XEvents.GetRoleMember(opts, role, index)
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		tasks <- i
+	}
+	close(tasks)
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return members, nil
+}
@@ -0,0 +1,134 @@
+// Copyright 2021 The MOAC-core Authors
+// This file is part of the MOAC-core library.
+//
+// The MOAC-core library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The MOAC-core library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the MOAC-core library. If not, see <http://www.gnu.org/licenses/>.
+
+package xevents
+
+import (
+	"errors"
+	"math/big"
+	"reflect"
+	"testing"
+
+	"github.com/MOACChain/MoacLib/common"
+)
+
+// newRolesCaller returns a caller on a backend answering getRoles,
+// getRoleMembers, getRoleMemberCount and getRoleMember from fixed data.
+func newRolesCaller(t *testing.T, roles []RoleAccessRole, members map[[32]byte][]common.Address) *XEventsCaller {
+	backend := newCallBackend(t, map[string]callHandler{
+		"getRoles": returns(roles),
+		"getRoleMembers": func(args []interface{}) (interface{}, error) {
+			return members[args[0].([32]byte)], nil
+		},
+		"getRoleMemberCount": func(args []interface{}) (interface{}, error) {
+			return big.NewInt(int64(len(members[args[0].([32]byte)]))), nil
+		},
+		"getRoleMember": func(args []interface{}) (interface{}, error) {
+			list, index := members[args[0].([32]byte)], args[1].(*big.Int)
+			if !index.IsInt64() || index.Int64() >= int64(len(list)) {
+				return nil, errors.New("member index out of range")
+			}
+			return list[index.Int64()], nil
+		},
+	})
+	caller, err := NewXEventsCaller(common.Address{}, backend)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return caller
+}
+
+func TestSessionAllRoleMembers(t *testing.T) {
+	admin, minter := [32]byte{1}, [32]byte{2}
+	roles := []RoleAccessRole{{Role: admin, Describe: "admin"}, {Role: minter, Describe: "minter"}}
+	want := map[[32]byte][]common.Address{
+		admin:  {common.HexToAddress("0x01")},
+		minter: {common.HexToAddress("0x02"), common.HexToAddress("0x03")},
+	}
+	caller := newRolesCaller(t, roles, want)
+	session := &XEventsSession{Contract: &XEvents{XEventsCaller: *caller}}
+
+	members, err := session.AllRoleMembers()
+	if err != nil {
+		t.Fatalf("failed to retrieve role members: %v", err)
+	}
+	if !reflect.DeepEqual(members, want) {
+		t.Errorf("role members mismatch: have %v, want %v", members, want)
+	}
+	roles, err := session.AllRoles()
+	if err != nil {
+		t.Fatalf("failed to retrieve roles: %v", err)
+	}
+	if len(roles) != 2 || roles[0].Describe != "admin" || roles[1].Describe != "minter" {
+		t.Errorf("role descriptions mismatch: %+v", roles)
+	}
+}
+
+func TestSessionRolesLookup(t *testing.T) {
+	admin, minter, shadow := [32]byte{1}, [32]byte{2}, [32]byte{3}
+	caller := newRolesCaller(t, []RoleAccessRole{
+		{Role: admin, Describe: "admin"},
+		{Role: minter, Describe: "minter"},
+		{Role: shadow, Describe: "admin"},
+	}, nil)
+	session := &XEventsSession{Contract: &XEvents{XEventsCaller: *caller}}
+
+	byName, err := session.RolesByName()
+	if err != nil {
+		t.Fatalf("failed to retrieve roles by name: %v", err)
+	}
+	if want := map[string][32]byte{"admin": admin, "minter": minter}; !reflect.DeepEqual(byName, want) {
+		t.Errorf("roles by name mismatch: have %x, want %x", byName, want)
+	}
+	byHash, err := session.RolesByHash()
+	if err != nil {
+		t.Fatalf("failed to retrieve roles by hash: %v", err)
+	}
+	if want := map[[32]byte]string{admin: "admin", minter: "minter", shadow: "admin"}; !reflect.DeepEqual(byHash, want) {
+		t.Errorf("roles by hash mismatch: have %v, want %v", byHash, want)
+	}
+}
+
+func TestGetRoleMembersPaged(t *testing.T) {
+	role := [32]byte{1}
+	var members []common.Address
+	for i := 0; i < 10; i++ {
+		members = append(members, common.BigToAddress(big.NewInt(int64(i+1))))
+	}
+	caller := newRolesCaller(t, nil, map[[32]byte][]common.Address{role: members})
+
+	tests := []struct {
+		offset, limit int64
+		want          []common.Address
+	}{
+		{3, 3, members[3:6]},
+		{8, 5, members[8:]},
+		{10, 2, []common.Address{}},
+		{0, 0, []common.Address{}},
+	}
+	for _, tt := range tests {
+		have, err := caller.GetRoleMembersPaged(nil, role, big.NewInt(tt.offset), big.NewInt(tt.limit))
+		if err != nil {
+			t.Fatalf("window [%d,+%d): %v", tt.offset, tt.limit, err)
+		}
+		if !reflect.DeepEqual(have, tt.want) {
+			t.Errorf("window [%d,+%d): have %v, want %v", tt.offset, tt.limit, have, tt.want)
+		}
+	}
+	if _, err := caller.GetRoleMembersPaged(nil, role, big.NewInt(-1), big.NewInt(2)); err != errNegativeRange {
+		t.Errorf("negative offset: have %v, want %v", err, errNegativeRange)
+	}
+}
@@ -0,0 +1,228 @@
+// Copyright 2021 The MOAC-core Authors
+// This file is part of the MOAC-core library.
+//
+// The MOAC-core library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The MOAC-core library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the MOAC-core library. If not, see <http://www.gnu.org/licenses/>.
+
+package xevents
+
+import (
+	"context"
+	"math/big"
+
+	"github.com/MOACChain/MoacLib/common"
+	"github.com/MOACChain/xchain/accounts/abi/bind"
+)
+
+// WithNonce returns a shallow copy of the session whose transact options use
+// the given nonce. The original session is left untouched, so a single base
+// session can be shared between goroutines submitting in parallel.
+func (_XEvents *XEventsSession) WithNonce(nonce *big.Int) *XEventsSession {
+	session := *_XEvents
+	if nonce != nil {
+		nonce = new(big.Int).Set(nonce)
+	}
+	session.TransactOpts.Nonce = nonce
+	return &session
+}
+
+// WithContext returns a shallow copy of the session whose call and transact
+// options both use the given context. The original session is left untouched.
+func (_XEvents *XEventsSession) WithContext(ctx context.Context) *XEventsSession {
+	session := *_XEvents
+	session.CallOpts.Context = ctx
+	session.TransactOpts.Context = ctx
+	return &session
+}
+
+// WithContext returns a shallow copy of the session whose call options use the
+// given context. The original session is left untouched.
+func (_XEvents *XEventsCallerSession) WithContext(ctx context.Context) *XEventsCallerSession {
+	session := *_XEvents
+	session.CallOpts.Context = ctx
+	return &session
+}
+
+// withContext returns a copy of the call options using the given context.
+func withContext(opts bind.CallOpts, ctx context.Context) *bind.CallOpts {
+	opts.Context = ctx
+	return &opts
+}
+
+// HasRoleCtx is HasRole bounded by the given context.
+func (_XEvents *XEventsSession) HasRoleCtx(ctx context.Context, role [32]byte, account common.Address) (bool, error) {
+	return _XEvents.Contract.HasRole(withContext(_XEvents.CallOpts, ctx), role, account)
+}
+
+// GetRoleAdminCtx is GetRoleAdmin bounded by the given context.
+func (_XEvents *XEventsSession) GetRoleAdminCtx(ctx context.Context, role [32]byte) ([32]byte, error) {
+	return _XEvents.Contract.GetRoleAdmin(withContext(_XEvents.CallOpts, ctx), role)
+}
+
+// GetRoleMemberCtx is GetRoleMember bounded by the given context.
+func (_XEvents *XEventsSession) GetRoleMemberCtx(ctx context.Context, role [32]byte, index *big.Int) (common.Address, error) {
+	return _XEvents.Contract.GetRoleMember(withContext(_XEvents.CallOpts, ctx), role, index)
+}
+
+// GetRoleMemberCountCtx is GetRoleMemberCount bounded by the given context.
+func (_XEvents *XEventsSession) GetRoleMemberCountCtx(ctx context.Context, role [32]byte) (*big.Int, error) {
+	return _XEvents.Contract.GetRoleMemberCount(withContext(_XEvents.CallOpts, ctx), role)
+}
+
+// GetRoleMembersCtx is GetRoleMembers bounded by the given context.
+func (_XEvents *XEventsSession) GetRoleMembersCtx(ctx context.Context, role [32]byte) ([]common.Address, error) {
+	return _XEvents.Contract.GetRoleMembers(withContext(_XEvents.CallOpts, ctx), role)
+}
+
+// GetRolesCtx is GetRoles bounded by the given context.
+func (_XEvents *XEventsSession) GetRolesCtx(ctx context.Context) ([]RoleAccessRole, error) {
+	return _XEvents.Contract.GetRoles(withContext(_XEvents.CallOpts, ctx))
+}
+
+// DEFAULTADMINROLECtx is DEFAULTADMINROLE bounded by the given context.
+func (_XEvents *XEventsSession) DEFAULTADMINROLECtx(ctx context.Context) ([32]byte, error) {
+	return _XEvents.Contract.DEFAULTADMINROLE(withContext(_XEvents.CallOpts, ctx))
+}
+
+// InitializedCtx is Initialized bounded by the given context.
+func (_XEvents *XEventsSession) InitializedCtx(ctx context.Context) (bool, error) {
+	return _XEvents.Contract.Initialized(withContext(_XEvents.CallOpts, ctx))
+}
+
+// SupportsInterfaceCtx is SupportsInterface bounded by the given context.
+func (_XEvents *XEventsSession) SupportsInterfaceCtx(ctx context.Context, interfaceId [4]byte) (bool, error) {
+	return _XEvents.Contract.SupportsInterface(withContext(_XEvents.CallOpts, ctx), interfaceId)
+}
+
+// StoreCounterCtx is StoreCounter bounded by the given context.
+func (_XEvents *XEventsSession) StoreCounterCtx(ctx context.Context) (*big.Int, error) {
+	return _XEvents.Contract.StoreCounter(withContext(_XEvents.CallOpts, ctx))
+}
+
+// MintWatermarkCtx is MintWatermark bounded by the given context.
+func (_XEvents *XEventsSession) MintWatermarkCtx(ctx context.Context, arg0 common.Address, arg1 [32]byte) (*big.Int, error) {
+	return _XEvents.Contract.MintWatermark(withContext(_XEvents.CallOpts, ctx), arg0, arg1)
+}
+
+// VaultEventDoneCtx is VaultEventDone bounded by the given context.
+func (_XEvents *XEventsSession) VaultEventDoneCtx(ctx context.Context, arg0 common.Address, arg1 [32]byte) (*big.Int, error) {
+	return _XEvents.Contract.VaultEventDone(withContext(_XEvents.CallOpts, ctx), arg0, arg1)
+}
+
+// VaultEventWatermarkCtx is VaultEventWatermark bounded by the given context.
+func (_XEvents *XEventsSession) VaultEventWatermarkCtx(ctx context.Context, arg0 common.Address, arg1 [32]byte) (*big.Int, error) {
+	return _XEvents.Contract.VaultEventWatermark(withContext(_XEvents.CallOpts, ctx), arg0, arg1)
+}
+
+// VaultEventsCtx is VaultEvents bounded by the given context.
+func (_XEvents *XEventsSession) VaultEventsCtx(ctx context.Context, arg0 common.Address, arg1 [32]byte, arg2 *big.Int) (struct {
+	EventData   []byte
+	Sig         []byte
+	BlockNumber *big.Int
+}, error) {
+	return _XEvents.Contract.VaultEvents(withContext(_XEvents.CallOpts, ctx), arg0, arg1, arg2)
+}
+
+// VaultStoreCounterCtx is VaultStoreCounter bounded by the given context.
+func (_XEvents *XEventsSession) VaultStoreCounterCtx(ctx context.Context, arg0 common.Address, arg1 *big.Int) (*big.Int, error) {
+	return _XEvents.Contract.VaultStoreCounter(withContext(_XEvents.CallOpts, ctx), arg0, arg1)
+}
+
+// VaultWatermarkCtx is VaultWatermark bounded by the given context.
+func (_XEvents *XEventsSession) VaultWatermarkCtx(ctx context.Context, arg0 common.Address) (*big.Int, error) {
+	return _XEvents.Contract.VaultWatermark(withContext(_XEvents.CallOpts, ctx), arg0)
+}
+
+// HasRoleCtx is HasRole bounded by the given context.
+func (_XEvents *XEventsCallerSession) HasRoleCtx(ctx context.Context, role [32]byte, account common.Address) (bool, error) {
+	return _XEvents.Contract.HasRole(withContext(_XEvents.CallOpts, ctx), role, account)
+}
+
+// GetRoleAdminCtx is GetRoleAdmin bounded by the given context.
+func (_XEvents *XEventsCallerSession) GetRoleAdminCtx(ctx context.Context, role [32]byte) ([32]byte, error) {
+	return _XEvents.Contract.GetRoleAdmin(withContext(_XEvents.CallOpts, ctx), role)
+}
+
+// GetRoleMemberCtx is GetRoleMember bounded by the given context.
+func (_XEvents *XEventsCallerSession) GetRoleMemberCtx(ctx context.Context, role [32]byte, index *big.Int) (common.Address, error) {
+	return _XEvents.Contract.GetRoleMember(withContext(_XEvents.CallOpts, ctx), role, index)
+}
+
+// GetRoleMemberCountCtx is GetRoleMemberCount bounded by the given context.
+func (_XEvents *XEventsCallerSession) GetRoleMemberCountCtx(ctx context.Context, role [32]byte) (*big.Int, error) {
+	return _XEvents.Contract.GetRoleMemberCount(withContext(_XEvents.CallOpts, ctx), role)
+}
+
+// GetRoleMembersCtx is GetRoleMembers bounded by the given context.
+func (_XEvents *XEventsCallerSession) GetRoleMembersCtx(ctx context.Context, role [32]byte) ([]common.Address, error) {
+	return _XEvents.Contract.GetRoleMembers(withContext(_XEvents.CallOpts, ctx), role)
+}
+
+// GetRolesCtx is GetRoles bounded by the given context.
+func (_XEvents *XEventsCallerSession) GetRolesCtx(ctx context.Context) ([]RoleAccessRole, error) {
+	return _XEvents.Contract.GetRoles(withContext(_XEvents.CallOpts, ctx))
+}
+
+// DEFAULTADMINROLECtx is DEFAULTADMINROLE bounded by the given context.
+func (_XEvents *XEventsCallerSession) DEFAULTADMINROLECtx(ctx context.Context) ([32]byte, error) {
+	return _XEvents.Contract.DEFAULTADMINROLE(withContext(_XEvents.CallOpts, ctx))
+}
+
+// InitializedCtx is Initialized bounded by the given context.
+func (_XEvents *XEventsCallerSession) InitializedCtx(ctx context.Context) (bool, error) {
+	return _XEvents.Contract.Initialized(withContext(_XEvents.CallOpts, ctx))
+}
+
+// SupportsInterfaceCtx is SupportsInterface bounded by the given context.
+func (_XEvents *XEventsCallerSession) SupportsInterfaceCtx(ctx context.Context, interfaceId [4]byte) (bool, error) {
+	return _XEvents.Contract.SupportsInterface(withContext(_XEvents.CallOpts, ctx), interfaceId)
+}
+
+// StoreCounterCtx is StoreCounter bounded by the given context.
+func (_XEvents *XEventsCallerSession) StoreCounterCtx(ctx context.Context) (*big.Int, error) {
+	return _XEvents.Contract.StoreCounter(withContext(_XEvents.CallOpts, ctx))
+}
+
+// MintWatermarkCtx is MintWatermark bounded by the given context.
+func (_XEvents *XEventsCallerSession) MintWatermarkCtx(ctx context.Context, arg0 common.Address, arg1 [32]byte) (*big.Int, error) {
+	return _XEvents.Contract.MintWatermark(withContext(_XEvents.CallOpts, ctx), arg0, arg1)
+}
+
+// VaultEventDoneCtx is VaultEventDone bounded by the given context.
+func (_XEvents *XEventsCallerSession) VaultEventDoneCtx(ctx context.Context, arg0 common.Address, arg1 [32]byte) (*big.Int, error) {
+	return _XEvents.Contract.VaultEventDone(withContext(_XEvents.CallOpts, ctx), arg0, arg1)
+}
+
+// VaultEventWatermarkCtx is VaultEventWatermark bounded by the given context.
+func (_XEvents *XEventsCallerSession) VaultEventWatermarkCtx(ctx context.Context, arg0 common.Address, arg1 [32]byte) (*big.Int, error) {
+	return _XEvents.Contract.VaultEventWatermark(withContext(_XEvents.CallOpts, ctx), arg0, arg1)
+}
+
+// VaultEventsCtx is VaultEvents bounded by the given context.
+func (_XEvents *XEventsCallerSession) VaultEventsCtx(ctx context.Context, arg0 common.Address, arg1 [32]byte, arg2 *big.Int) (struct {
+	EventData   []byte
+	Sig         []byte
+	BlockNumber *big.Int
+}, error) {
+	return _XEvents.Contract.VaultEvents(withContext(_XEvents.CallOpts, ctx), arg0, arg1, arg2)
+}
+
+// VaultStoreCounterCtx is VaultStoreCounter bounded by the given context.
+func (_XEvents *XEventsCallerSession) VaultStoreCounterCtx(ctx context.Context, arg0 common.Address, arg1 *big.Int) (*big.Int, error) {
+	return _XEvents.Contract.VaultStoreCounter(withContext(_XEvents.CallOpts, ctx), arg0, arg1)
+}
+
+// VaultWatermarkCtx is VaultWatermark bounded by the given context.
+func (_XEvents *XEventsCallerSession) VaultWatermarkCtx(ctx context.Context, arg0 common.Address) (*big.Int, error) {
+	return _XEvents.Contract.VaultWatermark(withContext(_XEvents.CallOpts, ctx), arg0)
+}
@@ -0,0 +1,118 @@
+// Copyright 2021 The MOAC-core Authors
+// This file is part of the MOAC-core library.
+//
+// The MOAC-core library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The MOAC-core library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the MOAC-core library. If not, see <http://www.gnu.org/licenses/>.
+
+package xevents
+
+import (
+	"context"
+	"math/big"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/MOACChain/MoacLib/common"
+	moaccore "github.com/MOACChain/xchain"
+	"github.com/MOACChain/xchain/accounts/abi/bind"
+)
+
+func TestSessionWithNonce(t *testing.T) {
+	base := &XEventsSession{
+		TransactOpts: bind.TransactOpts{Nonce: big.NewInt(7)},
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 64; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			session := base.WithNonce(big.NewInt(int64(100 + i)))
+			session.TransactOpts.Nonce.Add(session.TransactOpts.Nonce, big.NewInt(1))
+			if want := int64(101 + i); session.TransactOpts.Nonce.Int64() != want {
+				t.Errorf("session nonce mismatch: have %d, want %d", session.TransactOpts.Nonce, want)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if base.TransactOpts.Nonce.Int64() != 7 {
+		t.Fatalf("base session nonce changed: have %d, want 7", base.TransactOpts.Nonce)
+	}
+}
+
+func TestSessionWithContext(t *testing.T) {
+	base := &XEventsSession{}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	session := base.WithContext(ctx)
+	if session.CallOpts.Context != ctx || session.TransactOpts.Context != ctx {
+		t.Fatal("session context not set")
+	}
+	if base.CallOpts.Context != nil || base.TransactOpts.Context != nil {
+		t.Fatal("base session context changed")
+	}
+}
+
+// blockingCaller is a bind.ContractCaller whose calls only return once their
+// context is done, like a hung RPC provider.
+type blockingCaller struct{}
+
+func (blockingCaller) CodeAt(ctx context.Context, contract common.Address, blockNumber *big.Int) ([]byte, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func (blockingCaller) CallContract(ctx context.Context, call moaccore.CallMsg, blockNumber *big.Int) ([]byte, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestSessionCallCtxCancel(t *testing.T) {
+	caller, err := NewXEventsCaller(common.Address{}, blockingCaller{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	session := &XEventsCallerSession{Contract: caller}
+
+	calls := map[string]func(ctx context.Context) error{
+		"HasRole": func(ctx context.Context) error {
+			_, err := session.HasRoleCtx(ctx, [32]byte{1}, common.Address{2})
+			return err
+		},
+		"VaultWatermark": func(ctx context.Context) error {
+			_, err := session.VaultWatermarkCtx(ctx, common.Address{2})
+			return err
+		},
+		"VaultEvents": func(ctx context.Context) error {
+			_, err := session.VaultEventsCtx(ctx, common.Address{2}, [32]byte{1}, big.NewInt(0))
+			return err
+		},
+	}
+	for name, call := range calls {
+		ctx, cancel := context.WithCancel(context.Background())
+		errc := make(chan error, 1)
+		go func() { errc <- call(ctx) }()
+		cancel()
+		select {
+		case err := <-errc:
+			if err != context.Canceled {
+				t.Fatalf("%s: call error mismatch: have %v, want %v", name, err, context.Canceled)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("%s: call did not return after the context was cancelled", name)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+// Copyright 2021 The MOAC-core Authors
+// This file is part of the MOAC-core library.
+//
+// The MOAC-core library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The MOAC-core library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the MOAC-core library. If not, see <http://www.gnu.org/licenses/>.
+
+package xevents
+
+import (
+	"math/big"
+	"sync"
+
+	"github.com/MOACChain/MoacLib/common"
+	"github.com/MOACChain/MoacLib/types"
+)
+
+// storeNonce is the next store nonce of a vault token mapping.
+type storeNonce struct {
+	mu   sync.Mutex // serializes submissions for the token mapping
+	next *big.Int   // next nonce after the last submission, nil if none
+	refs int        // number of callers using the tracker, protected by storeNonces
+}
+
+// storeNonceKey identifies the submissions of one sender through one contract
+// binding, and so one backend, for a vault token mapping.
+type storeNonceKey struct {
+	contract *XEvents
+	from     common.Address
+	vaultMapping
+}
+
+// storeNonces tracks the store nonces submitted from this process. The
+// watermark in the contract only advances once the submissions are mined.
+// Trackers are dropped once the watermark caught up with them.
+var storeNonces = struct {
+	sync.Mutex
+	nonces map[storeNonceKey]*storeNonce
+}{nonces: make(map[storeNonceKey]*storeNonce)}
+
+// acquireStoreNonce returns the nonce tracker of key. It must be released
+// with releaseStoreNonce.
+func acquireStoreNonce(key storeNonceKey) *storeNonce {
+	storeNonces.Lock()
+	defer storeNonces.Unlock()
+
+	sn := storeNonces.nonces[key]
+	if sn == nil {
+		sn = new(storeNonce)
+		storeNonces.nonces[key] = sn
+	}
+	sn.refs++
+	return sn
+}
+
+// releaseStoreNonce releases the tracker of key, dropping it if it is unused
+// and tracks no submission the watermark doesn't cover yet.
+func releaseStoreNonce(key storeNonceKey, sn *storeNonce, resync bool) {
+	storeNonces.Lock()
+	defer storeNonces.Unlock()
+
+	if resync {
+		sn.next = nil
+	}
+	if sn.refs--; sn.refs == 0 && sn.next == nil {
+		delete(storeNonces.nonces, key)
+	}
+}
+
+// StoreNext submits a vault event with the next nonce of its token mapping.
+// The nonce is the watermark of the token mapping, or the nonce following the
+// last submission of the session's sender through its binding if that is
+// higher. Concurrent submissions for the same token mapping are serialized,
+// so they get consecutive nonces. A failed submission resyncs the nonce from
+// the watermark; the transaction nonce is taken from the backend's pending
+// nonce unless TransactOpts.Nonce pins it.
+func (_XEvents *XEventsSession) StoreNext(vault common.Address, tokenMapping [32]byte, blockNumber *big.Int, eventData []byte, sig []byte) (*types.Transaction, error) {
+	key := storeNonceKey{_XEvents.Contract, _XEvents.TransactOpts.From, vaultMapping{vault, tokenMapping}}
+	sn := acquireStoreNonce(key)
+	sn.mu.Lock()
+	defer sn.mu.Unlock()
+
+	nonce, err := _XEvents.VaultEventWatermark(vault, tokenMapping)
+	if err != nil {
+		releaseStoreNonce(key, sn, false)
+		return nil, err
+	}
+	if sn.next != nil && sn.next.Cmp(nonce) > 0 {
+		nonce = new(big.Int).Set(sn.next)
+	} else {
+		// The watermark covers all submissions.
+		sn.next = nil
+	}
+	tx, err := _XEvents.Store(sig, vault, nonce, tokenMapping, blockNumber, eventData)
+	if err != nil {
+		releaseStoreNonce(key, sn, true)
+		return nil, err
+	}
+	sn.next = new(big.Int).Add(nonce, big.NewInt(1))
+	releaseStoreNonce(key, sn, false)
+	return tx, nil
+}
@@ -0,0 +1,173 @@
+// Copyright 2021 The MOAC-core Authors
+// This file is part of the MOAC-core library.
+//
+// The MOAC-core library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The MOAC-core library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the MOAC-core library. If not, see <http://www.gnu.org/licenses/>.
+
+package xevents
+
+import (
+	"errors"
+	"math/big"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/MOACChain/MoacLib/common"
+	"github.com/MOACChain/MoacLib/types"
+	"github.com/MOACChain/xchain/accounts/abi/bind"
+)
+
+func TestStoreNextConcurrent(t *testing.T) {
+	watermark := big.NewInt(5)
+	backend := newCallBackend(t, map[string]callHandler{
+		"vaultEventWatermark": func([]interface{}) (interface{}, error) { return watermark, nil },
+	})
+	parsed := backend.abi
+	contract, err := NewXEvents(common.Address{}, backend)
+	if err != nil {
+		t.Fatal(err)
+	}
+	session := &XEventsSession{
+		Contract: contract,
+		TransactOpts: bind.TransactOpts{
+			Nonce:    big.NewInt(0),
+			GasPrice: big.NewInt(1),
+			GasLimit: 100000,
+			Signer:   func(_ common.Address, tx *types.Transaction) (*types.Transaction, error) { return tx, nil },
+			NoSend:   true,
+		},
+	}
+	var (
+		vault        = common.HexToAddress("0x2375")
+		tokenMapping = [32]byte{1}
+		wg           sync.WaitGroup
+		mu           sync.Mutex
+		nonces       []int64
+	)
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			tx, err := session.StoreNext(vault, tokenMapping, big.NewInt(100), []byte{0x01}, []byte{0x02})
+			if err != nil {
+				t.Errorf("store failed: %v", err)
+				return
+			}
+			args, err := parsed.Methods["store"].Inputs.Unpack(tx.Data()[4:])
+			if err != nil {
+				t.Errorf("can't decode store call: %v", err)
+				return
+			}
+			mu.Lock()
+			nonces = append(nonces, args[2].(*big.Int).Int64())
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	sort.Slice(nonces, func(i, j int) bool { return nonces[i] < nonces[j] })
+	if len(nonces) != 2 || nonces[0] != 5 || nonces[1] != 6 {
+		t.Errorf("store nonces mismatch: have %v, want [5 6]", nonces)
+	}
+	// Once the watermark passes the submitted nonces, it is used again.
+	watermark = big.NewInt(10)
+	tx, err := session.StoreNext(vault, tokenMapping, big.NewInt(100), []byte{0x01}, []byte{0x02})
+	if err != nil {
+		t.Fatalf("store failed: %v", err)
+	}
+	args, err := parsed.Methods["store"].Inputs.Unpack(tx.Data()[4:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nonce := args[2].(*big.Int); nonce.Int64() != 10 {
+		t.Errorf("store nonce after watermark advanced: have %v, want 10", nonce)
+	}
+}
+
+func TestStoreNextResync(t *testing.T) {
+	backend := newCallBackend(t, map[string]callHandler{"vaultEventWatermark": returns(big.NewInt(5))})
+	parsed := backend.abi
+	contract, err := NewXEvents(common.Address{}, backend)
+	if err != nil {
+		t.Fatal(err)
+	}
+	failSend := false
+	session := &XEventsSession{
+		Contract: contract,
+		TransactOpts: bind.TransactOpts{
+			From:     common.HexToAddress("0x1"),
+			Nonce:    big.NewInt(0),
+			GasPrice: big.NewInt(1),
+			GasLimit: 100000,
+			Signer: func(_ common.Address, tx *types.Transaction) (*types.Transaction, error) {
+				if failSend {
+					return nil, errors.New("send failed")
+				}
+				return tx, nil
+			},
+			NoSend: true,
+		},
+	}
+	vault, tokenMapping := common.HexToAddress("0x2375"), [32]byte{2}
+	storeNonce := func() int64 {
+		tx, err := session.StoreNext(vault, tokenMapping, big.NewInt(100), []byte{0x01}, []byte{0x02})
+		if err != nil {
+			t.Fatalf("store failed: %v", err)
+		}
+		args, err := parsed.Methods["store"].Inputs.Unpack(tx.Data()[4:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return args[2].(*big.Int).Int64()
+	}
+	key := storeNonceKey{contract, session.TransactOpts.From, vaultMapping{vault, tokenMapping}}
+	tracked := func() bool {
+		storeNonces.Lock()
+		defer storeNonces.Unlock()
+		_, ok := storeNonces.nonces[key]
+		return ok
+	}
+
+	if nonce := storeNonce(); nonce != 5 {
+		t.Fatalf("first nonce: have %d, want 5", nonce)
+	}
+	if nonce := storeNonce(); nonce != 6 {
+		t.Fatalf("second nonce: have %d, want 6", nonce)
+	}
+	// A failed send resyncs from the watermark.
+	failSend = true
+	if _, err := session.StoreNext(vault, tokenMapping, big.NewInt(100), []byte{0x01}, []byte{0x02}); err == nil {
+		t.Fatal("store succeeded despite failing send")
+	}
+	if tracked() {
+		t.Error("nonce still tracked after failed send")
+	}
+	failSend = false
+	if nonce := storeNonce(); nonce != 5 {
+		t.Errorf("nonce after failed send: have %d, want 5", nonce)
+	}
+
+	// Another sender through the same binding has its own nonces.
+	other := *session
+	other.TransactOpts.From = common.HexToAddress("0x2")
+	if _, err := other.StoreNext(vault, tokenMapping, big.NewInt(100), []byte{0x01}, []byte{0x02}); err != nil {
+		t.Fatalf("store of other sender failed: %v", err)
+	}
+	storeNonces.Lock()
+	next := storeNonces.nonces[storeNonceKey{contract, other.TransactOpts.From, vaultMapping{vault, tokenMapping}}].next
+	storeNonces.Unlock()
+	if next.Int64() != 6 {
+		t.Errorf("next nonce of other sender: have %v, want 6", next)
+	}
+}
@@ -0,0 +1,63 @@
+// Copyright 2021 The MOAC-core Authors
+// This file is part of the MOAC-core library.
+//
+// The MOAC-core library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The MOAC-core library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the MOAC-core library. If not, see <http://www.gnu.org/licenses/>.
+
+package xevents
+
+import (
+	"bytes"
+	"errors"
+	"strconv"
+
+	"github.com/MOACChain/MoacLib/common"
+	"github.com/MOACChain/MoacLib/crypto"
+)
+
+// eventSigPrefix is the domain separator of signed vault event data.
+const eventSigPrefix = "\x19XEvents Signed Event:\n"
+
+var errBadEventSig = errors.New("event signature must be 65 bytes")
+
+// EventSigHash returns the digest signed for a vault event. It is
+//
+//	keccak256("\x19XEvents Signed Event:\n" || len(eventData) || eventData)
+//
+// where len(eventData) is the byte length of the event data in decimal ASCII.
+func EventSigHash(eventData []byte) common.Hash {
+	msg := make([]byte, 0, len(eventSigPrefix)+20+len(eventData))
+	msg = append(msg, eventSigPrefix...)
+	msg = strconv.AppendInt(msg, int64(len(eventData)), 10)
+	msg = append(msg, eventData...)
+	return crypto.Keccak256Hash(msg)
+}
+
+// VerifyEventSignature reports whether sig is a signature of eventData by
+// signer. The signature is in the [R || S || V] format with V being 0 or 1;
+// 27 and 28 are accepted too. The signed digest is EventSigHash(eventData).
+func VerifyEventSignature(eventData []byte, sig []byte, signer common.Address) (bool, error) {
+	if len(sig) != 65 {
+		return false, errBadEventSig
+	}
+	sig = common.CopyBytes(sig)
+	if sig[64] >= 27 {
+		sig[64] -= 27
+	}
+	pub, err := crypto.Ecrecover(EventSigHash(eventData).Bytes(), sig)
+	if err != nil {
+		return false, err
+	}
+	recovered := common.BytesToAddress(crypto.Keccak256(pub[1:])[12:])
+	return bytes.Equal(recovered.Bytes(), signer.Bytes()), nil
+}
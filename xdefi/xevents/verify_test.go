@@ -0,0 +1,52 @@
+// Copyright 2021 The MOAC-core Authors
+// This file is part of the MOAC-core library.
+//
+// The MOAC-core library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The MOAC-core library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the MOAC-core library. If not, see <http://www.gnu.org/licenses/>.
+
+package xevents
+
+import (
+	"testing"
+
+	"github.com/MOACChain/MoacLib/crypto"
+)
+
+func TestVerifyEventSignature(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	other, _ := crypto.GenerateKey()
+	signer := crypto.PubkeyToAddress(key.PublicKey)
+	eventData := []byte("sample vault event")
+
+	sig, err := crypto.Sign(EventSigHash(eventData).Bytes(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := VerifyEventSignature(eventData, sig, signer); err != nil || !ok {
+		t.Fatalf("correct signer: got %v, %v, want true", ok, err)
+	}
+	if ok, err := VerifyEventSignature(eventData, sig, crypto.PubkeyToAddress(other.PublicKey)); err != nil || ok {
+		t.Fatalf("other signer: got %v, %v, want false", ok, err)
+	}
+	if ok, _ := VerifyEventSignature([]byte("tampered event"), sig, signer); ok {
+		t.Fatal("tampered event data verified")
+	}
+
+	sig[64] += 27
+	if ok, err := VerifyEventSignature(eventData, sig, signer); err != nil || !ok {
+		t.Fatalf("27-based V: got %v, %v, want true", ok, err)
+	}
+	if _, err := VerifyEventSignature(eventData, sig[:64], signer); err != errBadEventSig {
+		t.Fatalf("short signature: got %v, want %v", err, errBadEventSig)
+	}
+}
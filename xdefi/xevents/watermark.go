@@ -0,0 +1,161 @@
+// Copyright 2021 The MOAC-core Authors
+// This file is part of the MOAC-core library.
+//
+// The MOAC-core library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The MOAC-core library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the MOAC-core library. If not, see <http://www.gnu.org/licenses/>.
+
+package xevents
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/MOACChain/MoacLib/common"
+	"github.com/MOACChain/xchain/accounts/abi/bind"
+)
+
+var errNonPositivePoll = errors.New("poll interval must be positive")
+
+// WatermarkTimeoutError is returned by WaitVaultWatermark if the context ends
+// before the vault watermark reaches the target block.
+type WatermarkTimeoutError struct {
+	Vault  common.Address
+	Target *big.Int
+	Last   *big.Int // last watermark read, nil if none was read
+	Err    error    // the context error
+}
+
+func (e *WatermarkTimeoutError) Error() string {
+	return fmt.Sprintf("vault %x watermark %v did not reach %v: %v", e.Vault, e.Last, e.Target, e.Err)
+}
+
+func (e *WatermarkTimeoutError) Unwrap() error { return e.Err }
+
+// WaitVaultWatermark polls the watermark of the vault every poll interval
+// until it is at least target. It returns a *WatermarkTimeoutError once ctx
+// is done, calls are bounded by ctx as well.
+func (_XEvents *XEventsCaller) WaitVaultWatermark(ctx context.Context, opts *bind.CallOpts, vault common.Address, target *big.Int, poll time.Duration) error {
+	if poll <= 0 {
+		return errNonPositivePoll
+	}
+	if opts == nil {
+		opts = new(bind.CallOpts)
+	}
+	callOpts := withContext(*opts, ctx)
+
+	ticker := time.NewTicker(poll)
+	defer ticker.Stop()
+
+	var last *big.Int
+	for {
+		watermark, err := _XEvents.VaultWatermark(callOpts, vault)
+		if ctx.Err() != nil {
+			return &WatermarkTimeoutError{Vault: vault, Target: target, Last: last, Err: ctx.Err()}
+		}
+		if err != nil {
+			return err
+		}
+		if watermark.Cmp(target) >= 0 {
+			return nil
+		}
+		last = watermark
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return &WatermarkTimeoutError{Vault: vault, Target: target, Last: last, Err: ctx.Err()}
+		}
+	}
+}
+
+// TokenMappingStatus is the watermark state of a vault token mapping.
+type TokenMappingStatus struct {
+	TokenMapping   [32]byte
+	EventWatermark *big.Int // nonce of the next stored vault event
+	EventDone      *big.Int // vault events below are done
+	MintWatermark  *big.Int // vault events below are minted
+	Pending        *big.Int // stored vault events neither done nor minted
+}
+
+// VaultStatus is a snapshot of the watermarks of a vault.
+type VaultStatus struct {
+	Vault        common.Address
+	Watermark    *big.Int
+	StoreCounter *big.Int
+	Mappings     []TokenMappingStatus
+	Pending      *big.Int // sum of the pending vault events of all mappings
+}
+
+// VaultStatus reads the watermarks of the vault and of the given token
+// mappings concurrently. The lag between stored and minted vault events is
+// reported as Pending, per token mapping and in total.
+func (_XEvents *XEventsSession) VaultStatus(vault common.Address, tokenMappings ...[32]byte) (VaultStatus, error) {
+	status := VaultStatus{
+		Vault:    vault,
+		Mappings: make([]TokenMappingStatus, len(tokenMappings)),
+	}
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		ferr error
+	)
+	fetch := func(dst **big.Int, call func() (*big.Int, error)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			v, err := call()
+			if err != nil {
+				mu.Lock()
+				if ferr == nil {
+					ferr = err
+				}
+				mu.Unlock()
+				return
+			}
+			*dst = v
+		}()
+	}
+	fetch(&status.Watermark, func() (*big.Int, error) { return _XEvents.VaultWatermark(vault) })
+	fetch(&status.StoreCounter, _XEvents.StoreCounter)
+	for i, tokenMapping := range tokenMappings {
+		m := &status.Mappings[i]
+		m.TokenMapping = tokenMapping
+		tokenMapping := tokenMapping
+		fetch(&m.EventWatermark, func() (*big.Int, error) { return _XEvents.VaultEventWatermark(vault, tokenMapping) })
+		fetch(&m.EventDone, func() (*big.Int, error) { return _XEvents.VaultEventDone(vault, tokenMapping) })
+		fetch(&m.MintWatermark, func() (*big.Int, error) { return _XEvents.MintWatermark(vault, tokenMapping) })
+	}
+	wg.Wait()
+	if ferr != nil {
+		return VaultStatus{}, ferr
+	}
+
+	status.Pending = new(big.Int)
+	for i := range status.Mappings {
+		m := &status.Mappings[i]
+		handled := m.MintWatermark
+		if m.EventDone.Cmp(handled) > 0 {
+			handled = m.EventDone
+		}
+		m.Pending = new(big.Int).Sub(m.EventWatermark, handled)
+		if m.Pending.Sign() < 0 {
+			m.Pending.SetInt64(0)
+		}
+		status.Pending.Add(status.Pending, m.Pending)
+	}
+	return status, nil
+}
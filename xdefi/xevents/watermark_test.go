@@ -0,0 +1,175 @@
+// Copyright 2021 The MOAC-core Authors
+// This file is part of the MOAC-core library.
+//
+// The MOAC-core library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The MOAC-core library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the MOAC-core library. If not, see <http://www.gnu.org/licenses/>.
+
+package xevents
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/MOACChain/MoacLib/common"
+)
+
+// newWatermarkCaller returns a caller on a backend whose vault watermark
+// reads zero for the first two calls and target after.
+func newWatermarkCaller(t *testing.T, target *big.Int) (*XEventsCaller, *callBackend) {
+	var calls int
+	backend := newCallBackend(t, map[string]callHandler{
+		"vaultWatermark": func([]interface{}) (interface{}, error) {
+			if calls++; calls <= 2 {
+				return new(big.Int), nil
+			}
+			return target, nil
+		},
+	})
+	caller, err := NewXEventsCaller(common.Address{}, backend)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return caller, backend
+}
+
+func TestWaitVaultWatermark(t *testing.T) {
+	caller, backend := newWatermarkCaller(t, big.NewInt(20))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// The watermark reads 0, 0, 20: the target is reached after two polls.
+	start := time.Now()
+	if err := caller.WaitVaultWatermark(ctx, nil, common.HexToAddress("0x01"), big.NewInt(20), 10*time.Millisecond); err != nil {
+		t.Fatalf("wait failed: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("wait returned too late: %v", elapsed)
+	}
+	if calls := backend.callCount("vaultWatermark"); calls != 3 {
+		t.Errorf("call count mismatch: have %d, want 3", calls)
+	}
+}
+
+func TestWaitVaultWatermarkPoll(t *testing.T) {
+	caller, backend := newWatermarkCaller(t, big.NewInt(20))
+	for _, poll := range []time.Duration{0, -time.Second} {
+		if err := caller.WaitVaultWatermark(context.Background(), nil, common.HexToAddress("0x01"), big.NewInt(20), poll); err != errNonPositivePoll {
+			t.Errorf("poll %v: have error %v, want %v", poll, err, errNonPositivePoll)
+		}
+	}
+	if calls := backend.callCount("vaultWatermark"); calls != 0 {
+		t.Errorf("watermark read despite invalid poll interval: %d calls", calls)
+	}
+}
+
+func TestWaitVaultWatermarkTimeout(t *testing.T) {
+	backend := newCallBackend(t, map[string]callHandler{"vaultWatermark": returns(new(big.Int))})
+	caller, err := NewXEventsCaller(common.Address{}, backend)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	err = caller.WaitVaultWatermark(ctx, nil, common.HexToAddress("0x01"), big.NewInt(1), 10*time.Millisecond)
+	var timeout *WatermarkTimeoutError
+	if !errors.As(err, &timeout) {
+		t.Fatalf("wrong error: have %v, want *WatermarkTimeoutError", err)
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("timeout error does not wrap the context error: %v", err)
+	}
+	if timeout.Last == nil || timeout.Last.Sign() != 0 {
+		t.Errorf("last watermark mismatch: have %v, want 0", timeout.Last)
+	}
+}
+
+// newStatusSession returns a session on a backend answering the watermark
+// calls of a vault. The watermarks of a token mapping are multiples of its
+// first byte, and calls of the failOn method fail.
+func newStatusSession(t *testing.T, failOn string) (*XEventsSession, *callBackend) {
+	multiple := func(n int64) callHandler {
+		return func(args []interface{}) (interface{}, error) {
+			return big.NewInt(n * int64(args[1].([32]byte)[0])), nil
+		}
+	}
+	handlers := map[string]callHandler{
+		"vaultWatermark":      returns(big.NewInt(1000)),
+		"storeCounter":        returns(big.NewInt(42)),
+		"vaultEventWatermark": multiple(10),
+		"vaultEventDone":      multiple(2),
+		"mintWatermark":       multiple(3),
+	}
+	if failOn != "" {
+		handlers[failOn] = func([]interface{}) (interface{}, error) { return nil, errors.New("call failed") }
+	}
+	backend := newCallBackend(t, handlers)
+	contract, err := NewXEvents(common.HexToAddress("0x2397"), backend)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &XEventsSession{Contract: contract}, backend
+}
+
+func TestVaultStatus(t *testing.T) {
+	session, backend := newStatusSession(t, "")
+	vault := common.HexToAddress("0x01")
+	mappings := [][32]byte{{1}, {4}}
+
+	status, err := session.VaultStatus(vault, mappings...)
+	if err != nil {
+		t.Fatalf("status failed: %v", err)
+	}
+	if status.Vault != vault {
+		t.Errorf("vault mismatch: have %x, want %x", status.Vault, vault)
+	}
+	if status.Watermark == nil || status.Watermark.Int64() != 1000 {
+		t.Errorf("watermark mismatch: have %v, want 1000", status.Watermark)
+	}
+	if status.StoreCounter == nil || status.StoreCounter.Int64() != 42 {
+		t.Errorf("store counter mismatch: have %v, want 42", status.StoreCounter)
+	}
+	if len(status.Mappings) != len(mappings) {
+		t.Fatalf("mapping count mismatch: have %d, want %d", len(status.Mappings), len(mappings))
+	}
+	for i, m := range status.Mappings {
+		n := int64(mappings[i][0])
+		if m.TokenMapping != mappings[i] {
+			t.Errorf("mapping %d: token mapping mismatch: have %x, want %x", i, m.TokenMapping, mappings[i])
+		}
+		if m.EventWatermark.Int64() != 10*n || m.EventDone.Int64() != 2*n || m.MintWatermark.Int64() != 3*n {
+			t.Errorf("mapping %d: watermarks mismatch: have %v/%v/%v, want %d/%d/%d", i, m.EventWatermark, m.EventDone, m.MintWatermark, 10*n, 2*n, 3*n)
+		}
+		if m.Pending.Int64() != 7*n {
+			t.Errorf("mapping %d: pending mismatch: have %v, want %d", i, m.Pending, 7*n)
+		}
+	}
+	if status.Pending.Int64() != 35 {
+		t.Errorf("total pending mismatch: have %v, want 35", status.Pending)
+	}
+	for _, name := range []string{"vaultEventWatermark", "vaultEventDone", "mintWatermark"} {
+		if calls := backend.callCount(name); calls != len(mappings) {
+			t.Errorf("%s call count mismatch: have %d, want %d", name, calls, len(mappings))
+		}
+	}
+
+	session, _ = newStatusSession(t, "vaultEventDone")
+	if _, err := session.VaultStatus(vault, mappings...); err == nil {
+		t.Error("failing call not reported")
+	}
+}